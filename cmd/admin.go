@@ -204,7 +204,9 @@ func DryRunCmd(cmd string, dryRun bool) (string, error) {
 	return common.RunCmd(cmd)
 }
 
-func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun bool) error {
+// checkUpgradeVersions makes sure that a deployment with the old version can be upgraded to the new one,
+// and tells whether the server performs the upgrade by itself, without mysql_upgrade
+func checkUpgradeVersions(oldSbdesc, newSbdesc common.SandboxDescription) (upgradeWithServer bool, err error) {
 	var possibleUpgrades = map[string]string{
 		"5.0": "5.1",
 		"5.1": "5.5",
@@ -213,49 +215,16 @@ func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun b
 		"5.7": "8.0",
 		"8.0": "8.0",
 	}
-	if dryRun {
-		verbose = true
-	}
-	if verbose {
-		fmt.Printf("cd %s\n", sandboxDir)
-	}
-	err := os.Chdir(sandboxDir)
-	common.ErrCheckExitf(err, 1, "can't change directory to %s", sandboxDir)
-	scripts := []string{globals.ScriptStart, globals.ScriptStop, globals.ScriptMy}
-	for _, dir := range []string{oldSandbox, newSandbox} {
-		if !common.DirExists(dir) {
-			common.Exitf(1, globals.ErrDirectoryNotFoundInUpper, dir, sandboxDir)
-		}
-		for _, script := range scripts {
-			if !common.ExecExists(path.Join(dir, script)) {
-				common.Exit(1, fmt.Sprintf(globals.ErrScriptNotFoundInUpper, script, dir),
-					"The upgrade only works between SINGLE deployments")
-			}
-		}
-	}
-	newSbdesc, err := common.ReadSandboxDescription(newSandbox)
-	if err != nil {
-		return errors.Wrapf(err, "error reading new sandbox description")
-	}
-	oldSbdesc, err := common.ReadSandboxDescription(oldSandbox)
-	if err != nil {
-		return errors.Wrapf(err, "error reading old sandbox description")
-	}
-	mysqlUpgrade := path.Join(newSbdesc.Basedir, "bin", "mysql_upgrade")
-	if !common.ExecExists(mysqlUpgrade) {
-		_ = common.WriteString("", path.Join(newSandbox, "no_upgrade"))
-		return errors.Errorf("mysql_upgrade not found in %s. Upgrade is not possible", newSbdesc.Basedir)
-	}
 	newVersionList, err := common.VersionToList(newSbdesc.Version)
 	if err != nil {
-		return errors.Wrapf(err, "error converting new sandbox version to major/minor/rev")
+		return false, errors.Wrapf(err, "error converting new sandbox version to major/minor/rev")
 	}
 	newMajor := newVersionList[0]
 	newMinor := newVersionList[1]
 	newRev := newVersionList[2]
 	oldVersionList, err := common.VersionToList(oldSbdesc.Version)
 	if err != nil {
-		return errors.Wrapf(err, "error converting old sandbox version to major/minor/rev")
+		return false, errors.Wrapf(err, "error converting old sandbox version to major/minor/rev")
 	}
 	oldMajor := oldVersionList[0]
 	oldMinor := oldVersionList[1]
@@ -263,18 +232,20 @@ func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun b
 	newUpgradeVersion := fmt.Sprintf("%d.%d", newVersionList[0], newVersionList[1])
 	oldUpgradeVersion := fmt.Sprintf("%d.%d", oldVersionList[0], oldVersionList[1])
 	if oldSbdesc.Flavor == common.MariaDbFlavor || newSbdesc.Flavor == common.MariaDbFlavor {
-		common.Exit(1, "upgrade from and to MariaDB is not supported")
+		return false, fmt.Errorf("upgrade from and to MariaDB is not supported")
 	}
 	greaterThanNewVersion, err := common.GreaterOrEqualVersionList(oldVersionList, newVersionList)
-	common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
+	if err != nil {
+		return false, errors.Wrapf(err, globals.ErrWhileComparingVersions)
+	}
 	if greaterThanNewVersion {
-		common.Exitf(1, "version %s must be greater than %s", newUpgradeVersion, oldUpgradeVersion)
+		return false, fmt.Errorf("version %s must be greater than %s", newUpgradeVersion, oldUpgradeVersion)
 	}
 
 	// 8.0.16
-	upgradeWithServer, err := common.HasCapability(newSbdesc.Flavor, common.UpgradeWithServer, newSbdesc.Version)
+	upgradeWithServer, err = common.HasCapability(newSbdesc.Flavor, common.UpgradeWithServer, newSbdesc.Version)
 	if err != nil {
-		return errors.Wrapf(err, "error detecting upgrade capability")
+		return false, errors.Wrapf(err, "error detecting upgrade capability")
 	}
 	canBeUpgraded := false
 	if oldMajor < newMajor {
@@ -289,17 +260,20 @@ func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun b
 		}
 	}
 	if !canBeUpgraded {
-		return fmt.Errorf("version '%s' can only be upgraded to '%s' or to the same version with a higher revision", oldUpgradeVersion, possibleUpgrades[oldUpgradeVersion])
-	}
-	newSandboxOldData := path.Join(newSandbox, globals.DataDirName+"-"+newSandbox)
-	if common.DirExists(newSandboxOldData) {
-		return fmt.Errorf("sandbox '%s' is already the upgrade from an older version", newSandbox)
+		return false, fmt.Errorf("version '%s' can only be upgraded to '%s' or to the same version with a higher revision", oldUpgradeVersion, possibleUpgrades[oldUpgradeVersion])
 	}
+	return upgradeWithServer, nil
+}
+
+// upgradeNode moves the data directory of oldSandbox to newSandbox, and starts newSandbox with the upgrade.
+// The data directory of newSandbox is preserved in newSandboxOldData.
+// Both sandboxes must be single servers, or nodes of a topology
+func upgradeNode(oldSandbox, newSandbox, newSandboxOldData string, upgradeWithServer, verbose, dryRun bool) error {
 	oldScriptStop := path.Join(oldSandbox, globals.ScriptStop)
 	if verbose {
 		fmt.Printf("# %s\n", oldScriptStop)
 	}
-	_, err = DryRunCmd(oldScriptStop, dryRun)
+	_, err := DryRunCmd(oldScriptStop, dryRun)
 	if err != nil {
 		return errors.Wrapf(err, globals.ErrWhileStoppingSandbox, oldSandbox)
 	}
@@ -356,6 +330,61 @@ func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun b
 			return errors.Wrapf(err, "error while running mysql_upgrade in %s", newSandbox)
 		}
 	}
+	return nil
+}
+
+func upgradeSandbox(sandboxDir, oldSandbox, newSandbox string, verbose, dryRun bool) error {
+	if dryRun {
+		verbose = true
+	}
+	if verbose {
+		fmt.Printf("cd %s\n", sandboxDir)
+	}
+	err := os.Chdir(sandboxDir)
+	common.ErrCheckExitf(err, 1, "can't change directory to %s", sandboxDir)
+	for _, dir := range []string{oldSandbox, newSandbox} {
+		if !common.DirExists(dir) {
+			common.Exitf(1, globals.ErrDirectoryNotFoundInUpper, dir, sandboxDir)
+		}
+	}
+	newSbdesc, err := common.ReadSandboxDescription(newSandbox)
+	if err != nil {
+		return errors.Wrapf(err, "error reading new sandbox description")
+	}
+	oldSbdesc, err := common.ReadSandboxDescription(oldSandbox)
+	if err != nil {
+		return errors.Wrapf(err, "error reading old sandbox description")
+	}
+	if upgradableTopologies[oldSbdesc.SBType] || upgradableTopologies[newSbdesc.SBType] {
+		return upgradeTopology(path.Join(sandboxDir, oldSandbox), path.Join(sandboxDir, newSandbox), oldSbdesc, newSbdesc, dryRun)
+	}
+	scripts := []string{globals.ScriptStart, globals.ScriptStop, globals.ScriptMy}
+	for _, dir := range []string{oldSandbox, newSandbox} {
+		for _, script := range scripts {
+			if !common.ExecExists(path.Join(dir, script)) {
+				common.Exit(1, fmt.Sprintf(globals.ErrScriptNotFoundInUpper, script, dir),
+					"The upgrade only works between SINGLE deployments, or between topologies of type "+
+						upgradableTopologyList())
+			}
+		}
+	}
+	mysqlUpgrade := path.Join(newSbdesc.Basedir, "bin", "mysql_upgrade")
+	if !common.ExecExists(mysqlUpgrade) {
+		_ = common.WriteString("", path.Join(newSandbox, "no_upgrade"))
+		return errors.Errorf("mysql_upgrade not found in %s. Upgrade is not possible", newSbdesc.Basedir)
+	}
+	upgradeWithServer, err := checkUpgradeVersions(oldSbdesc, newSbdesc)
+	if err != nil {
+		common.Exitf(1, "%s", err)
+	}
+	newSandboxOldData := path.Join(newSandbox, globals.DataDirName+"-"+newSandbox)
+	if common.DirExists(newSandboxOldData) {
+		return fmt.Errorf("sandbox '%s' is already the upgrade from an older version", newSandbox)
+	}
+	err = upgradeNode(oldSandbox, newSandbox, newSandboxOldData, upgradeWithServer, verbose, dryRun)
+	if err != nil {
+		return err
+	}
 	fmt.Println("")
 	common.CondPrintf("The data directory from %s/data is preserved in %s\n", newSandbox, newSandboxOldData)
 	common.CondPrintf("The data directory from %s/data is now used in %s/data\n", oldSandbox, newSandbox)
@@ -460,8 +489,16 @@ Users can still delete locked sandboxes manually.`,
 		Short: "Upgrades a sandbox to a newer version",
 		Long: `Upgrades a sandbox to a newer version.
 The sandbox with the new version must exist already.
-The data directory of the old sandbox will be moved to the new one.`,
-		Example:     "dbdeployer admin upgrade msb_8_0_11 msb_8_0_12",
+The data directory of the old sandbox will be moved to the new one.
+Replication sandboxes (master-slave, fan-in, all-masters) and group replication
+sandboxes are upgraded node by node, while running: the new sandbox must have the same topology
+and number of nodes. Slaves and secondaries are upgraded first, masters and primaries last.
+After each node, replication is checked before moving on to the next one.
+With --dry-run, the upgrade plan is shown without executing it.`,
+		Example: `
+	$ dbdeployer admin upgrade msb_8_0_11 msb_8_0_12
+	$ dbdeployer admin upgrade --dry-run rsandbox_8_0_35 rsandbox_8_0_36
+	$ dbdeployer admin upgrade group_msb_8_0_35 group_msb_8_0_36`,
 		Run:         runUpgradeSandbox,
 		Args:        SandboxNames(2),
 		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 2)},
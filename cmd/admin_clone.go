@@ -0,0 +1,74 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+func cloneSandbox(cmd *cobra.Command, args []string) {
+	sourceDir := getSandboxDirFromArgs(cmd, args)
+	var sd sandbox.SandboxDef
+	var err error
+	sd.SandboxDir = common.DirName(sourceDir)
+	sd.DirName = args[1]
+	if common.DirExists(path.Join(sd.SandboxDir, sd.DirName)) {
+		common.Exitf(1, "sandbox %s already exists in %s", sd.DirName, sd.SandboxDir)
+	}
+	sd.InstalledPorts, err = common.GetInstalledPorts(sd.SandboxDir)
+	common.ErrCheckExitf(err, 1, "error retrieving installed ports: %s", err)
+	sd.InstalledPorts = append(sd.InstalledPorts, defaults.Defaults().ReservedPorts...)
+	sd.SkipStart, _ = cmd.Flags().GetBool(globals.SkipStartLabel)
+	sd.ShellPath = defaults.Defaults().ShellPath
+	sd.RplUser = globals.RplUserValue
+	sd.RplPassword = globals.RplPasswordValue
+	sd.RemoteAccess = globals.RemoteAccessValue
+	sd.BindAddress = globals.BindAddressValue
+
+	err = sandbox.CloneSandbox(sourceDir, sd)
+	common.ErrCheckExitf(err, 1, "error cloning sandbox %s: %s", args[0], err)
+	common.CondPrintf("Sandbox %s cloned into %s\n", args[0], path.Join(sd.SandboxDir, sd.DirName))
+}
+
+var adminCloneCmd = &cobra.Command{
+	Use:   "clone source_sandbox new_sandbox",
+	Short: "Creates a new sandbox from the data of a stopped one",
+	Long: `Creates a new single sandbox using a copy of the data directory of an existing one.
+The source sandbox must be stopped. Its data directory is cloned with copy-on-write
+(reflink) when the file system supports it (btrfs, XFS, APFS), and copied otherwise.
+The new sandbox gets free ports and a new server UUID, and its scripts are generated
+from the templates, as for a freshly deployed sandbox.
+Unlike the 'clone_from' script, this command does not need a running server,
+and works with every version.`,
+	Example: `
+	$ ~/sandboxes/msb_8_0_36/stop
+	$ dbdeployer admin clone msb_8_0_36 msb_8_0_36_test1`,
+	Args:        cobra.ExactArgs(2),
+	Run:         cloneSandbox,
+	Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+}
+
+func init() {
+	adminCmd.AddCommand(adminCloneCmd)
+	adminCloneCmd.Flags().Bool(globals.SkipStartLabel, false, "Does not start the new sandbox")
+}
@@ -0,0 +1,147 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/alexeyco/simpletable"
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
+)
+
+// nodeHealthSummary describes the replication state of a node in one line
+func nodeHealthSummary(node lifecycle.NodeHealth) string {
+	var items []string
+	for _, channel := range node.Channels {
+		label := "replication"
+		if channel.Name != "" {
+			label = channel.Name
+		}
+		threads := "IO/SQL "
+		for _, running := range []bool{channel.IORunning, channel.SQLRunning} {
+			if running {
+				threads += "Y"
+			} else {
+				threads += "N"
+			}
+		}
+		item := fmt.Sprintf("%s: %s", label, threads)
+		if channel.SecondsBehindMaster != nil {
+			item += fmt.Sprintf(" lag %ds", *channel.SecondsBehindMaster)
+		}
+		if channel.MissingGtids != "" {
+			item += " missing " + channel.MissingGtids
+		}
+		items = append(items, item)
+	}
+	if node.GroupMemberState != "" {
+		items = append(items, strings.TrimSpace(fmt.Sprintf("group: %s %s", node.GroupMemberState, node.GroupMemberRole)))
+	}
+	if node.Wsrep != nil {
+		items = append(items, fmt.Sprintf("wsrep: %s %s size %s",
+			node.Wsrep.ClusterStatus, node.Wsrep.LocalState, node.Wsrep.ClusterSize))
+	}
+	return strings.Join(items, "; ")
+}
+
+func printHealthTable(health lifecycle.SandboxHealth) {
+	verdict := "healthy"
+	if !health.Healthy {
+		verdict = "NOT HEALTHY"
+	}
+	fmt.Printf("%s (%s %s): %s\n", health.Name, health.Type, health.Version, verdict)
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: "node"},
+			{Align: simpletable.AlignCenter, Text: "port"},
+			{Align: simpletable.AlignCenter, Text: "up"},
+			{Align: simpletable.AlignCenter, Text: "replication"},
+			{Align: simpletable.AlignCenter, Text: "verdict"},
+		},
+	}
+	for _, node := range health.Nodes {
+		nodeVerdict := "ok"
+		if !node.Healthy {
+			nodeVerdict = strings.Join(node.Problems, "; ")
+		}
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: node.Name},
+			{Align: simpletable.AlignRight, Text: fmt.Sprintf("%d", node.Port)},
+			{Text: fmt.Sprintf("%v", node.Up)},
+			{Text: nodeHealthSummary(node)},
+			{Text: nodeVerdict},
+		})
+	}
+	table.SetStyle(simpletable.StyleCompactLite)
+	table.Println()
+}
+
+func checkSandboxHealth(cmd *cobra.Command, args []string) {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	output := getOutputFormat(cmd)
+	maxLag, _ := cmd.Flags().GetInt(globals.MaxLagLabel)
+
+	var healthList []lifecycle.SandboxHealth
+	allHealthy := true
+	for _, sandboxName := range args {
+		health, err := lifecycle.Health(path.Join(sandboxHome, sandboxName), maxLag)
+		common.ErrCheckExitf(err, 1, "error checking sandbox %s: %s", sandboxName, err)
+		allHealthy = allHealthy && health.Healthy
+		healthList = append(healthList, health)
+	}
+	if output != globals.OutputTable {
+		printStructured(output, outputKindHealth, healthList)
+	} else {
+		for _, health := range healthList {
+			printHealthTable(health)
+		}
+	}
+	if !allHealthy {
+		common.Exit(1)
+	}
+}
+
+var adminHealthCmd = &cobra.Command{
+	Use:   "health sandbox_name [sandbox_name ...]",
+	Short: "Checks the health of sandboxes and their replication",
+	Long: `Connects to every node of the given sandboxes and reports, for each node:
+whether the server is up; the state of the IO and SQL threads of its replication channels,
+with the seconds behind master, the last errors, and the transactions that the master has
+executed and the slave has not (when GTIDs are enabled); the state of the node in a replication group;
+the wsrep status of PXC nodes.
+The command exits with a non-zero code when any node is not healthy.
+With --max-lag, a slave that lags behind its master by more seconds is also considered not healthy.`,
+	Example: `
+	$ dbdeployer admin health rsandbox_8_0_36
+	$ dbdeployer admin health --max-lag=10 --output=json group_msb_8_0_36 msb_8_0_36`,
+	Args:        SandboxNames(1),
+	Run:         checkSandboxHealth,
+	Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+}
+
+func init() {
+	adminCmd.AddCommand(adminHealthCmd)
+	addOutputFlag(adminHealthCmd)
+	adminHealthCmd.Flags().Int(globals.MaxLagLabel, 0, "Maximum seconds behind master for a healthy slave (0 = not checked)")
+}
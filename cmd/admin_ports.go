@@ -0,0 +1,122 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/alexeyco/simpletable"
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func listPortLeases(cmd *cobra.Command, args []string) {
+	output := getOutputFormat(cmd)
+	if common.PortLeaseRegistry == "" {
+		common.Exit(1, "port leases are disabled (SKIP_DBDEPLOYER_PORT_LEASES is set)")
+	}
+	leases, err := common.GetPortLeases()
+	common.ErrCheckExitf(err, 1, "error reading port leases: %s", err)
+	if output != globals.OutputTable {
+		printStructured(output, outputKindPortLeases, leases)
+		return
+	}
+	if len(leases) == 0 {
+		common.CondPrintf("No port leases in %s\n", common.PortLeaseRegistry)
+		return
+	}
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: "port"},
+			{Align: simpletable.AlignCenter, Text: "pid"},
+			{Align: simpletable.AlignCenter, Text: "expires"},
+			{Align: simpletable.AlignCenter, Text: "owner"},
+		},
+	}
+	for _, lease := range leases {
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Align: simpletable.AlignRight, Text: fmt.Sprintf("%d", lease.Port)},
+			{Align: simpletable.AlignRight, Text: fmt.Sprintf("%d", lease.Pid)},
+			{Text: lease.Expires},
+			{Text: lease.Owner},
+		})
+	}
+	table.SetStyle(simpletable.StyleCompactLite)
+	table.Println()
+}
+
+func releasePortLeases(cmd *cobra.Command, args []string) {
+	all, _ := cmd.Flags().GetBool(globals.AllLabel)
+	if len(args) == 0 && !all {
+		common.Exitf(1, "indicate the ports to release, or use --%s", globals.AllLabel)
+	}
+	if len(args) > 0 && all {
+		common.Exitf(1, "ports and --%s should not be used together", globals.AllLabel)
+	}
+	var ports []int
+	for _, arg := range args {
+		port, err := strconv.Atoi(arg)
+		if err != nil {
+			common.Exitf(1, "invalid port '%s'", arg)
+		}
+		ports = append(ports, port)
+	}
+	released, err := common.ReleasePortLeases(ports)
+	common.ErrCheckExitf(err, 1, "error releasing port leases: %s", err)
+	if len(released) == 0 {
+		common.CondPrintf("No leases released\n")
+		return
+	}
+	common.CondPrintf("Released leases for ports %s\n", common.IntSliceToSeparatedString(released, " "))
+}
+
+var (
+	adminPortsCmd = &cobra.Command{
+		Use:   "ports",
+		Short: "Shows the ports leased by running deployments",
+		Long: fmt.Sprintf(`Shows the ports leased by running deployments.
+When dbdeployer looks for free ports, it skips the ports that are used by installed sandboxes,
+the ones that can't be bound on the local host, and the ones leased by other dbdeployer processes.
+The ports it finds are leased to the current process for %s, so that concurrent deployments
+don't pick the same ports. Once the sandbox is installed, its ports are found in its directory.
+Leases are stored in ~/.dbdeployer/ports.json.
+Setting SKIP_DBDEPLOYER_PORT_LEASES disables leases and the bindability check.`, common.PortLeaseTTL),
+		Args: cobra.NoArgs,
+		Run:  listPortLeases,
+	}
+
+	adminPortsReleaseCmd = &cobra.Command{
+		Use:   "release [port ...]",
+		Short: "Releases port leases",
+		Long: `Releases the leases for the given ports, or all leases with --all.
+It is only needed when a deployment was interrupted and its ports must be available before the lease expires.`,
+		Example: `
+	$ dbdeployer admin ports release 8036 18036
+	$ dbdeployer admin ports release --all`,
+		Run: releasePortLeases,
+	}
+)
+
+func init() {
+	adminCmd.AddCommand(adminPortsCmd)
+	adminPortsCmd.AddCommand(adminPortsReleaseCmd)
+	addOutputFlag(adminPortsCmd)
+	adminPortsReleaseCmd.Flags().Bool(globals.AllLabel, false, "Releases all leases")
+}
@@ -0,0 +1,182 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/alexeyco/simpletable"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+)
+
+// snapshotSandboxDir returns the full path of a sandbox in the sandbox home.
+// The sandbox may have been removed, while its snapshots are still around
+func snapshotSandboxDir(cmd *cobra.Command, sandboxName string) string {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	return path.Join(sandboxHome, sandboxName)
+}
+
+// getSandboxDirFromArgs returns the full path of the sandbox named in the first argument
+func getSandboxDirFromArgs(cmd *cobra.Command, args []string) string {
+	sandboxDir := snapshotSandboxDir(cmd, args[0])
+	if !common.DirExists(sandboxDir) {
+		sandboxHome := common.DirName(sandboxDir)
+		common.Exitf(1, globals.ErrDirectoryNotFoundInUpper, args[0], sandboxHome)
+	}
+	return sandboxDir
+}
+
+func createSnapshot(cmd *cobra.Command, args []string) {
+	sandboxDir := getSandboxDirFromArgs(cmd, args)
+	snapshotName := ""
+	if len(args) > 1 {
+		snapshotName = args[1]
+	}
+	info, err := ops.CreateSnapshot(sandboxDir, snapshotName)
+	common.ErrCheckExitf(err, 1, "error creating snapshot: %s", err)
+	common.CondPrintf("Snapshot %s created (%s)\n", info.Name, humanize.Bytes(uint64(info.Size)))
+}
+
+func listSnapshots(cmd *cobra.Command, args []string) {
+	sandboxDir := ""
+	if len(args) > 0 {
+		sandboxDir = snapshotSandboxDir(cmd, args[0])
+	}
+	output := getOutputFormat(cmd)
+	snapshots, err := ops.ListSnapshots(sandboxDir)
+	common.ErrCheckExitf(err, 1, "error listing snapshots: %s", err)
+	if output != globals.OutputTable {
+		printStructured(output, outputKindSnapshots, snapshots)
+		return
+	}
+	if len(snapshots) == 0 {
+		return
+	}
+	table := simpletable.New()
+	table.Header = &simpletable.Header{
+		Cells: []*simpletable.Cell{
+			{Align: simpletable.AlignCenter, Text: "sandbox"},
+			{Align: simpletable.AlignCenter, Text: "snapshot"},
+			{Align: simpletable.AlignCenter, Text: "version"},
+			{Align: simpletable.AlignCenter, Text: "nodes"},
+			{Align: simpletable.AlignRight, Text: "size"},
+			{Align: simpletable.AlignCenter, Text: "created"},
+		},
+	}
+	for _, info := range snapshots {
+		table.Body.Cells = append(table.Body.Cells, []*simpletable.Cell{
+			{Text: info.SandboxDir},
+			{Text: info.Name},
+			{Text: info.Version},
+			{Align: simpletable.AlignRight, Text: fmt.Sprintf("%d", len(info.Nodes))},
+			{Align: simpletable.AlignRight, Text: humanize.Bytes(uint64(info.Size))},
+			{Text: info.Created},
+		})
+	}
+	table.SetStyle(simpletable.StyleCompactLite)
+	table.Println()
+}
+
+func restoreSnapshot(cmd *cobra.Command, args []string) {
+	sandboxDir := getSandboxDirFromArgs(cmd, args)
+	force, _ := cmd.Flags().GetBool(globals.ForceLabel)
+	snapshotName := ""
+	if len(args) > 1 {
+		snapshotName = args[1]
+	}
+	err := ops.RestoreSnapshot(sandboxDir, snapshotName, force)
+	common.ErrCheckExitf(err, 1, "error restoring snapshot: %s", err)
+	common.CondPrintf("Sandbox %s restored\n", args[0])
+}
+
+func deleteSnapshot(cmd *cobra.Command, args []string) {
+	err := ops.DeleteSnapshot(snapshotSandboxDir(cmd, args[0]), args[1])
+	common.ErrCheckExitf(err, 1, "error deleting snapshot: %s", err)
+	common.CondPrintf("Snapshot %s of sandbox %s deleted\n", args[1], args[0])
+}
+
+var (
+	adminSnapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Saves and restores the data of a sandbox",
+		Long: fmt.Sprintf(`Saves and restores the data directories of a sandbox.
+A snapshot is a compressed archive of the data directory and the description of every node,
+stored under %s, in a directory named after the sandbox and a hash of its full path.
+The sandbox is stopped while the snapshot is created or restored, and restarted afterwards
+if it was running.`, ops.SnapshotsDir("")),
+	}
+
+	adminSnapshotCreateCmd = &cobra.Command{
+		Use:   "create sandbox_name [snapshot_name]",
+		Short: "Creates a snapshot of a sandbox",
+		Long: `Creates a snapshot of a sandbox.
+If no snapshot name is given, the snapshot is named after the current date and time.`,
+		Example: `
+	$ dbdeployer admin snapshot create msb_8_0_36
+	$ dbdeployer admin snapshot create rsandbox_8_0_36 after-load`,
+		Args:        cobra.RangeArgs(1, 2),
+		Run:         createSnapshot,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminSnapshotListCmd = &cobra.Command{
+		Use:     "list [sandbox_name]",
+		Aliases: []string{"ls"},
+		Short:   "Lists the snapshots of one or all sandboxes",
+		Args:    cobra.MaximumNArgs(1),
+		Run:     listSnapshots,
+	}
+
+	adminSnapshotRestoreCmd = &cobra.Command{
+		Use:   "restore sandbox_name [snapshot_name]",
+		Short: "Restores a snapshot into a sandbox",
+		Long: `Replaces the data directories of a sandbox with the ones saved in a snapshot.
+If no snapshot name is given, the most recent snapshot is used.
+A snapshot taken from a different version or flavor is refused, unless --force is used.`,
+		Example: `
+	$ dbdeployer admin snapshot restore msb_8_0_36
+	$ dbdeployer admin snapshot restore rsandbox_8_0_36 after-load`,
+		Args:        cobra.RangeArgs(1, 2),
+		Run:         restoreSnapshot,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminSnapshotDeleteCmd = &cobra.Command{
+		Use:         "delete sandbox_name snapshot_name",
+		Aliases:     []string{"remove", "rm"},
+		Short:       "Deletes a snapshot",
+		Args:        cobra.ExactArgs(2),
+		Run:         deleteSnapshot,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+)
+
+func init() {
+	adminCmd.AddCommand(adminSnapshotCmd)
+	adminSnapshotCmd.AddCommand(adminSnapshotCreateCmd)
+	adminSnapshotCmd.AddCommand(adminSnapshotListCmd)
+	adminSnapshotCmd.AddCommand(adminSnapshotRestoreCmd)
+	adminSnapshotCmd.AddCommand(adminSnapshotDeleteCmd)
+	addOutputFlag(adminSnapshotListCmd)
+	adminSnapshotRestoreCmd.Flags().Bool(globals.ForceLabel, false, "Restores a snapshot taken from a different version or flavor")
+}
@@ -0,0 +1,89 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+func runSwitchover(cmd *cobra.Command, args []string) {
+	sandboxDir := getSandboxDirFromArgs(cmd, args)
+	newMaster, _ := cmd.Flags().GetString(globals.NewMasterLabel)
+	if newMaster == "" {
+		common.Exitf(1, "option --%s is required", globals.NewMasterLabel)
+	}
+	err := sandbox.Switchover(sandboxDir, newMaster)
+	common.ErrCheckExitf(err, 1, "error during switchover: %s", err)
+	common.CondPrintf("%s is the new master of %s\n", newMaster, args[0])
+}
+
+func runFailover(cmd *cobra.Command, args []string) {
+	sandboxDir := getSandboxDirFromArgs(cmd, args)
+	newMaster, _ := cmd.Flags().GetString(globals.NewMasterLabel)
+	err := sandbox.Failover(sandboxDir, newMaster)
+	common.ErrCheckExitf(err, 1, "error during failover: %s", err)
+}
+
+var (
+	adminSwitchoverCmd = &cobra.Command{
+		Use:   "switchover sandbox_name --new-master=node_name",
+		Short: "Promotes a slave to master in a running master-slave sandbox",
+		Long: `Promotes a slave to master in a running master-slave sandbox. GTID must be enabled.
+The current master becomes read-only, and the slaves apply all its transactions.
+Then the new master stops replicating and becomes writable, while the other nodes,
+including the old master, replicate from it with GTID auto-position.
+The slaves get the same read-only mode that was used when the sandbox was created
+(--read-only-slaves or --super-read-only-slaves).
+The directories of the nodes keep their names, but the scripts of the sandbox
+(m, s1, s2, n1 ..., use_all, use_all_masters, use_all_slaves, check_slaves, initialize_slaves, etc.)
+are written again, so that they follow the new roles.`,
+		Example: `
+	$ dbdeployer admin switchover rsandbox_8_0_36 --new-master=node1
+	$ ~/sandboxes/rsandbox_8_0_36/m -e 'select @@port'`,
+		Args:        SandboxNames(1),
+		Run:         runSwitchover,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+
+	adminFailoverCmd = &cobra.Command{
+		Use:   "failover sandbox_name [--new-master=node_name]",
+		Short: "Promotes a slave to master in a master-slave sandbox whose master is not running",
+		Long: `Promotes a slave to master in a master-slave sandbox whose master is not running. GTID must be enabled.
+The running slaves apply all the transactions that they have received. Then the slave that
+has executed all the transactions of the others becomes the new master, unless --new-master is given.
+The other running slaves replicate from it with GTID auto-position.
+The old master is left out of replication, and it will be read-only, like the other slaves,
+if the sandbox was created with --read-only-slaves or --super-read-only-slaves.
+As with switchover, the scripts of the sandbox are written again to follow the new roles.`,
+		Example: `
+	$ ~/sandboxes/rsandbox_8_0_36/master/stop
+	$ dbdeployer admin failover rsandbox_8_0_36`,
+		Args:        SandboxNames(1),
+		Run:         runFailover,
+		Annotations: map[string]string{"export": makeExportArgs(globals.ExportSandboxDir, 1)},
+	}
+)
+
+func init() {
+	adminCmd.AddCommand(adminSwitchoverCmd)
+	adminCmd.AddCommand(adminFailoverCmd)
+	adminSwitchoverCmd.Flags().String(globals.NewMasterLabel, "", "Name of the node that becomes the master")
+	adminFailoverCmd.Flags().String(globals.NewMasterLabel, "", "Name of the node that becomes the master (default: the most up-to-date slave)")
+}
@@ -0,0 +1,452 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
+)
+
+// Topologies that can be upgraded node by node
+var upgradableTopologies = map[string]bool{
+	globals.MasterSlaveLabel: true,
+	globals.FanInLabel:       true,
+	globals.AllMastersLabel:  true,
+	"group-multi-primary":    true,
+	"group-single-primary":   true,
+}
+
+const (
+	upgradeHealthTimeout = 120 * time.Second
+	upgradeHealthPoll    = 2 * time.Second
+
+	groupNameOption  = "loose-group_replication_group_name"
+	groupSeedsOption = "loose-group-replication-group-seeds"
+)
+
+// upgradeStep is the upgrade of one node of a topology
+type upgradeStep struct {
+	name    string
+	role    string
+	oldDir  string
+	newDir  string
+	oldPort int
+	newPort int
+	// Nodes with a higher rank are upgraded later. Masters and primaries are upgraded last
+	rank int
+}
+
+func upgradableTopologyList() string {
+	var topologies []string
+	for topology := range upgradableTopologies {
+		topologies = append(topologies, topology)
+	}
+	sort.Strings(topologies)
+	return strings.Join(topologies, ", ")
+}
+
+func isGroupTopology(sbType string) bool {
+	return strings.HasPrefix(sbType, "group-")
+}
+
+// sortUpgradeSteps puts the steps in upgrade order:
+// slaves and secondaries first, then masters and primaries, each group in node order
+func sortUpgradeSteps(steps []upgradeStep) {
+	sort.SliceStable(steps, func(i, j int) bool {
+		if steps[i].rank != steps[j].rank {
+			return steps[i].rank < steps[j].rank
+		}
+		return steps[i].name < steps[j].name
+	})
+}
+
+// pairUpgradeNodes matches the nodes of the old sandbox with the ones of the new sandbox, by name
+func pairUpgradeNodes(oldSandbox, newSandbox string) ([]upgradeStep, error) {
+	oldNodes, err := lifecycle.NodeDirs(oldSandbox)
+	if err != nil {
+		return nil, err
+	}
+	newNodes, err := lifecycle.NodeDirs(newSandbox)
+	if err != nil {
+		return nil, err
+	}
+	if len(oldNodes) != len(newNodes) {
+		return nil, fmt.Errorf("sandbox %s has %d nodes, while %s has %d",
+			oldSandbox, len(oldNodes), newSandbox, len(newNodes))
+	}
+	var steps []upgradeStep
+	for _, oldDir := range oldNodes {
+		name := common.BaseName(oldDir)
+		newDir := path.Join(newSandbox, name)
+		if !common.DirExists(newDir) {
+			return nil, fmt.Errorf("node %s not found in %s", name, newSandbox)
+		}
+		step := upgradeStep{name: name, oldDir: oldDir, newDir: newDir}
+		for dir, port := range map[string]*int{oldDir: &step.oldPort, newDir: &step.newPort} {
+			sbDesc, err := common.ReadSandboxDescription(dir)
+			if err != nil {
+				return nil, err
+			}
+			if len(sbDesc.Port) == 0 {
+				return nil, fmt.Errorf("no port found in the description of %s", dir)
+			}
+			*port = sbDesc.Port[0]
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// setReplicationRoles ranks the nodes of a replication topology:
+// a node from which other nodes replicate is a master, and it is upgraded after its slaves
+func setReplicationRoles(steps []upgradeStep) error {
+	masterPorts := make(map[int]bool)
+	for _, step := range steps {
+		channels, err := lifecycle.ReplicationChannels(step.oldDir)
+		if err != nil {
+			return errors.Wrapf(err, "error reading replication status of %s", step.name)
+		}
+		for _, channel := range channels {
+			masterPorts[channel.MasterPort] = true
+		}
+	}
+	for i, step := range steps {
+		steps[i].role = "slave"
+		if masterPorts[step.oldPort] {
+			steps[i].role = "master"
+			steps[i].rank = 1
+		}
+	}
+	return nil
+}
+
+// setGroupRoles ranks the nodes of a group: the primary is upgraded last.
+// In a multi-primary group, all nodes have the same rank
+func setGroupRoles(steps []upgradeStep) error {
+	members, err := lifecycle.GroupMembers(steps[0].oldDir)
+	if err != nil {
+		return errors.Wrapf(err, "error reading group members from %s", steps[0].name)
+	}
+	roles := make(map[int]string)
+	for _, member := range members {
+		roles[member.Port] = member.Role
+	}
+	for i, step := range steps {
+		steps[i].role = strings.ToLower(roles[step.oldPort])
+		if steps[i].role == "" {
+			steps[i].role = "member"
+		}
+		if steps[i].role == "primary" {
+			steps[i].rank = 1
+		}
+	}
+	return nil
+}
+
+// readGroupOption returns the value of a group replication option from the configuration file of a node
+func readGroupOption(nodeDir, option string) (string, error) {
+	config, err := common.ParseConfigFile(path.Join(nodeDir, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return "", err
+	}
+	for _, kv := range config["mysqld"] {
+		if kv.Key == option {
+			return strings.Trim(strings.TrimSpace(kv.Value), `"'`), nil
+		}
+	}
+	return "", fmt.Errorf("option %s not found in %s", option, nodeDir)
+}
+
+// adoptGroupName replaces the group name in the configuration files of the new nodes,
+// so that they join the group of the old sandbox
+func adoptGroupName(steps []upgradeStep, oldGroupName string, dryRun bool) error {
+	for _, step := range steps {
+		newGroupName, err := readGroupOption(step.newDir, groupNameOption)
+		if err != nil {
+			return err
+		}
+		if newGroupName == oldGroupName {
+			continue
+		}
+		configFile := path.Join(step.newDir, globals.ScriptMySandboxCnf)
+		fmt.Printf("# replace group name %s with %s in %s\n", newGroupName, oldGroupName, configFile)
+		if dryRun {
+			continue
+		}
+		text, err := common.SlurpAsString(configFile)
+		if err != nil {
+			return err
+		}
+		err = common.WriteString(strings.ReplaceAll(text, newGroupName, oldGroupName), configFile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinGroup starts group replication in an upgraded node, using the old and new nodes as seeds
+func joinGroup(step upgradeStep, seeds string, dryRun bool) error {
+	queries := []string{
+		fmt.Sprintf("SET GLOBAL group_replication_group_seeds='%s'", seeds),
+		"START GROUP_REPLICATION",
+	}
+	for _, query := range queries {
+		fmt.Printf("# %s: %s\n", step.name, query)
+	}
+	if dryRun {
+		return nil
+	}
+	db, err := lifecycle.Connect(step.newDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, query := range queries {
+		_, err = db.Exec(query)
+		if err != nil {
+			return fmt.Errorf("error running '%s' in %s: %s", query, step.newDir, err)
+		}
+	}
+	return nil
+}
+
+// repointSlaves makes the replication channels that read from an upgraded node use its new port
+func repointSlaves(currentDirs []string, movedPorts map[int]int) error {
+	for _, nodeDir := range currentDirs {
+		channels, err := lifecycle.ReplicationChannels(nodeDir)
+		if err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			newPort, ok := movedPorts[channel.MasterPort]
+			if !ok {
+				continue
+			}
+			fmt.Printf("# %s: channel '%s' from port %d to port %d\n",
+				common.BaseName(nodeDir), channel.Name, channel.MasterPort, newPort)
+			err = lifecycle.RepointChannel(nodeDir, channel, channel.MasterHost, newPort)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkReplicationHealth returns nil when all the replication channels of the given nodes are running
+func checkReplicationHealth(currentDirs []string) error {
+	for _, nodeDir := range currentDirs {
+		channels, err := lifecycle.ReplicationChannels(nodeDir)
+		if err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			if !channel.IORunning || !channel.SQLRunning {
+				return fmt.Errorf("channel '%s' of %s is not running (IO error: '%s' - SQL error: '%s')",
+					channel.Name, common.BaseName(nodeDir), channel.LastIOError, channel.LastSQLError)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGroupHealth returns nil when the given node sees all the members of the group online
+func checkGroupHealth(nodeDir string, expectedMembers int) error {
+	members, err := lifecycle.GroupMembers(nodeDir)
+	if err != nil {
+		return err
+	}
+	online := 0
+	for _, member := range members {
+		if member.State == "ONLINE" {
+			online++
+		}
+	}
+	if online != expectedMembers {
+		return fmt.Errorf("%s sees %d members online out of %d", common.BaseName(nodeDir), online, expectedMembers)
+	}
+	return nil
+}
+
+// waitHealthy repeats a health check until it succeeds or the time is over
+func waitHealthy(check func() error) error {
+	var err error
+	deadline := time.Now().Add(upgradeHealthTimeout)
+	for time.Now().Before(deadline) {
+		err = check()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(upgradeHealthPoll)
+	}
+	return errors.Wrapf(err, "replication not healthy after %s", upgradeHealthTimeout)
+}
+
+// upgradeTopology upgrades a replication or group sandbox to a newer one with the same topology,
+// one node at a time: slaves and secondaries first, masters and primaries last.
+// After each node, the replication is checked before continuing.
+func upgradeTopology(oldSandbox, newSandbox string, oldSbdesc, newSbdesc common.SandboxDescription, dryRun bool) error {
+	if oldSbdesc.SBType != newSbdesc.SBType {
+		return fmt.Errorf("sandbox %s is of type %s, while %s is of type %s",
+			oldSandbox, oldSbdesc.SBType, newSandbox, newSbdesc.SBType)
+	}
+	upgradeWithServer, err := checkUpgradeVersions(oldSbdesc, newSbdesc)
+	if err != nil {
+		return err
+	}
+	if !upgradeWithServer {
+		upgradeWithTool, err := common.HasCapability(newSbdesc.Flavor, common.UpgradeWithTool, newSbdesc.Version)
+		if err != nil {
+			return errors.Wrapf(err, "error detecting upgrade capability")
+		}
+		mysqlUpgrade := path.Join(newSbdesc.Basedir, "bin", "mysql_upgrade")
+		if !upgradeWithTool || !common.ExecExists(mysqlUpgrade) {
+			return errors.Errorf("mysql_upgrade not found in %s. Upgrade is not possible", newSbdesc.Basedir)
+		}
+	}
+	status, err := lifecycle.Status(oldSandbox)
+	if err != nil {
+		return err
+	}
+	for _, nodeStatus := range status.Nodes {
+		if !nodeStatus.Running {
+			return fmt.Errorf("node %s of sandbox %s is not running. "+
+				"A topology can only be upgraded while it is running", nodeStatus.Name, oldSandbox)
+		}
+	}
+
+	steps, err := pairUpgradeNodes(oldSandbox, newSandbox)
+	if err != nil {
+		return err
+	}
+	preservedData := globals.DataDirName + "-" + common.BaseName(newSandbox)
+	for _, step := range steps {
+		if common.DirExists(path.Join(step.newDir, preservedData)) {
+			return fmt.Errorf("sandbox '%s' is already the upgrade from an older version", newSandbox)
+		}
+	}
+	isGroup := isGroupTopology(oldSbdesc.SBType)
+	if isGroup {
+		err = setGroupRoles(steps)
+	} else {
+		err = setReplicationRoles(steps)
+	}
+	if err != nil {
+		return err
+	}
+	sortUpgradeSteps(steps)
+
+	var oldGroupName, seeds string
+	if isGroup {
+		oldGroupName, err = readGroupOption(steps[0].oldDir, groupNameOption)
+		if err != nil {
+			return err
+		}
+		oldSeeds, err := readGroupOption(steps[0].oldDir, groupSeedsOption)
+		if err != nil {
+			return err
+		}
+		newSeeds, err := readGroupOption(steps[0].newDir, groupSeedsOption)
+		if err != nil {
+			return err
+		}
+		seeds = oldSeeds + "," + newSeeds
+	}
+
+	fmt.Printf("# Upgrade plan for %s %s (%s) to %s (%s)\n", oldSbdesc.SBType, oldSandbox, oldSbdesc.Version,
+		newSandbox, newSbdesc.Version)
+	for i, step := range steps {
+		fmt.Printf("# %d. %-8s %-8s port %d -> %d\n", i+1, step.name, step.role, step.oldPort, step.newPort)
+	}
+	fmt.Println("")
+
+	fmt.Printf("# stop %s\n", newSandbox)
+	if !dryRun {
+		err = lifecycle.Stop(newSandbox)
+		if err != nil {
+			return errors.Wrapf(err, globals.ErrWhileStoppingSandbox, newSandbox)
+		}
+	}
+	if isGroup {
+		err = adoptGroupName(steps, oldGroupName, dryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	movedPorts := make(map[int]int)
+	upgraded := make(map[string]bool)
+	for i, step := range steps {
+		fmt.Printf("# --- step %d: %s (%s)\n", i+1, step.name, step.role)
+		err = upgradeNode(step.oldDir, step.newDir, path.Join(step.newDir, preservedData), upgradeWithServer, true, dryRun)
+		if err != nil {
+			return err
+		}
+		movedPorts[step.oldPort] = step.newPort
+		upgraded[step.name] = true
+
+		// The nodes that are running now: the upgraded ones from the new sandbox, the others from the old one
+		var currentDirs []string
+		for _, s := range steps {
+			if upgraded[s.name] {
+				currentDirs = append(currentDirs, s.newDir)
+			} else {
+				currentDirs = append(currentDirs, s.oldDir)
+			}
+		}
+		if isGroup {
+			err = joinGroup(step, seeds, dryRun)
+		} else {
+			fmt.Printf("# channels reading from port %d will read from port %d\n", step.oldPort, step.newPort)
+			if !dryRun {
+				err = repointSlaves(currentDirs, movedPorts)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# check replication health\n")
+		if dryRun {
+			continue
+		}
+		if isGroup {
+			err = waitHealthy(func() error { return checkGroupHealth(step.newDir, len(steps)) })
+		} else {
+			err = waitHealthy(func() error { return checkReplicationHealth(currentDirs) })
+		}
+		if err != nil {
+			return errors.Wrapf(err, "upgrade stopped after node %s", step.name)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	fmt.Println("")
+	common.CondPrintf("The data directories of %s are preserved in %s/*/%s\n", newSandbox, newSandbox, preservedData)
+	common.CondPrintf("The data directories of %s are now used in %s\n", oldSandbox, newSandbox)
+	common.CondPrintf("%s is not operational and can be deleted\n", oldSandbox)
+	return nil
+}
@@ -0,0 +1,79 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortUpgradeSteps(t *testing.T) {
+	var testData = []struct {
+		label    string
+		steps    []upgradeStep
+		expected string
+	}{
+		{
+			label: "master-slave",
+			steps: []upgradeStep{
+				{name: "master", rank: 1},
+				{name: "node1"},
+				{name: "node2"},
+			},
+			expected: "node1 node2 master",
+		},
+		{
+			label: "fan-in",
+			steps: []upgradeStep{
+				{name: "node1", rank: 1},
+				{name: "node2", rank: 1},
+				{name: "node3"},
+			},
+			expected: "node3 node1 node2",
+		},
+		{
+			label: "single-primary",
+			steps: []upgradeStep{
+				{name: "node2"},
+				{name: "node1", rank: 1},
+				{name: "node3"},
+			},
+			expected: "node2 node3 node1",
+		},
+		{
+			label: "all-masters",
+			steps: []upgradeStep{
+				{name: "node3", rank: 1},
+				{name: "node1", rank: 1},
+				{name: "node2", rank: 1},
+			},
+			expected: "node1 node2 node3",
+		},
+	}
+	for _, td := range testData {
+		sortUpgradeSteps(td.steps)
+		var names []string
+		for _, step := range td.steps {
+			names = append(names, step.name)
+		}
+		result := strings.Join(names, " ")
+		if result != td.expected {
+			t.Errorf("%s: expected order '%s' - found '%s'", td.label, td.expected, result)
+		} else {
+			t.Logf("ok - %s: %s", td.label, result)
+		}
+	}
+}
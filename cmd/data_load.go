@@ -49,7 +49,72 @@ func loadArchive(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("command 'get' requires a database name and a destination sandbox")
 	}
 	overwrite, _ := cmd.Flags().GetBool(globals.OverwriteLabel)
-	return data_load.LoadArchive(args[0], args[1], overwrite)
+	verify, _ := cmd.Flags().GetBool(globals.VerifyLabel)
+	if verify {
+		if overwrite {
+			return fmt.Errorf("options --%s and --%s are mutually exclusive", globals.VerifyLabel, globals.OverwriteLabel)
+		}
+		return data_load.VerifyArchive(args[0], args[1])
+	}
+	threads, _ := cmd.Flags().GetInt(globals.ThreadsLabel)
+	return data_load.LoadArchive(args[0], args[1], overwrite, threads)
+}
+
+func addArchive(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("command 'add' requires an archive name and a local file or directory")
+	}
+	flags := cmd.Flags()
+	origin, err := common.AbsolutePath(args[1])
+	if err != nil {
+		return err
+	}
+	var archive data_load.DataDefinition
+	archive.Origin = origin
+	archive.Format, _ = flags.GetString(globals.FormatLabel)
+	archive.Database, _ = flags.GetString(globals.DatabaseLabel)
+	archive.DdlFile, _ = flags.GetString(globals.DdlFileLabel)
+	archive.CsvHeader, _ = flags.GetBool(globals.CsvHeaderLabel)
+	archive.Description, _ = flags.GetString(globals.DescLabel)
+	err = data_load.AddLocalArchive(args[0], archive)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("archive %s recorded at %s\n", args[0], defaults.ArchivesFile)
+	return nil
+}
+
+func archivesStatus(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("command 'status' requires a sandbox name")
+	}
+	return data_load.ArchivesStatus(args[0])
+}
+
+func generateData(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("command 'generate' requires a destination sandbox")
+	}
+	flags := cmd.Flags()
+	specFile, _ := flags.GetString(globals.SchemaLabel)
+	if specFile == "" {
+		return fmt.Errorf("command 'generate' requires a data specification (--%s)", globals.SchemaLabel)
+	}
+	spec, err := data_load.ReadGeneratorSpec(specFile)
+	if err != nil {
+		return err
+	}
+	if flags.Changed(globals.RowsLabel) {
+		spec.Rows, _ = flags.GetInt(globals.RowsLabel)
+	}
+	if flags.Changed(globals.SeedLabel) {
+		spec.Seed, _ = flags.GetInt64(globals.SeedLabel)
+	}
+	if flags.Changed(globals.BatchSizeLabel) {
+		spec.BatchSize, _ = flags.GetInt(globals.BatchSizeLabel)
+	}
+	overwrite, _ := flags.GetBool(globals.OverwriteLabel)
+	return data_load.GenerateData(spec, args[0], overwrite)
 }
 
 func showArchive(cmd *cobra.Command, args []string) error {
@@ -77,15 +142,30 @@ func showArchive(cmd *cobra.Command, args []string) error {
 			fmt.Printf("URL:           %s\n", archive.Origin)
 			fmt.Printf("File name:     %s\n", archive.FileName)
 			fmt.Printf("Size:          %s\n", humanize.Bytes(archive.Size))
-			fmt.Printf("internal dir   %s\n", archive.InternalDirectory)
-			fmt.Printf("Loading commands:\n")
-			delta := 1
-			if archive.ChangeDirectory {
-				fmt.Printf("\t%2d cd %s\n", delta, dbName)
-				delta = 2
+			if archive.Format != "" {
+				fmt.Printf("Format:        %s\n", archive.Format)
+				fmt.Printf("Database:      %s\n", archive.Database)
+				if archive.Format == data_load.FormatCsv {
+					fmt.Printf("DDL file:      %s\n", archive.DdlFile)
+					fmt.Printf("CSV header:    %v\n", archive.CsvHeader)
+				}
+			} else {
+				fmt.Printf("internal dir   %s\n", archive.InternalDirectory)
+				fmt.Printf("Loading commands:\n")
+				delta := 1
+				if archive.ChangeDirectory {
+					fmt.Printf("\t%2d cd %s\n", delta, dbName)
+					delta = 2
+				}
+				for i, line := range archive.LoadCommands {
+					fmt.Printf("\t%2d %s\n", i+delta, line)
+				}
 			}
-			for i, line := range archive.LoadCommands {
-				fmt.Printf("\t%2d %s\n", i+delta, line)
+			if len(archive.Verifications) > 0 {
+				fmt.Printf("Verification queries:\n")
+				for i, verification := range archive.Verifications {
+					fmt.Printf("\t%2d %s (expected: %s)\n", i+1, verification.Query, verification.Expected)
+				}
 			}
 		}
 
@@ -175,8 +255,81 @@ var (
 	dataLoadGetCmd = &cobra.Command{
 		Use:   "get archive-name sandbox-name",
 		Short: "Loads an archived database into a sandbox",
-		Long:  "Loads an archived database into a sandbox",
-		RunE:  loadArchive,
+		Long: `
+Loads an archived database into a sandbox.
+If the archive was already loaded into the sandbox, it is skipped, unless
+--overwrite is used. For local archives, --overwrite also drops the tables
+that the archive loads, which otherwise stop the load if they exist. After loading, the data is checked with the verification
+queries of the archive. With --verify, the archive is not loaded again, but only
+checked.
+`,
+		RunE: loadArchive,
+	}
+	dataLoadAddCmd = &cobra.Command{
+		Use:   "add archive-name {file|directory} --format=format-name",
+		Short: "Adds an archive from a local dump or directory",
+		Long: `
+Adds to the archives a local file or directory, which can then be loaded with
+'dbdeployer data-load get'. The tables of a local archive are loaded in parallel.
+Formats:
+  mysqldump  a file created by mysqldump (.sql or .sql.gz). If the dump was made
+             without --databases, --database is required, to choose where to load it
+  mydumper   a directory created by mydumper
+  csv        a directory with a DDL file (--ddl-file, default schema.sql) and one
+             CSV file for each table, named after the table. Requires --database
+`,
+		Example: `
+$ dbdeployer data-load add mydb /backups/mydb.sql.gz --format=mysqldump --database=mydb
+$ dbdeployer data-load add shop /backups/shop_dump --format=mydumper
+$ dbdeployer data-load add stats /data/stats --format=csv --database=stats --csv-header
+$ dbdeployer data-load get shop msb_8_0_35 --threads=8
+`,
+		RunE: addArchive,
+	}
+	dataLoadGenerateCmd = &cobra.Command{
+		Use:   "generate sandbox-name --schema=spec-file",
+		Short: "Creates tables and fills them with synthetic data",
+		Long: `
+Creates the tables described in a specification file (YAML or JSON)
+and fills them with synthetic data. The data depends only on the specification
+and on the seed, and does not require network access.
+Tables that already exist are not replaced, unless --overwrite is used.
+Column types:
+  sequence   1, 2, 3 ... (primary key). Option: start
+  int        random integer. Options: min, max
+  string     random string. Option: length
+  date       random date. Options: from, to (YYYY-MM-DD)
+  json       random JSON document
+  reference  existing value of a sequence column in a table defined
+             earlier. Option: references (table.column)
+`,
+		Example: `
+$ cat spec.yaml
+database: shop
+seed: 42
+tables:
+  - name: customers
+    columns:
+      - {name: id, type: sequence}
+      - {name: name, type: string, length: 30}
+      - {name: since, type: date, from: "2015-01-01"}
+  - name: orders
+    rows: 5000
+    columns:
+      - {name: id, type: sequence}
+      - {name: customer_id, type: reference, references: customers.id}
+      - {name: amount, type: int, min: 1, max: 500}
+      - {name: details, type: json}
+
+$ dbdeployer data-load generate msb_8_0_35 --schema=spec.yaml --rows=2000
+`,
+		RunE: generateData,
+	}
+	dataLoadStatusCmd = &cobra.Command{
+		Use:   "status sandbox-name",
+		Short: "Shows the archives loaded into a sandbox",
+		Long:  "Shows the archives loaded into a sandbox, and checks whether their data is intact",
+		RunE:  archivesStatus,
 	}
 	dataLoadExportCmd = &cobra.Command{
 		Use:   "export file-name",
@@ -207,6 +360,9 @@ func init() {
 	dataLoadCmd.AddCommand(dataLoadListCmd)
 	dataLoadCmd.AddCommand(dataLoadShowCmd)
 	dataLoadCmd.AddCommand(dataLoadGetCmd)
+	dataLoadCmd.AddCommand(dataLoadStatusCmd)
+	dataLoadCmd.AddCommand(dataLoadGenerateCmd)
+	dataLoadCmd.AddCommand(dataLoadAddCmd)
 	dataLoadCmd.AddCommand(dataLoadExportCmd)
 	dataLoadCmd.AddCommand(dataLoadImportCmd)
 	dataLoadCmd.AddCommand(dataLoadResetCmd)
@@ -214,4 +370,16 @@ func init() {
 	dataLoadListCmd.Flags().BoolP(globals.FullInfoLabel, "", false, "Shows all archive details")
 	dataLoadShowCmd.Flags().BoolP(globals.FullInfoLabel, "", false, "Shows all archive details")
 	dataLoadGetCmd.Flags().BoolP(globals.OverwriteLabel, "", false, "overwrite previously downloaded archive")
+	dataLoadGenerateCmd.Flags().BoolP(globals.OverwriteLabel, "", false, "replace tables that already exist")
+	dataLoadGenerateCmd.Flags().String(globals.SchemaLabel, "", "file (YAML or JSON) describing the tables to generate")
+	dataLoadGenerateCmd.Flags().Int(globals.RowsLabel, 1000, "rows for each table that does not set its own number")
+	dataLoadGenerateCmd.Flags().Int64(globals.SeedLabel, 0, "seed for the random values (overrides the specification)")
+	dataLoadGenerateCmd.Flags().Int(globals.BatchSizeLabel, 500, "rows inserted by each statement")
+	dataLoadAddCmd.Flags().String(globals.FormatLabel, "", "format of the archive (mysqldump, mydumper, csv)")
+	dataLoadAddCmd.Flags().String(globals.DatabaseLabel, "", "database that receives the data")
+	dataLoadAddCmd.Flags().String(globals.DdlFileLabel, "", "file with the table definitions for CSV archives (default: schema.sql)")
+	dataLoadAddCmd.Flags().Bool(globals.CsvHeaderLabel, false, "the CSV files start with a header line")
+	dataLoadAddCmd.Flags().String(globals.DescLabel, "", "description of the archive")
+	dataLoadGetCmd.Flags().Int(globals.ThreadsLabel, 4, "maximum number of tables loaded in parallel from a local archive")
+	dataLoadGetCmd.Flags().BoolP(globals.VerifyLabel, "", false, "verify a previously loaded archive without loading it again")
 }
@@ -23,13 +23,51 @@ import (
 	"github.com/spf13/cobra"
 )
 
+func runDeleteUnusedBinaries(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	if len(args) > 0 {
+		common.Exitf(1, "option --%s does not accept a binaries directory name", globals.UnusedLabel)
+	}
+	var err error
+	options := ops.UnusedBinariesOptions{}
+	options.SandboxBinary, err = getAbsolutePathFromFlag(cmd, "sandbox-binary")
+	common.ErrCheckExitf(err, 1, "error finding absolute path for 'sandbox-binary'")
+	options.SandboxHome, err = getAbsolutePathFromFlag(cmd, "sandbox-home")
+	common.ErrCheckExitf(err, 1, "error finding absolute path for 'sandbox-home'")
+	olderThan, _ := flags.GetString(globals.OlderThanLabel)
+	if olderThan != "" {
+		options.OlderThan, err = common.ParseDuration(olderThan)
+		common.ErrCheckExitf(err, 1, "invalid value for --%s: %s", globals.OlderThanLabel, err)
+	}
+	options.KeepLatest, _ = flags.GetInt(globals.KeepLatestLabel)
+	options.DryRun, _ = flags.GetBool(globals.DryRunLabel)
+	skipConfirm, _ := flags.GetBool(globals.SkipConfirmLabel)
+	options.Confirm = !skipConfirm
+
+	deleted, err := ops.DeleteUnusedBinaries(options)
+	for _, dir := range deleted {
+		fmt.Printf("Directory %s removed\n", dir)
+	}
+	common.ErrCheckExitf(err, 1, "%s", err)
+}
+
 func runDeleteBinaries(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	unused, _ := flags.GetBool(globals.UnusedLabel)
+	if unused {
+		runDeleteUnusedBinaries(cmd, args)
+		return
+	}
+	for _, label := range []string{globals.OlderThanLabel, globals.KeepLatestLabel, globals.DryRunLabel} {
+		if flags.Changed(label) {
+			common.Exitf(1, "option --%s requires --%s", label, globals.UnusedLabel)
+		}
+	}
 	if len(args) < 1 {
 		common.Exit(1,
 			"binaries directory name required.",
 			"You can run 'dbdeployer versions for a list of available binaries'")
 	}
-	flags := cmd.Flags()
 	binariesDir := args[0]
 	skipConfirm, _ := flags.GetBool(globals.SkipConfirmLabel)
 
@@ -45,13 +83,21 @@ func runDeleteBinaries(cmd *cobra.Command, args []string) {
 }
 
 var deleteBinariesCmd = &cobra.Command{
-	Use:   "delete-binaries binaries_dir_name",
+	Use:   "delete-binaries {binaries_dir_name | --unused}",
 	Short: "delete an expanded tarball",
 	Example: `
 	$ dbdeployer delete-binaries 8.0.4
-	$ dbdeployer delete ps5.7.25`,
+	$ dbdeployer delete ps5.7.25
+	$ dbdeployer delete-binaries --unused --dry-run
+	$ dbdeployer delete-binaries --unused --older-than=90d --keep-latest-per-short-version=1`,
 	Long: `Removes the given directory and all its subdirectories.
 It will fail if the directory is still used by any sandbox.
+With --unused, it removes all the directories of binaries that no sandbox uses, according
+to the catalog and to the description of the sandboxes (both server and client binaries count).
+The removal can be limited to the directories that were not modified recently (--older-than,
+accepting values such as 90d, 2w, or 36h), and can keep the newest directories for each flavor
+and short version (--keep-latest-per-short-version).
+Use --dry-run to see which directories would be removed, and how much space would be reclaimed.
 Warning: this command is irreversible!`,
 	Run:         runDeleteBinaries,
 	Annotations: map[string]string{"export": makeExportArgs(globals.ExportVersionDir, 1)},
@@ -60,4 +106,8 @@ Warning: this command is irreversible!`,
 func init() {
 	rootCmd.AddCommand(deleteBinariesCmd)
 	deleteBinariesCmd.Flags().BoolP(globals.SkipConfirmLabel, "", false, "Skips confirmation.")
+	deleteBinariesCmd.Flags().Bool(globals.UnusedLabel, false, "Delete all the binaries that no sandbox uses")
+	deleteBinariesCmd.Flags().String(globals.OlderThanLabel, "", "With --unused, delete only binaries not modified for this long (e.g. 90d)")
+	deleteBinariesCmd.Flags().Int(globals.KeepLatestLabel, 0, "With --unused, keep this many of the newest binaries for each short version")
+	deleteBinariesCmd.Flags().Bool(globals.DryRunLabel, false, "With --unused, show what would be deleted without deleting it")
 }
@@ -52,6 +52,7 @@ func init() {
 	deployCmd.PersistentFlags().Bool(globals.NativeAuthPluginLabel, false, "in 8.0.4+, uses the native password auth plugin")
 	deployCmd.PersistentFlags().Bool(globals.KeepServerUuidLabel, false, "Does not change the server UUID")
 	deployCmd.PersistentFlags().Bool(globals.ForceLabel, false, "If a destination sandbox already exists, it will be overwritten")
+	deployCmd.PersistentFlags().Bool(globals.ReconcileLabel, false, "If a destination sandbox already exists, it is compared with the requested one and changed only when needed")
 	deployCmd.PersistentFlags().Bool(globals.SkipStartLabel, false, "Does not start the database server")
 	deployCmd.PersistentFlags().Bool(globals.DisableMysqlXLabel, false, "Disable MySQLX plugin (8.0.11+)")
 	deployCmd.PersistentFlags().Bool(globals.EnableMysqlXLabel, false, "Enables MySQLX plugin (5.7.12+)")
@@ -67,6 +68,7 @@ func init() {
 	deployCmd.PersistentFlags().Bool(globals.SocketInDatadirLabel, false, "Create socket in datadir instead of $TMPDIR")
 	deployCmd.PersistentFlags().Bool(globals.FlavorInPromptLabel, false, "Add flavor values to prompt")
 	deployCmd.PersistentFlags().Bool(globals.PortAsServerIdLabel, false, "Use the port number as server ID")
+	deployCmd.PersistentFlags().Bool(globals.BasedirFromSystemLabel, false, "Use the server installed by the system packages instead of a version in sandbox-binary")
 
 	setPflag(deployCmd, globals.LogLogDirectoryLabel, "", "", defaults.Defaults().LogDirectory, "Where to store dbdeployer logs", false)
 	setPflag(deployCmd, globals.RemoteAccessLabel, "", "", globals.RemoteAccessValue, "defines the database access ", false)
@@ -144,6 +144,23 @@ func treeRemoteTarballs(cmd *cobra.Command, args []string) {
 	table.Println()
 }
 
+// tarballMatches tells whether a tarball satisfies the filters of 'downloads list'
+func tarballMatches(tb downloads.TarballDescription, version, flavor, OS, arch string) bool {
+	if version != "" && version != "all" && version != tb.Version && version != tb.ShortVersion {
+		return false
+	}
+	if flavor != "" && flavor != "all" && flavor != strings.ToLower(tb.Flavor) {
+		return false
+	}
+	if OS != "" && strings.ToLower(OS) != "all" && OS != strings.ToLower(tb.OperatingSystem) {
+		return false
+	}
+	if arch != "" && !strings.EqualFold(arch, tb.Arch) {
+		return false
+	}
+	return true
+}
+
 func listRemoteTarballs(cmd *cobra.Command, args []string) {
 
 	flavor, _ := cmd.Flags().GetString(globals.FlavorLabel)
@@ -158,6 +175,7 @@ func listRemoteTarballs(cmd *cobra.Command, args []string) {
 	OS = strings.ToLower(OS)
 	flavor = strings.ToLower(flavor)
 	showUrl, _ := cmd.Flags().GetBool(globals.ShowUrlLabel)
+	output := getOutputFormat(cmd)
 	if OS == "" {
 		OS = strings.ToLower(runtime.GOOS)
 	}
@@ -196,9 +214,19 @@ func listRemoteTarballs(cmd *cobra.Command, args []string) {
 		}
 		tarballList = tarballObj.Tarballs
 	}
+	tarballList = downloads.SortedTarballList(tarballList, sortBy)
+	if output != globals.OutputTable {
+		records := []downloads.TarballDescription{}
+		for _, tb := range tarballList {
+			if tarballMatches(tb, version, flavor, OS, arch) {
+				records = append(records, tb)
+			}
+		}
+		printStructured(output, outputKindTarballs, records)
+		return
+	}
 	fmt.Printf("Available tarballs %s (%s)\n", notes, downloads.DefaultTarballRegistry.UpdatedOn)
 
-	tarballList = downloads.SortedTarballList(tarballList, sortBy)
 	for _, tb := range tarballList {
 		var cells []*simpletable.Cell
 		minimalTag := ""
@@ -219,16 +247,7 @@ func listRemoteTarballs(cmd *cobra.Command, args []string) {
 		cells = append(cells, &simpletable.Cell{Text: tb.Flavor})
 		cells = append(cells, &simpletable.Cell{Align: simpletable.AlignRight, Text: humanize.Bytes(uint64(tb.Size))})
 		cells = append(cells, &simpletable.Cell{Text: minimalTag})
-		if version != "" && version != "all" && version != tb.Version && version != tb.ShortVersion {
-			continue
-		}
-		if flavor != "" && flavor != "all" && flavor != strings.ToLower(tb.Flavor) {
-			continue
-		}
-		if OS != "" && strings.ToLower(OS) != "all" && OS != strings.ToLower(tb.OperatingSystem) {
-			continue
-		}
-		if arch != "" && !strings.EqualFold(arch, tb.Arch) {
+		if !tarballMatches(tb, version, flavor, OS, arch) {
 			continue
 		}
 		table.Body.Cells = append(table.Body.Cells, cells)
@@ -311,6 +330,8 @@ func getCommonFlags(cmd *cobra.Command) ops.DownloadsOptions {
 	options.VerbosityLevel, _ = cmd.Flags().GetInt(globals.VerbosityLabel)
 	options.Version, _ = cmd.Flags().GetString(globals.UnpackVersionLabel)
 	options.Retries, _ = cmd.Flags().GetInt64(globals.RetriesOnFailureLabel)
+	options.ParallelParts, _ = cmd.Flags().GetInt(globals.ParallelPartsLabel)
+	options.RequireSignature, _ = cmd.Flags().GetBool(globals.RequireSignatureLabel)
 	return options
 }
 
@@ -390,6 +411,10 @@ func importTarballCollection(cmd *cobra.Command, args []string) error {
 	originalLength := len(downloads.DefaultTarballRegistry.Tarballs)
 	if common.IsUrl(fileName) {
 		fileUrl := fileName
+		// A URL without a JSON file is the address of a tarball cache server
+		if !strings.HasSuffix(fileUrl, ".json") {
+			fileUrl = strings.TrimSuffix(fileUrl, "/") + "/" + downloads.CacheIndexFile
+		}
 		re := regexp.MustCompile(`^(http|https)://`)
 		fileName = common.BaseName(re.ReplaceAllString(fileUrl, ""))
 		if common.FileExists(fileName) {
@@ -702,8 +727,17 @@ Use '--max-items' to display fewer or more items per version.
 var downloadsGetCmd = &cobra.Command{
 	Use:   "get tarball_name [options]",
 	Short: "Downloads a remote tarball",
-	Long:  ``,
-	RunE:  getRemoteTarball,
+	Long: `
+Downloads a tarball from the list, verifying its checksum.
+When there are public keys in $HOME/.dbdeployer/keys, the signature published
+next to the tarball (URL.asc) is also downloaded and verified.
+With --require-signature, a tarball without a valid signature is removed.
+`,
+	Example: `
+$ dbdeployer downloads get mysql-8.0.36-linux-glibc2.28-x86_64.tar.xz
+$ dbdeployer downloads get mysql-8.0.36-linux-glibc2.28-x86_64.tar.xz --require-signature --unpack
+`,
+	RunE: getRemoteTarball,
 }
 
 var downloadsGetUnpackCmd = &cobra.Command{
@@ -731,6 +765,9 @@ version, flavor, operating system, and optionally its minimal state.
 If you don't specify the Operating system, the current one will be assumed.
 If the flavor is not specified, 'mysql' is assumed.
 Use the option '--dry-run' to see what dbdeployer would download.
+When a short version (such as 8.0) is locked in a dbdeployer.lock file, in the current
+directory or in one of its parents, the locked tarball is downloaded, and the options
+'--newest' and '--guess-latest' are ignored. (See: dbdeployer lock)
 `,
 	Example: `
 $ dbdeployer downloads get-by-version 5.7 --newest --dry-run
@@ -759,6 +796,13 @@ Imports the list of tarballs from a file or a URL.
 If the argument is "remote-github" or "remote-tarballs", dbdeployer will get the file from
 its Github repository.
 (See: dbdeployer info defaults remote-tarball-url)
+If the URL does not end with ".json", it is taken as the address of a server started
+with "dbdeployer downloads serve", and the list is read from its index.
+`,
+	Example: `
+$ dbdeployer downloads import remote-tarballs
+$ dbdeployer downloads import ./my-tarballs.json
+$ dbdeployer downloads import http://cache.example.com:8880/
 `,
 
 	RunE: importTarballCollection,
@@ -793,9 +837,18 @@ var downloadsDeleteCmd = &cobra.Command{
 
 var downloadsAddRemoteCmd = &cobra.Command{
 	Use:   "add-remote tarball-type short-version operating-system",
-	Short: "Adds a tarball to the list, by searching MySQL downloads site ",
-	Long: `This command can add a tarball by searching the MySQL site for one of these
-tarball types: mysql, cluster, shell`,
+	Short: "Adds a tarball to the list, by searching the vendor downloads site ",
+	Long: `This command can add the tarballs of the latest release of a short version,
+by searching the vendor site for one of these tarball types:
+mysql, cluster, shell (MySQL downloads site)
+percona, pxc (Percona downloads site, Linux only)
+mariadb (MariaDB archive, Linux only)`,
+	Example: `
+$ dbdeployer downloads add-remote mysql 8.0 linux --minimal
+$ dbdeployer downloads add-remote percona 8.0 linux
+$ dbdeployer downloads add-remote pxc 5.7 linux --minimal
+$ dbdeployer downloads add-remote mariadb 10.11 linux
+`,
 
 	Run: addRemoteTarballToCollection,
 }
@@ -835,6 +888,8 @@ func addCommonDownloadsFlags(cmd *cobra.Command) {
 	cmd.Flags().Int64P(globals.ProgressStepLabel, "", globals.ProgressStepValue, "Progress interval")
 	cmd.Flags().BoolP(globals.DeleteAfterUnpackLabel, "", false, "Delete the tarball after successful unpack")
 	cmd.Flags().Int64P(globals.RetriesOnFailureLabel, "", 0, "How many times retry a download if a failure occurs on first try")
+	cmd.Flags().IntP(globals.ParallelPartsLabel, "", 1, "Download large tarballs as this many byte ranges at once")
+	cmd.Flags().BoolP(globals.RequireSignatureLabel, "", false, "Refuse a tarball without a valid signature (URL.asc)")
 }
 
 func init() {
@@ -860,6 +915,7 @@ func init() {
 	downloadsListCmd.Flags().String(globals.ArchLabel, "", "Which architecture will be listed")
 	downloadsListCmd.Flags().String(globals.VersionLabel, "", "Which version will be listed")
 	downloadsListCmd.Flags().String(globals.SortByLabel, "name", "Sort by field {name/date/version}")
+	addOutputFlag(downloadsListCmd)
 
 	downloadsTreeCmd.Flags().String(globals.FlavorLabel, "", "Which flavor will be listed")
 	downloadsTreeCmd.Flags().BoolP(globals.ShowUrlLabel, "", false, "Show the URL")
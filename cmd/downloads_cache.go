@@ -0,0 +1,191 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/downloads"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+)
+
+func getCacheDir(cmd *cobra.Command) (string, error) {
+	dir, _ := cmd.Flags().GetString(globals.CacheDirLabel)
+	if dir == "" {
+		return "", fmt.Errorf("option --%s is required", globals.CacheDirLabel)
+	}
+	return common.AbsolutePath(dir)
+}
+
+func serveTarballCache(cmd *cobra.Command, args []string) error {
+	dir, err := getCacheDir(cmd)
+	if err != nil {
+		return err
+	}
+	if !common.DirExists(dir) {
+		return fmt.Errorf(globals.ErrDirectoryNotFound, dir)
+	}
+	port, _ := cmd.Flags().GetInt(globals.PortLabel)
+	bindAddress, _ := cmd.Flags().GetString(globals.BindAddressLabel)
+	baseUrl, _ := cmd.Flags().GetString(globals.BaseUrlLabel)
+
+	server := downloads.NewCacheServer(dir, baseUrl)
+	address := net.JoinHostPort(bindAddress, fmt.Sprintf("%d", port))
+	publicUrl := baseUrl
+	if publicUrl == "" {
+		host := bindAddress
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host, _ = os.Hostname()
+		}
+		publicUrl = fmt.Sprintf("http://%s", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	}
+	publicUrl = strings.TrimSuffix(publicUrl, "/")
+	collection, err := server.Collection(publicUrl)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving %d tarballs from %s at %s\n", len(collection.Tarballs), dir, publicUrl)
+	fmt.Printf("Clients can use this server with:\n")
+	fmt.Printf("    dbdeployer downloads import %s/%s\n", publicUrl, downloads.CacheIndexFile)
+	fmt.Printf("    dbdeployer defaults update remote-tarball-url %s/%s\n", publicUrl, downloads.CacheIndexFile)
+	fmt.Printf("    dbdeployer defaults update download-url %s/MySQL\n", publicUrl)
+	// #nosec G114
+	return http.ListenAndServe(address, server)
+}
+
+func mirrorTarballs(cmd *cobra.Command, args []string) error {
+	dir, err := getCacheDir(cmd)
+	if err != nil {
+		return err
+	}
+	flags := cmd.Flags()
+	flavor, _ := flags.GetString(globals.FlavorLabel)
+	version, _ := flags.GetString(globals.VersionLabel)
+	OS, _ := flags.GetString(globals.OSLabel)
+	arch, _ := flags.GetString(globals.ArchLabel)
+	minimal, _ := flags.GetBool(globals.MinimalLabel)
+	if version == "" {
+		return fmt.Errorf("option --%s is required. Use '--%s=all' to mirror every version", globals.VersionLabel, globals.VersionLabel)
+	}
+	flavor = strings.ToLower(flavor)
+	OS = strings.ToLower(OS)
+	if OS == "" {
+		OS = strings.ToLower(runtime.GOOS)
+	}
+	if OS == "macos" || OS == "osx" {
+		OS = "darwin"
+	}
+
+	var tarballs []downloads.TarballDescription
+	for _, tb := range downloads.DefaultTarballRegistry.Tarballs {
+		if minimal && !tb.Minimal {
+			continue
+		}
+		if tarballMatches(tb, version, flavor, OS, arch) {
+			tarballs = append(tarballs, tb)
+		}
+	}
+	if len(tarballs) == 0 {
+		return fmt.Errorf("no tarballs found for flavor '%s', version '%s', OS '%s'", flavor, version, OS)
+	}
+	options := getCommonFlags(cmd)
+	err = ops.MirrorTarballs(dir, tarballs, options)
+	if err != nil {
+		return err
+	}
+	if !options.DryRun {
+		fmt.Printf("%d tarballs mirrored in %s\n", len(tarballs), dir)
+	}
+	return nil
+}
+
+var downloadsServeCmd = &cobra.Command{
+	Use:   "serve --dir=cache-directory [options]",
+	Short: "Serves a directory of tarballs over HTTP",
+	Long: `
+Serves the tarballs of a directory over HTTP, together with a tarball list
+(` + downloads.CacheIndexFile + `) where every tarball URL points to this server.
+The tarballs filled by "dbdeployer downloads mirror" are listed with their
+original metadata and checksums. Other tarballs in the directory are described
+from their name and contents.
+Any path ending with the name of a tarball gets the tarball, so that the server can
+replace both the remote tarball list and the MySQL download URL.
+Clients can import the list with "dbdeployer downloads import URL", or set the
+defaults "remote-tarball-url" and "download-url" to this server.
+The server runs until interrupted.
+`,
+	Example: `
+$ dbdeployer downloads serve --dir=/opt/tarball-cache
+$ dbdeployer downloads serve --dir=/opt/tarball-cache --port=9000 --base-url=http://cache.example.com:9000
+
+# on the clients
+$ dbdeployer downloads import http://cache.example.com:9000/
+$ dbdeployer defaults update remote-tarball-url http://cache.example.com:9000/tarball_list.json
+$ dbdeployer defaults update download-url http://cache.example.com:9000/MySQL
+`,
+	RunE: serveTarballCache,
+}
+
+var downloadsMirrorCmd = &cobra.Command{
+	Use:   "mirror --dir=cache-directory --version=version [options]",
+	Short: "Fills a directory of tarballs from the tarball list",
+	Long: `
+Downloads the tarballs of the current list that match the given version, flavor,
+operating system, and architecture into a cache directory.
+Each tarball is verified against its checksum, and its description is saved in the
+directory (` + downloads.CacheMetadataFile + `) so that "dbdeployer downloads serve" can
+publish it with the same metadata.
+Tarballs already in the cache with a matching checksum are not downloaded again.
+If you don't specify the operating system, the current one will be assumed.
+`,
+	Example: `
+$ dbdeployer downloads mirror --dir=/opt/tarball-cache --flavor=mysql --version=8.0
+$ dbdeployer downloads mirror --dir=/opt/tarball-cache --flavor=mysql --version=8.0.32 --OS=linux --arch=amd64
+$ dbdeployer downloads mirror --dir=/opt/tarball-cache --version=5.7 --minimal --dry-run
+`,
+	RunE: mirrorTarballs,
+}
+
+func init() {
+	downloadsCmd.AddCommand(downloadsServeCmd)
+	downloadsCmd.AddCommand(downloadsMirrorCmd)
+
+	downloadsServeCmd.Flags().String(globals.CacheDirLabel, "", "Directory of the tarballs to serve")
+	downloadsServeCmd.Flags().Int(globals.PortLabel, 8880, "Port of the server")
+	downloadsServeCmd.Flags().String(globals.BindAddressLabel, "", "Address where the server listens (default: all addresses)")
+	downloadsServeCmd.Flags().String(globals.BaseUrlLabel, "", "Base of the tarball URLs in the list (default: the address used by each client)")
+
+	downloadsMirrorCmd.Flags().String(globals.CacheDirLabel, "", "Directory where the tarballs are stored")
+	downloadsMirrorCmd.Flags().String(globals.FlavorLabel, "", "Choose only the given flavor")
+	downloadsMirrorCmd.Flags().String(globals.VersionLabel, "", "Choose only the given version or short version ('all' for every version)")
+	downloadsMirrorCmd.Flags().String(globals.OSLabel, "", "Choose only the given OS ('all' for every OS)")
+	downloadsMirrorCmd.Flags().String(globals.ArchLabel, "", "Choose only the given arch")
+	downloadsMirrorCmd.Flags().BoolP(globals.MinimalLabel, "", false, "Choose only minimal tarballs")
+	downloadsMirrorCmd.Flags().Bool(globals.DryRunLabel, false, "Show what would be downloaded, without downloading")
+	downloadsMirrorCmd.Flags().BoolP(globals.QuietLabel, "", false, "Do not show download progress")
+	downloadsMirrorCmd.Flags().Int64P(globals.ProgressStepLabel, "", globals.ProgressStepValue, "Progress interval")
+	downloadsMirrorCmd.Flags().Int64P(globals.RetriesOnFailureLabel, "", 0, "How many times retry a download if a failure occurs on first try")
+	downloadsMirrorCmd.Flags().IntP(globals.ParallelPartsLabel, "", 1, "Download large tarballs as this many byte ranges at once")
+}
@@ -0,0 +1,109 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
+)
+
+func minimizeBinaries(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, "sandbox-binary")
+	if err != nil {
+		return err
+	}
+	// The argument can be a directory, or the name of one in sandbox-binary
+	basedir := args[0]
+	if !common.DirExists(basedir) {
+		basedir = path.Join(sandboxBinary, args[0])
+	}
+	basedir, err = common.AbsolutePath(basedir)
+	if err != nil {
+		return err
+	}
+	if !common.DirExists(basedir) {
+		return fmt.Errorf(globals.ErrDirectoryNotFound, basedir)
+	}
+
+	options := ops.MinimizeOptions{Basedir: basedir}
+	flavor, _ := flags.GetString(globals.FlavorLabel)
+	options.Flavor = getFlavor(flavor, basedir)
+	options.Version, _ = flags.GetString(globals.UnpackVersionLabel)
+	if options.Version == "" {
+		options.Version = regexp.MustCompile(`\d+\.\d+\.\d+`).FindString(path.Base(basedir))
+	}
+	if options.Version == "" {
+		return fmt.Errorf("no version detected from %s. Use --%s", path.Base(basedir), globals.UnpackVersionLabel)
+	}
+	options.Strip, _ = flags.GetBool(globals.StripSymbolsLabel)
+	options.DryRun, _ = flags.GetBool(globals.DryRunLabel)
+	packDir, _ := flags.GetString(globals.PackDirLabel)
+	if packDir != "" {
+		options.PackDir, err = common.AbsolutePath(packDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := ops.MinimizeBinaries(options)
+	if err != nil {
+		return err
+	}
+	ops.ReportMinimize(options, result)
+	return nil
+}
+
+var downloadsMinimizeCmd = &cobra.Command{
+	Use:   "minimize binaries-dir [options]",
+	Short: "Reduces an expanded tarball to minimal binaries",
+	Long: `
+Removes from a directory of binaries (a path, or a name in sandbox-binary) what a sandbox
+does not need, using the rules for its flavor: debug binaries, test suites, static libraries,
+documentation, and test or example plugins.
+With --strip-symbols, the debugging symbols are also removed from executables and libraries.
+The work is done on a copy, which is checked for missing libraries, and for the files that the
+initialization of a sandbox uses. The original directory is replaced only when the checks
+succeed. With --pack-dir, it is then packed as a minimal tarball in the given directory,
+and recorded with its checksum in the cache metadata, so that 'dbdeployer downloads serve'
+can offer it.
+Warning: the removal is irreversible. Use --dry-run to see what would be removed.
+`,
+	Example: `
+$ dbdeployer downloads minimize 8.0.36 --dry-run
+$ dbdeployer downloads minimize 8.0.36 --strip-symbols
+$ dbdeployer downloads minimize $HOME/opt/mysql/ps8.0.35 --pack-dir=/var/cache/tarballs
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: minimizeBinaries,
+}
+
+func init() {
+	downloadsCmd.AddCommand(downloadsMinimizeCmd)
+
+	downloadsMinimizeCmd.Flags().Bool(globals.DryRunLabel, false, "Show what would be removed, without removing it")
+	downloadsMinimizeCmd.Flags().Bool(globals.StripSymbolsLabel, false, "Remove debugging symbols from executables and libraries")
+	downloadsMinimizeCmd.Flags().String(globals.PackDirLabel, "", "Pack the result as a minimal tarball in this directory")
+	downloadsMinimizeCmd.Flags().String(globals.FlavorLabel, "", "Flavor of the binaries (detected when not given)")
+	downloadsMinimizeCmd.Flags().String(globals.UnpackVersionLabel, "", "Version of the binaries (detected from the directory name when not given)")
+}
@@ -0,0 +1,172 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/downloads"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func showRegistryDiff(diff downloads.RegistryDiff, verbose bool) {
+	for _, tb := range diff.Added {
+		fmt.Printf("+ %-60s %s %s-%s\n", tb.Name, tb.Flavor, tb.OperatingSystem, tb.Arch)
+	}
+	for _, change := range diff.Changed {
+		if change.Old.Name != change.New.Name {
+			fmt.Printf("~ %-60s replaced by %s\n", change.Old.Name, change.New.Name)
+		} else {
+			fmt.Printf("~ %-60s changed: %s\n", change.New.Name, strings.Join(change.Fields, ", "))
+		}
+		if verbose {
+			fmt.Printf("    from %s\n", change.Origin)
+		}
+	}
+	for _, tb := range diff.Retired {
+		fmt.Printf("- %-60s moved to %s on %s\n", tb.Name, downloads.RemovedTarballListName, tb.DateRemoved)
+	}
+	if verbose {
+		for _, tb := range diff.Unlisted {
+			fmt.Printf("= %-60s not listed by any source (kept)\n", tb.Name)
+		}
+	}
+	fmt.Printf("Added: %d - Changed: %d - Retired: %d - Not in sources: %d\n",
+		len(diff.Added), len(diff.Changed), len(diff.Retired), len(diff.Unlisted))
+}
+
+func showRegistryChangelog() error {
+	changelog, err := downloads.ReadRegistryChangelog()
+	if err != nil {
+		return err
+	}
+	if len(changelog) == 0 {
+		fmt.Printf("No registry updates recorded in %s\n", downloads.RegistryChangelogFile)
+		return nil
+	}
+	for _, update := range changelog {
+		fmt.Printf("%s %s (%d => %d tarballs)\n", update.DateAdded, update.UpdatedBy, update.Before, update.After)
+		fmt.Printf("    sources: %s\n", strings.Join(update.Sources, ", "))
+		for _, name := range update.Added {
+			fmt.Printf("    + %s\n", name)
+		}
+		for _, name := range update.Changed {
+			fmt.Printf("    ~ %s\n", name)
+		}
+		for _, name := range update.Retired {
+			fmt.Printf("    - %s\n", name)
+		}
+	}
+	return nil
+}
+
+func updateTarballRegistry(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	showChangelog, _ := flags.GetBool(globals.ShowChangelogLabel)
+	if showChangelog {
+		return showRegistryChangelog()
+	}
+	dryRun, _ := flags.GetBool(globals.DryRunLabel)
+	skipConfirm, _ := flags.GetBool(globals.SkipConfirmLabel)
+	verbose, _ := flags.GetBool(globals.VerboseLabel)
+
+	sourceList := args
+	if len(sourceList) == 0 {
+		sourceList = downloads.RegistrySourceList()
+	}
+	for _, source := range sourceList {
+		fmt.Printf("Reading tarball list from %s\n", source)
+	}
+	sources, err := downloads.ReadRegistrySources(sourceList)
+	if err != nil {
+		return err
+	}
+	current := downloads.DefaultTarballRegistry
+	diff := downloads.DiffTarballRegistry(current, sources)
+	showRegistryDiff(diff, verbose)
+	if diff.IsEmpty() {
+		fmt.Println("The tarball registry is up to date")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	if !skipConfirm {
+		fmt.Printf("Do you want to merge these changes into %s? y/[N] ", downloads.TarballFileRegistry)
+		bio := bufio.NewReader(os.Stdin)
+		line, _, err := bio.ReadLine()
+		if err != nil {
+			return err
+		}
+		answer := string(line)
+		if answer != "y" && answer != "Y" {
+			fmt.Println("Update skipped at user request")
+			return nil
+		}
+	}
+	updated := downloads.ApplyRegistryDiff(current, diff)
+	err = downloads.TarballFileInfoValidation(updated)
+	if err != nil {
+		return fmt.Errorf("error validating the updated tarball list: %s", err)
+	}
+	err = downloads.WriteTarballFileInfo(updated)
+	if err != nil {
+		return fmt.Errorf("error writing tarball list: %s", err)
+	}
+	err = downloads.AddToRegistryChangelog(downloads.NewRegistryUpdate(diff, sources, len(current.Tarballs), len(updated.Tarballs)))
+	if err != nil {
+		return fmt.Errorf("error writing registry changelog: %s", err)
+	}
+	fmt.Printf("Tarball registry updated in %s (%d => %d tarballs)\n", downloads.TarballFileRegistry, len(current.Tarballs), len(updated.Tarballs))
+	fmt.Printf("Update recorded in %s\n", downloads.RegistryChangelogFile)
+	return nil
+}
+
+var downloadsUpdateRegistryCmd = &cobra.Command{
+	Use:   "update-registry [source ...]",
+	Short: "Refreshes the tarball registry from its sources",
+	Long: `
+Compares the current tarball list with one or more sources, and shows which tarballs
+would be added, changed, or retired (moved by the source into its ` + downloads.RemovedTarballListName + `).
+Tarballs that no source knows about, such as the ones added locally, are kept.
+After confirmation, the changes are merged into the local tarball list, and the update
+is recorded in a changelog, which can be seen with --show-changelog.
+A source can be a URL or a local file. When no source is given, the ones in the
+default "registry-sources" (a comma-separated list) are used, or else "remote-tarball-url".
+`,
+	Example: `
+$ dbdeployer downloads update-registry --dry-run
+$ dbdeployer downloads update-registry --skip-confirm
+$ dbdeployer downloads update-registry http://cache.example.com:9000/ ./my_tarball_list.json
+$ dbdeployer defaults update registry-sources "https://example.com/tarball_list.json,http://cache.example.com:9000/"
+$ dbdeployer downloads update-registry --show-changelog
+`,
+	RunE: updateTarballRegistry,
+}
+
+func init() {
+	downloadsCmd.AddCommand(downloadsUpdateRegistryCmd)
+
+	downloadsUpdateRegistryCmd.Flags().Bool(globals.DryRunLabel, false, "Show the changes without merging them")
+	downloadsUpdateRegistryCmd.Flags().Bool(globals.SkipConfirmLabel, false, "Merge the changes without asking for confirmation")
+	downloadsUpdateRegistryCmd.Flags().Bool(globals.VerboseLabel, false, "Show the source of each change, and the tarballs that no source lists")
+	downloadsUpdateRegistryCmd.Flags().Bool(globals.ShowChangelogLabel, false, "Show the registry updates recorded so far")
+}
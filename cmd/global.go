@@ -21,9 +21,11 @@ import (
 	"path"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
 	"github.com/spf13/cobra"
 )
 
@@ -63,6 +65,9 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 	sbPortRange, _ := flags.GetString(globals.PortRangeLabel)
 	verbose, _ := flags.GetBool(globals.VerboseLabel)
 	dryRun, _ := flags.GetBool(globals.DryRunLabel)
+	output := getOutputFormat(cmd)
+	statusList := []lifecycle.SandboxStatus{}
+	metadataList := []metadataRecord{}
 	sbPortValue, sbPortNegation := common.OptionComponents(sbPortOpt)
 	sbPort := 0
 	if sbPortValue != "" {
@@ -194,6 +199,22 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 			}
 			continue
 		}
+		// Sandboxes that need their own procedures, such as imported, TiDB,
+		// NDB, or PXC sandboxes, report their status through their scripts
+		if executable == globals.ScriptStatus && lifecycle.NativeSupport(fullDirPath) {
+			if dryRun {
+				common.CondPrintf("would get the status of %s\n", fullDirPath)
+				continue
+			}
+			status, err := lifecycle.Status(fullDirPath)
+			common.ErrCheckExitf(err, 1, "error getting the status of %s: %s", fullDirPath, err)
+			if output == globals.OutputTable {
+				printSandboxStatus(status)
+			} else {
+				statusList = append(statusList, status)
+			}
+			continue
+		}
 		cmdFile := path.Join(fullDirPath, executable)
 		realExecutable := executable
 		if !common.ExecExists(cmdFile) {
@@ -215,6 +236,17 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 		}
 		cmdArgs = append(cmdArgs, args...)
 		var err error
+		if output != globals.OutputTable && executable == globals.ScriptMetadata && !dryRun {
+			out, err := common.RunCmdCtrlWithArgs(cmdFile, cmdArgs, true)
+			common.ErrCheckExitf(err, 1, "error while running %s\n", cmdFile)
+			metadataList = append(metadataList, metadataRecord{
+				Name:      sb,
+				Directory: fullDirPath,
+				Keyword:   strings.Join(args, " "),
+				Value:     strings.TrimSpace(out),
+			})
+			continue
+		}
 		common.CondPrintf("# Running \"%s\" on %s\n", realExecutable, sb)
 		if dryRun {
 			argsStr := ""
@@ -234,6 +266,43 @@ func globalRunCommand(cmd *cobra.Command, executable string, args []string, requ
 		}
 		fmt.Println("")
 	}
+	if output != globals.OutputTable && !dryRun {
+		switch executable {
+		case globals.ScriptStatus:
+			printStructured(output, outputKindSandboxStatus, statusList)
+		case globals.ScriptMetadata:
+			printStructured(output, outputKindMetadata, metadataList)
+		}
+	}
+}
+
+// metadataRecord is the result of a metadata query in one sandbox,
+// used for --output=json|yaml
+type metadataRecord struct {
+	Name      string `json:"name"`
+	Directory string `json:"directory"`
+	Keyword   string `json:"keyword"`
+	Value     string `json:"value"`
+}
+
+// printSandboxStatus shows one line for each server in a sandbox
+func printSandboxStatus(status lifecycle.SandboxStatus) {
+	onOff := map[bool]string{true: "on", false: "off"}
+	fmt.Printf("%s %s\n", status.Name, onOff[status.Running])
+	if status.Type == globals.SbTypeSingle && len(status.Nodes) == 1 {
+		status.Nodes[0].Name = status.Name
+	}
+	for _, node := range status.Nodes {
+		line := fmt.Sprintf("    %-10s %-3s port: %d", node.Name, onOff[node.Running], node.Port)
+		if node.Running {
+			line += fmt.Sprintf(" pid: %d uptime: %s listening: %v", node.Pid,
+				time.Duration(node.UptimeSeconds)*time.Second, node.PortsListening)
+			if node.ReadOnly != nil {
+				line += fmt.Sprintf(" read_only: %s", onOff[*node.ReadOnly])
+			}
+		}
+		fmt.Println(line)
+	}
 }
 
 func startAllSandboxes(cmd *cobra.Command, args []string) {
@@ -313,8 +382,11 @@ var (
 	globalStatusCmd = &cobra.Command{
 		Use:   "status",
 		Short: "Shows the status in all sandboxes",
-		Long:  ``,
-		Run:   statusAllSandboxes,
+		Long: `Shows the status of every server in all sandboxes.
+For running servers, it shows the process ID, the uptime, the listening ports,
+and the read_only state.
+The status is read from the pid files and the sandbox description, without running the sandbox scripts.`,
+		Run: statusAllSandboxes,
 	}
 
 	globalTestCmd = &cobra.Command{
@@ -375,7 +447,8 @@ to only run "exec" in the specific topologies.
 		Short: "Runs a metadata query in all sandboxes",
 		Long:  `Runs a metadata query in all sandboxes`,
 		Example: `
-	$ dbdeployer global metadata `,
+	$ dbdeployer global metadata version
+	$ dbdeployer global metadata port --output=json`,
 		Run:         metadataAllSandboxes,
 		Annotations: map[string]string{"export": ExportAnnotationToJson(StringExport)},
 	}
@@ -402,4 +475,6 @@ func init() {
 	globalCmd.PersistentFlags().String(globals.PortLabel, "", "Runs commands only in sandboxes containing the given port")
 	globalCmd.PersistentFlags().Bool(globals.VerboseLabel, false, "Show what is matched when filters are used")
 	globalCmd.PersistentFlags().Bool(globals.DryRunLabel, false, "Show what would be executed, without doing it")
+	addOutputFlag(globalStatusCmd)
+	addOutputFlag(globalMetadataCmd)
 }
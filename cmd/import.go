@@ -17,10 +17,13 @@ package cmd
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"path"
 	"regexp"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
@@ -29,20 +32,15 @@ import (
 	"github.com/datacharmer/dbdeployer/sandbox"
 )
 
-func importSingleSandbox(cmd *cobra.Command, args []string) {
-	// args will be at least 4, as ensured by MinimumNArgs
-	host := args[0]
-	strPort := args[1]
-	user := args[2]
-	password := args[3]
-
-	port := common.Atoi(strPort)
+// importedServerVersion connects to a server and returns its version
+func importedServerVersion(host string, port int, user, password string) string {
 	var config = importing.ParamsToConfig(host, user, password, port)
 
 	db, err := importing.Connect(config)
 	if err != nil {
 		common.Exitf(1, "error connecting to server %s:%d - %s", host, port, err)
 	}
+	defer db.Close()
 	var versionString string
 
 	err = db.GetSingleResult(config, "SELECT version()", &versionString)
@@ -58,20 +56,81 @@ func importSingleSandbox(cmd *cobra.Command, args []string) {
 	if len(versionList) > 0 {
 		versionString = versionList[0]
 	}
+	return versionString
+}
 
-	fmt.Printf("detected: %s\n", versionString)
-	var sd sandbox.SandboxDef
-	sd, err = fillSandboxDefinition(cmd, []string{versionString}, true)
+// importCredentials gets the user and password for the servers to import.
+// The user is the first of args, or the one in the login path.
+// The password is taken, in order of preference, from the second of args, from the
+// environment variable named with --password-env, from the login path, or from a prompt
+func importCredentials(cmd *cobra.Command, args []string) (string, string) {
+	flags := cmd.Flags()
+	loginPathName, _ := flags.GetString(globals.LoginPathLabel)
+	passwordEnv, _ := flags.GetString(globals.PasswordEnvLabel)
+
+	var loginPath importing.LoginPath
+	if loginPathName != "" {
+		loginFile, _ := flags.GetString(globals.LoginFileLabel)
+		if loginFile == "" {
+			loginFile = path.Join(os.Getenv("HOME"), ".mylogin.cnf")
+		}
+		var err error
+		loginPath, err = importing.ReadLoginPath(loginFile, loginPathName)
+		if err != nil {
+			common.Exitf(1, "error reading credentials: %s", err)
+		}
+	}
+	user := loginPath.User
+	if len(args) > 0 {
+		user = args[0]
+	}
+	if user == "" {
+		common.Exitf(1, "no user given: use an argument or --%s", globals.LoginPathLabel)
+	}
+
+	var password string
+	switch {
+	case len(args) > 1:
+		password = args[1]
+		_, _ = fmt.Fprintf(os.Stderr, "# WARNING: a password on the command line can be seen by other users. "+
+			"Use --%s, --%s, or the interactive prompt instead\n", globals.LoginPathLabel, globals.PasswordEnvLabel)
+	case passwordEnv != "":
+		value, found := os.LookupEnv(passwordEnv)
+		if !found {
+			common.Exitf(1, "environment variable '%s' is not set", passwordEnv)
+		}
+		password = value
+	case loginPathName != "":
+		password = loginPath.Password
+	default:
+		stdin := int(os.Stdin.Fd())
+		if !term.IsTerminal(stdin) {
+			common.Exitf(1, "no password given for user %s: use --%s or --%s",
+				user, globals.LoginPathLabel, globals.PasswordEnvLabel)
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Password for %s: ", user)
+		text, err := term.ReadPassword(stdin)
+		_, _ = fmt.Fprintln(os.Stderr)
+		if err != nil {
+			common.Exitf(1, "error reading password: %s", err)
+		}
+		password = string(text)
+	}
+	// Makes sure that the password is not saved in the sandbox description and in the catalog
+	common.AddCommandLineSecret(password)
+	return user, password
+}
+
+// fillImportDefinition prepares the definition of a sandbox that imports the given servers
+func fillImportDefinition(cmd *cobra.Command, versionString string, nodes []sandbox.ImportedNode, user, password string) sandbox.SandboxDef {
+	sd, err := fillSandboxDefinition(cmd, []string{versionString}, true)
 	if err != nil {
 		common.Exitf(1, "error while filling the sandbox definition: %+v", err)
 	}
-	// When deploying a single sandbox, we disable concurrency
+	// When importing, we disable concurrency
 	sd.RunConcurrently = false
-	sd.SbHost = host
-	sd.Port = port
 	sd.DbUser = user
 	sd.DbPassword = password
-	sd.SBType = globals.SbTypeSingleImported
 	sd.Imported = true
 
 	if sd.ClientBasedir == "" {
@@ -89,16 +148,20 @@ func importSingleSandbox(cmd *cobra.Command, args []string) {
 	if err != nil {
 		common.Exitf(1, "error getting installed ports: %s", err)
 	}
-	for _, usedPort := range usedPorts {
-		if usedPort == port && host == globals.LocalHostIP {
-			common.Exitf(1, "server %s:%d is already a local sandbox", host, usedPort)
+	importedPorts := make(map[int]bool)
+	for _, node := range nodes {
+		importedPorts[node.Port] = true
+		for _, usedPort := range usedPorts {
+			if usedPort == node.Port && node.Host == globals.LocalHostIP {
+				common.Exitf(1, "server %s:%d is already a local sandbox", node.Host, usedPort)
+			}
 		}
 	}
 
-	// Remove the port from reserved ports, to avoid rejections later on
+	// Remove the ports from reserved ports, to avoid rejections later on
 	var newPortList []int
 	for _, reservedPort := range defaults.Defaults().ReservedPorts {
-		if reservedPort != port {
+		if !importedPorts[reservedPort] {
 			newPortList = append(newPortList, reservedPort)
 		}
 	}
@@ -108,8 +171,54 @@ func importSingleSandbox(cmd *cobra.Command, args []string) {
 	sd.SkipStart = true
 	sd.RplUser = user
 	sd.RplPassword = password
+	return sd
+}
 
-	err = sandbox.CreateStandaloneSandbox(sd)
+func importSingleSandbox(cmd *cobra.Command, args []string) {
+	// args will be at least 2, as ensured by MinimumNArgs
+	host := args[0]
+	strPort := args[1]
+	user, password := importCredentials(cmd, args[2:])
+
+	port := common.Atoi(strPort)
+	versionString := importedServerVersion(host, port, user, password)
+
+	fmt.Printf("detected: %s\n", versionString)
+	sd := fillImportDefinition(cmd, versionString, []sandbox.ImportedNode{{Host: host, Port: port}}, user, password)
+	sd.SbHost = host
+	sd.Port = port
+	sd.SBType = globals.SbTypeSingleImported
+
+	err := sandbox.CreateStandaloneSandbox(sd)
+	if err != nil {
+		common.Exitf(1, globals.ErrCreatingSandbox, err)
+	}
+}
+
+func importReplicationSandbox(cmd *cobra.Command, args []string) {
+	// args will be at least 1, as ensured by MinimumNArgs
+	host, strPort, err := net.SplitHostPort(args[0])
+	if err != nil {
+		common.Exitf(1, "invalid address '%s' - expected host:port", args[0])
+	}
+	user, password := importCredentials(cmd, args[1:])
+	port := common.Atoi(strPort)
+
+	versionString := importedServerVersion(host, port, user, password)
+	fmt.Printf("detected: %s\n", versionString)
+
+	topology, err := importing.DiscoverTopology(host, port, user, password)
+	if err != nil {
+		common.Exitf(1, "error discovering replication from %s:%d - %s", host, port, err)
+	}
+	var nodes []sandbox.ImportedNode
+	for _, node := range topology.Nodes {
+		nodes = append(nodes, sandbox.ImportedNode{Host: node.Host, Port: node.Port, Role: node.Role})
+	}
+	fmt.Printf("# Found %s with %d nodes\n", topology.Type, len(nodes))
+
+	sd := fillImportDefinition(cmd, versionString, nodes, user, password)
+	err = sandbox.CreateImportedReplication(sd, topology.Type, nodes)
 	if err != nil {
 		common.Exitf(1, globals.ErrCreatingSandbox, err)
 	}
@@ -130,19 +239,53 @@ var importCmd = &cobra.Command{
 // Add more sandbox creation options
 
 var importSingleCmd = &cobra.Command{
-	Use:   "single host port user password",
+	Use:   "single host port [user [password]]",
 	Short: "imports a MySQL server into a sandbox",
-	Args:  cobra.MinimumNArgs(4),
+	Args:  cobra.MinimumNArgs(2),
 	Long: `Imports an existing (local or remote) server into a sandbox,
 so that it can be used with the usual sandbox scripts.
 Requires host, port, user, password.
+The user can be taken from a login path (--login-path).
+The password can be taken from an environment variable (--password-env),
+from a login path, or from an interactive prompt. A password given as argument
+is visible to other users and is saved in the shell history.
+`,
+	Example: `
+	$ dbdeployer import single 192.168.1.10 3306 admin
+	$ DB_PASSWORD=secret dbdeployer import single 192.168.1.10 3306 admin --password-env=DB_PASSWORD
+	$ mysql_config_editor set --login-path=staging --user=admin --password
+	$ dbdeployer import single 192.168.1.10 3306 --login-path=staging
 `,
 	Run: importSingleSandbox,
 }
 
+var importReplicationCmd = &cobra.Command{
+	Use:   "replication host:port [user [password]]",
+	Short: "imports a replication topology into a sandbox",
+	Args:  cobra.MinimumNArgs(1),
+	Long: `Imports an existing replication into a sandbox with one node per server.
+Starting from the seed server, dbdeployer follows its sources and replicas
+(SHOW REPLICAS or SHOW SLAVE HOSTS) or the group replication membership,
+and creates a directory with scripts n1..nN, use_all, status_all, and check_slaves.
+The first node is the source (or the group primary).
+All servers must accept the same user and password, which are
+provided as in "dbdeployer import single".
+Replicas are found at the host set with report_host, or at the host of their source
+when report_host is not set.
+`,
+	Example: `
+	$ dbdeployer import replication 192.168.1.10:3306 --login-path=staging
+`,
+	Run: importReplicationSandbox,
+}
+
 func init() {
 	rootCmd.AddCommand(importCmd)
 	importCmd.AddCommand(importSingleCmd)
+	importCmd.AddCommand(importReplicationCmd)
 	setPflag(importCmd, globals.ClientFromLabel, "", "", "", "Where to get the client binaries from", false)
 	setPflag(importCmd, globals.SandboxDirectoryLabel, "", "", "", "Changes the default sandbox directory", false)
+	setPflag(importCmd, globals.LoginPathLabel, "", "", "", "Login path (created with mysql_config_editor) with the server credentials", false)
+	setPflag(importCmd, globals.LoginFileLabel, "", "", "", "File containing the login paths (default $HOME/.mylogin.cnf)", false)
+	setPflag(importCmd, globals.PasswordEnvLabel, "", "", "", "Name of the environment variable containing the password", false)
 }
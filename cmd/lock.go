@@ -0,0 +1,198 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datacharmer/dbdeployer/downloads"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// getLockFileName returns the lock file given with --lock-file, or the one that applies
+// to the current directory, or a new one in the current directory
+func getLockFileName(cmd *cobra.Command) (string, error) {
+	lockFile, _ := cmd.Flags().GetString(globals.LockFileLabel)
+	if lockFile != "" {
+		return lockFile, nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	lockFile = downloads.FindLockFile(cwd)
+	if lockFile == "" {
+		lockFile = downloads.LockFileName
+	}
+	return lockFile, nil
+}
+
+func describeLockedBinary(lb downloads.LockedBinary) string {
+	return fmt.Sprintf("%-6s %-12s %s-%s", lb.ShortVersion, lb.Flavor, lb.OperatingSystem, lb.Arch)
+}
+
+func updateLockFile(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	dryRun, _ := flags.GetBool(globals.DryRunLabel)
+	lockFile, err := getLockFileName(cmd)
+	if err != nil {
+		return err
+	}
+	lock, err := downloads.ReadLockFile(lockFile)
+	if err != nil {
+		return err
+	}
+
+	var wanted []downloads.LockedBinary
+	if len(args) == 0 {
+		if len(lock.Binaries) == 0 {
+			return fmt.Errorf("%s has no binaries to update. Indicate which short versions to lock", lockFile)
+		}
+		wanted = lock.Binaries
+	} else {
+		flavor, _ := flags.GetString(globals.FlavorLabel)
+		OS, _ := flags.GetString(globals.OSLabel)
+		arch, _ := flags.GetString(globals.ArchLabel)
+		minimal, _ := flags.GetBool(globals.MinimalLabel)
+		if OS == "" {
+			OS = runtime.GOOS
+		}
+		if arch == "" {
+			arch = runtime.GOARCH
+		}
+		for _, shortVersion := range args {
+			if !downloads.IsShortVersion(shortVersion) {
+				return fmt.Errorf("'%s' is not a short version. Use a version like '8.0'", shortVersion)
+			}
+			wanted = append(wanted, downloads.LockedBinary{
+				ShortVersion:    shortVersion,
+				Flavor:          flavor,
+				OperatingSystem: OS,
+				Arch:            arch,
+				Minimal:         minimal,
+			})
+		}
+	}
+
+	changes := 0
+	for _, w := range wanted {
+		tarball, err := downloads.NewestTarball(w.ShortVersion, w.Flavor, w.OperatingSystem, w.Arch, w.Minimal)
+		if err != nil {
+			return err
+		}
+		newBinary := downloads.LockTarball(tarball)
+		oldBinary, found := lock.Find(newBinary.ShortVersion, newBinary.Flavor, newBinary.OperatingSystem, newBinary.Arch)
+		switch {
+		case !found:
+			fmt.Printf("+ %s %s (%s)\n", describeLockedBinary(newBinary), newBinary.Version, newBinary.Tarball)
+		case oldBinary != newBinary:
+			fmt.Printf("~ %s %s => %s (%s)\n", describeLockedBinary(newBinary), oldBinary.Version, newBinary.Version, newBinary.Tarball)
+		default:
+			fmt.Printf("= %s %s\n", describeLockedBinary(newBinary), newBinary.Version)
+			continue
+		}
+		lock.Set(newBinary)
+		changes++
+	}
+	if changes == 0 {
+		fmt.Printf("%s is up to date\n", lockFile)
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	err = downloads.WriteLockFile(lockFile, lock)
+	if err != nil {
+		return fmt.Errorf("error writing lock file %s: %s", lockFile, err)
+	}
+	fmt.Printf("%s updated\n", lockFile)
+	return nil
+}
+
+func showLockFile(cmd *cobra.Command, args []string) error {
+	lockFile, err := getLockFileName(cmd)
+	if err != nil {
+		return err
+	}
+	lock, err := downloads.ReadLockFile(lockFile)
+	if err != nil {
+		return err
+	}
+	if len(lock.Binaries) == 0 {
+		fmt.Printf("No binaries locked in %s\n", lockFile)
+		return nil
+	}
+	fmt.Printf("# %s (updated on %s)\n", lockFile, lock.UpdatedOn)
+	for _, lb := range lock.Binaries {
+		fmt.Printf("%s %-10s %s\n", describeLockedBinary(lb), lb.Version, lb.Tarball)
+	}
+	return nil
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Pins short versions to exact binaries",
+	Long: `
+A short version, such as 8.0, resolves to the newest binary available, which can differ
+from one machine to another. A lock file (` + downloads.LockFileName + `) records, for each short version
+and flavor, the exact version, tarball, URL, and checksum that a project uses.
+The lock file is looked for in the current directory and its parents. The environment
+variable ` + downloads.LockFileEnvVar + ` can indicate a different file, or disable the lock with "none".
+When a lock applies, "deploy" and "downloads get-by-version" use the locked version
+instead of the newest one.
+`,
+}
+
+var lockUpdateCmd = &cobra.Command{
+	Use:   "update [short-version ...]",
+	Short: "Locks short versions to the newest tarballs",
+	Long: `
+Locks each short version to the newest tarball in the registry, for the given flavor,
+operating system, and architecture (by default, MySQL on the current platform).
+Without arguments, refreshes all the binaries already in the lock file.
+If no lock file is found, one is created in the current directory.
+`,
+	Example: `
+$ dbdeployer lock update 8.0 5.7
+$ dbdeployer lock update 8.0 --flavor=percona --OS=linux --arch=amd64
+$ dbdeployer lock update 8.0 --minimal --OS=linux
+$ dbdeployer lock update --dry-run
+`,
+	RunE: updateLockFile,
+}
+
+var lockShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Shows the binaries in the lock file",
+	RunE:  showLockFile,
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.AddCommand(lockUpdateCmd)
+	lockCmd.AddCommand(lockShowCmd)
+
+	lockCmd.PersistentFlags().String(globals.LockFileLabel, "", "Lock file to use, instead of the one found from the current directory")
+	lockUpdateCmd.Flags().Bool(globals.DryRunLabel, false, "Show the changes without writing the lock file")
+	lockUpdateCmd.Flags().String(globals.FlavorLabel, "", "Flavor of the binaries to lock (default: mysql)")
+	lockUpdateCmd.Flags().String(globals.OSLabel, "", "Operating system of the binaries to lock (default: current)")
+	lockUpdateCmd.Flags().String(globals.ArchLabel, "", "Architecture of the binaries to lock (default: current)")
+	lockUpdateCmd.Flags().Bool(globals.MinimalLabel, false, "Lock the minimal tarballs")
+}
@@ -24,6 +24,7 @@ import (
 
 func multipleSandbox(cmd *cobra.Command, args []string) {
 	var sd sandbox.SandboxDef
+	args = basedirFromSystem(cmd, args)
 	common.CheckOrigin(args)
 	flags := cmd.Flags()
 	sd, err := fillSandboxDefinition(cmd, args, false)
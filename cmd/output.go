@@ -0,0 +1,133 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// OutputSchemaVersion is the version of the structured output produced with --output=json|yaml.
+// It must be increased whenever a field is removed or changes meaning.
+// Adding fields does not change the version.
+const OutputSchemaVersion = 1
+
+// Kinds of structured output
+const (
+	outputKindSandboxes     = "sandboxes"
+	outputKindSandboxStatus = "sandbox-status"
+	outputKindMetadata      = "metadata"
+	outputKindVersions      = "versions"
+	outputKindTarballs      = "tarballs"
+	outputKindSnapshots     = "snapshots"
+	outputKindPortLeases    = "port-leases"
+	outputKindHealth        = "health"
+)
+
+// structuredOutput is the envelope of every list produced with --output=json|yaml
+type structuredOutput struct {
+	SchemaVersion int         `json:"schema-version"`
+	Kind          string      `json:"kind"`
+	Items         interface{} `json:"items"`
+}
+
+// addOutputFlag adds the --output option to a command
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().String(globals.OutputLabel, globals.OutputTable,
+		fmt.Sprintf("Output format {%s|%s|%s}", globals.OutputTable, globals.OutputJson, globals.OutputYaml))
+}
+
+// getOutputFormat returns the output format requested for a command.
+// Commands without the --output option always use the table format
+func getOutputFormat(cmd *cobra.Command) string {
+	if cmd.Flags().Lookup(globals.OutputLabel) == nil {
+		return globals.OutputTable
+	}
+	format, _ := cmd.Flags().GetString(globals.OutputLabel)
+	switch format {
+	case globals.OutputTable, globals.OutputJson, globals.OutputYaml:
+		return format
+	}
+	common.Exitf(1, "unrecognized output format '%s'. Accepted: %s, %s, %s",
+		format, globals.OutputTable, globals.OutputJson, globals.OutputYaml)
+	return ""
+}
+
+// jsonNumbersToInt converts the numbers decoded from JSON to integers, when possible,
+// so that the YAML output does not show them in scientific notation
+func jsonNumbersToInt(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = jsonNumbersToInt(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonNumbersToInt(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// structuredText returns a list of items as JSON or YAML.
+// The YAML output is derived from the JSON encoding, so that both formats
+// use the same field names
+func structuredText(format, kind string, items interface{}) (string, error) {
+	output := structuredOutput{
+		SchemaVersion: OutputSchemaVersion,
+		Kind:          kind,
+		Items:         items,
+	}
+	b, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if format == globals.OutputJson {
+		return string(b), nil
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return "", err
+	}
+	b, err = yaml.Marshal(jsonNumbersToInt(generic))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// printStructured prints a list of items as JSON or YAML
+func printStructured(format, kind string, items interface{}) {
+	text, err := structuredText(format, kind, items)
+	common.ErrCheckExitf(err, 1, "error encoding %s output: %s", format, err)
+	fmt.Println(text)
+}
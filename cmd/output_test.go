@@ -0,0 +1,72 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestStructuredText(t *testing.T) {
+	records := []sandboxRecord{
+		{
+			Name: "msb_8_0_36",
+			SandboxItem: defaults.SandboxItem{
+				SBType:      "single",
+				Version:     "8.0.36",
+				Port:        []int{8036, 18036},
+				Destination: "/home/user/sandboxes/msb_8_0_36",
+			},
+		},
+	}
+
+	jsonText, err := structuredText(globals.OutputJson, outputKindSandboxes, records)
+	compare.OkIsNil("JSON output", err, t)
+	var fromJson map[string]interface{}
+	err = json.Unmarshal([]byte(jsonText), &fromJson)
+	compare.OkIsNil("decoding JSON output", err, t)
+	compare.OkEqualInterface("JSON schema version", fromJson["schema-version"], float64(OutputSchemaVersion), t)
+	compare.OkEqualInterface("JSON kind", fromJson["kind"], outputKindSandboxes, t)
+	items := fromJson["items"].([]interface{})
+	compare.OkEqualInt("JSON items", len(items), 1, t)
+	item := items[0].(map[string]interface{})
+	compare.OkEqualInterface("JSON name", item["name"], "msb_8_0_36", t)
+	compare.OkEqualInterface("JSON embedded type", item["type"], "single", t)
+	_, hasStatus := item["status"]
+	compare.OkEqualBool("JSON status omitted", hasStatus, false, t)
+
+	yamlText, err := structuredText(globals.OutputYaml, outputKindSandboxes, records)
+	compare.OkIsNil("YAML output", err, t)
+	var fromYaml struct {
+		SchemaVersion int `yaml:"schema-version"`
+		Kind          string
+		Items         []map[string]interface{}
+	}
+	err = yaml.Unmarshal([]byte(yamlText), &fromYaml)
+	compare.OkIsNil("decoding YAML output", err, t)
+	compare.OkEqualInt("YAML schema version", fromYaml.SchemaVersion, OutputSchemaVersion, t)
+	compare.OkEqualString("YAML kind", fromYaml.Kind, outputKindSandboxes, t)
+	compare.OkEqualInt("YAML items", len(fromYaml.Items), 1, t)
+	compare.OkEqualInterface("YAML destination", fromYaml.Items[0]["destination"], "/home/user/sandboxes/msb_8_0_36", t)
+	compare.OkEqualBool("YAML integer ports", strings.Contains(yamlText, "- 18036"), true, t)
+}
@@ -0,0 +1,153 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+	"github.com/spf13/cobra"
+)
+
+func exportDeploymentPlan(sandboxHome, sandboxName string, args []string) {
+	plan, err := sandbox.DeploymentPlanFromSandbox(sandboxHome, sandboxName)
+	common.ErrCheckExitf(err, 1, "error creating deployment plan from sandbox %s: %s", sandboxName, err)
+	plan.SandboxBinary = common.ReplaceLiteralHome(plan.SandboxBinary)
+	if len(args) > 0 {
+		err = sandbox.WriteDeploymentPlan(plan, args[0])
+		common.ErrCheckExitf(err, 1, "error writing deployment plan: %s", err)
+		common.CondPrintf("Deployment plan for %s written to %s\n", sandboxName, args[0])
+		return
+	}
+	text, err := sandbox.DeploymentPlanToText(plan, true)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	fmt.Print(text)
+}
+
+func deployPlan(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	dryRun, _ := flags.GetBool(globals.DryRunLabel)
+	force, _ := flags.GetBool(globals.ForceLabel)
+	reconcile, _ := flags.GetBool(globals.ReconcileLabel)
+	fromSandbox, _ := flags.GetString(globals.FromSandboxLabel)
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
+	common.ErrCheckExitf(err, 1, "%s", err)
+
+	if fromSandbox != "" {
+		exportDeploymentPlan(sandboxHome, fromSandbox, args)
+		return
+	}
+	if len(args) < 1 {
+		common.Exit(1, "command 'plan' requires a plan file",
+			"Example: dbdeployer deploy plan my-sandboxes.yaml")
+	}
+	plan, err := sandbox.ReadDeploymentPlan(args[0])
+	common.ErrCheckExitf(err, 1, "%s", err)
+	if plan.SandboxBinary != "" {
+		sandboxBinary, err = common.AbsolutePath(common.ReplaceHomeVar(plan.SandboxBinary))
+		common.ErrCheckExitf(err, 1, "%s", err)
+	}
+	if plan.SandboxHome != "" {
+		sandboxHome, err = common.AbsolutePath(common.ReplaceHomeVar(plan.SandboxHome))
+		common.ErrCheckExitf(err, 1, "%s", err)
+	}
+	err = common.CheckSandboxDir(sandboxHome)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	err = plan.Validate(sandboxBinary, sandboxHome, force || reconcile)
+	common.ErrCheckExitf(err, 1, "invalid deployment plan %s: %s", args[0], err)
+
+	// All the entries are converted before deploying anything, so that
+	// an error in the plan does not leave a partial deployment behind
+	type planItem struct {
+		sd sandbox.SandboxDef
+		rd sandbox.ReplicationData
+	}
+	var items []planItem
+	for i, ps := range plan.Sandboxes {
+		sd, rd, err := sandbox.PlanSandboxToDefinition(ps, sandboxBinary, sandboxHome)
+		common.ErrCheckExitf(err, 1, "error in sandbox #%d of deployment plan: %s", i+1, err)
+		sd.Force = force
+		sd.Reconcile = reconcile
+		items = append(items, planItem{sd: sd, rd: rd})
+	}
+	if dryRun {
+		for i, item := range items {
+			fmt.Printf("%d %-12s %-10s %-10s nodes: %d\n", i+1, item.rd.Topology, item.sd.Flavor, item.sd.Version, item.rd.Nodes)
+		}
+		return
+	}
+	err = common.CheckPrerequisites("dbdeployer needed tools", globals.NeededExecutables)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	for i, item := range items {
+		// Ports used by the sandboxes deployed in previous steps
+		// must be excluded from the next ones
+		installedPorts, err := common.GetInstalledPorts(sandboxHome)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		item.sd.InstalledPorts = append(item.sd.InstalledPorts, installedPorts...)
+		common.CondPrintf("# Deploying sandbox #%d (%s %s)\n", i+1, item.rd.Topology, item.sd.Version)
+		err = sandbox.DeployPlanSandbox(item.sd, item.rd)
+		if err != nil {
+			common.Exitf(1, globals.ErrCreatingSandbox, err)
+		}
+	}
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan plan-file",
+	Short: "deploys sandboxes from a plan file",
+	Long: `Deploys one or more sandboxes described in a YAML or JSON file.
+The file format is chosen from the extension (.yaml or .yml for YAML, anything else for JSON.)
+The whole plan is validated before any sandbox is created.
+With --from-sandbox, the command writes a plan that reproduces an existing sandbox,
+either to the given file or to the standard output.
+With --reconcile, sandboxes that already exist are left untouched when they match the plan,
+get a new configuration and a restart when only the server options differ,
+and are rebuilt when other properties differ. A rebuild replaces the existing
+sandbox only if --force is also used. Otherwise, the differences are listed
+and the deployment stops.
+`,
+	Example: `
+	$ dbdeployer deploy plan my-sandboxes.yaml
+	$ dbdeployer deploy plan my-sandboxes.yaml --dry-run
+	$ dbdeployer deploy plan my-sandboxes.yaml --reconcile
+	$ dbdeployer deploy plan --from-sandbox=msb_8_0_36 msb_8_0_36.yaml
+
+	# Sample plan
+	plan-version: 1
+	sandboxes:
+	  - version: "8.0"
+	    topology: master-slave
+	    nodes: 3
+	    gtid: true
+	    my-cnf-options:
+	      - max_connections=500
+	  - version: 5.7.44
+	    name: single57
+	    port: 15744
+	`,
+	Run:         deployPlan,
+	Annotations: map[string]string{"export": ExportAnnotationToJson(StringExport)},
+}
+
+func init() {
+	deployCmd.AddCommand(planCmd)
+	planCmd.Flags().Bool(globals.DryRunLabel, false, "Validates the plan and shows what would be deployed")
+	planCmd.Flags().String(globals.FromSandboxLabel, "", "Writes a plan from an existing sandbox instead of deploying")
+}
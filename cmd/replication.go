@@ -25,6 +25,7 @@ import (
 func replicationSandbox(cmd *cobra.Command, args []string) {
 	var sd sandbox.SandboxDef
 	var semisync bool
+	args = basedirFromSystem(cmd, args)
 	common.CheckOrigin(args)
 	sd, err := fillSandboxDefinition(cmd, args, false)
 	common.ErrCheckExitf(err, 1, "error filling sandbox definition : %s", err)
@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"fmt"
+	"path"
 	"sort"
 	"time"
 
@@ -27,14 +28,69 @@ import (
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
 )
 
-func showSandboxesFromCatalog(currentSandboxHome string, useFlavor, useHeader, useTable bool) {
+// sandboxRecord is the structured description of a deployed sandbox,
+// used for --output=json|yaml
+type sandboxRecord struct {
+	Name string `json:"name"`
+	defaults.SandboxItem
+	Locked bool                     `json:"locked"`
+	Status *lifecycle.SandboxStatus `json:"status,omitempty"`
+}
+
+// newSandboxRecord combines the catalog entry of a sandbox with its live state.
+// When the sandbox is not in the catalog, the entry is built from its description
+func newSandboxRecord(sandboxDir string, catalog defaults.SandboxCatalog) sandboxRecord {
+	record := sandboxRecord{
+		Name: common.BaseName(sandboxDir),
+		Locked: common.FileExists(path.Join(sandboxDir, globals.ScriptNoClear)) ||
+			common.FileExists(path.Join(sandboxDir, globals.ScriptNoClearAll)),
+	}
+	item, found := catalog[sandboxDir]
+	if !found {
+		sbDesc, _ := common.ReadSandboxDescription(sandboxDir)
+		item = defaults.SandboxItem{
+			Origin:            sbDesc.Basedir,
+			SBType:            sbDesc.SBType,
+			Version:           sbDesc.Version,
+			Flavor:            sbDesc.Flavor,
+			Host:              sbDesc.Host,
+			Port:              sbDesc.Port,
+			Destination:       sandboxDir,
+			DbDeployerVersion: sbDesc.DbDeployerVersion,
+			Timestamp:         sbDesc.Timestamp,
+			CommandLine:       sbDesc.CommandLine,
+		}
+	}
+	record.SandboxItem = item
+	status, err := lifecycle.Status(sandboxDir)
+	if err == nil {
+		record.Status = &status
+	}
+	return record
+}
+
+func showSandboxesFromCatalog(currentSandboxHome string, useFlavor, useHeader, useTable bool, output string) {
 	var sandboxList defaults.SandboxCatalog
 	var err error
 	sandboxList, err = defaults.ReadCatalog()
 
 	common.ErrCheckExitf(err, 1, "error getting sandboxes from catalog: %s", err)
+	if output != globals.OutputTable {
+		var names []string
+		for name := range sandboxList {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		records := []sandboxRecord{}
+		for _, name := range names {
+			records = append(records, newSandboxRecord(name, sandboxList))
+		}
+		printStructured(output, outputKindSandboxes, records)
+		return
+	}
 	if len(sandboxList) == 0 {
 		return
 	}
@@ -95,6 +151,7 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 	byFlavor, _ := flags.GetBool(globals.ByFlavorLabel)
 	latest, _ := flags.GetBool(globals.LatestLabel)
 	oldest, _ := flags.GetBool(globals.OldestLabel)
+	output := getOutputFormat(cmd)
 	useHost := false
 
 	if oldest && latest {
@@ -115,7 +172,7 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 		useHost = true
 	}
 	if readCatalog {
-		showSandboxesFromCatalog(SandboxHome, useFlavor, useHeader, useTable)
+		showSandboxesFromCatalog(SandboxHome, useFlavor, useHeader, useTable, output)
 		return
 	}
 	var sandboxList common.SandboxInfoList
@@ -136,7 +193,7 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 			useHost = true
 		}
 	}
-	if len(sandboxList) == 0 {
+	if len(sandboxList) == 0 && output == globals.OutputTable {
 		return
 	}
 
@@ -153,12 +210,24 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 			return sandboxList[i].SandboxDesc.Flavor < sandboxList[j].SandboxDesc.Flavor
 		})
 	}
-	if oldest {
+	if oldest && len(sandboxList) > 0 {
 		sandboxList = common.SandboxInfoList{sandboxList[0]}
 	}
-	if latest {
+	if latest && len(sandboxList) > 0 {
 		sandboxList = common.SandboxInfoList{sandboxList[len(sandboxList)-1]}
 	}
+	if output != globals.OutputTable {
+		catalog, err := defaults.ReadCatalog()
+		common.ErrCheckExitf(err, 1, "error getting sandboxes from catalog: %s", err)
+		sandboxHome, err := common.AbsolutePath(SandboxHome)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		records := []sandboxRecord{}
+		for _, sb := range sandboxList {
+			records = append(records, newSandboxRecord(path.Join(sandboxHome, sb.SandboxName), catalog))
+		}
+		printStructured(output, outputKindSandboxes, records)
+		return
+	}
 
 	table := simpletable.New()
 
@@ -262,6 +331,8 @@ If sandboxes are installed in a different location, use --sandbox-home to
 indicate where to look.
 Alternatively, using --catalog will list all sandboxes, regardless of where 
 they were deployed.
+With --output=json or --output=yaml, the list includes the catalog entry
+and the live state of every server in each sandbox.
 `,
 	Aliases: []string{"installed", "deployed"},
 	Run:     showSandboxes,
@@ -280,4 +351,5 @@ func init() {
 	sandboxesCmd.Flags().BoolP(globals.ByVersionLabel, "", false, "Show sandboxes sorted by version")
 	sandboxesCmd.Flags().BoolP(globals.LatestLabel, "", false, "Show only latest sandbox")
 	sandboxesCmd.Flags().BoolP(globals.OldestLabel, "", false, "Show only oldest sandbox")
+	addOutputFlag(sandboxesCmd)
 }
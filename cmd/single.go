@@ -24,7 +24,9 @@ import (
 
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/downloads"
 	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/ops"
 	"github.com/datacharmer/dbdeployer/sandbox"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -89,7 +91,7 @@ func getAbsolutePathFromFlag(cmd *cobra.Command, name string) (string, error) {
 	}
 }
 
-func checkIfAbridgedVersion(version, basedir string) string {
+func checkIfAbridgedVersion(version, flavor, basedir string) string {
 	fullPattern := regexp.MustCompile(`\d\.\d+\.\d+$`)
 	if fullPattern.MatchString(version) {
 		return version
@@ -98,6 +100,19 @@ func checkIfAbridgedVersion(version, basedir string) string {
 	if !validPattern.MatchString(version) {
 		return version
 	}
+	lockedVersion, lockFile, err := downloads.LockedVersion(version, flavor)
+	if err != nil {
+		common.Exitf(1, "error reading the lock file: %s", err)
+	}
+	if lockedVersion != "" {
+		if !common.DirExists(path.Join(basedir, lockedVersion)) {
+			common.Exitf(1, "version %s is locked to %s in %s, but %s was not found in %s\n"+
+				"Use 'dbdeployer downloads get-by-version %s --unpack' to install it\n",
+				version, lockedVersion, lockFile, lockedVersion, basedir, version)
+		}
+		common.CondPrintf("# %s => %s (locked in %s)\n", version, lockedVersion, lockFile)
+		return lockedVersion
+	}
 	fullVersion := common.LatestVersion(basedir, version)
 	if fullVersion == "" {
 		common.Exitf(1, "FATAL: no full version found for %s in %s\n", version, basedir)
@@ -150,6 +165,32 @@ func getFlavor(userDefinedFlavor, basedir string) string {
 	return flavor
 }
 
+// basedirFromSystem replaces the version argument with a basedir linked to the
+// binaries of the system packages, when --basedir-from-system is used
+func basedirFromSystem(cmd *cobra.Command, args []string) []string {
+	fromSystem, _ := cmd.Flags().GetBool(globals.BasedirFromSystemLabel)
+	if !fromSystem {
+		return args
+	}
+	if len(args) > 0 {
+		common.Exitf(1, "option --%s replaces the version argument (%s given)\n", globals.BasedirFromSystemLabel, args[0])
+	}
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
+	common.ErrCheckExitf(err, 1, "error getting absolute path for '%s'", globals.SandboxBinaryLabel)
+	flavor, _ := cmd.Flags().GetString(globals.FlavorLabel)
+	// The links are rebuilt when they don't match the installed packages, to follow upgrades
+	sb, err := ops.CreateSystemBasedir(ops.SystemBinariesOptions{
+		SandboxBinary:  sandboxBinary,
+		Flavor:         flavor,
+		Overwrite:      true,
+		ReuseUnchanged: true,
+	})
+	if err != nil {
+		common.Exitf(1, "error using system binaries: %s\n", err)
+	}
+	return []string{common.BaseName(sb.Basedir)}
+}
+
 func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool) (sandbox.SandboxDef, error) {
 	var sd sandbox.SandboxDef
 	var err error
@@ -199,7 +240,8 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	if sd.Version == "" {
 		sd.Version = args[0]
 		oldVersion := sd.Version
-		sd.Version = checkIfAbridgedVersion(sd.Version, basedir)
+		flavor, _ := flags.GetString(globals.FlavorLabel)
+		sd.Version = checkIfAbridgedVersion(sd.Version, flavor, basedir)
 		if oldVersion != sd.Version {
 			sd.BasedirName = sd.Version
 		}
@@ -341,6 +383,7 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	sd.RplPassword, _ = flags.GetString(globals.RplPasswordLabel)
 	sd.RemoteAccess, _ = flags.GetString(globals.RemoteAccessLabel)
 	sd.BindAddress, _ = flags.GetString(globals.BindAddressLabel)
+	common.PortBindAddress = sd.BindAddress
 	sd.CustomMysqld, _ = flags.GetString(globals.CustomMysqldLabel)
 	sd.InitOptions, _ = flags.GetStringArray(globals.InitOptionsLabel)
 	sd.MyCnfOptions, _ = flags.GetStringArray(globals.MyCnfOptionsLabel)
@@ -353,6 +396,7 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 	sd.NativeAuthPlugin, _ = flags.GetBool(globals.NativeAuthPluginLabel)
 	sd.KeepUuid, _ = flags.GetBool(globals.KeepServerUuidLabel)
 	sd.Force, _ = flags.GetBool(globals.ForceLabel)
+	sd.Reconcile, _ = flags.GetBool(globals.ReconcileLabel)
 	sd.ExposeDdTables, _ = flags.GetBool(globals.ExposeDdTablesLabel)
 	sd.InitGeneralLog, _ = flags.GetBool(globals.InitGeneralLogLabel)
 	sd.EnableGeneralLog, _ = flags.GetBool(globals.EnableGeneralLogLabel)
@@ -440,6 +484,7 @@ func fillSandboxDefinition(cmd *cobra.Command, args []string, usingImport bool)
 func singleSandbox(cmd *cobra.Command, args []string) {
 	var sd sandbox.SandboxDef
 	var err error
+	args = basedirFromSystem(cmd, args)
 	common.CheckOrigin(args)
 	sd, err = fillSandboxDefinition(cmd, args, false)
 	if err != nil {
@@ -464,10 +509,13 @@ For example:
 	dbdeployer deploy single 5.7     # deploys the latest release of 5.7.x
 	dbdeployer deploy single 5.7.21  # deploys a specific release
 	dbdeployer deploy single /path/to/5.7.21  # deploys a specific release in a given path
+	dbdeployer deploy single --basedir-from-system  # deploys the server installed by apt/yum
 
 For this command to work, there must be a directory $HOME/opt/mysql/5.7.21, containing
 the binary files from mysql-5.7.21-$YOUR_OS-x86_64.tar.gz
 Use the "unpack" command to get the tarball into the right directory.
+With --basedir-from-system, the version is not needed: the server installed by the system packages
+is linked into $HOME/opt/mysql/system8.0.xx (see 'dbdeployer unpack --from-system'), and used from there.
 `,
 	Run:         singleSandbox,
 	Annotations: map[string]string{"export": makeExportArgs(globals.ExportVersionDir, 1)},
@@ -37,23 +37,65 @@ func unpackTarball(cmd *cobra.Command, args []string) {
 	flavor, _ := flags.GetString(globals.FlavorLabel)
 	dryRun, _ := flags.GetBool(globals.DryRunLabel)
 	Version, _ := flags.GetString(globals.UnpackVersionLabel)
+	requireSignature, _ := flags.GetBool(globals.RequireSignatureLabel)
+	makeMinimal, _ := flags.GetBool(globals.MakeMinimalLabel)
+	stripSymbols, _ := flags.GetBool(globals.StripSymbolsLabel)
+	packDir, _ := flags.GetString(globals.PackDirLabel)
+	if (stripSymbols || packDir != "") && !makeMinimal {
+		common.Exitf(1, "options --%s and --%s require --%s", globals.StripSymbolsLabel, globals.PackDirLabel, globals.MakeMinimalLabel)
+	}
+	if packDir != "" {
+		packDir, err = common.AbsolutePath(packDir)
+		common.ErrCheckExitf(err, 1, "error getting absolute path for %s", packDir)
+	}
 	if !common.DirExists(Basedir) {
 		common.Exit(1,
 			fmt.Sprintf(globals.ErrDirectoryNotFound, Basedir),
 			"You should create it or provide an alternate base directory using --sandbox-binary")
 	}
+	fromSystem, _ := flags.GetBool(globals.FromSystemLabel)
+	if fromSystem {
+		if len(args) > 0 {
+			common.Exitf(1, "option --%s does not use a tarball (%s given)", globals.FromSystemLabel, args[0])
+		}
+		if isShell || makeMinimal {
+			common.Exitf(1, "option --%s can't be used with --%s or --%s", globals.FromSystemLabel, globals.ShellLabel, globals.MakeMinimalLabel)
+		}
+		systemRoot, _ := flags.GetString(globals.SystemRootLabel)
+		_, err = ops.CreateSystemBasedir(ops.SystemBinariesOptions{
+			SandboxBinary: Basedir,
+			SystemRoot:    systemRoot,
+			Prefix:        Prefix,
+			Flavor:        flavor,
+			Version:       Version,
+			Overwrite:     overwrite,
+			DryRun:        dryRun,
+		})
+		if err != nil {
+			common.Exitf(1, "error using system binaries: %s", err)
+		}
+		return
+	}
+	if len(args) < 1 {
+		common.Exitf(1, "this command requires a tarball, or --%s", globals.FromSystemLabel)
+	}
 
 	err = ops.UnpackTarball(ops.UnpackOptions{
-		SandboxBinary: Basedir,
-		TarballName:   args[0],
-		TargetServer:  target,
-		Version:       Version,
-		Prefix:        Prefix,
-		Flavor:        flavor,
-		Verbosity:     verbosity,
-		IsShell:       isShell,
-		Overwrite:     overwrite,
-		DryRun:        dryRun,
+		SandboxBinary:    Basedir,
+		TarballName:      args[0],
+		TargetServer:     target,
+		Version:          Version,
+		Prefix:           Prefix,
+		Flavor:           flavor,
+		Verbosity:        verbosity,
+		IsShell:          isShell,
+		Overwrite:        overwrite,
+		DryRun:           dryRun,
+		RequireSignature: requireSignature,
+		MakeMinimal:      makeMinimal,
+		StripSymbols:     stripSymbols,
+		PackDir:          packDir,
+		ExtraPackages:    args[1:],
 	})
 
 	if err != nil {
@@ -63,16 +105,27 @@ func unpackTarball(cmd *cobra.Command, args []string) {
 
 // unpackCmd represents the unpack command
 var unpackCmd = &cobra.Command{
-	Use:     "unpack MySQL-tarball",
-	Args:    cobra.ExactArgs(1),
+	Use:     "unpack {MySQL-tarball | MySQL-package [MySQL-package ...] | --from-system}",
+	Args:    cobra.ArbitraryArgs,
 	Aliases: []string{"extract", "untar", "unzip", "inflate", "expand"},
 	Short:   "unpack a tarball into the binary directory",
-	Long: `If you want to create a sandbox from a tarball (.tar.gz or .tar.xz), you first need to unpack it
+	Long: `If you want to create a sandbox from a tarball (.tar.gz, .tar.xz, .tar.zst, or .zip), you first need to unpack it
 into the sandbox-binary directory. This command carries out that task, so that afterwards 
 you can call 'deploy single', 'deploy multiple', and 'deploy replication' commands with only 
 the MySQL version for that tarball.
 If the version is not contained in the tarball name, it should be supplied using --unpack-version.
 If there is already an expanded tarball with the same version, a new one can be differentiated with --prefix.
+If a signature file (tarball name + ".asc") is found next to the tarball, it is verified against
+the public keys stored in $HOME/.dbdeployer/keys, and the result is saved in the expanded directory
+(` + globals.SignatureFileName + `). With --require-signature, tarballs without a valid signature are refused.
+With --make-minimal, the expanded directory is reduced to what a sandbox needs: debug binaries,
+test suites, static libraries, and test plugins are removed (see 'dbdeployer downloads minimize').
+RPM (.rpm) and DEB (.deb) packages are also accepted: the files that a sandbox needs (server, client,
+libraries, plugins, error messages) are placed in the layout of an expanded tarball. Since server and
+client come in separate packages, several packages can be given, and are unpacked into the same directory.
+With --from-system, there is no tarball: the server and client installed by the system packages
+(apt, yum, dnf) are linked into a directory named after their version (system8.0.36), with the
+layout of an expanded tarball, so that it can be used for deploying sandboxes.
 `,
 	Run: unpackTarball,
 	Example: `
@@ -84,6 +137,17 @@ If there is already an expanded tarball with the same version, a new one can be
 
     $ dbdeployer unpack --unpack-version=8.0.18 --prefix=bld mysql-mybuild.tar.gz
     Unpacking tarball mysql-mybuild.tar.gz to $HOME/opt/mysql/bld8.0.18
+
+    $ dbdeployer unpack --require-signature mysql-8.0.36-linux-glibc2.28-x86_64.tar.xz
+
+    $ dbdeployer unpack --make-minimal --strip-symbols --pack-dir=/var/cache/tarballs mysql-8.0.36-linux-glibc2.28-x86_64.tar.xz
+
+    $ dbdeployer unpack mysql-community-server-core_8.0.36-1ubuntu22.04_amd64.deb \
+        mysql-community-client-core_8.0.36-1ubuntu22.04_amd64.deb \
+        mysql-community-client-plugins_8.0.36-1ubuntu22.04_amd64.deb
+
+    $ dbdeployer unpack --from-system
+    Created $HOME/opt/mysql/system8.0.36
 	`,
 	Annotations: map[string]string{"export": ExportAnnotationToJson(StringExport)},
 }
@@ -99,4 +163,10 @@ func init() {
 	unpackCmd.PersistentFlags().Bool(globals.DryRunLabel, false, "Show unpack operations, but do not run them")
 	unpackCmd.PersistentFlags().String(globals.TargetServerLabel, "", "Uses a different server to unpack a shell tarball")
 	unpackCmd.PersistentFlags().String(globals.FlavorLabel, "", "Defines the tarball flavor (MySQL, NDB, Percona Server, etc)")
+	unpackCmd.PersistentFlags().Bool(globals.RequireSignatureLabel, false, "Refuse a tarball without a valid signature (tarball.asc)")
+	unpackCmd.PersistentFlags().Bool(globals.MakeMinimalLabel, false, "Remove from the expanded directory what a sandbox does not need")
+	unpackCmd.PersistentFlags().Bool(globals.StripSymbolsLabel, false, "With --make-minimal, also remove debugging symbols from executables and libraries")
+	unpackCmd.PersistentFlags().String(globals.PackDirLabel, "", "With --make-minimal, pack the result as a minimal tarball in this directory")
+	unpackCmd.PersistentFlags().Bool(globals.FromSystemLabel, false, "Link the binaries installed by the system packages instead of unpacking a tarball")
+	unpackCmd.PersistentFlags().String(globals.SystemRootLabel, "/", "With --from-system, root of the file system where the packages are installed")
 }
@@ -31,12 +31,22 @@ func showVersions(cmd *cobra.Command, args []string) {
 	common.ErrCheckExitf(err, 1, "error getting absolute path for 'sandbox-binary'")
 	flavor, _ := cmd.Flags().GetString(globals.FlavorLabel)
 	byFlavor, _ := cmd.Flags().GetBool(globals.ByFlavorLabel)
+	output := getOutputFormat(cmd)
 
-	err = ops.ShowVersions(ops.VersionOptions{
+	options := ops.VersionOptions{
 		SandboxBinary: basedir,
 		Flavor:        flavor,
 		ByFlavor:      byFlavor,
-	})
+	}
+	if output != globals.OutputTable {
+		records, err := ops.GetVersions(options)
+		if err != nil {
+			common.Exitf(1, "error getting versions: %s", err)
+		}
+		printStructured(output, outputKindVersions, records)
+		return
+	}
+	err = ops.ShowVersions(options)
 	if err != nil {
 		common.Exitf(1, "error showing versions: %s", err)
 	}
@@ -55,4 +65,5 @@ func init() {
 	rootCmd.AddCommand(versionsCmd)
 	setPflag(versionsCmd, globals.FlavorLabel, "", "", "", "Get only versions of the given flavor", false)
 	versionsCmd.Flags().BoolP(globals.ByFlavorLabel, "", false, "Shows versions list by flavor")
+	addOutputFlag(versionsCmd)
 }
@@ -429,6 +429,11 @@ Checks that the extracted tarball directory
 	* using a source or test tarball instead of a binaries one.
 */
 func CheckTarballOperatingSystem(basedir string) error {
+	// A basedir made of links to the binaries installed on this host
+	// (see 'dbdeployer unpack --from-system') can't be for a different OS
+	if FileExists(path.Join(basedir, globals.SystemBinariesFileName)) {
+		return nil
+	}
 	currentOs := runtime.GOOS
 	// CondPrintf("<%s>\n",currentOs)
 	type OSFinding struct {
@@ -495,7 +500,8 @@ func CheckTarballOperatingSystem(basedir string) error {
 // for use with dbdeployer
 func IsATarball(fileName string) bool {
 	if strings.HasSuffix(fileName, ".tar.gz") ||
-		strings.HasSuffix(fileName, ".tar.xz") {
+		strings.HasSuffix(fileName, ".tar.xz") ||
+		strings.HasSuffix(fileName, ".tar.zst") {
 		return true
 	}
 	return false
@@ -785,6 +791,8 @@ func findFreePortRange(basePort int, usedPorts PortMap, howMany int) (int, error
 // installedPorts is a slice of ports already used by other sandboxes.
 // Calls either findFreePortRange or findFreePortSingle, depending on the
 // amount of ports requested.
+// When PortLeaseRegistry is set, the ports leased by other dbdeployer processes
+// and the ones that can't be bound are skipped, and the ports found are leased.
 // Returns the first port of the requested range
 func FindFreePort(basePort int, installedPorts []int, howMany int) (int, error) {
 	if portDebug {
@@ -795,6 +803,9 @@ func FindFreePort(basePort int, installedPorts []int, howMany int) (int, error)
 	for _, p := range installedPorts {
 		usedPorts[p] = true
 	}
+	if PortLeaseRegistry != "" {
+		return leaseFreePort(basePort, usedPorts, howMany)
+	}
 	if howMany == 1 {
 		return findFreePortSingle(basePort, usedPorts)
 	}
@@ -45,7 +45,9 @@ func TestIsATarball(t *testing.T) {
 	var data = []testStringBool{
 		{"dummy.tar.gz", true},
 		{"dummy.tar.xz", true},
+		{"dummy.tar.zst", true},
 		{"dummy.targz", false},
+		{"dummy.zst", false},
 		{"dummy.tar", false},
 		{"dummy.gz", false},
 		{"dummy.xz", false},
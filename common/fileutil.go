@@ -48,19 +48,21 @@ type SandboxUser struct {
 }
 
 type SandboxDescription struct {
-	Basedir           string `json:"basedir"`
-	ClientBasedir     string `json:"client_basedir,omitempty"`
-	SBType            string `json:"type"` // single multi master-slave group
-	Version           string `json:"version"`
-	Flavor            string `json:"flavor,omitempty"`
-	Host              string `json:"host,omitempty"`
-	Port              []int  `json:"port"`
-	Nodes             int    `json:"nodes"`
-	NodeNum           int    `json:"node_num"`
-	DbDeployerVersion string `json:"dbdeployer-version"`
-	Timestamp         string `json:"timestamp"`
-	CommandLine       string `json:"command-line"`
-	LogFile           string `json:"log-file,omitempty"`
+	Basedir           string   `json:"basedir"`
+	ClientBasedir     string   `json:"client_basedir,omitempty"`
+	SBType            string   `json:"type"` // single multi master-slave group
+	Version           string   `json:"version"`
+	Flavor            string   `json:"flavor,omitempty"`
+	Host              string   `json:"host,omitempty"`
+	Port              []int    `json:"port"`
+	Nodes             int      `json:"nodes"`
+	NodeNum           int      `json:"node_num"`
+	DbDeployerVersion string   `json:"dbdeployer-version"`
+	Timestamp         string   `json:"timestamp"`
+	CommandLine       string   `json:"command-line"`
+	LogFile           string   `json:"log-file,omitempty"`
+	MyCnfOptions      []string `json:"my-cnf-options,omitempty"`
+	CustomMysqld      string   `json:"custom-mysqld,omitempty"`
 }
 
 type KeyValue struct {
@@ -72,6 +74,49 @@ type ConfigOptions map[string][]KeyValue
 
 var CommandLineArgs []string
 
+// Values that must not appear in the recorded command line, such as passwords
+// given as positional arguments
+var commandLineSecrets = make(map[string]bool)
+
+// RedactedValue replaces passwords in logs and recorded command lines
+const RedactedValue = "*****"
+
+// AddCommandLineSecret registers a value that will be masked in the recorded command line
+func AddCommandLineSecret(secret string) {
+	if secret != "" {
+		commandLineSecrets[secret] = true
+	}
+}
+
+// RedactedCommandLine returns the command line that invoked dbdeployer, with passwords masked.
+// Besides the registered secrets, it masks the value of options whose name ends in "password"
+// (such as --db-password=xxx or --rpl-password xxx)
+func RedactedCommandLine() string {
+	rePasswordOption := regexp.MustCompile(`^(--?[\w-]*password)(=.*)?$`)
+	var args []string
+	maskNext := false
+	for _, arg := range CommandLineArgs {
+		switch {
+		case maskNext:
+			arg = RedactedValue
+			maskNext = false
+		case commandLineSecrets[arg]:
+			arg = RedactedValue
+		default:
+			option := rePasswordOption.FindStringSubmatch(arg)
+			if len(option) > 0 {
+				if option[2] != "" {
+					arg = option[1] + "=" + RedactedValue
+				} else {
+					maskNext = true
+				}
+			}
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, " ")
+}
+
 // Returns the name of the log directory
 func LogDirName() string {
 	logDirName := ""
@@ -159,7 +204,7 @@ func ParseConfigFile(filename string) (ConfigOptions, error) {
 func WriteSandboxDescription(destination string, sd SandboxDescription) error {
 	sd.DbDeployerVersion = VersionDef
 	sd.Timestamp = time.Now().Format(time.UnixDate)
-	sd.CommandLine = strings.Join(CommandLineArgs, " ")
+	sd.CommandLine = RedactedCommandLine()
 	b, err := json.MarshalIndent(sd, " ", "\t")
 	if err != nil {
 		return errors.Wrapf(err, "error encoding sandbox description")
@@ -257,24 +302,38 @@ func WriteStrings(lines []string, filename string, termination string) error {
 	return w.Flush()
 }
 
-// Get a file checksum, choosing among MD5, SH1, SHA256, and SHA512
-func GetFileChecksum(fileName, crcType string) (string, error) {
-	var hasher hash.Hash
+// NewChecksumHasher returns the hash function for a checksum type (MD5, SHA1, SHA256, SHA512)
+func NewChecksumHasher(crcType string) (hash.Hash, error) {
 	switch strings.ToLower(crcType) {
 	case "md5":
-		hasher = md5.New() // #nosec G401 need to compute legacy checksums
+		return md5.New(), nil // #nosec G401 need to compute legacy checksums
 	case "sha1":
-		hasher = sha1.New() // #nosec G401 need to compute legacy checksums
+		return sha1.New(), nil // #nosec G401 need to compute legacy checksums
 	case "sha256":
-		hasher = sha256.New()
+		return sha256.New(), nil
 	case "sha512":
-		hasher = sha512.New()
-	default:
-		return globals.EmptyString, fmt.Errorf("unsupported checksum type %s", crcType)
+		return sha512.New(), nil
 	}
+	return nil, fmt.Errorf("unsupported checksum type %s", crcType)
+}
+
+// ParseChecksum splits a checksum in the format "TYPE:VALUE" (e.g. "SHA256:a1b2c3...")
+// into its type and value
+func ParseChecksum(checksum string) (crcType, crcText string, err error) {
+	reCRC := regexp.MustCompile(`(MD5|SHA1|SHA256|SHA512)\s*:\s*(\S+)`)
+	crcList := reCRC.FindAllStringSubmatch(checksum, -1)
+
+	if len(crcList) < 1 || len(crcList[0]) < 2 {
+		return "", "", fmt.Errorf("not a valid CRC pattern found. Expected: (MD5|SHA1|SHA256|SHA512):CHECKSUM_STRING")
+	}
+	return crcList[0][1], crcList[0][2], nil
+}
 
-	if hasher == nil {
-		return globals.EmptyString, fmt.Errorf("unhandled checksum error")
+// Get a file checksum, choosing among MD5, SH1, SHA256, and SHA512
+func GetFileChecksum(fileName, crcType string) (string, error) {
+	hasher, err := NewChecksumHasher(crcType)
+	if err != nil {
+		return globals.EmptyString, err
 	}
 	f, err := os.Open(fileName) // #nosec G304
 	if err != nil {
@@ -330,6 +389,26 @@ func WriteString(line string, filename string) error {
 	return WriteStrings([]string{line}, filename, "")
 }
 
+// WritePrivateString writes a string into a file that only its owner can read
+func WritePrivateString(line string, filename string) error {
+	// #nosec G304
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, globals.PrivateFileAttr)
+	if err != nil {
+		return errors.Wrapf(err, "error creating file %s", filename)
+	}
+	defer file.Close() // #nosec G307
+	// An existing file keeps its permissions when opened
+	err = file.Chmod(globals.PrivateFileAttr)
+	if err != nil {
+		return errors.Wrapf(err, "error changing permissions of file %s", filename)
+	}
+	_, err = file.WriteString(line)
+	if err != nil {
+		return errors.Wrapf(err, "error writing to file %s", filename)
+	}
+	return nil
+}
+
 // returns true if a given file exists
 func FileExists(filename string) bool {
 	_, err := os.Stat(filename)
@@ -596,6 +675,63 @@ func CopyFile(source, destination string) error {
 	return nil
 }
 
+// CloneDirectory copies the contents of source into destination, which is created if needed.
+// Regular files are cloned with copy-on-write (reflink) when the file system supports it,
+// and copied otherwise. Hard links are not used, because a database server modifies its
+// files in place, and the changes would show in both directories.
+// Paths (relative to source) for which skip returns true are not copied.
+// Returns the number of files that were cloned and copied.
+func CloneDirectory(source, destination string, skip func(relPath string) bool) (cloned, copied int, err error) {
+	if !DirExists(source) {
+		return 0, 0, fmt.Errorf(globals.ErrDirectoryNotFound, source)
+	}
+	useReflink := true
+	err = filepath.Walk(source, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(source, fullPath)
+		if err != nil {
+			return err
+		}
+		if relPath != "." && skip != nil && skip(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := path.Join(destination, relPath)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(fullPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			// After the first failure, we don't try cloning again,
+			// as the file system does not support it
+			if useReflink {
+				if reflinkFile(fullPath, target, info.Mode().Perm()) == nil {
+					cloned++
+					return nil
+				}
+				useReflink = false
+			}
+			err = CopyFile(fullPath, target)
+			if err != nil {
+				return err
+			}
+			copied++
+		}
+		// Sockets and pipes are not copied
+		return nil
+	})
+	return cloned, copied, err
+}
+
 // Returns the base name of a file
 func BaseName(filename string) string {
 	return filepath.Base(filename)
@@ -61,6 +61,30 @@ func TestLogDirName(t *testing.T) {
 	}
 }
 
+func TestRedactedCommandLine(t *testing.T) {
+	savedArgs := CommandLineArgs
+	defer func() { CommandLineArgs = savedArgs }()
+	AddCommandLineSecret("nOtMyPassW0rd")
+	var data = []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"dbdeployer", "deploy", "single", "8.0.36"}, "dbdeployer deploy single 8.0.36"},
+		{[]string{"dbdeployer", "import", "single", "db1", "3306", "admin", "nOtMyPassW0rd"},
+			"dbdeployer import single db1 3306 admin *****"},
+		{[]string{"dbdeployer", "deploy", "single", "8.0.36", "--db-password=secret"},
+			"dbdeployer deploy single 8.0.36 --db-password=*****"},
+		{[]string{"dbdeployer", "deploy", "single", "--rpl-password", "secret", "8.0.36"},
+			"dbdeployer deploy single --rpl-password ***** 8.0.36"},
+		{[]string{"dbdeployer", "import", "single", "db1", "3306", "--password-env=DB_PWD"},
+			"dbdeployer import single db1 3306 --password-env=DB_PWD"},
+	}
+	for _, d := range data {
+		CommandLineArgs = d.args
+		compare.OkEqualString(fmt.Sprintf("redacted %v", d.args), RedactedCommandLine(), d.expected, t)
+	}
+}
+
 func TestParseConfigFile(t *testing.T) {
 	var sampleConfig = ConfigOptions{
 		"label1": {
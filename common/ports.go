@@ -0,0 +1,277 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nightlyone/lockfile"
+
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// PortLease is a temporary reservation of a port, made by a dbdeployer process
+// between the moment a port is found free and the moment the sandbox using it is installed
+type PortLease struct {
+	Port    int    `json:"port"`
+	Pid     int    `json:"pid"`
+	Owner   string `json:"owner"`
+	Created string `json:"created"`
+	Expires string `json:"expires"`
+}
+
+type portLeaseMap map[int]PortLease
+
+var (
+	// PortLeaseRegistry is the file containing the port leases.
+	// When empty, FindFreePort does not use leases, and does not check whether ports are bindable.
+	PortLeaseRegistry string
+	// PortLeaseLock is the lock file that guards PortLeaseRegistry
+	PortLeaseLock string
+	// PortLeaseTTL is the duration of a lease.
+	// After that, the sandbox is expected to be installed, and its ports to be found by GetInstalledPorts
+	PortLeaseTTL = 10 * time.Minute
+	// PortBindAddress is the address where the servers being deployed will listen.
+	// IsPortBindable checks it together with the local host and with all addresses
+	PortBindAddress = globals.BindAddressValue
+)
+
+// Timeout for waiting on concurrent lease requests
+const portLockTimeout = 5 * time.Second
+
+// IsPortBindable tells whether a port can be used by a server listening on the local host,
+// on all addresses, or on PortBindAddress
+func IsPortBindable(port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(globals.LocalHostIP, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	_ = listener.Close()
+	addresses := []string{"0.0.0.0"}
+	if PortBindAddress != "" && PortBindAddress != globals.LocalHostIP && PortBindAddress != "0.0.0.0" {
+		addresses = append(addresses, PortBindAddress)
+	}
+	for _, address := range addresses {
+		listener, err = net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+		if err != nil {
+			// An address that doesn't belong to this host can't tell whether the port is free:
+			// only a port already in use makes it unavailable
+			if errors.Is(err, syscall.EADDRINUSE) {
+				return false
+			}
+			continue
+		}
+		_ = listener.Close()
+	}
+	return true
+}
+
+// Sets the lease lock, waiting up to portLockTimeout if a concurrent operation is under way
+func setPortLeaseLock() (lockfile.Lockfile, error) {
+	err := os.MkdirAll(path.Dir(PortLeaseLock), globals.PublicDirectoryAttr)
+	if err != nil {
+		return lockfile.Lockfile(""), err
+	}
+	lock, err := lockfile.New(PortLeaseLock)
+	if err != nil {
+		return lockfile.Lockfile(""), fmt.Errorf("could not establish lock file for port leases: %s", err)
+	}
+	err = lock.TryLock()
+	start := time.Now()
+	for err == lockfile.ErrBusy || err == lockfile.ErrNotExist {
+		if time.Since(start) > portLockTimeout {
+			break
+		}
+		time.Sleep(3 * time.Millisecond)
+		err = lock.TryLock()
+	}
+	if err != nil {
+		return lockfile.Lockfile(""), fmt.Errorf("could not set lock for port leases: %s", err)
+	}
+	return lock, nil
+}
+
+// Reads the leases, skipping the expired ones.
+// This is an unsafe operation, which must be kept under a lock
+func readPortLeases() (portLeaseMap, error) {
+	leases := make(portLeaseMap)
+	if !FileExists(PortLeaseRegistry) {
+		return leases, nil
+	}
+	text, err := SlurpAsBytes(PortLeaseRegistry)
+	if err != nil {
+		return leases, err
+	}
+	var list []PortLease
+	if len(strings.TrimSpace(string(text))) > 0 {
+		err = json.Unmarshal(text, &list)
+		if err != nil {
+			// A damaged registry only contains short-lived information: we start anew
+			return leases, nil
+		}
+	}
+	now := time.Now()
+	for _, lease := range list {
+		expires, err := time.Parse(time.RFC3339, lease.Expires)
+		if err != nil || expires.Before(now) {
+			continue
+		}
+		leases[lease.Port] = lease
+	}
+	return leases, nil
+}
+
+// Writes the leases on file.
+// This is an unsafe operation, which must be kept under a lock
+func writePortLeases(leases portLeaseMap) error {
+	list := sortedPortLeases(leases)
+	text, err := json.MarshalIndent(list, " ", "\t")
+	if err != nil {
+		return err
+	}
+	return WriteString(string(text), PortLeaseRegistry)
+}
+
+func sortedPortLeases(leases portLeaseMap) []PortLease {
+	list := []PortLease{}
+	for _, lease := range leases {
+		list = append(list, lease)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Port < list[j].Port })
+	return list
+}
+
+// Runs an operation on the leases, under a lock, and saves the result
+func updatePortLeases(operation func(leases portLeaseMap) error) error {
+	lock, err := setPortLeaseLock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock() // #nosec G307
+	leases, err := readPortLeases()
+	if err != nil {
+		return err
+	}
+	err = operation(leases)
+	if err != nil {
+		return err
+	}
+	return writePortLeases(leases)
+}
+
+// GetPortLeases returns the current (non expired) port leases
+func GetPortLeases() ([]PortLease, error) {
+	if PortLeaseRegistry == "" {
+		return []PortLease{}, nil
+	}
+	var list []PortLease
+	err := updatePortLeases(func(leases portLeaseMap) error {
+		list = sortedPortLeases(leases)
+		return nil
+	})
+	return list, err
+}
+
+// ReleasePortLeases removes the leases for the given ports, or all leases when no port is given.
+// Returns the ports that were released
+func ReleasePortLeases(ports []int) ([]int, error) {
+	released := []int{}
+	if PortLeaseRegistry == "" {
+		return released, nil
+	}
+	err := updatePortLeases(func(leases portLeaseMap) error {
+		if len(ports) == 0 {
+			for port := range leases {
+				ports = append(ports, port)
+			}
+		}
+		for _, port := range ports {
+			if _, found := leases[port]; found {
+				delete(leases, port)
+				released = append(released, port)
+			}
+		}
+		return nil
+	})
+	sort.Ints(released)
+	return released, err
+}
+
+// leaseFreePort finds a range of free ports, excluding the ones leased by other processes
+// and the ones that can't be bound, and leases them to the current process
+func leaseFreePort(basePort int, usedPorts PortMap, howMany int) (int, error) {
+	var firstPort int
+	err := updatePortLeases(func(leases portLeaseMap) error {
+		pid := os.Getpid()
+		for port, lease := range leases {
+			// The leases of the current process do not block it:
+			// the same port can be requested more than once during a deployment
+			if lease.Pid != pid {
+				usedPorts[port] = true
+			}
+		}
+		for {
+			var err error
+			if howMany == 1 {
+				firstPort, err = findFreePortSingle(basePort, usedPorts)
+			} else {
+				firstPort, err = findFreePortRange(basePort, usedPorts, howMany)
+			}
+			if err != nil {
+				return err
+			}
+			allBindable := true
+			for port := firstPort; port < firstPort+howMany; port++ {
+				if !IsPortBindable(port) {
+					if portDebug {
+						CondPrintf("- port %d is not bindable\n", port)
+					}
+					usedPorts[port] = true
+					allBindable = false
+				}
+			}
+			if allBindable {
+				break
+			}
+		}
+		now := time.Now()
+		owner := RedactedCommandLine()
+		for port := firstPort; port < firstPort+howMany; port++ {
+			leases[port] = PortLease{
+				Port:    port,
+				Pid:     pid,
+				Owner:   owner,
+				Created: now.Format(time.RFC3339),
+				Expires: now.Add(PortLeaseTTL).Format(time.RFC3339),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return firstPort, nil
+}
@@ -0,0 +1,89 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestPortLeases(t *testing.T) {
+	leaseDir := t.TempDir()
+	savedRegistry, savedLock := PortLeaseRegistry, PortLeaseLock
+	PortLeaseRegistry = path.Join(leaseDir, "ports.json")
+	PortLeaseLock = path.Join(leaseDir, "ports.lock")
+	defer func() { PortLeaseRegistry, PortLeaseLock = savedRegistry, savedLock }()
+
+	// A port that is in use on the local host is skipped
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	compare.OkIsNil("listener", err, t)
+	defer listener.Close() // #nosec G307
+	busyPort := listener.Addr().(*net.TCPAddr).Port
+	compare.OkEqualBool("busy port not bindable", IsPortBindable(busyPort), false, t)
+
+	// A port in use on another address of the host is also skipped
+	otherListener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err == nil {
+		otherBusyPort := otherListener.Addr().(*net.TCPAddr).Port
+		compare.OkEqualBool("port busy on other address", IsPortBindable(otherBusyPort), false, t)
+		_ = otherListener.Close()
+	}
+
+	port, err := FindFreePort(busyPort, []int{}, 1)
+	compare.OkIsNil("port after busy one", err, t)
+	compare.OkEqualBool("busy port skipped", port > busyPort, true, t)
+
+	leases, err := GetPortLeases()
+	compare.OkIsNil("reading leases", err, t)
+	compare.OkEqualInt("one lease", len(leases), 1, t)
+	compare.OkEqualInt("leased port", leases[0].Port, port, t)
+
+	// The leases of the current process don't block it
+	samePort, err := FindFreePort(port, []int{}, 1)
+	compare.OkIsNil("same port again", err, t)
+	compare.OkEqualInt("same port again", samePort, port, t)
+
+	// The leases of another process block the leased ports
+	now := time.Now()
+	err = writePortLeases(portLeaseMap{
+		port: {Port: port, Pid: -1, Owner: "other", Created: now.Format(time.RFC3339),
+			Expires: now.Add(time.Minute).Format(time.RFC3339)},
+		port + 5: {Port: port + 5, Pid: -1, Owner: "expired", Created: now.Format(time.RFC3339),
+			Expires: now.Add(-time.Minute).Format(time.RFC3339)},
+	})
+	compare.OkIsNil("writing leases", err, t)
+	otherPort, err := FindFreePort(port, []int{}, 1)
+	compare.OkIsNil("port leased by other", err, t)
+	compare.OkEqualBool("leased port skipped", otherPort > port, true, t)
+
+	leases, err = GetPortLeases()
+	compare.OkIsNil("reading leases", err, t)
+	compare.OkEqualInt("expired lease removed", len(leases), 2, t)
+
+	released, err := ReleasePortLeases([]int{port})
+	compare.OkIsNil("releasing lease", err, t)
+	compare.OkEqualInt("released leases", len(released), 1, t)
+	released, err = ReleasePortLeases(nil)
+	compare.OkIsNil("releasing all leases", err, t)
+	compare.OkEqualInt("released all leases", len(released), 1, t)
+	leases, err = GetPortLeases()
+	compare.OkIsNil("reading leases", err, t)
+	compare.OkEqualInt("no leases", len(leases), 0, t)
+}
@@ -0,0 +1,47 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package common
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkFile creates destination as a copy-on-write clone of source,
+// using the FICLONE ioctl (btrfs, XFS with reflink, bcachefs, overlayfs on top of them)
+func reflinkFile(source, destination string, mode os.FileMode) error {
+	from, err := os.Open(source) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer from.Close() // #nosec G307
+
+	to, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode) // #nosec G304
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(to.Fd()), int(from.Fd()))
+	closeErr := to.Close()
+	if err != nil {
+		_ = os.Remove(destination)
+		return err
+	}
+	return closeErr
+}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/datacharmer/dbdeployer/globals"
 )
@@ -301,6 +302,25 @@ func RemoveSuffix(s, suffix string) string {
 	return re.ReplaceAllString(s, "")
 }
 
+// ParseDuration works like time.ParseDuration, and also accepts days and weeks,
+// with the suffixes "d" and "w". For example: "90d", "2w", "36h"
+func ParseDuration(s string) (time.Duration, error) {
+	re := regexp.MustCompile(`^(\d+)([dw])$`)
+	matches := re.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return time.ParseDuration(s)
+	}
+	num, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, err
+	}
+	day := 24 * time.Hour
+	if matches[2] == "w" {
+		return time.Duration(num) * 7 * day, nil
+	}
+	return time.Duration(num) * day, nil
+}
+
 // ------------------------------------------------------------------------------------
 // The functions below this point are intended only for use with a command line client,
 // and may not be suitable for other client types
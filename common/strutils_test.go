@@ -22,6 +22,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 type pathInfo struct {
@@ -433,3 +434,25 @@ func TestOptionComponents(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDuration(t *testing.T) {
+	var data = []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{"90d", 90 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"0d", 0, false},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"ninety days", 0, true},
+	}
+	for _, d := range data {
+		result, err := ParseDuration(d.input)
+		compare.OkEqualBool(fmt.Sprintf("error for %s", d.input), err != nil, d.wantErr, t)
+		compare.OkEqualInt(fmt.Sprintf("duration for %s", d.input), int(result/time.Minute), int(d.expected/time.Minute), t)
+	}
+}
@@ -168,6 +168,94 @@ func RunParallelTasksByPriority(execLists []ExecutionList) {
 	}
 }
 
+// TaskResult is the outcome of a command run by RunLimitedTasksByPriority
+type TaskResult struct {
+	Index    int // Position of the command in the execution list
+	Priority int
+	Command  ExecCommand
+	Output   string
+	Err      error
+	Started  time.Time
+	Elapsed  time.Duration
+}
+
+// Report receives the result of each command as soon as it ends
+type Report func(result TaskResult)
+
+// RunLimitedTasksByPriority runs the commands in the same order as RunParallelTasksByPriority,
+// but with at most maxParallel commands at once (no limit if maxParallel is less than 1).
+// Unlike RunParallelTasksByPriority, it returns the result of every command that was started.
+// When a command fails, the commands with a higher priority are not started.
+// The report function, if not nil, is never called by two commands at the same time.
+func RunLimitedTasksByPriority(execLists []ExecutionList, maxParallel int, report Report) []TaskResult {
+	var results []TaskResult
+	maxPriority := 0
+	for _, list := range execLists {
+		if list.Priority > maxPriority {
+			maxPriority = list.Priority
+		}
+	}
+	var reportMutex sync.Mutex
+	for N := 0; N <= maxPriority; N++ {
+		var indexes []int
+		for i, list := range execLists {
+			if list.Priority == N {
+				indexes = append(indexes, i)
+				if list.Logger != nil {
+					list.Logger.Printf(" Queueing command %s [%v] with priority # %d\n",
+						list.Command.Cmd, list.Command.Args, list.Priority)
+				}
+			}
+		}
+		if len(indexes) == 0 {
+			continue
+		}
+		workers := maxParallel
+		if workers < 1 || workers > len(indexes) {
+			workers = len(indexes)
+		}
+		levelResults := make([]TaskResult, len(indexes))
+		queue := make(chan int, len(indexes))
+		for i := range indexes {
+			queue <- i
+		}
+		close(queue)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range queue {
+					list := execLists[indexes[i]]
+					if list.Command.Tracer != nil {
+						list.Command.Tracer(TraceInfo{Time: time.Now(), Cmd: list.Command.Cmd, Args: list.Command.Args, Level: list.Priority})
+					}
+					result := TaskResult{Index: indexes[i], Priority: N, Command: list.Command, Started: time.Now()}
+					// #nosec G204
+					out, err := exec.Command(list.Command.Cmd, list.Command.Args...).CombinedOutput()
+					result.Elapsed = time.Since(result.Started)
+					result.Output = string(out)
+					result.Err = err
+					levelResults[i] = result
+					if report != nil {
+						reportMutex.Lock()
+						report(result)
+						reportMutex.Unlock()
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		results = append(results, levelResults...)
+		for _, result := range levelResults {
+			if result.Err != nil {
+				return results
+			}
+		}
+	}
+	return results
+}
+
 func init() {
 	if common.IsEnvSet("DEBUG_CONCURRENCY") {
 		DebugConcurrency = true
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"sort"
 	"testing"
+	"time"
 )
 
 type Times []int64
@@ -118,3 +119,44 @@ func TestConcurrency(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRunLimitedTasksByPriority(t *testing.T) {
+	var execLists []ExecutionList
+	for i := 0; i < 4; i++ {
+		execLists = append(execLists, ExecutionList{
+			Priority: 0,
+			Command:  ExecCommand{Cmd: "sleep", Args: []string{"0.2"}},
+		})
+	}
+	execLists = append(execLists,
+		ExecutionList{Priority: 1, Command: ExecCommand{Cmd: "echo", Args: []string{"one"}}},
+		ExecutionList{Priority: 1, Command: ExecCommand{Cmd: "false"}},
+		ExecutionList{Priority: 2, Command: ExecCommand{Cmd: "echo", Args: []string{"not run"}}},
+	)
+
+	reported := 0
+	start := time.Now()
+	results := RunLimitedTasksByPriority(execLists, 2, func(result TaskResult) {
+		reported++
+	})
+	elapsed := time.Since(start)
+
+	// Four tasks of 0.2 seconds, two at a time, take at least 0.4 seconds
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("tasks were not limited: elapsed %s", elapsed)
+	}
+	if len(results) != 6 || reported != 6 {
+		t.Fatalf("expected 6 results and reports - got %d results and %d reports", len(results), reported)
+	}
+	for i, result := range results {
+		if result.Index != i {
+			t.Errorf("expected index %d - got %d", i, result.Index)
+		}
+	}
+	if results[4].Err != nil || results[4].Output != "one\n" {
+		t.Errorf("unexpected result for 'echo one': %+v", results[4])
+	}
+	if results[5].Err == nil {
+		t.Errorf("expected error for 'false'")
+	}
+}
@@ -21,7 +21,9 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
 
@@ -41,6 +43,46 @@ type DataDefinition struct {
 	LoadCommands      []string `json:"load-commands"`                // [Required] Set of commands used to load the archive
 	Size              uint64   `json:"size,omitempty"`               // Size of original archive
 	Sha256            string   `json:"sha256,omitempty"`             // SHA 256 checksum of the compressed archive
+	// Optional queries that check the loaded data
+	Verifications []Verification `json:"verifications,omitempty"`
+	// Archives with a local origin (a file or directory) can use one of the Format* values.
+	// Their tables are loaded in parallel, and LoadCommands are not used
+	Format    string `json:"format,omitempty"`     // Format of a local archive
+	Database  string `json:"database,omitempty"`   // Database for dumps that don't include one, and for CSV files
+	DdlFile   string `json:"ddl-file,omitempty"`   // CSV: file with the table definitions (default: schema.sql)
+	CsvHeader bool   `json:"csv-header,omitempty"` // CSV: the first line of each file contains the column names
+}
+
+// Verification is a query that checks the loaded data, such as a row count or a checksum
+type Verification struct {
+	Description string `json:"description,omitempty"`
+	Query       string `json:"query"`    // [Required] SQL query
+	Expected    string `json:"expected"` // [Required] Result of the query, with columns separated by tabs
+}
+
+// LoadRecord describes an archive that was loaded into a sandbox
+type LoadRecord struct {
+	Archive      string `json:"archive"`
+	Origin       string `json:"origin"`
+	FileName     string `json:"file_name"`
+	Sha256       string `json:"sha256,omitempty"`
+	LoadedAt     string `json:"loaded_at"`
+	VerifiedAt   string `json:"verified_at,omitempty"`
+	Verification string `json:"verification"` // One of VerificationPassed, VerificationFailed, VerificationNone
+}
+
+const (
+	VerificationPassed = "passed"
+	VerificationFailed = "failed"
+	VerificationNone   = "none"
+)
+
+func rowCount(table string, count int) Verification {
+	return Verification{
+		Description: "row count for " + table,
+		Query:       "select count(*) from " + table,
+		Expected:    fmt.Sprintf("%d", count),
+	}
 }
 
 var defaultArchives = map[string]DataDefinition{
@@ -52,6 +94,11 @@ var defaultArchives = map[string]DataDefinition{
 		LoadCommands:      []string{"$use < world-db/world.sql"},
 		Size:              92707,
 		Sha256:            "", // removed: CRC provided by MySQL is not reliable, as it changes often
+		Verifications: []Verification{
+			rowCount("world.city", 4079),
+			rowCount("world.country", 239),
+			rowCount("world.countrylanguage", 984),
+		},
 	},
 	"worldx": {
 		Description:       "world_X database",
@@ -61,6 +108,12 @@ var defaultArchives = map[string]DataDefinition{
 		LoadCommands:      []string{"$use < world_x-db/world_x.sql"},
 		Size:              99295,
 		Sha256:            "", // removed: CRC provided by MySQL is not reliable, as it changes often
+		Verifications: []Verification{
+			rowCount("world_x.city", 4079),
+			rowCount("world_x.country", 239),
+			rowCount("world_x.countryinfo", 239),
+			rowCount("world_x.countrylanguage", 984),
+		},
 	},
 	"sakila": {
 		Description:       "Sakila database",
@@ -73,6 +126,12 @@ var defaultArchives = map[string]DataDefinition{
 		},
 		Size:   732126,
 		Sha256: "", // removed: CRC provided by MySQL is not reliable, as it changes often
+		Verifications: []Verification{
+			rowCount("sakila.actor", 200),
+			rowCount("sakila.film", 1000),
+			rowCount("sakila.customer", 599),
+			rowCount("sakila.rental", 16044),
+		},
 	},
 	"employees": {
 		Description:       "employee data (large dataset, includes data and test/verification suite)",
@@ -85,6 +144,15 @@ var defaultArchives = map[string]DataDefinition{
 		},
 		Size:   35607473,
 		Sha256: "c44c140f352f35d47fdb65df60f52b779ef552822fad6c4efcfa7b134c3faf84",
+		// The same counts are checked by the test suite that comes with the archive
+		Verifications: []Verification{
+			rowCount("employees.employees", 300024),
+			rowCount("employees.departments", 9),
+			rowCount("employees.dept_manager", 24),
+			rowCount("employees.dept_emp", 331603),
+			rowCount("employees.titles", 443308),
+			rowCount("employees.salaries", 2844047),
+		},
 	},
 	"menagerie": {
 		Description:       "menagerie database",
@@ -156,40 +224,27 @@ func ListArchives() {
 	}
 }
 
-func LoadArchive(archiveName, sandboxName string, overwrite bool) error {
-
-	archives, _ := Archives()
-	archive, found := archives[archiveName]
-	if !found {
-		return fmt.Errorf("archive %s not found", archiveName)
-	}
-	sandboxHome := defaults.Defaults().SandboxHome
-
-	sandboxPath := path.Join(sandboxHome, sandboxName)
-	if !common.DirExists(sandboxPath) {
-		return fmt.Errorf("sandbox %s not found", sandboxName)
-	}
-	ext := ""
-	if strings.HasSuffix(archive.FileName, globals.TarGzExt) {
-		ext = globals.TarGzExt
-	} else {
-		if strings.HasSuffix(archive.FileName, globals.GzExt) {
-			ext = globals.GzExt
-		}
-	}
+// sandboxExecutables contains the scripts used to load and verify data
+type sandboxExecutables struct {
+	use    string
+	useAll string
+	my     string
+}
 
+// getSandboxExecutables finds the scripts of a sandbox. For sandboxes with more than one node,
+// the data is loaded into the first node
+func getSandboxExecutables(sandboxPath string) (sandboxExecutables, error) {
 	useExecutable := path.Join(sandboxPath, "use")
 	useAllExecutable := path.Join(sandboxPath, "use_all")
 	useMultiExecutable := path.Join(sandboxPath, "n1")
 	myExecutable := path.Join(sandboxPath, "my")
 	myMultiExecutable := path.Join(sandboxPath, defaults.Defaults().NodePrefix+"1", "my")
 	myReplicationExecutable := path.Join(sandboxPath, defaults.Defaults().MasterName, "my")
-	internalDir := path.Join(sandboxPath, archive.InternalDirectory)
 	if !common.ExecExists(useExecutable) {
 		if common.ExecExists(useMultiExecutable) {
 			useExecutable = useMultiExecutable
 		} else {
-			return fmt.Errorf("executable %s not found", useExecutable)
+			return sandboxExecutables{}, fmt.Errorf("executable %s not found", useExecutable)
 		}
 	}
 
@@ -204,33 +259,237 @@ func LoadArchive(archiveName, sandboxName string, overwrite bool) error {
 			if common.ExecExists(myReplicationExecutable) {
 				myExecutable = myReplicationExecutable
 			} else {
-				return fmt.Errorf("executable %s not found", myExecutable)
+				return sandboxExecutables{}, fmt.Errorf("executable %s not found", myExecutable)
 			}
 		}
 	}
-	if !overwrite && archive.InternalDirectory != "" && common.DirExists(path.Join(sandboxPath, internalDir)) {
-		return fmt.Errorf("internal directory %s already exists", internalDir)
+	return sandboxExecutables{use: useExecutable, useAll: useAllExecutable, my: myExecutable}, nil
+}
+
+func getSandboxPath(sandboxName string) (string, error) {
+	sandboxPath := path.Join(defaults.Defaults().SandboxHome, sandboxName)
+	if !common.DirExists(sandboxPath) {
+		return "", fmt.Errorf("sandbox %s not found", sandboxName)
 	}
+	return sandboxPath, nil
+}
 
-	compressedFile := path.Join(sandboxPath, archive.FileName)
-	if !overwrite && common.FileExists(compressedFile) {
-		return fmt.Errorf(globals.ErrFileAlreadyExists, compressedFile)
+// ReadLoadRecords returns the archives that were loaded into the sandbox in sandboxPath
+func ReadLoadRecords(sandboxPath string) (map[string]LoadRecord, error) {
+	records := make(map[string]LoadRecord)
+	recordFile := path.Join(sandboxPath, globals.DataLoadRecordName)
+	if !common.FileExists(recordFile) {
+		return records, nil
 	}
-	fmt.Printf("downloading %s\n", archive.Origin)
-	err := rest.DownloadFile(compressedFile, archive.Origin, true, globals.MB)
+	text, err := common.SlurpAsBytes(recordFile)
 	if err != nil {
-		return fmt.Errorf("error downloading archive %s: %s", archiveName, err)
+		return records, err
 	}
-	if !common.FileExists(compressedFile) {
-		return fmt.Errorf("file %s not found after downloading", compressedFile)
+	err = json.Unmarshal(text, &records)
+	if err != nil {
+		return records, fmt.Errorf("error decoding %s: %s", recordFile, err)
 	}
+	return records, nil
+}
 
-	localChecksum, err := common.GetFileChecksum(compressedFile, "SHA256")
+func writeLoadRecords(sandboxPath string, records map[string]LoadRecord) error {
+	text, err := json.MarshalIndent(records, "", "  ")
 	if err != nil {
-		return fmt.Errorf("error retrieving checksum for file %s", compressedFile)
+		return err
 	}
-	if archive.Sha256 != "" && localChecksum != archive.Sha256 {
-		return fmt.Errorf("the checksum of file %s doesn't match. Expected: %s - Found: %s", compressedFile, archive.Sha256, localChecksum)
+	return common.WriteString(string(text), path.Join(sandboxPath, globals.DataLoadRecordName))
+}
+
+// verifyData runs the verification queries of an archive using the given "use" script.
+// It returns an error describing all the queries that did not give the expected result
+func verifyData(archive DataDefinition, useExecutable string) error {
+	var failures []string
+	for _, verification := range archive.Verifications {
+		label := verification.Description
+		if label == "" {
+			label = verification.Query
+		}
+		output, err := common.RunCmdCtrlWithArgs(useExecutable, []string{"-BN", "-e", verification.Query}, true)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: error running query: %s", label, err))
+			continue
+		}
+		result := strings.TrimSpace(output)
+		if result != verification.Expected {
+			failures = append(failures, fmt.Sprintf("%s: expected '%s' - found '%s'", label, verification.Expected, result))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("verification failed:\n\t%s", strings.Join(failures, "\n\t"))
+	}
+	return nil
+}
+
+// updateVerification runs the verification of an archive and records the outcome
+func updateVerification(record LoadRecord, archive DataDefinition, useExecutable string) (LoadRecord, error) {
+	if len(archive.Verifications) == 0 {
+		record.Verification = VerificationNone
+		return record, nil
+	}
+	err := verifyData(archive, useExecutable)
+	record.VerifiedAt = time.Now().Format(time.UnixDate)
+	record.Verification = VerificationPassed
+	if err != nil {
+		record.Verification = VerificationFailed
+	}
+	return record, err
+}
+
+// VerifyArchive checks that the data of an archive loaded into a sandbox is intact
+func VerifyArchive(archiveName, sandboxName string) error {
+	archives, _ := Archives()
+	archive, found := archives[archiveName]
+	if !found {
+		return fmt.Errorf("archive %s not found", archiveName)
+	}
+	sandboxPath, err := getSandboxPath(sandboxName)
+	if err != nil {
+		return err
+	}
+	executables, err := getSandboxExecutables(sandboxPath)
+	if err != nil {
+		return err
+	}
+	records, err := ReadLoadRecords(sandboxPath)
+	if err != nil {
+		return err
+	}
+	record, loaded := records[archiveName]
+	if !loaded {
+		return fmt.Errorf("archive %s was not loaded into sandbox %s", archiveName, sandboxName)
+	}
+	record, verifyErr := updateVerification(record, archive, executables.use)
+	records[archiveName] = record
+	err = writeLoadRecords(sandboxPath, records)
+	if err != nil {
+		return err
+	}
+	if verifyErr != nil {
+		return fmt.Errorf("archive %s in sandbox %s: %s", archiveName, sandboxName, verifyErr)
+	}
+	if record.Verification == VerificationNone {
+		fmt.Printf("Archive %s has no verification queries\n", archiveName)
+	} else {
+		fmt.Printf("Archive %s in sandbox %s verified: %d queries passed\n", archiveName, sandboxName, len(archive.Verifications))
+	}
+	return nil
+}
+
+// ArchivesStatus shows the archives loaded into a sandbox, and checks whether their data is intact
+func ArchivesStatus(sandboxName string) error {
+	sandboxPath, err := getSandboxPath(sandboxName)
+	if err != nil {
+		return err
+	}
+	records, err := ReadLoadRecords(sandboxPath)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Printf("No archives loaded into sandbox %s\n", sandboxName)
+		return nil
+	}
+	executables, err := getSandboxExecutables(sandboxPath)
+	if err != nil {
+		return err
+	}
+	archives, _ := Archives()
+	var names []string
+	for name := range records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failed := 0
+	for _, name := range names {
+		record := records[name]
+		status := "no verification queries"
+		archive, found := archives[name]
+		if !found {
+			status = "archive definition not found"
+		} else {
+			record, err = updateVerification(record, archive, executables.use)
+			records[name] = record
+			switch {
+			case err != nil:
+				status = "NOT INTACT - " + err.Error()
+				failed++
+			case record.Verification == VerificationPassed:
+				status = "intact"
+			}
+		}
+		fmt.Printf("%-20s loaded %s - %s\n", name, record.LoadedAt, status)
+	}
+	err = writeLoadRecords(sandboxPath, records)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d archives in sandbox %s failed verification", failed, sandboxName)
+	}
+	return nil
+}
+
+// checkArchiveFile returns the checksum of an archive file, and compares it to the expected one, if any
+func checkArchiveFile(fileName, expectedSha256 string) (string, error) {
+	localChecksum, err := common.GetFileChecksum(fileName, "SHA256")
+	if err != nil {
+		return "", fmt.Errorf("error retrieving checksum for file %s", fileName)
+	}
+	if expectedSha256 != "" && localChecksum != expectedSha256 {
+		return "", fmt.Errorf("the checksum of file %s doesn't match. Expected: %s - Found: %s", fileName, expectedSha256, localChecksum)
+	}
+	return localChecksum, nil
+}
+
+// loadPackagedArchive gets a compressed archive, from a URL or a local file, unpacks it
+// into the sandbox directory, and runs its load commands. It returns the archive checksum
+func loadPackagedArchive(archiveName string, archive DataDefinition, sandboxPath string, executables sandboxExecutables, overwrite bool) (string, error) {
+	fileName := archive.FileName
+	if fileName == "" && isLocalOrigin(archive.Origin) {
+		fileName = path.Base(localOriginPath(archive.Origin))
+	}
+	ext := ""
+	if strings.HasSuffix(fileName, globals.TarGzExt) {
+		ext = globals.TarGzExt
+	} else {
+		if strings.HasSuffix(fileName, globals.GzExt) {
+			ext = globals.GzExt
+		}
+	}
+
+	internalDir := path.Join(sandboxPath, archive.InternalDirectory)
+	if !overwrite && archive.InternalDirectory != "" && common.DirExists(internalDir) {
+		return "", fmt.Errorf("internal directory %s already exists", internalDir)
+	}
+
+	compressedFile := path.Join(sandboxPath, fileName)
+	if isLocalOrigin(archive.Origin) {
+		compressedFile = localOriginPath(archive.Origin)
+		if !common.FileExists(compressedFile) {
+			return "", fmt.Errorf(globals.ErrFileNotFound, compressedFile)
+		}
+	} else {
+		if !overwrite && common.FileExists(compressedFile) {
+			return "", fmt.Errorf(globals.ErrFileAlreadyExists, compressedFile)
+		}
+		fmt.Printf("downloading %s\n", archive.Origin)
+		err := rest.DownloadFile(compressedFile, archive.Origin, true, globals.MB)
+		if err != nil {
+			return "", fmt.Errorf("error downloading archive %s: %s", archiveName, err)
+		}
+		if !common.FileExists(compressedFile) {
+			return "", fmt.Errorf("file %s not found after downloading", compressedFile)
+		}
+	}
+
+	localChecksum, err := checkArchiveFile(compressedFile, archive.Sha256)
+	if err != nil {
+		return "", err
 	}
 
 	fmt.Printf("Unpacking %s\n", compressedFile)
@@ -238,16 +497,16 @@ func LoadArchive(archiveName, sandboxName string, overwrite bool) error {
 	case globals.TarGzExt:
 		err = unpack.UnpackTar(compressedFile, sandboxPath, unpack.VERBOSE)
 	case globals.GzExt:
-		err = unpack.GunzipFile(compressedFile, common.RemoveSuffix(compressedFile, `\.gz`), overwrite)
+		err = unpack.GunzipFile(compressedFile, path.Join(sandboxPath, common.RemoveSuffix(path.Base(compressedFile), `\.gz`)), overwrite)
 	default:
-		return fmt.Errorf("unsupported file extension")
+		return "", fmt.Errorf("unsupported file extension")
 	}
 	if err != nil {
-		return fmt.Errorf("error unpacking file %s: %s", compressedFile, err)
+		return "", fmt.Errorf("error unpacking file %s: %s", compressedFile, err)
 	}
 
-	if archive.InternalDirectory != "" && !common.DirExists(path.Join(sandboxPath, archive.InternalDirectory)) {
-		return fmt.Errorf("internal directory %s not found after unpacking %s", archive.InternalDirectory, archiveName)
+	if archive.InternalDirectory != "" && !common.DirExists(internalDir) {
+		return "", fmt.Errorf("internal directory %s not found after unpacking %s", archive.InternalDirectory, archiveName)
 	}
 	reUse := regexp.MustCompile(`\$use\b`)
 	reUseAll := regexp.MustCompile(`\$use_all\b`)
@@ -260,26 +519,96 @@ func LoadArchive(archiveName, sandboxName string, overwrite bool) error {
 		loadCommands = append(loadCommands, fmt.Sprintf("cd %s", sandboxPath))
 	}
 	for _, rawCommand := range archive.LoadCommands {
-		command := reUse.ReplaceAllString(rawCommand, useExecutable)
-		command = reUseAll.ReplaceAllString(command, useAllExecutable)
-		command = reMy.ReplaceAllString(command, myExecutable)
+		command := reUse.ReplaceAllString(rawCommand, executables.use)
+		command = reUseAll.ReplaceAllString(command, executables.useAll)
+		command = reMy.ReplaceAllString(command, executables.my)
 		loadCommands = append(loadCommands, command)
 	}
 
 	loadScript := path.Join(sandboxPath, "load_db.sh")
 	err = common.WriteStrings(loadCommands, loadScript, "\n")
 	if err != nil {
-		return fmt.Errorf("error creating load script %s: %s", loadScript, err)
+		return "", fmt.Errorf("error creating load script %s: %s", loadScript, err)
 	}
 	err = os.Chmod(loadScript, globals.ExecutableFileAttr)
 	if err != nil {
-		return fmt.Errorf("error changing attributes to load script %s: %s", loadScript, err)
+		return "", fmt.Errorf("error changing attributes to load script %s: %s", loadScript, err)
 	}
 	fmt.Printf("Running %s\n", loadScript)
 	_, err = common.RunCmd(loadScript)
 	if err != nil {
-		return fmt.Errorf("error running load script %s: %s", loadScript, err)
+		return "", fmt.Errorf("error running load script %s: %s", loadScript, err)
+	}
+
+	return localChecksum, nil
+}
+
+// LoadArchive loads the contents of an archive into a sandbox.
+// An archive that was already loaded is skipped, unless overwrite is set.
+// Archives with a local format are loaded using up to "threads" parallel tasks.
+// After loading, the data is checked using the archive verification queries.
+func LoadArchive(archiveName, sandboxName string, overwrite bool, threads int) error {
+
+	archives, _ := Archives()
+	archive, found := archives[archiveName]
+	if !found {
+		return fmt.Errorf("archive %s not found", archiveName)
+	}
+	sandboxPath, err := getSandboxPath(sandboxName)
+	if err != nil {
+		return err
+	}
+	executables, err := getSandboxExecutables(sandboxPath)
+	if err != nil {
+		return err
+	}
+	records, err := ReadLoadRecords(sandboxPath)
+	if err != nil {
+		return err
+	}
+	if record, loaded := records[archiveName]; loaded && !overwrite {
+		fmt.Printf("Archive %s was already loaded into %s on %s. Use --%s to load it again\n",
+			archiveName, sandboxName, record.LoadedAt, globals.OverwriteLabel)
+		return nil
+	}
+	var localChecksum string
+	if archive.Format != "" {
+		if !isLocalOrigin(archive.Origin) {
+			return fmt.Errorf("archive %s has format %s, but its origin is not a local file or directory", archiveName, archive.Format)
+		}
+		origin := localOriginPath(archive.Origin)
+		if common.FileExists(origin) {
+			localChecksum, err = checkArchiveFile(origin, archive.Sha256)
+			if err != nil {
+				return err
+			}
+		}
+		err = loadLocalArchive(archiveName, archive, sandboxPath, executables.use, threads, overwrite)
+	} else {
+		localChecksum, err = loadPackagedArchive(archiveName, archive, sandboxPath, executables, overwrite)
+	}
+	if err != nil {
+		return err
 	}
 
+	record := LoadRecord{
+		Archive:  archiveName,
+		Origin:   archive.Origin,
+		FileName: archive.FileName,
+		Sha256:   localChecksum,
+		LoadedAt: time.Now().Format(time.UnixDate),
+	}
+	record, verifyErr := updateVerification(record, archive, executables.use)
+	records[archiveName] = record
+	err = writeLoadRecords(sandboxPath, records)
+	if err != nil {
+		return fmt.Errorf("error recording archive %s in sandbox %s: %s", archiveName, sandboxName, err)
+	}
+	if verifyErr != nil {
+		return fmt.Errorf("archive %s was loaded, but %s", archiveName, verifyErr)
+	}
+	if record.Verification == VerificationPassed {
+		fmt.Printf("Data verified: %d queries passed\n", len(archive.Verifications))
+	}
 	return nil
 }
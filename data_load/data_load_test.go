@@ -0,0 +1,106 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data_load
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// fakeUse creates a script that answers queries like the "use" script of a sandbox
+func fakeUse(t *testing.T, dir string) string {
+	script := path.Join(dir, "use")
+	text := `#!/usr/bin/env bash
+case "$3" in
+    *t1) echo 10 ;;
+    *t2) echo 20 ;;
+    *) exit 1 ;;
+esac
+`
+	err := os.WriteFile(script, []byte(text), 0744)
+	if err != nil {
+		t.Fatalf("error writing script %s: %s", script, err)
+	}
+	return script
+}
+
+func TestVerifyData(t *testing.T) {
+	useExecutable := fakeUse(t, t.TempDir())
+	var testData = []struct {
+		name          string
+		verifications []Verification
+		expected      string
+		isErrorful    bool
+	}{
+		{"none", nil, VerificationNone, false},
+		{"passed", []Verification{rowCount("t1", 10), rowCount("t2", 20)}, VerificationPassed, false},
+		{"wrong-count", []Verification{rowCount("t1", 10), rowCount("t2", 21)}, VerificationFailed, true},
+		{"query-error", []Verification{rowCount("t3", 0)}, VerificationFailed, true},
+	}
+	for _, td := range testData {
+		t.Run(td.name, func(t *testing.T) {
+			archive := DataDefinition{Verifications: td.verifications}
+			record, err := updateVerification(LoadRecord{Archive: td.name}, archive, useExecutable)
+			if (err != nil) != td.isErrorful {
+				t.Fatalf("expected error: %v - got %v", td.isErrorful, err)
+			}
+			if record.Verification != td.expected {
+				t.Errorf("expected verification %s - got %s", td.expected, record.Verification)
+			}
+		})
+	}
+}
+
+func TestLoadRecords(t *testing.T) {
+	sandboxPath := t.TempDir()
+	records, err := ReadLoadRecords(sandboxPath)
+	if err != nil {
+		t.Fatalf("error reading empty records: %s", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected no records - got %d", len(records))
+	}
+	records["world"] = LoadRecord{Archive: "world", LoadedAt: "now", Verification: VerificationPassed}
+	err = writeLoadRecords(sandboxPath, records)
+	if err != nil {
+		t.Fatalf("error writing records: %s", err)
+	}
+	saved, err := ReadLoadRecords(sandboxPath)
+	if err != nil {
+		t.Fatalf("error reading records: %s", err)
+	}
+	if saved["world"] != records["world"] {
+		t.Errorf("expected %+v - got %+v", records["world"], saved["world"])
+	}
+}
+
+func TestLoadPackagedArchiveExistingDirectory(t *testing.T) {
+	sandboxPath := t.TempDir()
+	err := os.Mkdir(path.Join(sandboxPath, "world"), 0755)
+	if err != nil {
+		t.Fatalf("error creating internal directory: %s", err)
+	}
+	archive := DataDefinition{
+		Origin:            path.Join(t.TempDir(), "world.sql.tar.gz"),
+		InternalDirectory: "world",
+	}
+	_, err = loadPackagedArchive("world", archive, sandboxPath, sandboxExecutables{}, false)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected error for existing internal directory - got %v", err)
+	}
+}
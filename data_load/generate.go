@@ -0,0 +1,496 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data_load
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/importing"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
+)
+
+// Column types for synthetic data
+const (
+	ColumnSequence  = "sequence"  // 1, 2, 3, ... Used as primary key
+	ColumnInt       = "int"       // random integer between min and max
+	ColumnString    = "string"    // random string of the given length
+	ColumnDate      = "date"      // random date between from and to
+	ColumnJson      = "json"      // random JSON document
+	ColumnReference = "reference" // value of an existing row in another table
+)
+
+const (
+	defaultGeneratedRows  = 1000
+	defaultBatchSize      = 500
+	maxPlaceholders       = 65535
+	defaultGeneratedDb    = "synthetic"
+	defaultStringLength   = 20
+	defaultIntMax         = 1000000
+	defaultDateFrom       = "2000-01-01"
+	defaultDateTo         = "2030-12-31"
+	generatorDateFormat   = "2006-01-02"
+	generatorCharacterSet = "abcdefghijklmnopqrstuvwxyz"
+)
+
+// GeneratorSpec describes the tables to create and fill with synthetic data
+type GeneratorSpec struct {
+	Database  string      `json:"database,omitempty" yaml:"database,omitempty"`     // Schema where tables are created
+	Seed      int64       `json:"seed,omitempty" yaml:"seed,omitempty"`             // Seed for the random values
+	Rows      int         `json:"rows,omitempty" yaml:"rows,omitempty"`             // Rows for tables without their own count
+	BatchSize int         `json:"batch-size,omitempty" yaml:"batch-size,omitempty"` // Rows for each INSERT statement
+	Tables    []TableSpec `json:"tables" yaml:"tables"`
+}
+
+// TableSpec describes one table of synthetic data
+type TableSpec struct {
+	Name    string       `json:"name" yaml:"name"`
+	Rows    int          `json:"rows,omitempty" yaml:"rows,omitempty"`
+	Columns []ColumnSpec `json:"columns" yaml:"columns"`
+}
+
+// ColumnSpec describes one column of synthetic data
+type ColumnSpec struct {
+	Name       string `json:"name" yaml:"name"`
+	Type       string `json:"type" yaml:"type"`                                 // One of the Column* types
+	Length     int    `json:"length,omitempty" yaml:"length,omitempty"`         // string
+	Min        int64  `json:"min,omitempty" yaml:"min,omitempty"`               // int
+	Max        int64  `json:"max,omitempty" yaml:"max,omitempty"`               // int
+	Start      int64  `json:"start,omitempty" yaml:"start,omitempty"`           // sequence
+	From       string `json:"from,omitempty" yaml:"from,omitempty"`             // date
+	To         string `json:"to,omitempty" yaml:"to,omitempty"`                 // date
+	References string `json:"references,omitempty" yaml:"references,omitempty"` // reference: table.column
+}
+
+var reIdentifier = regexp.MustCompile(`^\w+$`)
+
+// ReadGeneratorSpec reads a synthetic data specification from a YAML or JSON file.
+// The format is chosen from the file extension
+func ReadGeneratorSpec(fileName string) (GeneratorSpec, error) {
+	var spec GeneratorSpec
+	if !common.FileExists(fileName) {
+		return spec, fmt.Errorf(globals.ErrFileNotFound, fileName)
+	}
+	contents, err := common.SlurpAsBytes(fileName)
+	if err != nil {
+		return spec, err
+	}
+	if strings.HasSuffix(fileName, ".yaml") || strings.HasSuffix(fileName, ".yml") {
+		err = yaml.UnmarshalStrict(contents, &spec)
+	} else {
+		decoder := json.NewDecoder(strings.NewReader(string(contents)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&spec)
+	}
+	if err != nil {
+		return GeneratorSpec{}, errors.Wrapf(err, "error decoding data specification %s", fileName)
+	}
+	return spec, nil
+}
+
+// withDefaults returns a copy of the specification with all the optional values filled
+func (spec GeneratorSpec) withDefaults() GeneratorSpec {
+	if spec.Database == "" {
+		spec.Database = defaultGeneratedDb
+	}
+	if spec.Rows == 0 {
+		spec.Rows = defaultGeneratedRows
+	}
+	if spec.BatchSize == 0 {
+		spec.BatchSize = defaultBatchSize
+	}
+	var tables []TableSpec
+	for _, table := range spec.Tables {
+		if table.Rows == 0 {
+			table.Rows = spec.Rows
+		}
+		var columns []ColumnSpec
+		for _, column := range table.Columns {
+			switch column.Type {
+			case ColumnSequence:
+				if column.Start == 0 {
+					column.Start = 1
+				}
+			case ColumnInt:
+				if column.Min == 0 && column.Max == 0 {
+					column.Max = defaultIntMax
+				}
+			case ColumnString:
+				if column.Length == 0 {
+					column.Length = defaultStringLength
+				}
+			case ColumnDate:
+				if column.From == "" {
+					column.From = defaultDateFrom
+				}
+				if column.To == "" {
+					column.To = defaultDateTo
+				}
+			}
+			columns = append(columns, column)
+		}
+		table.Columns = columns
+		tables = append(tables, table)
+	}
+	spec.Tables = tables
+	return spec
+}
+
+// splitReference returns the table and column of a reference column
+func splitReference(reference string) (string, string) {
+	parts := strings.Split(reference, ".")
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// findSequence returns the sequence column of a table, if it has one
+func (table TableSpec) findSequence() (ColumnSpec, bool) {
+	for _, column := range table.Columns {
+		if column.Type == ColumnSequence {
+			return column, true
+		}
+	}
+	return ColumnSpec{}, false
+}
+
+// Validate checks that a specification with defaults can be used to generate data.
+// A reference column must point to the sequence column of a table defined before its own
+func (spec GeneratorSpec) Validate() error {
+	if !reIdentifier.MatchString(spec.Database) {
+		return fmt.Errorf("invalid database name '%s'", spec.Database)
+	}
+	if len(spec.Tables) == 0 {
+		return fmt.Errorf("no tables defined")
+	}
+	if spec.Rows < 0 || spec.BatchSize < 0 {
+		return fmt.Errorf("rows and batch size must be positive numbers")
+	}
+	tables := make(map[string]TableSpec)
+	for _, table := range spec.Tables {
+		if !reIdentifier.MatchString(table.Name) {
+			return fmt.Errorf("invalid table name '%s'", table.Name)
+		}
+		if _, found := tables[table.Name]; found {
+			return fmt.Errorf("table %s defined more than once", table.Name)
+		}
+		if table.Rows < 0 {
+			return fmt.Errorf("table %s: rows must be a positive number", table.Name)
+		}
+		if len(table.Columns) == 0 {
+			return fmt.Errorf("table %s has no columns", table.Name)
+		}
+		columns := make(map[string]bool)
+		sequences := 0
+		for _, column := range table.Columns {
+			if !reIdentifier.MatchString(column.Name) {
+				return fmt.Errorf("table %s: invalid column name '%s'", table.Name, column.Name)
+			}
+			if columns[column.Name] {
+				return fmt.Errorf("table %s: column %s defined more than once", table.Name, column.Name)
+			}
+			columns[column.Name] = true
+			switch column.Type {
+			case ColumnSequence:
+				sequences++
+			case ColumnInt:
+				if column.Min > column.Max {
+					return fmt.Errorf("table %s: column %s has min greater than max", table.Name, column.Name)
+				}
+			case ColumnString:
+				if column.Length < 1 {
+					return fmt.Errorf("table %s: column %s must have a positive length", table.Name, column.Name)
+				}
+			case ColumnDate:
+				from, err := time.Parse(generatorDateFormat, column.From)
+				if err != nil {
+					return fmt.Errorf("table %s: column %s has an invalid 'from' date: %s", table.Name, column.Name, err)
+				}
+				to, err := time.Parse(generatorDateFormat, column.To)
+				if err != nil {
+					return fmt.Errorf("table %s: column %s has an invalid 'to' date: %s", table.Name, column.Name, err)
+				}
+				if to.Before(from) {
+					return fmt.Errorf("table %s: column %s has 'to' date before 'from' date", table.Name, column.Name)
+				}
+			case ColumnJson:
+			case ColumnReference:
+				refTable, refColumn := splitReference(column.References)
+				target, found := tables[refTable]
+				if !found {
+					return fmt.Errorf("table %s: column %s references '%s', which is not a table defined before this one",
+						table.Name, column.Name, column.References)
+				}
+				sequence, hasSequence := target.findSequence()
+				if !hasSequence || sequence.Name != refColumn {
+					return fmt.Errorf("table %s: column %s must reference the sequence column of table %s",
+						table.Name, column.Name, refTable)
+				}
+			default:
+				return fmt.Errorf("table %s: column %s has unknown type '%s'", table.Name, column.Name, column.Type)
+			}
+		}
+		if sequences > 1 {
+			return fmt.Errorf("table %s has more than one sequence column", table.Name)
+		}
+		tables[table.Name] = table
+	}
+	return nil
+}
+
+// columnDefinition returns the SQL type of a column
+func columnDefinition(column ColumnSpec) string {
+	switch column.Type {
+	case ColumnSequence, ColumnReference:
+		return "BIGINT NOT NULL"
+	case ColumnInt:
+		return "BIGINT"
+	case ColumnString:
+		return fmt.Sprintf("VARCHAR(%d)", column.Length)
+	case ColumnDate:
+		return "DATE"
+	case ColumnJson:
+		return "JSON"
+	}
+	return ""
+}
+
+// createStatements returns the statements that create the schema and the tables.
+// With overwrite, existing tables are dropped in reverse order, so that foreign keys don't get in the way
+func createStatements(spec GeneratorSpec, overwrite bool) []string {
+	statements := []string{fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", spec.Database)}
+	for i := len(spec.Tables) - 1; i >= 0 && overwrite; i-- {
+		statements = append(statements, fmt.Sprintf("DROP TABLE IF EXISTS `%s`.`%s`", spec.Database, spec.Tables[i].Name))
+	}
+	for _, table := range spec.Tables {
+		var definitions []string
+		var constraints []string
+		for _, column := range table.Columns {
+			definitions = append(definitions, fmt.Sprintf("`%s` %s", column.Name, columnDefinition(column)))
+			switch column.Type {
+			case ColumnSequence:
+				constraints = append(constraints, fmt.Sprintf("PRIMARY KEY (`%s`)", column.Name))
+			case ColumnReference:
+				refTable, refColumn := splitReference(column.References)
+				constraints = append(constraints, fmt.Sprintf("FOREIGN KEY (`%s`) REFERENCES `%s` (`%s`)",
+					column.Name, refTable, refColumn))
+			}
+		}
+		statements = append(statements, fmt.Sprintf("CREATE TABLE `%s`.`%s` (\n  %s\n)",
+			spec.Database, table.Name, strings.Join(append(definitions, constraints...), ",\n  ")))
+	}
+	return statements
+}
+
+// rowGenerator produces the rows of one table.
+// Each table has its own random source, so that its data depends only on the seed
+// and on its position in the specification
+type rowGenerator struct {
+	table      TableSpec
+	random     *rand.Rand
+	references map[string]TableSpec
+	rowNumber  int64
+}
+
+func newRowGenerator(spec GeneratorSpec, tableIndex int) *rowGenerator {
+	references := make(map[string]TableSpec)
+	for _, table := range spec.Tables[:tableIndex] {
+		references[table.Name] = table
+	}
+	return &rowGenerator{
+		table: spec.Tables[tableIndex],
+		// #nosec G404 The data must be reproducible, not secure
+		random:     rand.New(rand.NewSource(spec.Seed + int64(tableIndex))),
+		references: references,
+	}
+}
+
+func (rg *rowGenerator) randomString(length int) string {
+	text := make([]byte, length)
+	for i := range text {
+		text[i] = generatorCharacterSet[rg.random.Intn(len(generatorCharacterSet))]
+	}
+	return string(text)
+}
+
+func (rg *rowGenerator) value(column ColumnSpec) interface{} {
+	switch column.Type {
+	case ColumnSequence:
+		return column.Start + rg.rowNumber
+	case ColumnInt:
+		return column.Min + rg.random.Int63n(column.Max-column.Min+1)
+	case ColumnString:
+		return rg.randomString(column.Length)
+	case ColumnDate:
+		// Dates were checked by Validate
+		from, _ := time.Parse(generatorDateFormat, column.From)
+		to, _ := time.Parse(generatorDateFormat, column.To)
+		days := int(to.Sub(from).Hours() / 24)
+		return from.AddDate(0, 0, rg.random.Intn(days+1)).Format(generatorDateFormat)
+	case ColumnJson:
+		document, _ := json.Marshal(map[string]interface{}{
+			"id":    rg.rowNumber + 1,
+			"label": rg.randomString(8),
+			"score": rg.random.Intn(100),
+			"flag":  rg.random.Intn(2) == 1,
+		})
+		return string(document)
+	case ColumnReference:
+		refTable, _ := splitReference(column.References)
+		target := rg.references[refTable]
+		sequence, _ := target.findSequence()
+		return sequence.Start + rg.random.Int63n(int64(target.Rows))
+	}
+	return nil
+}
+
+// next returns the values of the next row
+func (rg *rowGenerator) next() []interface{} {
+	var row []interface{}
+	for _, column := range rg.table.Columns {
+		row = append(row, rg.value(column))
+	}
+	rg.rowNumber++
+	return row
+}
+
+// insertStatement returns an INSERT statement with placeholders for the given number of rows
+func insertStatement(database string, table TableSpec, rows int) string {
+	var columns []string
+	var placeholders []string
+	for _, column := range table.Columns {
+		columns = append(columns, fmt.Sprintf("`%s`", column.Name))
+		placeholders = append(placeholders, "?")
+	}
+	rowPlaceholder := "(" + strings.Join(placeholders, ", ") + ")"
+	values := make([]string, rows)
+	for i := range values {
+		values[i] = rowPlaceholder
+	}
+	return fmt.Sprintf("INSERT INTO `%s`.`%s` (%s) VALUES %s", database, table.Name,
+		strings.Join(columns, ", "), strings.Join(values, ", "))
+}
+
+// generatorNodeDir returns the directory of the node that receives the data.
+// For master-slave sandboxes it is the master, where node1 is a slave.
+// For other sandboxes with more than one node, it is the first node
+func generatorNodeDir(sandboxPath string) (string, error) {
+	for _, nodeDir := range []string{
+		sandboxPath,
+		path.Join(sandboxPath, defaults.Defaults().MasterName),
+		path.Join(sandboxPath, defaults.Defaults().NodePrefix+"1"),
+	} {
+		if common.FileExists(path.Join(nodeDir, globals.ScriptConnectionJson)) {
+			return nodeDir, nil
+		}
+	}
+	return "", fmt.Errorf("no connection information found in sandbox %s", sandboxPath)
+}
+
+// existingTables returns the tables of a specification that are already in the server
+func existingTables(db *importing.DB, spec GeneratorSpec) ([]string, error) {
+	var existing []string
+	for _, table := range spec.Tables {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = ? AND table_name = ?",
+			spec.Database, table.Name).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			existing = append(existing, spec.Database+"."+table.Name)
+		}
+	}
+	return existing, nil
+}
+
+// GenerateData creates the tables of a specification in a sandbox, and fills them
+// with synthetic data. The same specification and seed always produce the same data.
+// Tables that already exist are replaced only when overwrite is set
+func GenerateData(spec GeneratorSpec, sandboxName string, overwrite bool) error {
+	spec = spec.withDefaults()
+	err := spec.Validate()
+	if err != nil {
+		return err
+	}
+	sandboxPath, err := getSandboxPath(sandboxName)
+	if err != nil {
+		return err
+	}
+	nodeDir, err := generatorNodeDir(sandboxPath)
+	if err != nil {
+		return err
+	}
+	db, err := lifecycle.Connect(nodeDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !overwrite {
+		existing, err := existingTables(db, spec)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("tables %s already exist. Use --%s to replace them",
+				strings.Join(existing, ", "), globals.OverwriteLabel)
+		}
+	}
+	for _, statement := range createStatements(spec, overwrite) {
+		_, err = db.Exec(statement)
+		if err != nil {
+			return fmt.Errorf("error running '%s': %s", statement, err)
+		}
+	}
+	for i, table := range spec.Tables {
+		generator := newRowGenerator(spec, i)
+		batchSize := spec.BatchSize
+		// A statement can't have more than 65535 placeholders
+		if batchSize*len(table.Columns) > maxPlaceholders {
+			batchSize = maxPlaceholders / len(table.Columns)
+		}
+		for inserted := 0; inserted < table.Rows; {
+			batchRows := batchSize
+			if table.Rows-inserted < batchRows {
+				batchRows = table.Rows - inserted
+			}
+			var values []interface{}
+			for n := 0; n < batchRows; n++ {
+				values = append(values, generator.next()...)
+			}
+			_, err = db.Exec(insertStatement(spec.Database, table, batchRows), values...)
+			if err != nil {
+				return fmt.Errorf("error inserting rows into %s.%s: %s", spec.Database, table.Name, err)
+			}
+			inserted += batchRows
+		}
+		fmt.Printf("Table %s.%s: %d rows\n", spec.Database, table.Name, table.Rows)
+	}
+	return nil
+}
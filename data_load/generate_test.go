@@ -0,0 +1,216 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data_load
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+const testSpecYaml = `
+database: shop
+seed: 42
+rows: 50
+tables:
+  - name: customers
+    columns:
+      - {name: id, type: sequence}
+      - {name: name, type: string, length: 12}
+      - {name: since, type: date, from: "2020-01-01", to: "2020-12-31"}
+  - name: orders
+    rows: 200
+    columns:
+      - {name: id, type: sequence, start: 1000}
+      - {name: customer_id, type: reference, references: customers.id}
+      - {name: amount, type: int, min: 1, max: 5}
+      - {name: details, type: json}
+`
+
+func readTestSpec(t *testing.T) GeneratorSpec {
+	fileName := path.Join(t.TempDir(), "spec.yaml")
+	err := os.WriteFile(fileName, []byte(testSpecYaml), 0644)
+	if err != nil {
+		t.Fatalf("error writing spec: %s", err)
+	}
+	spec, err := ReadGeneratorSpec(fileName)
+	if err != nil {
+		t.Fatalf("error reading spec: %s", err)
+	}
+	return spec.withDefaults()
+}
+
+func TestGeneratorSpecValidate(t *testing.T) {
+	spec := readTestSpec(t)
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error for valid spec: %s", err)
+	}
+	if spec.Tables[0].Rows != 50 || spec.Tables[1].Rows != 200 || spec.BatchSize != defaultBatchSize {
+		t.Errorf("defaults not applied: %+v", spec)
+	}
+
+	var testData = []struct {
+		name   string
+		change func(spec *GeneratorSpec)
+	}{
+		{"unknown-type", func(spec *GeneratorSpec) { spec.Tables[0].Columns[1].Type = "blob" }},
+		{"bad-name", func(spec *GeneratorSpec) { spec.Tables[0].Name = "cust omers" }},
+		{"min-max", func(spec *GeneratorSpec) { spec.Tables[1].Columns[2].Min = 10 }},
+		{"bad-date", func(spec *GeneratorSpec) { spec.Tables[0].Columns[2].From = "2020-13-01" }},
+		{"forward-reference", func(spec *GeneratorSpec) {
+			spec.Tables[0], spec.Tables[1] = spec.Tables[1], spec.Tables[0]
+		}},
+		{"reference-not-sequence", func(spec *GeneratorSpec) { spec.Tables[1].Columns[1].References = "customers.name" }},
+		{"two-sequences", func(spec *GeneratorSpec) { spec.Tables[0].Columns[1].Type = ColumnSequence }},
+	}
+	for _, td := range testData {
+		t.Run(td.name, func(t *testing.T) {
+			broken := readTestSpec(t)
+			td.change(&broken)
+			if err := broken.Validate(); err == nil {
+				t.Errorf("expected error for %s", td.name)
+			}
+		})
+	}
+}
+
+func generateRows(spec GeneratorSpec, tableIndex int) [][]interface{} {
+	var rows [][]interface{}
+	generator := newRowGenerator(spec, tableIndex)
+	for i := 0; i < spec.Tables[tableIndex].Rows; i++ {
+		rows = append(rows, generator.next())
+	}
+	return rows
+}
+
+func TestRowGenerator(t *testing.T) {
+	spec := readTestSpec(t)
+	customers := generateRows(spec, 0)
+	orders := generateRows(spec, 1)
+	if !reflect.DeepEqual(orders, generateRows(spec, 1)) {
+		t.Errorf("the same seed produced different data")
+	}
+	spec.Seed++
+	if reflect.DeepEqual(orders, generateRows(spec, 1)) {
+		t.Errorf("a different seed produced the same data")
+	}
+
+	for i, row := range customers {
+		if row[0] != int64(i+1) {
+			t.Errorf("expected sequence %d - got %v", i+1, row[0])
+		}
+		if len(row[1].(string)) != 12 {
+			t.Errorf("expected string of length 12 - got '%s'", row[1])
+		}
+		if !strings.HasPrefix(row[2].(string), "2020-") {
+			t.Errorf("date %s out of range", row[2])
+		}
+	}
+	for i, row := range orders {
+		if row[0] != int64(1000+i) {
+			t.Errorf("expected sequence %d - got %v", 1000+i, row[0])
+		}
+		customerId := row[1].(int64)
+		if customerId < 1 || customerId > int64(len(customers)) {
+			t.Errorf("reference %d does not match an existing customer", customerId)
+		}
+		amount := row[2].(int64)
+		if amount < 1 || amount > 5 {
+			t.Errorf("amount %d out of range", amount)
+		}
+		var document map[string]interface{}
+		if err := json.Unmarshal([]byte(row[3].(string)), &document); err != nil {
+			t.Errorf("invalid JSON %s: %s", row[3], err)
+		}
+	}
+}
+
+func TestGeneratorStatements(t *testing.T) {
+	spec := readTestSpec(t)
+	statements := createStatements(spec, false)
+	if len(statements) != 3 || strings.Contains(strings.Join(statements, "\n"), "DROP TABLE") {
+		t.Errorf("unexpected statements without overwrite: %v", statements)
+	}
+	statements = createStatements(spec, true)
+	expected := []string{
+		"CREATE DATABASE IF NOT EXISTS `shop`",
+		"DROP TABLE IF EXISTS `shop`.`orders`",
+		"DROP TABLE IF EXISTS `shop`.`customers`",
+	}
+	if !reflect.DeepEqual(statements[:3], expected) {
+		t.Errorf("expected %v - got %v", expected, statements[:3])
+	}
+	if len(statements) != 5 || !strings.Contains(statements[4], "FOREIGN KEY (`customer_id`) REFERENCES `customers` (`id`)") {
+		t.Errorf("foreign key not found in %v", statements)
+	}
+
+	insert := insertStatement("shop", spec.Tables[1], 2)
+	expectedInsert := "INSERT INTO `shop`.`orders` (`id`, `customer_id`, `amount`, `details`) VALUES (?, ?, ?, ?), (?, ?, ?, ?)"
+	if insert != expectedInsert {
+		t.Errorf("expected %s - got %s", expectedInsert, insert)
+	}
+}
+
+func TestGeneratorNodeDir(t *testing.T) {
+	makeNode := func(dir string) {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("error creating node directory %s: %s", dir, err)
+		}
+		err = os.WriteFile(path.Join(dir, globals.ScriptConnectionJson), []byte("{}"), 0644)
+		if err != nil {
+			t.Fatalf("error writing connection file in %s: %s", dir, err)
+		}
+	}
+	// A master-slave sandbox, where node1 is the first slave
+	replication := t.TempDir()
+	makeNode(path.Join(replication, defaults.Defaults().MasterName))
+	makeNode(path.Join(replication, defaults.Defaults().NodePrefix+"1"))
+	// A group or multiple sandbox, where node1 is the first node
+	group := t.TempDir()
+	makeNode(path.Join(group, defaults.Defaults().NodePrefix+"1"))
+	makeNode(path.Join(group, defaults.Defaults().NodePrefix+"2"))
+	single := t.TempDir()
+	makeNode(single)
+
+	var tests = []struct {
+		sandboxPath string
+		expected    string
+	}{
+		{replication, path.Join(replication, defaults.Defaults().MasterName)},
+		{group, path.Join(group, defaults.Defaults().NodePrefix+"1")},
+		{single, single},
+	}
+	for _, tt := range tests {
+		nodeDir, err := generatorNodeDir(tt.sandboxPath)
+		if err != nil {
+			t.Fatalf("error finding node in %s: %s", tt.sandboxPath, err)
+		}
+		if nodeDir != tt.expected {
+			t.Errorf("expected node %s - got %s", tt.expected, nodeDir)
+		}
+	}
+	_, err := generatorNodeDir(t.TempDir())
+	if err == nil {
+		t.Errorf("expected error for a directory without sandboxes")
+	}
+}
@@ -0,0 +1,667 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data_load
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Formats of archives loaded from a local file or directory
+const (
+	FormatMysqldump = "mysqldump" // A file created by mysqldump (.sql or .sql.gz)
+	FormatMydumper  = "mydumper"  // A directory created by mydumper
+	FormatCsv       = "csv"       // A directory with one CSV file per table, plus a DDL file
+)
+
+// Priorities of the load tasks. Tasks with the same priority run in parallel
+const (
+	prioritySchema = iota // CREATE DATABASE
+	priorityTable         // CREATE TABLE
+	priorityData          // INSERT or LOAD DATA
+	priorityObject        // Views, triggers, routines
+)
+
+const (
+	defaultDdlFile      = "schema.sql"
+	localWorkDirPrefix  = "data_load_"
+	foreignKeysDisabled = "SET FOREIGN_KEY_CHECKS=0"
+)
+
+// AddLocalArchive adds to the archives file the definition of an archive with a local origin
+func AddLocalArchive(archiveName string, archive DataDefinition) error {
+	switch archive.Format {
+	case FormatMysqldump:
+		if !common.FileExists(localOriginPath(archive.Origin)) {
+			return fmt.Errorf(globals.ErrFileNotFound, archive.Origin)
+		}
+	case FormatMydumper, FormatCsv:
+		if !common.DirExists(localOriginPath(archive.Origin)) {
+			return fmt.Errorf(globals.ErrDirectoryNotFound, archive.Origin)
+		}
+	default:
+		return fmt.Errorf("format must be one of %s, %s, %s", FormatMysqldump, FormatMydumper, FormatCsv)
+	}
+	if archive.Format == FormatCsv && archive.Database == "" {
+		return fmt.Errorf("CSV archives require a database")
+	}
+	if archive.Format == FormatMysqldump && archive.Database == "" {
+		hasDatabase, err := dumpHasDatabase(localOriginPath(archive.Origin))
+		if err != nil {
+			return err
+		}
+		if !hasDatabase {
+			return fmt.Errorf("dump %s does not say which database it belongs to. Use --%s to indicate it",
+				archive.Origin, globals.DatabaseLabel)
+		}
+	}
+	currentArchives, _ := Archives()
+	if _, found := currentArchives[archiveName]; found {
+		return fmt.Errorf("archive %s already exists", archiveName)
+	}
+	if archive.FileName == "" {
+		archive.FileName = path.Base(localOriginPath(archive.Origin))
+	}
+	// The current archives may be the defaults, which must not change
+	archives := map[string]DataDefinition{archiveName: archive}
+	for name, definition := range currentArchives {
+		archives[name] = definition
+	}
+	text, err := json.MarshalIndent(archives, " ", " ")
+	if err != nil {
+		return err
+	}
+	if !common.DirExists(defaults.ConfigurationDir) {
+		err = os.Mkdir(defaults.ConfigurationDir, globals.PublicDirectoryAttr)
+		if err != nil {
+			return err
+		}
+	}
+	return common.WriteString(UnescapeJsonString(text), defaults.ArchivesFile)
+}
+
+// loadTask is a single operation of a local load
+type loadTask struct {
+	label    string // Table (or object) affected by the task
+	file     string // File sent to the server. Can be compressed with gzip or zstd
+	database string // Default database for the client
+	query    string // Query to run instead of sending a file
+	size     int64  // Bytes of data handled by the task
+	priority int
+	optional bool // Errors are ignored
+}
+
+var reUrl = regexp.MustCompile(`^\w+://`)
+
+// isLocalOrigin tells whether an archive origin is a local file or directory instead of a URL
+func isLocalOrigin(origin string) bool {
+	return strings.HasPrefix(origin, "file://") || !reUrl.MatchString(origin)
+}
+
+func localOriginPath(origin string) string {
+	return strings.TrimPrefix(origin, "file://")
+}
+
+// shellQuote encloses a string in single quotes for the shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sqlQuote encloses a string in single quotes for SQL
+func sqlQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", `\'`) + "'"
+}
+
+func fileSize(fileName string) int64 {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// uncompressedName removes the compression extension from a file name
+func uncompressedName(fileName string) string {
+	for _, ext := range []string{globals.GzExt, ".zst"} {
+		if strings.HasSuffix(fileName, ext) {
+			return strings.TrimSuffix(fileName, ext)
+		}
+	}
+	return fileName
+}
+
+// taskCommand returns the shell command that runs a task through the "use" script
+func taskCommand(task loadTask, useExecutable string) string {
+	args := []string{shellQuote(useExecutable), "--init-command=" + shellQuote(foreignKeysDisabled)}
+	if task.database != "" {
+		args = append(args, "--database="+shellQuote(task.database))
+	}
+	var command string
+	client := strings.Join(args, " ")
+	switch {
+	case task.query != "":
+		command = fmt.Sprintf("%s --local-infile=1 -e %s", client, shellQuote(task.query))
+	case strings.HasSuffix(task.file, globals.GzExt):
+		command = fmt.Sprintf("set -o pipefail; gzip -dc %s | %s", shellQuote(task.file), client)
+	case strings.HasSuffix(task.file, ".zst"):
+		command = fmt.Sprintf("set -o pipefail; zstd -dc %s | %s", shellQuote(task.file), client)
+	default:
+		command = fmt.Sprintf("%s < %s", client, shellQuote(task.file))
+	}
+	if task.optional {
+		command += " 2>/dev/null || true"
+	}
+	return command
+}
+
+// Markers of the sections in a mysqldump file
+var (
+	reDumpDatabase = regexp.MustCompile("^-- Current Database: `")
+	reDumpTable    = regexp.MustCompile("^-- (?:Table structure for table|Temporary view structure for view) `(.+)`")
+	reDumpObjects  = regexp.MustCompile("^-- (?:Final view structure for view|Dumping routines for database|Dumping events for database) ")
+	reDumpUse      = regexp.MustCompile("^USE `(.+)`;")
+	reDumpGtid     = regexp.MustCompile(`^SET @@GLOBAL.GTID_PURGED`)
+	reDumpFooter   = regexp.MustCompile(`^/\*!40103 SET TIME_ZONE=@OLD_TIME_ZONE`)
+)
+
+// dumpChunk is a part of a mysqldump file that can be loaded independently
+type dumpChunk struct {
+	label    string
+	priority int
+	lines    []string
+}
+
+// splitMysqldump divides the output of mysqldump into chunks: one for the schemas,
+// one for each table, and one for views, routines and events, which must be created
+// after all the tables. Every chunk starts with the session settings of the dump header,
+// and with the USE statement of its database.
+// Statements that change the global state, such as GTID_PURGED, go to the schema chunk only.
+func splitMysqldump(reader io.Reader, database string) ([]dumpChunk, error) {
+	var header []string
+	schema := dumpChunk{label: "schema", priority: prioritySchema}
+	objects := dumpChunk{label: "views and routines", priority: priorityObject}
+	var tables []*dumpChunk
+	var current *dumpChunk
+	currentUse := ""
+	if database != "" {
+		schema.lines = append(schema.lines, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`;", database))
+		currentUse = fmt.Sprintf("USE `%s`;", database)
+	}
+	inGtid := false
+	inFooter := false
+	bufReader := bufio.NewReader(reader)
+	for {
+		line, err := bufReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case inFooter:
+		case inGtid || reDumpGtid.MatchString(line):
+			schema.lines = append(schema.lines, line)
+			inGtid = !strings.HasSuffix(line, ";")
+		case reDumpFooter.MatchString(line):
+			inFooter = true
+		case reDumpDatabase.MatchString(line):
+			current = &schema
+			current.lines = append(current.lines, line)
+		case reDumpTable.MatchString(line):
+			tableName := reDumpTable.FindStringSubmatch(line)[1]
+			label := tableName
+			if currentUse != "" {
+				label = reDumpUse.FindStringSubmatch(currentUse)[1] + "." + tableName
+			}
+			current = &dumpChunk{label: label, priority: priorityTable}
+			tables = append(tables, current)
+			current.lines = append(current.lines, header...)
+			if currentUse != "" {
+				current.lines = append(current.lines, currentUse)
+			}
+			current.lines = append(current.lines, line)
+		case reDumpObjects.MatchString(line):
+			if current != &objects && currentUse != "" {
+				objects.lines = append(objects.lines, currentUse)
+			}
+			current = &objects
+			current.lines = append(current.lines, line)
+		case current == nil:
+			header = append(header, line)
+		default:
+			if reDumpUse.MatchString(line) {
+				currentUse = line
+			}
+			current.lines = append(current.lines, line)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no tables found in dump")
+	}
+	var chunks []dumpChunk
+	if len(schema.lines) > 0 {
+		schema.lines = append(header, schema.lines...)
+		chunks = append(chunks, schema)
+	}
+	for _, table := range tables {
+		chunks = append(chunks, *table)
+	}
+	if len(objects.lines) > 0 {
+		objects.lines = append(header, objects.lines...)
+		chunks = append(chunks, objects)
+	}
+	return chunks, nil
+}
+
+// openDump opens a dump file, uncompressing it if needed
+func openDump(dumpFile string) (io.ReadCloser, error) {
+	// #nosec G304
+	file, err := os.Open(dumpFile)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(dumpFile, globals.GzExt) {
+		return file, nil
+	}
+	gzReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading compressed file %s: %s", dumpFile, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gzReader, file}, nil
+}
+
+// dumpHasDatabase tells whether a dump file selects its database, with a
+// "-- Current Database" marker or a USE statement, before its first table
+func dumpHasDatabase(dumpFile string) (bool, error) {
+	reader, err := openDump(dumpFile)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case reDumpDatabase.MatchString(line), reDumpUse.MatchString(line):
+			return true, nil
+		case reDumpTable.MatchString(line):
+			return false, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// mysqldumpTasks splits a dump file into chunks written to workDir
+func mysqldumpTasks(dumpFile, workDir, database string) ([]loadTask, error) {
+	reader, err := openDump(dumpFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	chunks, err := splitMysqldump(reader, database)
+	if err != nil {
+		return nil, fmt.Errorf("error splitting %s: %s", dumpFile, err)
+	}
+	var tasks []loadTask
+	for i, chunk := range chunks {
+		chunkFile := path.Join(workDir, fmt.Sprintf("%05d.sql", i))
+		err = common.WriteStrings(chunk.lines, chunkFile, "\n")
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, loadTask{
+			label:    chunk.label,
+			file:     chunkFile,
+			size:     fileSize(chunkFile),
+			priority: chunk.priority,
+		})
+	}
+	return tasks, nil
+}
+
+// File names in a mydumper directory, after removing the compression extension
+var (
+	reMydumperSchemaCreate = regexp.MustCompile(`^(.+)-schema-create\.sql$`)
+	reMydumperSchemaPost   = regexp.MustCompile(`^(.+)-schema-post\.sql$`)
+	reMydumperTable        = regexp.MustCompile(`^([^.]+)\.(.+)-schema\.sql$`)
+	reMydumperObject       = regexp.MustCompile(`^([^.]+)\.(.+)-schema-[\w-]+\.sql$`)
+	reMydumperData         = regexp.MustCompile(`^([^.]+)\.([^.]+)(?:\.[\d-]+)?\.sql$`)
+)
+
+// mydumperTasks creates a task for every file in a directory created by mydumper.
+// The data files of all tables, including the chunks of the same table, are loaded in parallel
+func mydumperTasks(dir string) ([]loadTask, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []loadTask
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fileName := path.Join(dir, entry.Name())
+		name := uncompressedName(entry.Name())
+		task := loadTask{file: fileName, size: fileSize(fileName)}
+		switch {
+		case reMydumperSchemaCreate.MatchString(name):
+			task.label = reMydumperSchemaCreate.FindStringSubmatch(name)[1]
+			task.priority = prioritySchema
+		case reMydumperSchemaPost.MatchString(name):
+			task.database = reMydumperSchemaPost.FindStringSubmatch(name)[1]
+			task.label = task.database + " routines"
+			task.priority = priorityObject
+		case reMydumperTable.MatchString(name):
+			matches := reMydumperTable.FindStringSubmatch(name)
+			task.database = matches[1]
+			task.label = matches[1] + "." + matches[2]
+			task.priority = priorityTable
+		case reMydumperObject.MatchString(name):
+			matches := reMydumperObject.FindStringSubmatch(name)
+			task.database = matches[1]
+			task.label = matches[1] + "." + matches[2]
+			task.priority = priorityObject
+		case reMydumperData.MatchString(name):
+			matches := reMydumperData.FindStringSubmatch(name)
+			task.database = matches[1]
+			task.label = matches[1] + "." + matches[2]
+			task.priority = priorityData
+		default:
+			// metadata and other files
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("no mydumper files found in %s", dir)
+	}
+	return tasks, nil
+}
+
+// csvTasks creates the tasks that load a directory with a DDL file and one CSV file
+// for each table. The name of each CSV file, without extension, is the name of its table
+func csvTasks(dir, ddlFile, database string, header bool) ([]loadTask, error) {
+	if database == "" {
+		return nil, fmt.Errorf("CSV archives require a database")
+	}
+	if ddlFile == "" {
+		ddlFile = defaultDdlFile
+	}
+	if !path.IsAbs(ddlFile) {
+		ddlFile = path.Join(dir, ddlFile)
+	}
+	if !common.FileExists(ddlFile) {
+		return nil, fmt.Errorf(globals.ErrFileNotFound, ddlFile)
+	}
+	tasks := []loadTask{
+		{
+			label:    database,
+			query:    fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", database),
+			priority: prioritySchema,
+		},
+		// LOAD DATA LOCAL is disabled by default in MySQL 8.0.
+		// Servers that don't have this variable, or where the user can't change it, are left alone
+		{
+			label:    "local_infile",
+			query:    "SET GLOBAL local_infile=1",
+			priority: prioritySchema,
+			optional: true,
+		},
+		{
+			label:    database + " tables",
+			file:     ddlFile,
+			database: database,
+			size:     fileSize(ddlFile),
+			priority: priorityTable,
+		},
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ignoreLines := ""
+	if header {
+		ignoreLines = " IGNORE 1 LINES"
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".csv") {
+			continue
+		}
+		table := strings.TrimSuffix(entry.Name(), ".csv")
+		fileName := path.Join(dir, entry.Name())
+		tasks = append(tasks, loadTask{
+			label:    database + "." + table,
+			database: database,
+			query: fmt.Sprintf("LOAD DATA LOCAL INFILE %s INTO TABLE `%s` "+
+				`FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' LINES TERMINATED BY '\n'%s`,
+				sqlQuote(fileName), table, ignoreLines),
+			size:     fileSize(fileName),
+			priority: priorityData,
+		})
+	}
+	if len(tasks) == 3 {
+		return nil, fmt.Errorf("no CSV files found in %s", dir)
+	}
+	return tasks, nil
+}
+
+// tableStats collects the progress of all the tasks of one table
+type tableStats struct {
+	size    int64
+	tasks   int
+	started time.Time
+	ended   time.Time
+}
+
+func throughput(size int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "-"
+	}
+	return humanize.Bytes(uint64(float64(size)/elapsed.Seconds())) + "/s"
+}
+
+// targetTables returns the tables, as "database.table", that the tasks of a local archive fill
+func targetTables(tasks []loadTask, format string) []string {
+	tablePriority := priorityTable
+	if format == FormatCsv {
+		tablePriority = priorityData
+	}
+	var tables []string
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		if task.priority != tablePriority || !strings.Contains(task.label, ".") || seen[task.label] {
+			continue
+		}
+		seen[task.label] = true
+		tables = append(tables, task.label)
+	}
+	return tables
+}
+
+// quoteTable returns a "database.table" name quoted for SQL
+func quoteTable(table string) string {
+	parts := strings.SplitN(table, ".", 2)
+	return fmt.Sprintf("`%s`.`%s`", parts[0], parts[1])
+}
+
+// existingLocalTables returns the tables, among the given ones, that are already in the server
+func existingLocalTables(tables []string, useExecutable string) ([]string, error) {
+	var quoted []string
+	for _, table := range tables {
+		quoted = append(quoted, sqlQuote(table))
+	}
+	query := fmt.Sprintf("SELECT CONCAT(table_schema, '.', table_name) FROM information_schema.tables "+
+		"WHERE CONCAT(table_schema, '.', table_name) IN (%s)", strings.Join(quoted, ", "))
+	output, err := common.RunCmdCtrlWithArgs(useExecutable, []string{"-BN", "-e", query}, true)
+	if err != nil {
+		return nil, fmt.Errorf("error checking existing tables: %s", err)
+	}
+	return strings.Fields(output), nil
+}
+
+// loadLocalArchive loads an archive from a local file or directory, running in parallel
+// the tasks that don't depend on each other, with at most "threads" tasks at once.
+// Tables that already exist are dropped first when overwrite is set. Otherwise, they stop the load
+func loadLocalArchive(archiveName string, archive DataDefinition, sandboxPath, useExecutable string, threads int, overwrite bool) error {
+	origin := localOriginPath(archive.Origin)
+	var tasks []loadTask
+	var err error
+	switch archive.Format {
+	case FormatMysqldump:
+		if !common.FileExists(origin) {
+			return fmt.Errorf(globals.ErrFileNotFound, origin)
+		}
+		workDir := path.Join(sandboxPath, localWorkDirPrefix+archiveName)
+		err = os.MkdirAll(workDir, globals.PublicDirectoryAttr)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(workDir)
+		fmt.Printf("Splitting %s\n", origin)
+		tasks, err = mysqldumpTasks(origin, workDir, archive.Database)
+	case FormatMydumper:
+		if !common.DirExists(origin) {
+			return fmt.Errorf(globals.ErrDirectoryNotFound, origin)
+		}
+		tasks, err = mydumperTasks(origin)
+	case FormatCsv:
+		if !common.DirExists(origin) {
+			return fmt.Errorf(globals.ErrDirectoryNotFound, origin)
+		}
+		tasks, err = csvTasks(origin, archive.DdlFile, archive.Database, archive.CsvHeader)
+	default:
+		return fmt.Errorf("unsupported format '%s' for local archive %s", archive.Format, archiveName)
+	}
+	if err != nil {
+		return err
+	}
+	targets := targetTables(tasks, archive.Format)
+	if len(targets) > 0 {
+		if overwrite {
+			var quoted []string
+			for _, table := range targets {
+				quoted = append(quoted, quoteTable(table))
+			}
+			tasks = append([]loadTask{{
+				label:    "drop tables",
+				query:    "DROP TABLE IF EXISTS " + strings.Join(quoted, ", "),
+				priority: prioritySchema,
+			}}, tasks...)
+		} else {
+			existing, err := existingLocalTables(targets, useExecutable)
+			if err != nil {
+				return err
+			}
+			if len(existing) > 0 {
+				return fmt.Errorf("tables %s already exist. Use --%s to replace them",
+					strings.Join(existing, ", "), globals.OverwriteLabel)
+			}
+		}
+	}
+
+	var execLists []concurrent.ExecutionList
+	for _, task := range tasks {
+		execLists = append(execLists, concurrent.ExecutionList{
+			Priority: task.priority,
+			Command: concurrent.ExecCommand{
+				Cmd:  defaults.Defaults().ShellPath,
+				Args: []string{"-c", taskCommand(task, useExecutable)},
+			},
+		})
+	}
+	stats := make(map[string]*tableStats)
+	done := 0
+	fmt.Printf("Loading %s: %d tasks (%d in parallel)\n", origin, len(tasks), threads)
+	results := concurrent.RunLimitedTasksByPriority(execLists, threads, func(result concurrent.TaskResult) {
+		task := tasks[result.Index]
+		done++
+		status := "ok"
+		if result.Err != nil {
+			status = "FAILED"
+		}
+		fmt.Printf("[%d/%d] %-40s %10s %8.2fs %12s %s\n", done, len(tasks),
+			task.label, humanize.Bytes(uint64(task.size)), result.Elapsed.Seconds(),
+			throughput(task.size, result.Elapsed), status)
+		if task.priority != priorityData && !(archive.Format == FormatMysqldump && task.priority == priorityTable) {
+			return
+		}
+		ended := result.Started.Add(result.Elapsed)
+		ts, found := stats[task.label]
+		if !found {
+			ts = &tableStats{started: result.Started, ended: ended}
+			stats[task.label] = ts
+		}
+		ts.size += task.size
+		ts.tasks++
+		if result.Started.Before(ts.started) {
+			ts.started = result.Started
+		}
+		if ended.After(ts.ended) {
+			ts.ended = ended
+		}
+	})
+
+	var failures []string
+	for _, result := range results {
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s %s", tasks[result.Index].label, result.Err, strings.TrimSpace(result.Output)))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("error loading %s:\n\t%s", archiveName, strings.Join(failures, "\n\t"))
+	}
+
+	var tables []string
+	for table := range stats {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	fmt.Printf("\n%-40s %6s %10s %8s %12s\n", "table", "tasks", "size", "time", "throughput")
+	for _, table := range tables {
+		ts := stats[table]
+		elapsed := ts.ended.Sub(ts.started)
+		fmt.Printf("%-40s %6d %10s %7.2fs %12s\n", table, ts.tasks, humanize.Bytes(uint64(ts.size)),
+			elapsed.Seconds(), throughput(ts.size, elapsed))
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data_load
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testDump = "-- MySQL dump 10.13\n" +
+	"/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;\n" +
+	"SET @@SESSION.SQL_LOG_BIN= 0;\n" +
+	"SET @@GLOBAL.GTID_PURGED=/*!80000 '+'*/ 'aaaa:1-10,\n" +
+	"bbbb:1-5';\n" +
+	"--\n" +
+	"-- Current Database: `shop`\n" +
+	"--\n" +
+	"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `shop`;\n" +
+	"USE `shop`;\n" +
+	"-- Table structure for table `customers`\n" +
+	"CREATE TABLE `customers` (id int);\n" +
+	"INSERT INTO `customers` VALUES (1),(2);\n" +
+	"-- Table structure for table `orders`\n" +
+	"CREATE TABLE `orders` (id int);\n" +
+	"INSERT INTO `orders` VALUES (1);\n" +
+	"-- Temporary view structure for view `big_orders`\n" +
+	"CREATE TABLE `big_orders` (id int);\n" +
+	"-- Dumping routines for database 'shop'\n" +
+	"CREATE PROCEDURE p() SELECT 1;\n" +
+	"-- Final view structure for view `big_orders`\n" +
+	"CREATE VIEW `big_orders` AS SELECT * FROM orders;\n" +
+	"/*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;\n" +
+	"-- Dump completed\n"
+
+func TestSplitMysqldump(t *testing.T) {
+	chunks, err := splitMysqldump(strings.NewReader(testDump), "")
+	if err != nil {
+		t.Fatalf("error splitting dump: %s", err)
+	}
+	var labels []string
+	for _, chunk := range chunks {
+		labels = append(labels, chunk.label)
+	}
+	expectedLabels := []string{"schema", "shop.customers", "shop.orders", "shop.big_orders", "views and routines"}
+	if !reflect.DeepEqual(labels, expectedLabels) {
+		t.Fatalf("expected chunks %v - got %v", expectedLabels, labels)
+	}
+	schema := strings.Join(chunks[0].lines, "\n")
+	if !strings.Contains(schema, "bbbb:1-5';") || !strings.Contains(schema, "CREATE DATABASE") {
+		t.Errorf("schema chunk is incomplete: %s", schema)
+	}
+	expectedOrders := []string{
+		"-- MySQL dump 10.13",
+		"/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;",
+		"SET @@SESSION.SQL_LOG_BIN= 0;",
+		"--",
+		"USE `shop`;",
+		"-- Table structure for table `orders`",
+		"CREATE TABLE `orders` (id int);",
+		"INSERT INTO `orders` VALUES (1);",
+	}
+	if !reflect.DeepEqual(chunks[2].lines, expectedOrders) {
+		t.Errorf("expected orders chunk %v - got %v", expectedOrders, chunks[2].lines)
+	}
+	objects := strings.Join(chunks[4].lines, "\n")
+	if !strings.Contains(objects, "USE `shop`;") || !strings.Contains(objects, "CREATE VIEW") ||
+		strings.Contains(objects, "Dump completed") {
+		t.Errorf("unexpected objects chunk: %s", objects)
+	}
+
+	chunks, err = splitMysqldump(strings.NewReader("-- Table structure for table `t1`\nCREATE TABLE t1 (id int);\n"), "db1")
+	if err != nil {
+		t.Fatalf("error splitting dump: %s", err)
+	}
+	if len(chunks) != 2 || chunks[1].label != "db1.t1" || chunks[1].lines[0] != "USE `db1`;" {
+		t.Errorf("database not applied to dump: %+v", chunks)
+	}
+
+	_, err = splitMysqldump(strings.NewReader("SELECT 1;\n"), "")
+	if err == nil {
+		t.Errorf("expected error for dump without tables")
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		err := os.WriteFile(path.Join(dir, name), []byte("SELECT 1;\n"), 0644)
+		if err != nil {
+			t.Fatalf("error writing %s: %s", name, err)
+		}
+	}
+}
+
+func TestMydumperTasks(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir,
+		"metadata",
+		"shop-schema-create.sql",
+		"shop-schema-post.sql",
+		"shop.customers-schema.sql",
+		"shop.customers.00000.sql.gz",
+		"shop.customers.00001.sql.gz",
+		"shop.orders-schema.sql",
+		"shop.orders.sql",
+		"shop.big_orders-schema-view.sql",
+	)
+	tasks, err := mydumperTasks(dir)
+	if err != nil {
+		t.Fatalf("error reading mydumper directory: %s", err)
+	}
+	var summary []string
+	for _, task := range tasks {
+		summary = append(summary, strings.Join([]string{task.label, task.database, path.Base(task.file), string(rune('0' + task.priority))}, " "))
+	}
+	sort.Strings(summary)
+	expected := []string{
+		"shop  shop-schema-create.sql 0",
+		"shop routines shop shop-schema-post.sql 3",
+		"shop.big_orders shop shop.big_orders-schema-view.sql 3",
+		"shop.customers shop shop.customers-schema.sql 1",
+		"shop.customers shop shop.customers.00000.sql.gz 2",
+		"shop.customers shop shop.customers.00001.sql.gz 2",
+		"shop.orders shop shop.orders-schema.sql 1",
+		"shop.orders shop shop.orders.sql 2",
+	}
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("expected tasks:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(summary, "\n"))
+	}
+}
+
+func TestCsvTasks(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "tables.sql", "t1.csv", "t2.csv", "notes.txt")
+	_, err := csvTasks(dir, "tables.sql", "", false)
+	if err == nil {
+		t.Errorf("expected error for missing database")
+	}
+	_, err = csvTasks(dir, "", "stats", false)
+	if err == nil {
+		t.Errorf("expected error for missing DDL file")
+	}
+	tasks, err := csvTasks(dir, "tables.sql", "stats", true)
+	if err != nil {
+		t.Fatalf("error reading CSV directory: %s", err)
+	}
+	if len(tasks) != 5 {
+		t.Fatalf("expected 5 tasks - got %d", len(tasks))
+	}
+	load := tasks[3]
+	if load.label != "stats.t1" || load.priority != priorityData ||
+		!strings.HasPrefix(load.query, "LOAD DATA LOCAL INFILE '"+path.Join(dir, "t1.csv")+"' INTO TABLE `t1`") ||
+		!strings.HasSuffix(load.query, "IGNORE 1 LINES") {
+		t.Errorf("unexpected load task: %+v", load)
+	}
+}
+
+func TestTargetTables(t *testing.T) {
+	tasks := []loadTask{
+		{label: "shop", priority: prioritySchema},
+		{label: "shop.customers", priority: priorityTable},
+		{label: "shop.customers", priority: priorityData},
+		{label: "shop.orders", priority: priorityTable},
+		{label: "views and routines", priority: priorityObject},
+	}
+	tables := targetTables(tasks, FormatMydumper)
+	if !reflect.DeepEqual(tables, []string{"shop.customers", "shop.orders"}) {
+		t.Errorf("unexpected tables %v", tables)
+	}
+	tables = targetTables(tasks, FormatCsv)
+	if !reflect.DeepEqual(tables, []string{"shop.customers"}) {
+		t.Errorf("unexpected CSV tables %v", tables)
+	}
+	if quoteTable("shop.order.items") != "`shop`.`order.items`" {
+		t.Errorf("unexpected quoted table %s", quoteTable("shop.order.items"))
+	}
+}
+
+func TestDumpHasDatabase(t *testing.T) {
+	dir := t.TempDir()
+	var testData = []struct {
+		contents string
+		expected bool
+	}{
+		{testDump, true},
+		{"-- Table structure for table `t1`\nCREATE TABLE t1 (id int);\nUSE `db1`;\n", false},
+		{"USE `db1`;\n-- Table structure for table `t1`\n", true},
+	}
+	for i, td := range testData {
+		dumpFile := path.Join(dir, fmt.Sprintf("dump%d.sql", i))
+		err := os.WriteFile(dumpFile, []byte(td.contents), 0644)
+		if err != nil {
+			t.Fatalf("error writing dump: %s", err)
+		}
+		hasDatabase, err := dumpHasDatabase(dumpFile)
+		if err != nil {
+			t.Fatalf("error reading dump: %s", err)
+		}
+		if hasDatabase != td.expected {
+			t.Errorf("dump %d: expected %v - got %v", i, td.expected, hasDatabase)
+		}
+	}
+}
+
+func TestTaskCommand(t *testing.T) {
+	var testData = []struct {
+		task     loadTask
+		expected string
+	}{
+		{loadTask{file: "/tmp/a b.sql"},
+			`'/sb/use' --init-command='SET FOREIGN_KEY_CHECKS=0' < '/tmp/a b.sql'`},
+		{loadTask{file: "/tmp/t.sql.gz", database: "db"},
+			`set -o pipefail; gzip -dc '/tmp/t.sql.gz' | '/sb/use' --init-command='SET FOREIGN_KEY_CHECKS=0' --database='db'`},
+		{loadTask{query: "SELECT 'x'", optional: true},
+			`'/sb/use' --init-command='SET FOREIGN_KEY_CHECKS=0' --local-infile=1 -e 'SELECT '\''x'\''' 2>/dev/null || true`},
+	}
+	for _, td := range testData {
+		command := taskCommand(td.task, "/sb/use")
+		if command != td.expected {
+			t.Errorf("expected %s - got %s", td.expected, command)
+		}
+	}
+}
+
+func TestLoadLocalArchive(t *testing.T) {
+	sandboxPath := t.TempDir()
+	dumpFile := path.Join(t.TempDir(), "shop.sql")
+	err := os.WriteFile(dumpFile, []byte(testDump), 0644)
+	if err != nil {
+		t.Fatalf("error writing dump: %s", err)
+	}
+	// The fake "use" script saves what it receives in a separate file for every call.
+	// Queries for existing tables get the contents of the "existing" file
+	received := path.Join(t.TempDir(), "received")
+	err = os.Mkdir(received, 0755)
+	if err != nil {
+		t.Fatalf("error creating directory: %s", err)
+	}
+	existing := path.Join(t.TempDir(), "existing")
+	useExecutable := path.Join(sandboxPath, "use")
+	script := "#!/usr/bin/env bash\n" +
+		"if [ \"$1\" == \"-BN\" ]; then cat " + existing + " 2>/dev/null; exit 0; fi\n" +
+		"cat > $(mktemp " + received + "/XXXXXX)\n"
+	err = os.WriteFile(useExecutable, []byte(script), 0744)
+	if err != nil {
+		t.Fatalf("error writing script: %s", err)
+	}
+	countReceived := func() int {
+		entries, err := os.ReadDir(received)
+		if err != nil {
+			t.Fatalf("error reading directory: %s", err)
+		}
+		return len(entries)
+	}
+
+	archive := DataDefinition{Origin: dumpFile, Format: FormatMysqldump}
+	err = loadLocalArchive("shop", archive, sandboxPath, useExecutable, 2, false)
+	if err != nil {
+		t.Fatalf("error loading archive: %s", err)
+	}
+	if countReceived() != 5 {
+		t.Errorf("expected 5 chunks loaded - got %d", countReceived())
+	}
+	if _, err := os.Stat(path.Join(sandboxPath, localWorkDirPrefix+"shop")); !os.IsNotExist(err) {
+		t.Errorf("work directory was not removed")
+	}
+
+	// Existing tables stop the load, unless they can be replaced
+	err = os.WriteFile(existing, []byte("shop.orders\n"), 0644)
+	if err != nil {
+		t.Fatalf("error writing existing tables: %s", err)
+	}
+	err = loadLocalArchive("shop", archive, sandboxPath, useExecutable, 2, false)
+	if err == nil || !strings.Contains(err.Error(), "shop.orders") {
+		t.Errorf("expected error for existing table - got %v", err)
+	}
+	if countReceived() != 5 {
+		t.Errorf("expected no chunks loaded with existing tables - got %d", countReceived()-5)
+	}
+	err = loadLocalArchive("shop", archive, sandboxPath, useExecutable, 2, true)
+	if err != nil {
+		t.Fatalf("error loading archive with overwrite: %s", err)
+	}
+	// The chunks, plus the removal of the tables
+	if countReceived() != 11 {
+		t.Errorf("expected 6 tasks with overwrite - got %d", countReceived()-5)
+	}
+
+	archive.Format = "unknown"
+	err = loadLocalArchive("shop", archive, sandboxPath, useExecutable, 2, false)
+	if err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
@@ -19,7 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
+	"path"
 	"time"
 
 	"github.com/nightlyone/lockfile"
@@ -134,7 +134,7 @@ func setLock(label string) (lockfile.Lockfile, error) {
 func UpdateCatalog(sbName string, details SandboxItem) error {
 	details.DbDeployerVersion = common.VersionDef
 	details.Timestamp = time.Now().Format(time.UnixDate)
-	details.CommandLine = strings.Join(common.CommandLineArgs, " ")
+	details.CommandLine = common.RedactedCommandLine()
 	if !enableCatalogManagement {
 		return nil
 	}
@@ -203,8 +203,21 @@ func checkCatalog() error {
 	return nil
 }
 
+// Tells the port finder where to keep the port leases.
+// Leases are disabled when SKIP_DBDEPLOYER_PORT_LEASES is set
+func setPortLeaseFiles() {
+	if common.IsEnvSet("SKIP_DBDEPLOYER_PORT_LEASES") {
+		common.PortLeaseRegistry = ""
+		common.PortLeaseLock = ""
+		return
+	}
+	common.PortLeaseRegistry = path.Join(ConfigurationDir, PortLeasesName)
+	common.PortLeaseLock = path.Join(ConfigurationDir, PortLeasesLockName)
+}
+
 func init() {
 	if common.IsEnvSet("SKIP_DBDEPLOYER_CATALOG") {
 		enableCatalogManagement = false
 	}
+	setPortLeaseFiles()
 }
@@ -64,6 +64,7 @@ type DbdeployerDefaults struct {
 	RemoteIndexFile               string `json:"remote-index-file"`
 	RemoteCompletionUrl           string `json:"remote-completion-url"`
 	RemoteTarballUrl              string `json:"remote-tarball-url"`
+	RegistrySources               string `json:"registry-sources,omitempty"`
 	PxcPrefix                     string `json:"pxc-prefix"`
 	NdbPrefix                     string `json:"ndb-prefix"`
 	DefaultSandboxExecutable      string `json:"default-sandbox-executable"`
@@ -81,6 +82,9 @@ const (
 	ArchivesFileName        string = "archives.json"
 	SandboxRegistryName     string = "sandboxes.json"
 	SandboxRegistryLockName string = "sandboxes.lock"
+	PortLeasesName          string = "ports.json"
+	PortLeasesLockName      string = "ports.lock"
+	KeysDirName             string = "keys"
 )
 
 var (
@@ -88,6 +92,7 @@ var (
 	ConfigurationDir        string = path.Join(homeDir, ConfigurationDirName)
 	ConfigurationFile       string = path.Join(ConfigurationDir, ConfigurationFileName)
 	ArchivesFile            string = path.Join(ConfigurationDir, ArchivesFileName)
+	KeysDir                 string = path.Join(ConfigurationDir, KeysDirName)
 	CustomConfigurationFile string = ""
 	SandboxRegistry         string = path.Join(ConfigurationDir, SandboxRegistryName)
 	SandboxRegistryLock     string = path.Join(common.GlobalTempDir(), SandboxRegistryLockName)
@@ -397,6 +402,8 @@ func UpdateDefaults(label, value string, storeDefaults bool) {
 		newDefaults.RemoteCompletionUrl = value
 	case "remote-tarball-url":
 		newDefaults.RemoteTarballUrl = value
+	case "registry-sources":
+		newDefaults.RegistrySources = value
 	case "reserved-ports":
 		newDefaults.ReservedPorts = strToSlice("reserved-ports", value)
 	case "pxc-prefix":
@@ -534,6 +541,8 @@ func DefaultsToMap() common.StringMap {
 		"remote-tarball-url":                currentDefaults.RemoteTarballUrl,
 		"remote-tarballs":                   currentDefaults.RemoteTarballUrl,
 		"remote-github":                     currentDefaults.RemoteTarballUrl,
+		"RegistrySources":                   currentDefaults.RegistrySources,
+		"registry-sources":                  currentDefaults.RegistrySources,
 		"PxcPrefix":                         currentDefaults.PxcPrefix,
 		"pxc-prefix":                        currentDefaults.PxcPrefix,
 		"NdbPrefix":                         currentDefaults.NdbPrefix,
@@ -558,6 +567,7 @@ func ResetDefaults() {
 	CustomConfigurationFile = ""
 	SandboxRegistry = path.Join(ConfigurationDir, SandboxRegistryName)
 	SandboxRegistryLock = path.Join(common.GlobalTempDir(), SandboxRegistryLockName)
+	setPortLeaseFiles()
 	LogSBOperations = common.IsEnvSet("DBDEPLOYER_LOGGING")
 	factoryDefaults.SandboxBinary = path.Join(homeDir, "opt", "mysql")
 	factoryDefaults.SandboxHome = path.Join(homeDir, "sandboxes")
@@ -0,0 +1,285 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// LockFileName is the project file that pins each short version to an exact binary
+const LockFileName = "dbdeployer.lock"
+
+// LockFileEnvVar can point to a lock file, or disable the lock with the value "none"
+const LockFileEnvVar = "DBDEPLOYER_LOCK"
+
+var reShortVersion = regexp.MustCompile(`^\d+\.\d+$`)
+
+// IsShortVersion tells whether a version has only major and minor numbers, like "8.0"
+func IsShortVersion(version string) bool {
+	return reShortVersion.MatchString(version)
+}
+
+// LockedBinary is the binary that a project uses for a short version, flavor, and platform
+type LockedBinary struct {
+	ShortVersion    string `json:"short_version"`
+	Flavor          string `json:"flavor"`
+	OperatingSystem string `json:"OS"`
+	Arch            string `json:"arch"`
+	Version         string `json:"version"`
+	Minimal         bool   `json:"minimal"`
+	Tarball         string `json:"tarball"`
+	Url             string `json:"url"`
+	Checksum        string `json:"checksum,omitempty"`
+}
+
+// BinaryLock is the contents of a lock file
+type BinaryLock struct {
+	DbdeployerVersion string
+	UpdatedOn         string `json:"updated_on,omitempty"`
+	Binaries          []LockedBinary
+}
+
+func lockOS(OS string) string {
+	OS = strings.ToLower(OS)
+	if OS == "osx" || OS == "macos" || OS == "os x" {
+		OS = "darwin"
+	}
+	return OS
+}
+
+func lockArch(arch string) string {
+	arch = strings.ToLower(arch)
+	if arch == "x86_64" || arch == "x86-64" {
+		arch = "amd64"
+	}
+	return arch
+}
+
+func lockFlavor(flavor string) string {
+	if flavor == "" {
+		return common.MySQLFlavor
+	}
+	return strings.ToLower(flavor)
+}
+
+// FindLockFile looks for a lock file in dir and its parents, or uses the one indicated
+// by the environment. It returns an empty string when no lock applies
+func FindLockFile(dir string) string {
+	fromEnv := os.Getenv(LockFileEnvVar)
+	if strings.ToLower(fromEnv) == "none" {
+		return ""
+	}
+	if fromEnv != "" {
+		return fromEnv
+	}
+	for {
+		fileName := path.Join(dir, LockFileName)
+		if common.FileExists(fileName) {
+			return fileName
+		}
+		parent := path.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// ReadLockFile reads a lock file. A missing file gives an empty lock
+func ReadLockFile(fileName string) (BinaryLock, error) {
+	var lock BinaryLock
+	if !common.FileExists(fileName) {
+		return lock, nil
+	}
+	text, err := common.SlurpAsBytes(fileName)
+	if err != nil {
+		return lock, err
+	}
+	err = json.Unmarshal(text, &lock)
+	if err != nil {
+		return lock, fmt.Errorf("error decoding lock file %s: %s", fileName, err)
+	}
+	return lock, nil
+}
+
+// ProjectLock returns the lock that applies to the current directory, and the file it comes from.
+// When there is no lock file, both are empty
+func ProjectLock() (BinaryLock, string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return BinaryLock{}, "", err
+	}
+	fileName := FindLockFile(cwd)
+	if fileName == "" {
+		return BinaryLock{}, "", nil
+	}
+	lock, err := ReadLockFile(fileName)
+	return lock, fileName, err
+}
+
+// WriteLockFile saves a lock, with its entries in a stable order
+func WriteLockFile(fileName string, lock BinaryLock) error {
+	sort.Slice(lock.Binaries, func(i, j int) bool {
+		bi, bj := lock.Binaries[i], lock.Binaries[j]
+		if bi.Flavor != bj.Flavor {
+			return bi.Flavor < bj.Flavor
+		}
+		if bi.ShortVersion != bj.ShortVersion {
+			return versionLess(bi.ShortVersion+".0", bj.ShortVersion+".0")
+		}
+		if bi.OperatingSystem != bj.OperatingSystem {
+			return bi.OperatingSystem < bj.OperatingSystem
+		}
+		return bi.Arch < bj.Arch
+	})
+	lock.DbdeployerVersion = common.VersionDef
+	lock.UpdatedOn = time.Now().Format("2006-01-02 15:04")
+	text, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return common.WriteString(string(text)+"\n", fileName)
+}
+
+// Find returns the binary locked for a short version, flavor, and platform
+func (lock BinaryLock) Find(shortVersion, flavor, OS, arch string) (LockedBinary, bool) {
+	for _, lb := range lock.Binaries {
+		if lb.ShortVersion == shortVersion && lb.Flavor == lockFlavor(flavor) &&
+			lb.OperatingSystem == lockOS(OS) && lb.Arch == lockArch(arch) {
+			return lb, true
+		}
+	}
+	return LockedBinary{}, false
+}
+
+// PinnedVersion returns the exact version locked for a short version and flavor, on any platform.
+// An empty flavor means MySQL
+func (lock BinaryLock) PinnedVersion(shortVersion, flavor string) (string, bool) {
+	for _, lb := range lock.Binaries {
+		if lb.ShortVersion == shortVersion && lb.Flavor == lockFlavor(flavor) {
+			return lb.Version, true
+		}
+	}
+	return "", false
+}
+
+// Set adds a locked binary, replacing the one for the same short version, flavor, and platform
+func (lock *BinaryLock) Set(binary LockedBinary) {
+	for i, lb := range lock.Binaries {
+		if lb.ShortVersion == binary.ShortVersion && lb.Flavor == binary.Flavor &&
+			lb.OperatingSystem == binary.OperatingSystem && lb.Arch == binary.Arch {
+			lock.Binaries[i] = binary
+			return
+		}
+	}
+	lock.Binaries = append(lock.Binaries, binary)
+}
+
+// LockTarball describes a tarball as a locked binary
+func LockTarball(tb TarballDescription) LockedBinary {
+	return LockedBinary{
+		ShortVersion:    tb.ShortVersion,
+		Flavor:          lockFlavor(tb.Flavor),
+		OperatingSystem: lockOS(tb.OperatingSystem),
+		Arch:            lockArch(tb.Arch),
+		Version:         tb.Version,
+		Minimal:         tb.Minimal,
+		Tarball:         tb.Name,
+		Url:             tb.Url,
+		Checksum:        tb.Checksum,
+	}
+}
+
+// LockedTarball returns the description of a locked binary. The URL and checksum always come
+// from the lock, while the other details come from the tarball registry, when it still lists the tarball
+func LockedTarball(lb LockedBinary) TarballDescription {
+	tb, err := FindTarballByName(lb.Tarball)
+	if err != nil {
+		tb = TarballDescription{
+			Name:            lb.Tarball,
+			OperatingSystem: lb.OperatingSystem,
+			Arch:            lb.Arch,
+			Flavor:          lb.Flavor,
+			Minimal:         lb.Minimal,
+			ShortVersion:    lb.ShortVersion,
+			Version:         lb.Version,
+		}
+	}
+	tb.Url = lb.Url
+	tb.Checksum = lb.Checksum
+	return tb
+}
+
+// NewestTarball finds the newest tarball in the registry for a short version, flavor, and platform,
+// among the minimal or the full ones
+func NewestTarball(shortVersion, flavor, OS, arch string, minimal bool) (TarballDescription, error) {
+	var newest TarballDescription
+	found := false
+	for _, tb := range DefaultTarballRegistry.Tarballs {
+		if tb.ShortVersion != shortVersion || lockFlavor(tb.Flavor) != lockFlavor(flavor) ||
+			lockOS(tb.OperatingSystem) != lockOS(OS) || tb.Minimal != minimal {
+			continue
+		}
+		if tb.Arch != "" && lockArch(tb.Arch) != lockArch(arch) {
+			continue
+		}
+		if !found || versionLess(newest.Version, tb.Version) {
+			newest = tb
+			found = true
+		}
+	}
+	if !found {
+		minimalText := ""
+		if minimal {
+			minimalText = "minimal "
+		}
+		return newest, fmt.Errorf("no %starball found for version %s, flavor %s, OS %s, arch %s",
+			minimalText, shortVersion, lockFlavor(flavor), OS, arch)
+	}
+	return newest, nil
+}
+
+// LockedVersion returns the version that the project lock pins for a short version and flavor,
+// preferring the binary locked for the current platform, together with the name of the lock file.
+// When no lock applies, both are empty
+func LockedVersion(shortVersion, flavor string) (string, string, error) {
+	if !IsShortVersion(shortVersion) {
+		return "", "", nil
+	}
+	lock, lockFile, err := ProjectLock()
+	if err != nil || lockFile == "" {
+		return "", "", err
+	}
+	lb, found := lock.Find(shortVersion, flavor, runtime.GOOS, runtime.GOARCH)
+	if found {
+		return lb.Version, lockFile, nil
+	}
+	version, found := lock.PinnedVersion(shortVersion, flavor)
+	if !found {
+		return "", "", nil
+	}
+	return version, lockFile, nil
+}
@@ -0,0 +1,155 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestNewestTarball(t *testing.T) {
+	savedRegistry := DefaultTarballRegistry
+	defer func() { DefaultTarballRegistry = savedRegistry }()
+	macTarball := registryTarball("8.0.35", false)
+	macTarball.Name = "mysql-8.0.35-macos13-x86_64.tar.gz"
+	macTarball.OperatingSystem = "Darwin"
+	DefaultTarballRegistry = TarballCollection{Tarballs: []TarballDescription{
+		registryTarball("8.0.9", false),
+		registryTarball("8.0.31", false),
+		registryTarball("8.0.33", true),
+		registryTarball("8.0.30", false),
+		macTarball,
+	}}
+
+	var tests = []struct {
+		name    string
+		OS      string
+		arch    string
+		minimal bool
+		version string
+		wantErr bool
+	}{
+		{"full", "linux", "amd64", false, "8.0.31", false},
+		{"minimal", "Linux", "x86_64", true, "8.0.33", false},
+		{"other OS", "darwin", "amd64", false, "8.0.35", false},
+		{"macOS alias", "macos", "amd64", false, "8.0.35", false},
+		{"other arch", "linux", "arm64", false, "", true},
+		{"no minimal", "darwin", "amd64", true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb, err := NewestTarball("8.0", "", tt.OS, tt.arch, tt.minimal)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewestTarball error = %v, wantErr %v", err, tt.wantErr)
+			}
+			compare.OkEqualString("version", tb.Version, tt.version, t)
+		})
+	}
+}
+
+func TestBinaryLock(t *testing.T) {
+	savedRegistry := DefaultTarballRegistry
+	defer func() { DefaultTarballRegistry = savedRegistry }()
+	listed := registryTarball("8.0.31", false)
+	DefaultTarballRegistry = TarballCollection{Tarballs: []TarballDescription{listed}}
+
+	var lock BinaryLock
+	linux := LockTarball(listed)
+	compare.OkEqualString("OS", linux.OperatingSystem, "linux", t)
+	mac := linux
+	mac.OperatingSystem = "darwin"
+	mac.Version = "8.0.30"
+	mac.Tarball = "mysql-8.0.30-macos13-x86_64.tar.gz"
+	lock.Set(mac)
+	lock.Set(linux)
+	updated := linux
+	updated.Checksum = "SHA512:abcdef"
+	lock.Set(updated)
+	compare.OkEqualInt("locked binaries", len(lock.Binaries), 2, t)
+
+	var findTests = []struct {
+		shortVersion string
+		flavor       string
+		OS           string
+		arch         string
+		found        bool
+		version      string
+	}{
+		{"8.0", "", "Linux", "x86_64", true, "8.0.31"},
+		{"8.0", "mysql", "linux", "amd64", true, "8.0.31"},
+		{"8.0", "", "Darwin", "amd64", true, "8.0.30"},
+		{"8.0", "percona", "linux", "amd64", false, ""},
+		{"5.7", "", "linux", "amd64", false, ""},
+		{"8.0", "", "linux", "arm64", false, ""},
+	}
+	for _, ft := range findTests {
+		lb, found := lock.Find(ft.shortVersion, ft.flavor, ft.OS, ft.arch)
+		compare.OkEqualBool("found "+ft.shortVersion+" "+ft.flavor+" "+ft.OS+" "+ft.arch, found, ft.found, t)
+		compare.OkEqualString("version", lb.Version, ft.version, t)
+	}
+	pinned, found := lock.PinnedVersion("8.0", "")
+	compare.OkEqualBool("pinned version found", found, true, t)
+	compare.OkMatchesString("pinned version", pinned, `^8\.0\.3[01]$`, t)
+
+	lockFile := path.Join(t.TempDir(), LockFileName)
+	compare.OkIsNil("lock written", WriteLockFile(lockFile, lock), t)
+	saved, err := ReadLockFile(lockFile)
+	compare.OkIsNil("lock read", err, t)
+	compare.OkEqualInt("saved binaries", len(saved.Binaries), 2, t)
+	compare.OkEqualString("sorted by OS", saved.Binaries[0].OperatingSystem, "darwin", t)
+	compare.OkEqualString("saved checksum", saved.Binaries[1].Checksum, "SHA512:abcdef", t)
+
+	tb := LockedTarball(saved.Binaries[1])
+	compare.OkEqualString("checksum from the lock", tb.Checksum, "SHA512:abcdef", t)
+	compare.OkEqualInt("size from the registry", int(tb.Size), int(listed.Size), t)
+	tb = LockedTarball(saved.Binaries[0])
+	compare.OkEqualString("unlisted tarball", tb.Name, mac.Tarball, t)
+	compare.OkEqualString("unlisted tarball version", tb.Version, "8.0.30", t)
+
+	missing, err := ReadLockFile(path.Join(t.TempDir(), LockFileName))
+	compare.OkIsNil("missing lock file", err, t)
+	compare.OkEqualInt("binaries in missing lock file", len(missing.Binaries), 0, t)
+}
+
+func TestFindLockFile(t *testing.T) {
+	savedEnv, hadEnv := os.LookupEnv(LockFileEnvVar)
+	defer func() {
+		if hadEnv {
+			_ = os.Setenv(LockFileEnvVar, savedEnv)
+		} else {
+			_ = os.Unsetenv(LockFileEnvVar)
+		}
+	}()
+	_ = os.Unsetenv(LockFileEnvVar)
+
+	project := t.TempDir()
+	subDir := path.Join(project, "a", "b")
+	compare.OkIsNil("sub directory", os.MkdirAll(subDir, 0755), t)
+	compare.OkEqualString("no lock file", FindLockFile(subDir), "", t)
+
+	lockFile := path.Join(project, LockFileName)
+	compare.OkIsNil("lock file written", os.WriteFile(lockFile, []byte("{}"), 0644), t)
+	compare.OkEqualString("lock file in parent", FindLockFile(subDir), lockFile, t)
+	compare.OkEqualString("lock file in same directory", FindLockFile(project), lockFile, t)
+
+	_ = os.Setenv(LockFileEnvVar, "/some/other.lock")
+	compare.OkEqualString("lock file from environment", FindLockFile(subDir), "/some/other.lock", t)
+	_ = os.Setenv(LockFileEnvVar, "none")
+	compare.OkEqualString("lock disabled", FindLockFile(subDir), "", t)
+}
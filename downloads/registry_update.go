@@ -0,0 +1,341 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// RemovedTarballListName is the file, next to a tarball list, where a source
+// keeps the tarballs that are no longer available
+const RemovedTarballListName = "removed_tarball_list.json"
+
+// RegistryUpdater is the value of UpdatedBy for the tarballs merged by a registry update
+const RegistryUpdater = "update-registry"
+
+const registryChangelogName string = "tarball-registry-changelog.json"
+
+var RegistryChangelogFile string = path.Join(defaults.ConfigurationDir, registryChangelogName)
+
+// RegistrySource is a tarball list used to refresh the registry, together with
+// the list of the tarballs that the source has retired
+type RegistrySource struct {
+	Origin     string
+	Collection TarballCollection
+	Removed    TarballCollection
+}
+
+// ChangedTarball is a tarball that a source describes differently from the registry.
+// When the source has replaced a tarball with one of the same kind, Old and New have different names
+type ChangedTarball struct {
+	Old    TarballDescription
+	New    TarballDescription
+	Fields []string
+	Origin string
+}
+
+// RegistryDiff lists what a refresh from the sources would change in the registry
+type RegistryDiff struct {
+	Added   []TarballDescription
+	Changed []ChangedTarball
+	// Tarballs that a source moved into its removed list
+	Retired []TarballDescription
+	// Tarballs that no source knows about, such as the ones added locally. They are kept
+	Unlisted []TarballDescription
+}
+
+// IsEmpty tells whether merging the diff would leave the registry as it is
+func (diff RegistryDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Changed) == 0 && len(diff.Retired) == 0
+}
+
+// RegistryUpdate is an entry of the registry changelog
+type RegistryUpdate struct {
+	DateAdded string   `json:"date_added"`
+	UpdatedBy string   `json:"updated_by"`
+	Sources   []string `json:"sources"`
+	Added     []string `json:"added,omitempty"`
+	Changed   []string `json:"changed,omitempty"`
+	Retired   []string `json:"retired,omitempty"`
+	Before    int      `json:"tarballs_before"`
+	After     int      `json:"tarballs_after"`
+}
+
+// RegistrySourceList returns the sources configured in the defaults,
+// or the remote tarball list when there are none
+func RegistrySourceList() []string {
+	var sources []string
+	for _, source := range strings.Split(defaults.Defaults().RegistrySources, ",") {
+		source = strings.TrimSpace(source)
+		if source != "" {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		sources = append(sources, defaults.Defaults().RemoteTarballUrl)
+	}
+	return sources
+}
+
+// ReadRegistrySources gets the tarball lists, and their removed lists, from URLs or local files
+func ReadRegistrySources(sources []string) ([]RegistrySource, error) {
+	fetch := newPageFetcher(userAgents[OsLinux])
+	var result []RegistrySource
+	for _, source := range sources {
+		registrySource, err := readRegistrySource(fetch, source)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, registrySource)
+	}
+	return result, nil
+}
+
+func readSourceFile(fetch PageFetcher, source string) ([]byte, error) {
+	if common.IsUrl(source) {
+		return fetch(source)
+	}
+	return common.SlurpAsBytes(source)
+}
+
+func readRegistrySource(fetch PageFetcher, source string) (RegistrySource, error) {
+	registrySource := RegistrySource{Origin: source}
+	listName := source
+	// A URL without a JSON file is the address of a tarball cache server
+	if common.IsUrl(source) && !strings.HasSuffix(source, ".json") {
+		listName = strings.TrimSuffix(source, "/") + "/" + CacheIndexFile
+	}
+	text, err := readSourceFile(fetch, listName)
+	if err != nil {
+		return registrySource, fmt.Errorf("error reading tarball list from %s: %s", listName, err)
+	}
+	err = json.Unmarshal(text, &registrySource.Collection)
+	if err != nil {
+		return registrySource, fmt.Errorf("error decoding tarball list from %s: %s", listName, err)
+	}
+	err = TarballFileInfoValidation(registrySource.Collection)
+	if err != nil {
+		return registrySource, fmt.Errorf("error validating tarball list from %s: %s", listName, err)
+	}
+	// The removed list is optional: sources that never retired a tarball don't have one
+	removedName := listName[:len(listName)-len(path.Base(listName))] + RemovedTarballListName
+	text, err = readSourceFile(fetch, removedName)
+	if err == nil {
+		err = json.Unmarshal(text, &registrySource.Removed)
+		if err != nil {
+			return registrySource, fmt.Errorf("error decoding removed tarball list from %s: %s", removedName, err)
+		}
+	}
+	return registrySource, nil
+}
+
+// changedFields returns the fields that describe the tarball itself and differ between two descriptions.
+// Notes and dates are not compared
+func changedFields(oldTb, newTb TarballDescription) []string {
+	var fields []string
+	compareField := func(name string, oldValue, newValue interface{}) {
+		if oldValue != newValue {
+			fields = append(fields, name)
+		}
+	}
+	compareField("name", oldTb.Name, newTb.Name)
+	compareField("checksum", oldTb.Checksum, newTb.Checksum)
+	compareField("OS", oldTb.OperatingSystem, newTb.OperatingSystem)
+	compareField("arch", oldTb.Arch, newTb.Arch)
+	compareField("url", oldTb.Url, newTb.Url)
+	compareField("flavor", oldTb.Flavor, newTb.Flavor)
+	compareField("minimal", oldTb.Minimal, newTb.Minimal)
+	compareField("size", oldTb.Size, newTb.Size)
+	compareField("short_version", oldTb.ShortVersion, newTb.ShortVersion)
+	compareField("version", oldTb.Version, newTb.Version)
+	return fields
+}
+
+// DiffTarballRegistry compares the registry with the sources.
+// When more sources list the same tarball, the first one wins
+func DiffTarballRegistry(current TarballCollection, sources []RegistrySource) RegistryDiff {
+	var diff RegistryDiff
+	listed := make(map[string]TarballDescription)
+	origins := make(map[string]string)
+	var listedNames []string
+	retired := make(map[string]TarballDescription)
+	for _, source := range sources {
+		for _, tb := range source.Collection.Tarballs {
+			if _, seen := listed[tb.Name]; !seen {
+				listed[tb.Name] = tb
+				origins[tb.Name] = source.Origin
+				listedNames = append(listedNames, tb.Name)
+			}
+		}
+		for _, tb := range source.Removed.Tarballs {
+			if _, seen := retired[tb.Name]; !seen {
+				retired[tb.Name] = tb
+			}
+		}
+	}
+
+	currentNames := make(map[string]bool)
+	// Tarballs that the sources no longer list, by kind, so that a replacement can be recognized
+	gone := make(map[string]TarballDescription)
+	for _, tb := range current.Tarballs {
+		currentNames[tb.Name] = true
+		newTb, found := listed[tb.Name]
+		if found {
+			fields := changedFields(tb, newTb)
+			if len(fields) > 0 {
+				diff.Changed = append(diff.Changed, ChangedTarball{Old: tb, New: newTb, Fields: fields, Origin: origins[tb.Name]})
+			}
+			continue
+		}
+		gone[combinationKey(tb)] = tb
+	}
+	replaced := make(map[string]bool)
+	for _, name := range listedNames {
+		if currentNames[name] {
+			continue
+		}
+		newTb := listed[name]
+		oldTb, found := gone[combinationKey(newTb)]
+		if found && !replaced[oldTb.Name] {
+			replaced[oldTb.Name] = true
+			diff.Changed = append(diff.Changed, ChangedTarball{Old: oldTb, New: newTb, Fields: changedFields(oldTb, newTb), Origin: origins[name]})
+			continue
+		}
+		diff.Added = append(diff.Added, newTb)
+	}
+	for _, tb := range current.Tarballs {
+		if _, found := listed[tb.Name]; found || replaced[tb.Name] {
+			continue
+		}
+		removedTb, found := retired[tb.Name]
+		if found {
+			tb.DateRemoved = removedTb.DateRemoved
+			diff.Retired = append(diff.Retired, tb)
+		} else {
+			diff.Unlisted = append(diff.Unlisted, tb)
+		}
+	}
+	return diff
+}
+
+// ApplyRegistryDiff returns the registry with the changes of diff merged in.
+// Added and changed tarballs are marked as updated by RegistryUpdater, and get the current date
+// when the source does not say when they were added
+func ApplyRegistryDiff(current TarballCollection, diff RegistryDiff) TarballCollection {
+	now := time.Now().Format("2006-01-02 15:04")
+	stamp := func(tb TarballDescription) TarballDescription {
+		tb.UpdatedBy = RegistryUpdater
+		if tb.DateAdded == "" {
+			tb.DateAdded = now
+		}
+		return tb
+	}
+	dropped := make(map[string]bool)
+	replacements := make(map[string]TarballDescription)
+	for _, tb := range diff.Retired {
+		dropped[tb.Name] = true
+	}
+	for _, change := range diff.Changed {
+		replacements[change.Old.Name] = stamp(change.New)
+	}
+	result := current
+	result.Tarballs = nil
+	for _, tb := range current.Tarballs {
+		if dropped[tb.Name] {
+			continue
+		}
+		if newTb, found := replacements[tb.Name]; found {
+			tb = newTb
+		}
+		result.Tarballs = append(result.Tarballs, tb)
+	}
+	for _, tb := range diff.Added {
+		result.Tarballs = append(result.Tarballs, stamp(tb))
+	}
+	result.Tarballs = SortedTarballList(result.Tarballs, SORT_BY_ALL_FIELDS)
+	result.DbdeployerVersion = common.VersionDef
+	result.UpdatedOn = now
+	return result
+}
+
+// NewRegistryUpdate describes a merge for the changelog
+func NewRegistryUpdate(diff RegistryDiff, sources []RegistrySource, before, after int) RegistryUpdate {
+	update := RegistryUpdate{
+		DateAdded: time.Now().Format("2006-01-02 15:04"),
+		UpdatedBy: fmt.Sprintf("dbdeployer %s %s", common.VersionDef, RegistryUpdater),
+		Before:    before,
+		After:     after,
+	}
+	for _, source := range sources {
+		update.Sources = append(update.Sources, source.Origin)
+	}
+	for _, tb := range diff.Added {
+		update.Added = append(update.Added, tb.Name)
+	}
+	for _, change := range diff.Changed {
+		description := fmt.Sprintf("%s (%s)", change.New.Name, strings.Join(change.Fields, ","))
+		if change.Old.Name != change.New.Name {
+			description = fmt.Sprintf("%s => %s", change.Old.Name, change.New.Name)
+		}
+		update.Changed = append(update.Changed, description)
+	}
+	for _, tb := range diff.Retired {
+		update.Retired = append(update.Retired, tb.Name)
+	}
+	return update
+}
+
+// ReadRegistryChangelog returns the registry updates recorded so far
+func ReadRegistryChangelog() ([]RegistryUpdate, error) {
+	var changelog []RegistryUpdate
+	if !common.FileExists(RegistryChangelogFile) {
+		return changelog, nil
+	}
+	text, err := common.SlurpAsBytes(RegistryChangelogFile)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(text, &changelog)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", RegistryChangelogFile, err)
+	}
+	return changelog, nil
+}
+
+// AddToRegistryChangelog records a registry update
+func AddToRegistryChangelog(update RegistryUpdate) error {
+	changelog, err := ReadRegistryChangelog()
+	if err != nil {
+		return err
+	}
+	changelog = append(changelog, update)
+	text, err := json.MarshalIndent(changelog, " ", " ")
+	if err != nil {
+		return err
+	}
+	err = checkConfigurationDir()
+	if err != nil {
+		return err
+	}
+	return common.WriteString(string(text), RegistryChangelogFile)
+}
@@ -0,0 +1,230 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func registryTarball(version string, minimal bool) TarballDescription {
+	suffix := ""
+	if minimal {
+		suffix = "-minimal"
+	}
+	name := fmt.Sprintf("mysql-%s-linux-glibc2.17-x86_64%s.tar.xz", version, suffix)
+	return TarballDescription{
+		Name:            name,
+		Checksum:        "MD5:0123456789abcdef0123456789abcdef",
+		OperatingSystem: "Linux",
+		Arch:            "amd64",
+		Url:             "https://dev.mysql.com/get/Downloads/MySQL-8.0/" + name,
+		Flavor:          "mysql",
+		Minimal:         minimal,
+		Size:            1000,
+		ShortVersion:    "8.0",
+		Version:         version,
+		DateAdded:       "2023-01-01 10:00",
+	}
+}
+
+func tarballNames(tarballs []TarballDescription) []string {
+	var names []string
+	for _, tb := range tarballs {
+		names = append(names, tb.Name)
+	}
+	return names
+}
+
+func TestDiffTarballRegistry(t *testing.T) {
+	kept := registryTarball("8.0.31", false)
+	local := registryTarball("8.0.30", true)
+	retired := registryTarball("8.0.29", false)
+	changed := registryTarball("8.0.32", false)
+	replaced := registryTarball("8.0.33", false)
+	current := TarballCollection{Tarballs: []TarballDescription{kept, local, retired, changed, replaced}}
+
+	newChanged := changed
+	newChanged.Checksum = "SHA512:abcdef"
+	newChanged.Size = 2000
+	newChanged.Notes = "notes are not compared"
+	replacement := replaced
+	replacement.Name = "mysql-8.0.33-linux-glibc2.28-x86_64.tar.xz"
+	replacement.Url = "https://dev.mysql.com/get/Downloads/MySQL-8.0/" + replacement.Name
+	replacement.DateAdded = ""
+	added := registryTarball("8.0.34", false)
+	addedBySecond := registryTarball("8.0.34", true)
+	overridden := newChanged
+	overridden.Checksum = "SHA512:ignored"
+	removed := retired
+	removed.DateRemoved = "2023-06-01"
+
+	sources := []RegistrySource{
+		{
+			Origin:     "first",
+			Collection: TarballCollection{Tarballs: []TarballDescription{kept, newChanged, replacement, added}},
+			Removed:    TarballCollection{Tarballs: []TarballDescription{removed}},
+		},
+		{
+			Origin:     "second",
+			Collection: TarballCollection{Tarballs: []TarballDescription{overridden, addedBySecond}},
+		},
+	}
+	diff := DiffTarballRegistry(current, sources)
+
+	compare.OkEqualBool("diff is empty", diff.IsEmpty(), false, t)
+	if !reflect.DeepEqual(tarballNames(diff.Added), []string{added.Name, addedBySecond.Name}) {
+		t.Errorf("added: %v", tarballNames(diff.Added))
+	}
+	if !reflect.DeepEqual(tarballNames(diff.Retired), []string{retired.Name}) {
+		t.Errorf("retired: %v", tarballNames(diff.Retired))
+	}
+	compare.OkEqualString("date removed", diff.Retired[0].DateRemoved, "2023-06-01", t)
+	if !reflect.DeepEqual(tarballNames(diff.Unlisted), []string{local.Name}) {
+		t.Errorf("unlisted: %v", tarballNames(diff.Unlisted))
+	}
+	compare.OkEqualInt("changed", len(diff.Changed), 2, t)
+	expectedChanges := map[string]ChangedTarball{
+		changed.Name:  {Old: changed, New: newChanged, Fields: []string{"checksum", "size"}, Origin: "first"},
+		replaced.Name: {Old: replaced, New: replacement, Fields: []string{"name", "url"}, Origin: "first"},
+	}
+	for _, change := range diff.Changed {
+		if !reflect.DeepEqual(change, expectedChanges[change.Old.Name]) {
+			t.Errorf("change of %s: %+v - expected %+v", change.Old.Name, change, expectedChanges[change.Old.Name])
+		}
+	}
+
+	updated := ApplyRegistryDiff(current, diff)
+	compare.OkIsNil("updated registry is valid", TarballFileInfoValidation(updated), t)
+	compare.OkIsNil("updated registry has no duplicates", CheckTarballList(updated.Tarballs), t)
+	byName := make(map[string]TarballDescription)
+	for _, tb := range updated.Tarballs {
+		byName[tb.Name] = tb
+	}
+	compare.OkEqualInt("tarballs after update", len(updated.Tarballs), 6, t)
+	var stateTests = []struct {
+		name      string
+		present   bool
+		updatedBy string
+		checksum  string
+	}{
+		{kept.Name, true, "", kept.Checksum},
+		{local.Name, true, "", local.Checksum},
+		{retired.Name, false, "", ""},
+		{replaced.Name, false, "", ""},
+		{replacement.Name, true, RegistryUpdater, replacement.Checksum},
+		{changed.Name, true, RegistryUpdater, newChanged.Checksum},
+		{added.Name, true, RegistryUpdater, added.Checksum},
+		{addedBySecond.Name, true, RegistryUpdater, addedBySecond.Checksum},
+	}
+	for _, st := range stateTests {
+		tb, found := byName[st.name]
+		compare.OkEqualBool("present "+st.name, found, st.present, t)
+		if !found {
+			continue
+		}
+		compare.OkEqualString("updated by "+st.name, tb.UpdatedBy, st.updatedBy, t)
+		compare.OkEqualString("checksum "+st.name, tb.Checksum, st.checksum, t)
+		if tb.DateAdded == "" {
+			t.Errorf("tarball %s without date_added", st.name)
+		}
+	}
+	compare.OkEqualString("kept date", byName[added.Name].DateAdded, added.DateAdded, t)
+
+	update := NewRegistryUpdate(diff, sources, len(current.Tarballs), len(updated.Tarballs))
+	if !reflect.DeepEqual(update.Sources, []string{"first", "second"}) {
+		t.Errorf("sources in update: %v", update.Sources)
+	}
+	compare.OkEqualInt("changes in update", len(update.Changed), 2, t)
+	compare.OkEqualInt("tarballs before", update.Before, 5, t)
+	compare.OkEqualInt("tarballs after", update.After, 6, t)
+
+	upToDate := DiffTarballRegistry(updated, sources)
+	compare.OkEqualBool("no changes after update", upToDate.IsEmpty(), true, t)
+}
+
+func TestReadRegistrySource(t *testing.T) {
+	toJson := func(collection TarballCollection) []byte {
+		text, err := json.Marshal(collection)
+		compare.OkIsNil("collection encoded", err, t)
+		return text
+	}
+	listed := TarballCollection{DbdeployerVersion: "1.0.0", Tarballs: []TarballDescription{registryTarball("8.0.31", false)}}
+	removed := TarballCollection{DbdeployerVersion: "1.0.0", Tarballs: []TarballDescription{registryTarball("8.0.29", false)}}
+	pages := map[string][]byte{
+		"https://example.com/lists/tarball_list.json":         toJson(listed),
+		"https://example.com/lists/removed_tarball_list.json": toJson(removed),
+		"http://cache.example.com:8880/" + CacheIndexFile:     toJson(listed),
+		"https://example.com/broken/tarball_list.json":        []byte("{not json"),
+	}
+	fetch := func(pageUrl string) ([]byte, error) {
+		text, ok := pages[pageUrl]
+		if !ok {
+			return nil, fmt.Errorf("GET %s: received code 404", pageUrl)
+		}
+		return text, nil
+	}
+	var tests = []struct {
+		source  string
+		listed  int
+		removed int
+		wantErr bool
+	}{
+		{"https://example.com/lists/tarball_list.json", 1, 1, false},
+		{"http://cache.example.com:8880/", 1, 0, false},
+		{"https://example.com/broken/tarball_list.json", 0, 0, true},
+		{"https://example.com/missing/tarball_list.json", 0, 0, true},
+		{path.Join(t.TempDir(), "no-such-file.json"), 0, 0, true},
+	}
+	for _, tt := range tests {
+		source, err := readRegistrySource(fetch, tt.source)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readRegistrySource(%s) error = %v, wantErr %v", tt.source, err, tt.wantErr)
+			continue
+		}
+		compare.OkEqualString("origin", source.Origin, tt.source, t)
+		compare.OkEqualInt("listed in "+tt.source, len(source.Collection.Tarballs), tt.listed, t)
+		compare.OkEqualInt("removed in "+tt.source, len(source.Removed.Tarballs), tt.removed, t)
+	}
+}
+
+func TestRegistryChangelog(t *testing.T) {
+	savedChangelog := RegistryChangelogFile
+	defer func() { RegistryChangelogFile = savedChangelog }()
+	RegistryChangelogFile = path.Join(t.TempDir(), registryChangelogName)
+
+	changelog, err := ReadRegistryChangelog()
+	compare.OkIsNil("empty changelog", err, t)
+	compare.OkEqualInt("entries in empty changelog", len(changelog), 0, t)
+
+	updates := []RegistryUpdate{
+		{DateAdded: "2023-01-01 10:00", UpdatedBy: "test", Sources: []string{"a"}, Added: []string{"x"}, Before: 1, After: 2},
+		{DateAdded: "2023-02-01 10:00", UpdatedBy: "test", Sources: []string{"b"}, Retired: []string{"x"}, Before: 2, After: 1},
+	}
+	for _, update := range updates {
+		compare.OkIsNil("changelog entry added", AddToRegistryChangelog(update), t)
+	}
+	changelog, err = ReadRegistryChangelog()
+	compare.OkIsNil("changelog read", err, t)
+	if !reflect.DeepEqual(changelog, updates) {
+		t.Errorf("changelog %+v - expected %+v", changelog, updates)
+	}
+}
@@ -21,7 +21,6 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
@@ -82,6 +81,7 @@ type TarballDescription struct {
 	UpdatedBy       string `json:"updated_by,omitempty"`
 	Notes           string `json:"notes,omitempty"`
 	DateAdded       string `json:"date_added,omitempty"`
+	DateRemoved     string `json:"date_removed,omitempty"`
 }
 
 type TarballDescriptionByAll []TarballDescription
@@ -268,16 +268,11 @@ func CompareTarballChecksum(tarball TarballDescription, fileName string) error {
 	if tarball.Checksum == "" {
 		return nil
 	}
-	reCRC := regexp.MustCompile(`(MD5|SHA1|SHA256|SHA512)\s*:\s*(\S+)`)
-	crcList := reCRC.FindAllStringSubmatch(tarball.Checksum, -1)
-
-	if len(crcList) < 1 || len(crcList[0]) < 2 {
-		return fmt.Errorf("not a valid CRC pattern found. Expected: (MD5|SHA1|SHA256|SHA512):CHECKSUM_STRING")
+	crcType, crcText, err := common.ParseChecksum(tarball.Checksum)
+	if err != nil {
+		return err
 	}
 
-	crcType := crcList[0][1]
-	crcText := crcList[0][2]
-
 	if crcType == "" {
 		return fmt.Errorf("no CRC type detected in checksum field for %s", tarball.Name)
 	}
@@ -645,6 +640,11 @@ func checkRemoteUrl(remoteUrl string) (int64, error) {
 	return size, nil
 }
 
+// combinationKey identifies the kind of tarball: a list should have only one tarball for each key
+func combinationKey(tb TarballDescription) string {
+	return fmt.Sprintf("%s-%s-%s-%s-%v", tb.OperatingSystem, tb.Arch, tb.Flavor, tb.Version, tb.Minimal)
+}
+
 // CheckTarballList checks a list of tarballs returning an error
 // if there are duplicate names or OS+arch+Flavor+Version+Minimal
 // combinations
@@ -652,7 +652,7 @@ func CheckTarballList(tarballList []TarballDescription) error {
 	uniqueNames := make(map[string]bool)
 	uniqueCombinations := make(map[string]bool)
 	for _, tb := range tarballList {
-		key := fmt.Sprintf("%s-%s-%s-%s-%v", tb.OperatingSystem, tb.Arch, tb.Flavor, tb.Version, tb.Minimal)
+		key := combinationKey(tb)
 
 		// Makes sure that we don't have duplicate names in the list
 		_, seen := uniqueNames[tb.Name]
@@ -19,6 +19,7 @@ import (
 	"io"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -53,8 +54,23 @@ const (
 	TtMysql   TarballType = "mysql"
 	TtCluster TarballType = "cluster"
 	TtShell   TarballType = "shell"
+	TtPercona TarballType = "percona"
+	TtPxc     TarballType = "pxc"
+	TtMariaDB TarballType = "mariadb"
 )
 
+// PageFetcher retrieves the contents of a page from a vendor site
+type PageFetcher func(pageUrl string) ([]byte, error)
+
+// TarballSource finds the tarballs that a vendor publishes
+type TarballSource interface {
+	// Versions returns the short versions that the source can search
+	Versions() []string
+	// Tarballs returns the tarballs of the latest release of a short version
+	// for an operating system (OsLinux or OsMacOs), using fetch to retrieve the vendor pages
+	Tarballs(fetch PageFetcher, version, OS string) ([]TarballDescription, error)
+}
+
 var downloadsSettings = map[TarballType]TarballDef{
 	TtCluster: {
 		Flavor:      "ndb",
@@ -114,6 +130,31 @@ var osNormalize = map[string]string{
 	"Darwin": OsMacOs,
 }
 
+var tarballSources = map[TarballType]TarballSource{
+	TtMysql:   mysqlSource{downloadsSettings[TtMysql]},
+	TtCluster: mysqlSource{downloadsSettings[TtCluster]},
+	TtShell:   mysqlSource{downloadsSettings[TtShell]},
+	TtPercona: perconaServerSource,
+	TtPxc:     pxcSource,
+	TtMariaDB: mariaDbSource,
+}
+
+// RegisterTarballSource makes a tarball source available to GetRemoteTarballList,
+// replacing the one with the same tarball type
+func RegisterTarballSource(tarballType TarballType, source TarballSource) {
+	tarballSources[tarballType] = source
+}
+
+// TarballTypes returns the tarball types that GetRemoteTarballList can search
+func TarballTypes() []string {
+	var types []string
+	for tarballType := range tarballSources {
+		types = append(types, string(tarballType))
+	}
+	sort.Strings(types)
+	return types
+}
+
 func validateTarballRequest(tarballType TarballType, version string, OS string) (string, error) {
 	osText, ok := osNormalize[OS]
 	if !ok {
@@ -124,22 +165,23 @@ func validateTarballRequest(tarballType TarballType, version string, OS string)
 		return "", fmt.Errorf("unrecognized OS %s: it must be one of [%s, %s] ", OS, OsMacOs, OsLinux)
 	}
 
-	settings, foundSettings := downloadsSettings[tarballType]
-	if !foundSettings {
-		return "", fmt.Errorf("unrecognized tarball type %s: it must be one of [%s, %s, %s] ", OS, TtMysql, TtCluster, TtShell)
+	source, foundSource := tarballSources[tarballType]
+	if !foundSource {
+		return "", fmt.Errorf("unrecognized tarball type %s: it must be one of %v ", tarballType, TarballTypes())
 	}
 	acceptedVersion := false
-	for _, v := range settings.Versions {
+	for _, v := range source.Versions() {
 		if v == version {
 			acceptedVersion = true
 		}
 	}
 	if !acceptedVersion {
-		return "", fmt.Errorf("version '%s' is not accepted for tarball type %s: it must be one of [%v] ", version, tarballType, settings.Versions)
+		return "", fmt.Errorf("version '%s' is not accepted for tarball type %s: it must be one of %v ", version, tarballType, source.Versions())
 	}
 	return OS, nil
 }
 
+// GetRemoteTarballList searches the vendor site for the tarballs of a given type, short version, and operating system
 func GetRemoteTarballList(tarballType TarballType, version, OS string, withSize, alternativeUserAgent bool) ([]TarballDescription, error) {
 	var err error
 	OS, err = validateTarballRequest(tarballType, version, OS)
@@ -147,8 +189,6 @@ func GetRemoteTarballList(tarballType TarballType, version, OS string, withSize,
 		return nil, err
 	}
 
-	pageUrl := basePageUrl + "/" + downloadsSettings[tarballType].NameInUrl + "/" + version + ".html"
-
 	if alternativeUserAgent {
 		userAgents[OsLinux] = alternativeUserAgentLinux
 		userAgents[OsMacOs] = alternativeUserAgentMacOs
@@ -162,8 +202,71 @@ func GetRemoteTarballList(tarballType TarballType, version, OS string, withSize,
 		fmt.Printf("user agent: %s\n", userAgent)
 	}
 
+	result, err := tarballSources[tarballType].Tarballs(newPageFetcher(userAgent), version, OS)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no tarballs found")
+	}
+	if withSize {
+		for i := range result {
+			result[i].Size, _ = checkRemoteUrl(result[i].Url)
+		}
+	}
+	return result, nil
+}
+
+// newPageFetcher returns a PageFetcher that identifies itself with the given user agent
+func newPageFetcher(userAgent string) PageFetcher {
 	client := &http.Client{}
+	return func(pageUrl string) ([]byte, error) {
+		req, err := http.NewRequest(http.MethodGet, pageUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("User-Agent", userAgent)
+
+		response, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %s", pageUrl, err)
+		}
+		defer func(Body io.ReadCloser) {
+			err := Body.Close()
+			if err != nil {
+				fmt.Printf("[GetRemoteTarballList] error closing response body: %s", err)
+			}
+		}(response.Body)
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: received code %d", pageUrl, response.StatusCode)
+		}
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body: %s", err)
+		}
+		return body, nil
+	}
+}
+
+// mysqlSource finds the tarballs in the MySQL downloads site
+type mysqlSource struct {
+	settings TarballDef
+}
+
+func (ms mysqlSource) Versions() []string {
+	return ms.settings.Versions
+}
+
+func (ms mysqlSource) Tarballs(fetch PageFetcher, version, OS string) ([]TarballDescription, error) {
+	pageUrl := basePageUrl + "/" + ms.settings.NameInUrl + "/" + version + ".html"
+	body, err := fetch(pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	return ms.parsePage(body, version, OS)
+}
 
+func (ms mysqlSource) parsePage(body []byte, version, OS string) ([]TarballDescription, error) {
 	var matches [][]string
 	var result []TarballDescription
 	reVersions := regexp.MustCompile(`((\d+\.\d+)\.\d+)`)
@@ -182,29 +285,9 @@ func GetRemoteTarballList(tarballType TarballType, version, OS string, withSize,
 				`\s*` +                              // optional spaces
 				`(\w+)`))                            // Capture a string of alphanumeric characters (the checksum)
 	*/
-	reLine := regexp.MustCompile(fmt.Sprintf(`\((%s-\d+\.\d+\.\d+[^\)]+-(\S+64)[^\)]+z)\).*?MD5:\s*(\w+)`, downloadsSettings[tarballType].NameInFile))
-	req, _ := http.NewRequest(http.MethodGet, pageUrl, nil)
-	req.Header.Add("User-Agent", userAgent)
-
-	response, err := client.Do(req)
+	reLine := regexp.MustCompile(fmt.Sprintf(`\((%s-\d+\.\d+\.\d+[^\)]+-(\S+64)[^\)]+z)\).*?MD5:\s*(\w+)`, ms.settings.NameInFile))
 
-	if err != nil {
-		return nil, fmt.Errorf("GET %s: %s", pageUrl, err)
-	}
-
-	body, err := io.ReadAll(response.Body)
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Printf("[GetRemoteTarballList] error closing response body: %s", err)
-		}
-	}(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %s", err)
-	}
-	htmlText := string(body)
-
-	text, err := html2text.FromString(htmlText, html2text.Options{PrettyTables: true})
+	text, err := html2text.FromString(string(body), html2text.Options{PrettyTables: true})
 	if err != nil {
 		return nil, err
 	}
@@ -212,13 +295,12 @@ func GetRemoteTarballList(tarballType TarballType, version, OS string, withSize,
 	matches = reLine.FindAllStringSubmatch(text, -1)
 	if len(matches) == 0 {
 		if common.IsEnvSet("SBDEBUG") {
-			fmt.Printf("With user agent '%s'\n", userAgent)
 			fmt.Printf("Text retrieved from server: %s\n", basePageUrl)
 			fmt.Println(text)
 		} else {
-			return nil, fmt.Errorf("no %s tarballs found for %s - set environment variable 'SBDBUG' to see failure details", tarballType, version)
+			return nil, fmt.Errorf("no %s tarballs found for %s - set environment variable 'SBDBUG' to see failure details", ms.settings.NameInUrl, version)
 		}
-		return nil, fmt.Errorf("no %s tarballs found for %s", tarballType, version)
+		return nil, fmt.Errorf("no %s tarballs found for %s", ms.settings.NameInUrl, version)
 	}
 
 	for _, m := range matches {
@@ -236,20 +318,14 @@ func GetRemoteTarballList(tarballType TarballType, version, OS string, withSize,
 			Arch:            archNormalize(m[2]),
 			ShortVersion:    shortVersion,
 			Version:         longVersion,
-			Flavor:          downloadsSettings[tarballType].Flavor,
+			Flavor:          ms.settings.Flavor,
 			Minimal:         strings.Contains(m[1], "minimal"),
 			Url: baseDownloadUrl + "/" +
-				strings.Replace(downloadsSettings[tarballType].DownloadDir, "VERSION", version, 1) +
+				strings.Replace(ms.settings.DownloadDir, "VERSION", version, 1) +
 				"/" + m[1],
 		}
-		if withSize {
-			tbd.Size, _ = checkRemoteUrl(tbd.Url)
-		}
 		result = append(result, tbd)
 	}
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no tarballs found")
-	}
 	return result, nil
 }
 
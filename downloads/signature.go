@@ -0,0 +1,151 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// SignatureVerification is the outcome of checking a tarball against its detached signature.
+// It is saved in the directory where the tarball is unpacked
+type SignatureVerification struct {
+	Tarball    string `json:"tarball"`
+	Signature  string `json:"signature"`
+	Verified   bool   `json:"verified"`
+	KeyId      string `json:"key_id,omitempty"`
+	Signer     string `json:"signer,omitempty"`
+	Error      string `json:"error,omitempty"`
+	VerifiedOn string `json:"verified_on"`
+}
+
+// keyFileExtensions lists the files of a keys directory that are read as public keys
+var keyFileExtensions = map[string]bool{".asc": true, ".gpg": true, ".pub": true, ".key": true}
+
+// ReadKeyring collects the public keys stored in dir, either armored or binary.
+// A missing directory gives an empty keyring
+func ReadKeyring(dir string) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	if !common.DirExists(dir) {
+		return keyring, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !keyFileExtensions[strings.ToLower(path.Ext(entry.Name()))] {
+			continue
+		}
+		fileName := path.Join(dir, entry.Name())
+		text, err := os.ReadFile(fileName) // #nosec G304
+		if err != nil {
+			return nil, err
+		}
+		var keys openpgp.EntityList
+		if isArmored(text) {
+			keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(text))
+		} else {
+			keys, err = openpgp.ReadKeyRing(bytes.NewReader(text))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading keys from %s: %s", fileName, err)
+		}
+		keyring = append(keyring, keys...)
+	}
+	return keyring, nil
+}
+
+func isArmored(text []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(text), []byte("-----BEGIN PGP"))
+}
+
+// VerifyTarballSignature checks a tarball against a detached signature, armored or binary,
+// made by one of the keys in keyring.
+// The result is always returned: when the check fails, Verified is false and Error says why
+func VerifyTarballSignature(tarball, signature string, keyring openpgp.EntityList) SignatureVerification {
+	result := SignatureVerification{
+		Tarball:    common.BaseName(tarball),
+		Signature:  common.BaseName(signature),
+		VerifiedOn: time.Now().Format("2006-01-02 15:04"),
+	}
+	err := verifyTarballSignature(tarball, signature, keyring, &result)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Verified = true
+	return result
+}
+
+func verifyTarballSignature(tarball, signature string, keyring openpgp.EntityList, result *SignatureVerification) error {
+	if len(keyring) == 0 {
+		return fmt.Errorf("no public keys available. Add the vendor keys to %s", defaults.KeysDir)
+	}
+	signatureText, err := os.ReadFile(signature) // #nosec G304
+	if err != nil {
+		return err
+	}
+	signed, err := os.Open(tarball) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer signed.Close()
+	var signer *openpgp.Entity
+	if isArmored(signatureText) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keyring, signed, bytes.NewReader(signatureText), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keyring, signed, bytes.NewReader(signatureText), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("signature %s does not match %s: %s", result.Signature, result.Tarball, err)
+	}
+	result.KeyId = strings.ToUpper(signer.PrimaryKey.KeyIdString())
+	if identity := signer.PrimaryIdentity(); identity != nil {
+		result.Signer = identity.Name
+	}
+	return nil
+}
+
+// WriteSignatureVerification saves the result of a signature check into dir
+func WriteSignatureVerification(dir string, result SignatureVerification) error {
+	text, err := json.MarshalIndent(result, " ", " ")
+	if err != nil {
+		return err
+	}
+	return common.WriteString(string(text), path.Join(dir, globals.SignatureFileName))
+}
+
+// ReadSignatureVerification returns the result of the signature check saved in dir
+func ReadSignatureVerification(dir string) (SignatureVerification, error) {
+	var result SignatureVerification
+	text, err := common.SlurpAsBytes(path.Join(dir, globals.SignatureFileName))
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(text, &result)
+	return result, err
+}
@@ -0,0 +1,141 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func newTestSigner(t *testing.T, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", strings.ToLower(name)+"@example.com",
+		&packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	compare.OkIsNil("key created for "+name, err, t)
+	return entity
+}
+
+func writePublicKey(t *testing.T, entity *openpgp.Entity, fileName string, armored bool) {
+	var buf bytes.Buffer
+	if armored {
+		w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+		compare.OkIsNil("armor encoder", err, t)
+		compare.OkIsNil("public key serialized", entity.Serialize(w), t)
+		compare.OkIsNil("armor closed", w.Close(), t)
+	} else {
+		compare.OkIsNil("public key serialized", entity.Serialize(&buf), t)
+	}
+	compare.OkIsNil("public key written", os.WriteFile(fileName, buf.Bytes(), 0644), t)
+}
+
+func writeSignature(t *testing.T, entity *openpgp.Entity, contents, fileName string, armored bool) {
+	var buf bytes.Buffer
+	var err error
+	if armored {
+		err = openpgp.ArmoredDetachSign(&buf, entity, strings.NewReader(contents), nil)
+	} else {
+		err = openpgp.DetachSign(&buf, entity, strings.NewReader(contents), nil)
+	}
+	compare.OkIsNil("tarball signed", err, t)
+	compare.OkIsNil("signature written", os.WriteFile(fileName, buf.Bytes(), 0644), t)
+}
+
+func TestVerifyTarballSignature(t *testing.T) {
+	dir := t.TempDir()
+	keysDir := path.Join(dir, "keys")
+	compare.OkIsNil("keys directory", os.Mkdir(keysDir, 0755), t)
+
+	vendor := newTestSigner(t, "Vendor")
+	builder := newTestSigner(t, "Builder")
+	stranger := newTestSigner(t, "Stranger")
+	writePublicKey(t, vendor, path.Join(keysDir, "vendor.asc"), true)
+	writePublicKey(t, builder, path.Join(keysDir, "builder.gpg"), false)
+	compare.OkIsNil("other file written", os.WriteFile(path.Join(keysDir, "README"), []byte("not a key"), 0644), t)
+
+	keyring, err := ReadKeyring(keysDir)
+	compare.OkIsNil("keyring read", err, t)
+	compare.OkEqualInt("keys in keyring", len(keyring), 2, t)
+
+	emptyKeyring, err := ReadKeyring(path.Join(dir, "no-such-dir"))
+	compare.OkIsNil("missing keys directory", err, t)
+	compare.OkEqualInt("keys in missing directory", len(emptyKeyring), 0, t)
+
+	const contents = "mysql-8.0.99 tarball contents"
+	tarball := path.Join(dir, "mysql-8.0.99-linux-glibc2.17-x86_64.tar.xz")
+	compare.OkIsNil("tarball written", os.WriteFile(tarball, []byte(contents), 0644), t)
+	tampered := path.Join(dir, "tampered.tar.xz")
+	compare.OkIsNil("tampered tarball written", os.WriteFile(tampered, []byte(contents+"!"), 0644), t)
+
+	signatures := map[string]struct {
+		signer  *openpgp.Entity
+		armored bool
+	}{
+		"vendor.asc":   {vendor, true},
+		"builder.sig":  {builder, false},
+		"stranger.asc": {stranger, true},
+	}
+	for name, s := range signatures {
+		writeSignature(t, s.signer, contents, path.Join(dir, name), s.armored)
+	}
+
+	var tests = []struct {
+		name      string
+		tarball   string
+		signature string
+		keyring   openpgp.EntityList
+		verified  bool
+		signer    *openpgp.Entity
+		errorText string
+	}{
+		{"armored", tarball, "vendor.asc", keyring, true, vendor, ""},
+		{"binary", tarball, "builder.sig", keyring, true, builder, ""},
+		{"unknown key", tarball, "stranger.asc", keyring, false, nil, "does not match"},
+		{"tampered", tampered, "vendor.asc", keyring, false, nil, "does not match"},
+		{"no keys", tarball, "vendor.asc", emptyKeyring, false, nil, "no public keys"},
+		{"no signature", tarball, "missing.asc", keyring, false, nil, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := VerifyTarballSignature(tt.tarball, path.Join(dir, tt.signature), tt.keyring)
+			compare.OkEqualBool("verified", result.Verified, tt.verified, t)
+			compare.OkEqualString("tarball", result.Tarball, path.Base(tt.tarball), t)
+			compare.OkEqualString("signature", result.Signature, tt.signature, t)
+			if tt.verified {
+				compare.OkEqualString("error", result.Error, "", t)
+				compare.OkEqualString("key id", result.KeyId, strings.ToUpper(tt.signer.PrimaryKey.KeyIdString()), t)
+				compare.OkEqualString("signer", result.Signer, tt.signer.PrimaryIdentity().Name, t)
+			} else {
+				compare.OkMatchesString("error", result.Error, tt.errorText, t)
+				compare.OkEqualString("key id", result.KeyId, "", t)
+			}
+		})
+	}
+
+	result := VerifyTarballSignature(tarball, path.Join(dir, "vendor.asc"), keyring)
+	compare.OkIsNil("verification saved", WriteSignatureVerification(dir, result), t)
+	saved, err := ReadSignatureVerification(dir)
+	compare.OkIsNil("verification read", err, t)
+	if saved != result {
+		t.Errorf("saved verification %+v - expected %+v", saved, result)
+	}
+}
@@ -0,0 +1,222 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// CacheMetadataFile is the file, inside a tarball cache directory, that keeps the descriptions
+// of the cached tarballs, with their original URLs and checksums
+const CacheMetadataFile = "tarball_cache.json"
+
+// CacheIndexFile is the name under which a cache server publishes its tarball collection
+const CacheIndexFile = "tarball_list.json"
+
+// ReadCacheMetadata returns the tarball descriptions stored in a cache directory.
+// A directory without metadata gives an empty collection
+func ReadCacheMetadata(dir string) (TarballCollection, error) {
+	var collection TarballCollection
+	fileName := path.Join(dir, CacheMetadataFile)
+	if !common.FileExists(fileName) {
+		return collection, nil
+	}
+	text, err := common.SlurpAsBytes(fileName)
+	if err != nil {
+		return collection, err
+	}
+	err = json.Unmarshal(text, &collection)
+	if err != nil {
+		return collection, fmt.Errorf("error decoding %s: %s", fileName, err)
+	}
+	return collection, nil
+}
+
+// AddToCacheMetadata records the description of a tarball stored in a cache directory,
+// replacing a previous one with the same name
+func AddToCacheMetadata(dir string, tarball TarballDescription) error {
+	collection, err := ReadCacheMetadata(dir)
+	if err != nil {
+		return err
+	}
+	var tarballs []TarballDescription
+	for _, tb := range collection.Tarballs {
+		if tb.Name != tarball.Name {
+			tarballs = append(tarballs, tb)
+		}
+	}
+	collection.Tarballs = SortedTarballList(append(tarballs, tarball), SORT_BY_ALL_FIELDS)
+	collection.DbdeployerVersion = common.VersionDef
+	collection.UpdatedOn = time.Now().Format("2006-01-02 15:04")
+	text, err := json.MarshalIndent(collection, " ", " ")
+	if err != nil {
+		return err
+	}
+	return common.WriteString(string(text), path.Join(dir, CacheMetadataFile))
+}
+
+// describeCachedFile builds the description of a tarball for which the cache has no metadata,
+// using what the file name tells about it
+func describeCachedFile(fileName string) (TarballDescription, error) {
+	baseName := common.BaseName(fileName)
+	description := TarballDescription{
+		Minimal:   identifyMinimal(baseName),
+		Notes:     fmt.Sprintf("found in cache by version %s", common.VersionDef),
+		DateAdded: time.Now().Format("2006-01-02 15:04"),
+	}
+	if OS, err := identifyOS(baseName); err == nil {
+		description.OperatingSystem = OS
+	}
+	if arch, err := identifyArchitecture(baseName); err == nil {
+		description.Arch = arch
+	}
+	return GetTarballInfo(fileName, description)
+}
+
+type cachedDescription struct {
+	size        int64
+	modTime     time.Time
+	description TarballDescription
+}
+
+// CacheServer serves the tarballs of a cache directory over HTTP, together with
+// a tarball collection that lists them with URLs pointing to the server.
+// Any path ending with the name of a cached tarball gets the tarball, so that
+// the server can stand in for both remote-tarball-url and download-url
+type CacheServer struct {
+	Dir     string
+	BaseUrl string // Base of the URLs in the collection. When empty, it is taken from each request
+	mutex   sync.Mutex
+	// Descriptions of the tarballs without metadata, which are expensive to calculate
+	described map[string]cachedDescription
+}
+
+// NewCacheServer returns a server for the tarballs in dir
+func NewCacheServer(dir, baseUrl string) *CacheServer {
+	return &CacheServer{
+		Dir:       dir,
+		BaseUrl:   strings.TrimSuffix(baseUrl, "/"),
+		described: make(map[string]cachedDescription),
+	}
+}
+
+func (cs *CacheServer) describe(fileName string, info os.FileInfo) (TarballDescription, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	cached, found := cs.described[fileName]
+	if found && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.description, nil
+	}
+	description, err := describeCachedFile(fileName)
+	if err != nil {
+		return TarballDescription{}, err
+	}
+	cs.described[fileName] = cachedDescription{size: info.Size(), modTime: info.ModTime(), description: description}
+	return description, nil
+}
+
+// Collection returns the tarballs available in the cache, with their URLs starting with baseUrl
+func (cs *CacheServer) Collection(baseUrl string) (TarballCollection, error) {
+	metadata, err := ReadCacheMetadata(cs.Dir)
+	if err != nil {
+		return TarballCollection{}, err
+	}
+	byName := make(map[string]TarballDescription)
+	for _, tb := range metadata.Tarballs {
+		byName[tb.Name] = tb
+	}
+	entries, err := os.ReadDir(cs.Dir)
+	if err != nil {
+		return TarballCollection{}, err
+	}
+	collection := TarballCollection{
+		DbdeployerVersion: common.VersionDef,
+		UpdatedOn:         time.Now().Format("2006-01-02 15:04"),
+		Tarballs:          []TarballDescription{},
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !common.IsATarball(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return TarballCollection{}, err
+		}
+		description, found := byName[entry.Name()]
+		// A file that does not match its metadata is described again
+		if !found || (description.Size != 0 && description.Size != info.Size()) {
+			description, err = cs.describe(path.Join(cs.Dir, entry.Name()), info)
+			// Files that dbdeployer can't recognize are not published
+			if err != nil {
+				continue
+			}
+		}
+		description.Url = baseUrl + "/" + entry.Name()
+		collection.Tarballs = append(collection.Tarballs, description)
+	}
+	sort.Stable(TarballDescriptionByAll(collection.Tarballs))
+	return collection, nil
+}
+
+func (cs *CacheServer) baseUrl(r *http.Request) string {
+	if cs.BaseUrl != "" {
+		return cs.BaseUrl
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+func (cs *CacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	if r.URL.Path == "/" || name == CacheIndexFile {
+		collection, err := cs.Collection(cs.baseUrl(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		text, err := json.MarshalIndent(collection, " ", " ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(text)
+		return
+	}
+	fileName := path.Join(cs.Dir, name)
+	// Signatures are published next to their tarballs
+	tarballName := strings.TrimSuffix(name, globals.SignatureExt)
+	if !common.IsATarball(tarballName) || strings.HasPrefix(name, ".") || !common.FileExists(fileName) {
+		http.NotFound(w, r)
+		return
+	}
+	// ServeFile answers HEAD and Range requests, which resumable and parallel downloads need
+	http.ServeFile(w, r, fileName)
+}
@@ -0,0 +1,124 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downloads
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestCacheServer(t *testing.T) {
+	dir := t.TempDir()
+	mirrored := TarballDescription{
+		Name:            "mysql-8.0.98-linux-glibc2.17-x86_64-minimal.tar.xz",
+		Checksum:        "MD5:0123456789abcdef",
+		OperatingSystem: "Linux",
+		Arch:            "amd64",
+		Url:             "https://dev.mysql.com/get/Downloads/MySQL-8.0/mysql-8.0.98-linux-glibc2.17-x86_64-minimal.tar.xz",
+		Flavor:          "mysql",
+		Minimal:         true,
+		Size:            10,
+		ShortVersion:    "8.0",
+		Version:         "8.0.98",
+	}
+	found := "mysql-8.0.99-linux-glibc2.17-x86_64.tar.xz"
+	files := map[string]string{
+		mirrored.Name:       "0123456789",
+		found:               "abcdefghijklmnopqrstuvwxyz",
+		"not-a-tarball.txt": "text",
+		found + ".asc":      "signature",
+	}
+	for name, contents := range files {
+		err := os.WriteFile(path.Join(dir, name), []byte(contents), 0644)
+		compare.OkIsNil("file "+name+" written", err, t)
+	}
+	err := AddToCacheMetadata(dir, mirrored)
+	compare.OkIsNil("metadata added", err, t)
+
+	server := httptest.NewServer(NewCacheServer(dir, ""))
+	defer server.Close()
+
+	get := func(url string, header map[string]string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		compare.OkIsNil("request "+url, err, t)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		compare.OkIsNil("get "+url, err, t)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		compare.OkIsNil("read "+url, err, t)
+		return resp.StatusCode, body
+	}
+
+	for _, indexPath := range []string{"/", "/" + CacheIndexFile} {
+		code, body := get(server.URL+indexPath, nil)
+		compare.OkEqualInt("index status "+indexPath, code, http.StatusOK, t)
+		var collection TarballCollection
+		err = json.Unmarshal(body, &collection)
+		compare.OkIsNil("index decoded", err, t)
+		compare.OkIsNil("index validation", TarballFileInfoValidation(collection), t)
+		compare.OkEqualInt("tarballs in index", len(collection.Tarballs), 2, t)
+		for _, tb := range collection.Tarballs {
+			compare.OkEqualString("URL of "+tb.Name, tb.Url, server.URL+"/"+tb.Name, t)
+			switch tb.Name {
+			case mirrored.Name:
+				// The metadata from the mirror is kept intact
+				compare.OkEqualString("mirrored checksum", tb.Checksum, mirrored.Checksum, t)
+				compare.OkEqualString("mirrored arch", tb.Arch, mirrored.Arch, t)
+				compare.OkEqualBool("mirrored minimal", tb.Minimal, true, t)
+			case found:
+				compare.OkMatchesString("found checksum", tb.Checksum, `^SHA512:\w+`, t)
+				compare.OkEqualString("found version", tb.Version, "8.0.99", t)
+				compare.OkEqualString("found short version", tb.ShortVersion, "8.0", t)
+				compare.OkEqualString("found arch", tb.Arch, "x86_64", t)
+				compare.OkEqualInt("found size", int(tb.Size), len(files[found]), t)
+			default:
+				t.Errorf("unexpected tarball %s in index", tb.Name)
+			}
+		}
+	}
+
+	var fileTests = []struct {
+		urlPath  string
+		header   map[string]string
+		code     int
+		contents string
+	}{
+		{"/" + found, nil, http.StatusOK, files[found]},
+		{"/MySQL-8.0/" + found, nil, http.StatusOK, files[found]},
+		{"/" + found, map[string]string{"Range": "bytes=10-"}, http.StatusPartialContent, files[found][10:]},
+		{"/MySQL-8.0/" + found + ".asc", nil, http.StatusOK, "signature"},
+		{"/" + CacheMetadataFile, nil, http.StatusNotFound, ""},
+		{"/not-a-tarball.txt", nil, http.StatusNotFound, ""},
+		{"/missing-8.0.1.tar.gz", nil, http.StatusNotFound, ""},
+	}
+	for _, ft := range fileTests {
+		code, body := get(server.URL+ft.urlPath, ft.header)
+		compare.OkEqualInt("status "+ft.urlPath, code, ft.code, t)
+		if ft.contents != "" {
+			compare.OkEqualString("contents "+ft.urlPath, string(body), ft.contents, t)
+		}
+	}
+}
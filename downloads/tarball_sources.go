@@ -0,0 +1,248 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloads
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// listingSource finds the tarballs of a vendor that publishes its releases as directory listings:
+// a page with one directory for each release, and a directory with the tarballs of a release,
+// together with their checksums.
+type listingSource struct {
+	flavor   string
+	versions []string
+	// Listing of the releases. VERSION is replaced by the short version, and
+	// NODOTVERSION by the short version without dots
+	releasesUrl string
+	// Name of the directory of a release. The groups are the full version
+	// and the build number, which can be empty
+	reRelease *regexp.Regexp
+	// Path of the tarballs, relative to the release directory
+	tarballsDir string
+	// Name of a tarball. The groups are the full version, the architecture,
+	// and a non-empty string for minimal tarballs
+	reTarball *regexp.Regexp
+	// When set, the checksums of all the tarballs are in this file, inside the tarballs directory.
+	// Otherwise, each tarball has its checksum in a file with the same name plus checksumSuffix
+	checksumFile   string
+	checksumSuffix string
+	checksumType   string
+}
+
+var perconaServerSource = listingSource{
+	flavor:         common.PerconaServerFlavor,
+	versions:       []string{"5.7", "8.0"},
+	releasesUrl:    "https://downloads.percona.com/downloads/Percona-Server-VERSION/",
+	reRelease:      regexp.MustCompile(`^Percona-Server-(\d+\.\d+\.\d+)-([\d.]+)$`),
+	tarballsDir:    "binary/tarball/",
+	reTarball:      regexp.MustCompile(`^Percona-Server-(\d+\.\d+\.\d+)-[\d.]+-Linux\.(x86_64|aarch64)\.glibc[\d.]+(-minimal)?\.tar\.gz$`),
+	checksumSuffix: ".sha256sum",
+	checksumType:   "SHA256",
+}
+
+var pxcSource = listingSource{
+	flavor:         common.PxcFlavor,
+	versions:       []string{"5.7", "8.0"},
+	releasesUrl:    "https://downloads.percona.com/downloads/Percona-XtraDB-Cluster-NODOTVERSION/",
+	reRelease:      regexp.MustCompile(`^Percona-XtraDB-Cluster-(\d+\.\d+\.\d+)-([\d.]+)$`),
+	tarballsDir:    "binary/tarball/",
+	reTarball:      regexp.MustCompile(`^Percona-XtraDB-Cluster[-_](\d+\.\d+\.\d+)-[\w.-]+[._]Linux\.(x86_64|aarch64)\.glibc[\d.]+(-minimal)?\.tar\.gz$`),
+	checksumSuffix: ".sha256sum",
+	checksumType:   "SHA256",
+}
+
+// The MariaDB archive only publishes generic Linux tarballs for x86_64
+var mariaDbSource = listingSource{
+	flavor:       common.MariaDbFlavor,
+	versions:     []string{"10.4", "10.5", "10.6", "10.11", "11.4"},
+	releasesUrl:  "https://archive.mariadb.org/",
+	reRelease:    regexp.MustCompile(`^mariadb-(\d+\.\d+\.\d+)()$`),
+	tarballsDir:  "bintar-linux-systemd-x86_64/",
+	reTarball:    regexp.MustCompile(`^mariadb-(\d+\.\d+\.\d+)-linux-systemd-(x86_64)()\.tar\.gz$`),
+	checksumFile: "sha256sums.txt",
+	checksumType: "SHA256",
+}
+
+var reHref = regexp.MustCompile(`(?i)<a\s[^>]*href\s*=\s*"([^"]+)"`)
+
+// listingLinks returns the links of a directory listing, resolved against the page URL
+func listingLinks(page []byte, pageUrl string) ([]*url.URL, error) {
+	base, err := url.Parse(pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	var links []*url.URL
+	for _, m := range reHref.FindAllStringSubmatch(string(page), -1) {
+		link, err := url.Parse(m[1])
+		if err != nil {
+			continue
+		}
+		links = append(links, base.ResolveReference(link))
+	}
+	return links, nil
+}
+
+// linkName returns the last element of a link path, without the trailing slash of directories
+func linkName(link *url.URL) string {
+	return path.Base(strings.TrimSuffix(link.Path, "/"))
+}
+
+// parseChecksums reads a list of checksums in the format of sha256sum and md5sum,
+// and returns them by file name
+func parseChecksums(text string) map[string]string {
+	checksums := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := path.Base(strings.TrimPrefix(fields[1], "*"))
+		checksums[name] = strings.ToLower(fields[0])
+	}
+	return checksums
+}
+
+func (ls listingSource) Versions() []string {
+	return ls.versions
+}
+
+// latestRelease finds the directory of the newest release for a short version
+func (ls listingSource) latestRelease(page []byte, pageUrl, version string) (*url.URL, error) {
+	links, err := listingLinks(page, pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	var latest *url.URL
+	var latestVersion, latestBuild string
+	for _, link := range links {
+		m := ls.reRelease.FindStringSubmatch(linkName(link))
+		if len(m) < 3 || !strings.HasPrefix(m[1], version+".") {
+			continue
+		}
+		// For the same version, the release with the highest build number wins,
+		// regardless of its position in the listing
+		if latest == nil || versionLess(latestVersion, m[1]) ||
+			(m[1] == latestVersion && buildLess(latestBuild, m[2])) {
+			latest, latestVersion, latestBuild = link, m[1], m[2]
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no %s release found for version %s in %s", ls.flavor, version, pageUrl)
+	}
+	return latest, nil
+}
+
+// buildLess tells whether build number b1, such as "25" or "25.1", is lower than b2
+func buildLess(b1, b2 string) bool {
+	parts1 := strings.Split(b1, ".")
+	parts2 := strings.Split(b2, ".")
+	for i := 0; i < len(parts1) && i < len(parts2); i++ {
+		n1, _ := strconv.Atoi(parts1[i])
+		n2, _ := strconv.Atoi(parts2[i])
+		if n1 != n2 {
+			return n1 < n2
+		}
+	}
+	return len(parts1) < len(parts2)
+}
+
+func (ls listingSource) Tarballs(fetch PageFetcher, version, OS string) ([]TarballDescription, error) {
+	if OS != OsLinux {
+		return nil, fmt.Errorf("%s tarballs are only available for %s", ls.flavor, OsLinux)
+	}
+	releasesUrl := strings.NewReplacer(
+		"NODOTVERSION", strings.ReplaceAll(version, ".", ""),
+		"VERSION", version).Replace(ls.releasesUrl)
+	page, err := fetch(releasesUrl)
+	if err != nil {
+		return nil, err
+	}
+	release, err := ls.latestRelease(page, releasesUrl, version)
+	if err != nil {
+		return nil, err
+	}
+	releaseUrl := release.String()
+	if !strings.HasSuffix(releaseUrl, "/") {
+		releaseUrl += "/"
+	}
+	tarballsUrl := releaseUrl + ls.tarballsDir
+	page, err = fetch(tarballsUrl)
+	if err != nil {
+		return nil, err
+	}
+	return ls.parseTarballs(fetch, page, tarballsUrl, version)
+}
+
+// parseTarballs returns the tarballs listed in a release directory, with their checksums
+func (ls listingSource) parseTarballs(fetch PageFetcher, page []byte, tarballsUrl, version string) ([]TarballDescription, error) {
+	links, err := listingLinks(page, tarballsUrl)
+	if err != nil {
+		return nil, err
+	}
+	var checksums map[string]string
+	if ls.checksumFile != "" {
+		text, err := fetch(tarballsUrl + ls.checksumFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading checksums for %s: %s", tarballsUrl, err)
+		}
+		checksums = parseChecksums(string(text))
+	}
+	var result []TarballDescription
+	seen := make(map[string]bool)
+	for _, link := range links {
+		name := linkName(link)
+		m := ls.reTarball.FindStringSubmatch(name)
+		if len(m) < 4 || seen[name] {
+			continue
+		}
+		seen[name] = true
+		checksum := ""
+		if ls.checksumFile != "" {
+			checksum = checksums[name]
+		} else {
+			text, err := fetch(link.String() + ls.checksumSuffix)
+			if err != nil {
+				return nil, fmt.Errorf("error reading checksum for %s: %s", name, err)
+			}
+			checksum = parseChecksums(string(text))[name]
+		}
+		if checksum == "" {
+			return nil, fmt.Errorf("no checksum found for %s", name)
+		}
+		result = append(result, TarballDescription{
+			Name:            name,
+			Checksum:        ls.checksumType + ":" + checksum,
+			OperatingSystem: internalOsName[OsLinux],
+			Arch:            archNormalize(m[2]),
+			Url:             link.String(),
+			Flavor:          ls.flavor,
+			Minimal:         m[3] != "",
+			ShortVersion:    version,
+			Version:         m[1],
+		})
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no %s tarballs found in %s", ls.flavor, tarballsUrl)
+	}
+	return result, nil
+}
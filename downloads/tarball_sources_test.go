@@ -0,0 +1,236 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package downloads
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+const fixturesDir = "testdata/tarball_sources"
+
+// fixtureFetcher serves the pages of a vendor site from the files in fixturesDir
+func fixtureFetcher(pages map[string]string) PageFetcher {
+	return func(pageUrl string) ([]byte, error) {
+		fileName, ok := pages[pageUrl]
+		if !ok {
+			return nil, fmt.Errorf("GET %s: received code 404", pageUrl)
+		}
+		return os.ReadFile(path.Join(fixturesDir, fileName))
+	}
+}
+
+func TestTarballSources(t *testing.T) {
+	const (
+		perconaTarballs = "https://downloads.percona.com/downloads/Percona-Server-8.0/Percona-Server-8.0.34-26/binary/tarball/"
+		pxcTarballs     = "https://downloads.percona.com/downloads/Percona-XtraDB-Cluster-80/Percona-XtraDB-Cluster-8.0.33-25/binary/tarball/"
+		mariaDbTarballs = "https://archive.mariadb.org/mariadb-10.11.10/bintar-linux-systemd-x86_64/"
+		perconaMinimal  = "Percona-Server-8.0.34-26-Linux.x86_64.glibc2.17-minimal.tar.gz"
+		perconaFull     = "Percona-Server-8.0.34-26-Linux.x86_64.glibc2.17.tar.gz"
+		pxcMinimal      = "Percona-XtraDB-Cluster_8.0.33-25.1_Linux.x86_64.glibc2.17-minimal.tar.gz"
+		mariaDb         = "mariadb-10.11.10-linux-systemd-x86_64.tar.gz"
+	)
+	pages := map[string]string{
+		"https://dev.mysql.com/downloads/mysql/8.0.html":              "mysql-8.0.html",
+		"https://downloads.percona.com/downloads/Percona-Server-8.0/": "percona-server-8.0.html",
+		perconaTarballs: "percona-server-8.0.34-26-tarballs.html",
+		perconaTarballs + perconaMinimal + ".sha256sum":                      perconaMinimal + ".sha256sum",
+		perconaTarballs + perconaFull + ".sha256sum":                         perconaFull + ".sha256sum",
+		"https://downloads.percona.com/downloads/Percona-XtraDB-Cluster-80/": "pxc-80.html",
+		pxcTarballs:                             "pxc-8.0.33-25-tarballs.html",
+		pxcTarballs + pxcMinimal + ".sha256sum": pxcMinimal + ".sha256sum",
+		"https://archive.mariadb.org/":          "mariadb-archive.html",
+		mariaDbTarballs:                         "mariadb-10.11.10-bintar.html",
+		mariaDbTarballs + "sha256sums.txt":      "mariadb-10.11.10-sha256sums.txt",
+	}
+
+	var tests = []struct {
+		tarballType TarballType
+		version     string
+		OS          string
+		expected    []TarballDescription
+		wantErr     bool
+	}{
+		{
+			tarballType: TtMysql,
+			version:     "8.0",
+			OS:          OsLinux,
+			expected: []TarballDescription{
+				{
+					Name:            "mysql-8.0.34-linux-glibc2.17-x86_64.tar.xz",
+					Checksum:        "MD5:1ec9bb1e9b6a27b4a2b2a1ff2b0e6a0f",
+					OperatingSystem: "Linux",
+					Arch:            "amd64",
+					Url:             "https://dev.mysql.com/get/Downloads/MySQL-8.0/mysql-8.0.34-linux-glibc2.17-x86_64.tar.xz",
+					Flavor:          "mysql",
+					ShortVersion:    "8.0",
+					Version:         "8.0.34",
+				},
+				{
+					Name:            "mysql-8.0.34-linux-glibc2.17-x86_64-minimal.tar.xz",
+					Checksum:        "MD5:7e2d5d5b4d40bca4e4a0a7a3bd7c7a1c",
+					OperatingSystem: "Linux",
+					Arch:            "amd64",
+					Url:             "https://dev.mysql.com/get/Downloads/MySQL-8.0/mysql-8.0.34-linux-glibc2.17-x86_64-minimal.tar.xz",
+					Flavor:          "mysql",
+					Minimal:         true,
+					ShortVersion:    "8.0",
+					Version:         "8.0.34",
+				},
+			},
+		},
+		{
+			tarballType: TtPercona,
+			version:     "8.0",
+			OS:          OsLinux,
+			expected: []TarballDescription{
+				{
+					Name:            perconaMinimal,
+					Checksum:        "SHA256:5e1d7bb0a3b2b1f1c9bd0f0a33c1c7e9f3b6b7f5a2b3e6e3c1c1d0b3b9a2e7e1",
+					OperatingSystem: "Linux",
+					Arch:            "amd64",
+					Url:             perconaTarballs + perconaMinimal,
+					Flavor:          "percona",
+					Minimal:         true,
+					ShortVersion:    "8.0",
+					Version:         "8.0.34",
+				},
+				{
+					Name:            perconaFull,
+					Checksum:        "SHA256:9a8c1f8d52a5c0e3b6d7c1e2f4a9b0d3c6e5f8a7b2c1d0e9f8a7b6c5d4e3f2a1",
+					OperatingSystem: "Linux",
+					Arch:            "amd64",
+					Url:             perconaTarballs + perconaFull,
+					Flavor:          "percona",
+					ShortVersion:    "8.0",
+					Version:         "8.0.34",
+				},
+			},
+		},
+		{
+			tarballType: TtPxc,
+			version:     "8.0",
+			OS:          OsLinux,
+			expected: []TarballDescription{
+				{
+					Name:            pxcMinimal,
+					Checksum:        "SHA256:c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2",
+					OperatingSystem: "Linux",
+					Arch:            "amd64",
+					Url:             pxcTarballs + pxcMinimal,
+					Flavor:          "pxc",
+					Minimal:         true,
+					ShortVersion:    "8.0",
+					Version:         "8.0.33",
+				},
+			},
+		},
+		{
+			tarballType: TtMariaDB,
+			version:     "10.11",
+			OS:          OsLinux,
+			expected: []TarballDescription{
+				{
+					Name:            mariaDb,
+					Checksum:        "SHA256:0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0",
+					OperatingSystem: "Linux",
+					Arch:            "amd64",
+					Url:             mariaDbTarballs + mariaDb,
+					Flavor:          "mariadb",
+					ShortVersion:    "10.11",
+					Version:         "10.11.10",
+				},
+			},
+		},
+		// No release in the listing
+		{tarballType: TtMariaDB, version: "10.5", OS: OsLinux, wantErr: true},
+		// The release listing is not in the fixtures
+		{tarballType: TtMariaDB, version: "10.6", OS: OsLinux, wantErr: true},
+		{tarballType: TtPercona, version: "8.0", OS: OsMacOs, wantErr: true},
+		{tarballType: TtPxc, version: "5.7", OS: OsLinux, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%s-%s", tt.tarballType, tt.version, tt.OS), func(t *testing.T) {
+			source, ok := tarballSources[tt.tarballType]
+			if !ok {
+				t.Fatalf("no source for tarball type %s", tt.tarballType)
+			}
+			got, err := source.Tarballs(fixtureFetcher(pages), tt.version, tt.OS)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Tarballs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Tarballs() = %+v\nwant %+v", got, tt.expected)
+			}
+			if err != nil {
+				return
+			}
+			err = TarballFileInfoValidation(TarballCollection{DbdeployerVersion: "test", Tarballs: got})
+			if err != nil {
+				t.Errorf("tarballs not valid: %s", err)
+			}
+		})
+	}
+}
+
+func TestValidateTarballRequest(t *testing.T) {
+	var tests = []struct {
+		tarballType TarballType
+		version     string
+		OS          string
+		expectedOS  string
+		wantErr     bool
+	}{
+		{TtMysql, "8.0", "linux", OsLinux, false},
+		{TtPercona, "5.7", "Linux", OsLinux, false},
+		{TtPxc, "8.0", "linux", OsLinux, false},
+		{TtMariaDB, "10.11", "linux", OsLinux, false},
+		{TtMariaDB, "8.0", "linux", "", true},
+		{TarballType("unknown"), "8.0", "linux", "", true},
+		{TtShell, "8.0", "windows", "", true},
+	}
+	for _, tt := range tests {
+		OS, err := validateTarballRequest(tt.tarballType, tt.version, tt.OS)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateTarballRequest(%s, %s, %s) error = %v, wantErr %v", tt.tarballType, tt.version, tt.OS, err, tt.wantErr)
+		}
+		if OS != tt.expectedOS {
+			t.Errorf("validateTarballRequest(%s, %s, %s) = %s, want %s", tt.tarballType, tt.version, tt.OS, OS, tt.expectedOS)
+		}
+	}
+}
+
+func TestBuildLess(t *testing.T) {
+	var tests = []struct {
+		b1, b2   string
+		expected bool
+	}{
+		{"25", "26", true},
+		{"26", "25", false},
+		{"25", "25", false},
+		{"25", "25.1", true},
+		{"25.1", "25", false},
+		{"9", "10", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if result := buildLess(tt.b1, tt.b2); result != tt.expected {
+			t.Errorf("buildLess(%q, %q) = %v, want %v", tt.b1, tt.b2, result, tt.expected)
+		}
+	}
+}
@@ -24,9 +24,11 @@ var UsingDbDeployer = false
 
 const (
 	// Sandbox types
-	SbTypeSingle         = "single"
-	SbTypeMultiple       = "multiple"
-	SbTypeSingleImported = "single-imported"
+	SbTypeSingle           = "single"
+	SbTypeMultiple         = "multiple"
+	SbTypeSingleImported   = "single-imported"
+	SbTypeReplImported     = "replication-imported"
+	SbTypeReplImportedNode = SbTypeReplImported + "-node"
 
 	// Instantiated in cmd/root.go
 	ConfigLabel        = "config"
@@ -91,6 +93,7 @@ const (
 	PreGrantsSqlFileLabel     = "pre-grants-sql-file"
 	PreGrantsSqlLabel         = "pre-grants-sql"
 	RawLabel                  = "raw"
+	ReconcileLabel            = "reconcile"
 	RemoteAccessLabel         = "remote-access"
 	RemoteAccessValue         = "127.%"
 	ReplCrashSafeLabel        = "repl-crash-safe"
@@ -111,6 +114,9 @@ const (
 	SocketInDatadirLabel      = "socket-in-datadir"
 	PortAsServerIdLabel       = "port-as-server-id"
 
+	// Instantiated in cmd/plan.go
+	FromSandboxLabel = "from-sandbox"
+
 	// Instantiated in cmd/single.go
 	MasterLabel    = "master"
 	ServerIdLabel  = "server-id"
@@ -145,6 +151,34 @@ const (
 	DeleteAfterUnpackLabel = "delete-after-unpack"
 	MaxItemsLabel          = "max-items"
 	ChangeUserAgentLabel   = "change-user-agent"
+	ParallelPartsLabel     = "parallel-parts"
+
+	// Instantiated in cmd/downloads_cache.go
+	CacheDirLabel = "dir"
+	BaseUrlLabel  = "base-url"
+
+	// Instantiated in cmd/downloads_registry.go
+	ShowChangelogLabel = "show-changelog"
+
+	// Instantiated in cmd/lock.go
+	LockFileLabel = "lock-file"
+
+	// Instantiated in cmd/unpack.go and cmd/downloads_minimize.go
+	MakeMinimalLabel  = "make-minimal"
+	StripSymbolsLabel = "strip-symbols"
+	PackDirLabel      = "pack-dir"
+
+	// Instantiated in cmd/unpack.go and cmd/deploy.go
+	FromSystemLabel        = "from-system"
+	BasedirFromSystemLabel = "basedir-from-system"
+	SystemRootLabel        = "system-root"
+	SystemBinariesFileName = "system-binaries.json"
+	SystemBinariesPrefix   = "system"
+
+	// Instantiated in cmd/delete_binaries.go
+	UnusedLabel     = "unused"
+	OlderThanLabel  = "older-than"
+	KeepLatestLabel = "keep-latest-per-short-version"
 
 	// Instantiated in cmd/admin.go
 	VerboseLabel = "verbose"
@@ -183,6 +217,9 @@ const (
 	TarExt             = ".tar"
 	TarGzExt           = ".tar.gz"
 	TarXzExt           = ".tar.xz"
+	TarZstExt          = ".tar.zst"
+	RpmExt             = ".rpm"
+	DebExt             = ".deb"
 	TargetServerLabel  = "target-server"
 	TgzExt             = ".tgz"
 	ZipExt             = ".zip"
@@ -196,6 +233,10 @@ const (
 	ShortVersionLabel  = "short-version"
 	FlavorFileName     = "FLAVOR"
 
+	SignatureExt          = ".asc"
+	SignatureFileName     = "signature.json"
+	RequireSignatureLabel = "require-signature"
+
 	// Instantiated in cmd/use.go
 	RunLabel = "run"
 	LsLabel  = "ls"
@@ -216,6 +257,21 @@ const (
 	OldestLabel    = "oldest"
 	LocalHostIP    = "127.0.0.1"
 
+	// Instantiated in cmd/output.go
+	OutputLabel = "output"
+	OutputTable = "table"
+	OutputJson  = "json"
+	OutputYaml  = "yaml"
+
+	// Instantiated in cmd/admin_ports.go
+	AllLabel = "all"
+
+	// Instantiated in cmd/admin_health.go
+	MaxLagLabel = "max-lag"
+
+	// Instantiated in cmd/admin_switchover.go
+	NewMasterLabel = "new-master"
+
 	// Instantiated in cmd/templates.go
 	SimpleLabel       = "simple"
 	WithContentsLabel = "with-contents"
@@ -231,6 +287,24 @@ const (
 	// Instantiated in cmd/versions.go
 	ByFlavorLabel = "by-flavor"
 
+	// Instantiated in cmd/data_load.go
+	VerifyLabel    = "verify"
+	SchemaLabel    = "schema"
+	RowsLabel      = "rows"
+	SeedLabel      = "seed"
+	BatchSizeLabel = "batch-size"
+	ThreadsLabel   = "threads"
+	FormatLabel    = "format"
+	DatabaseLabel  = "database"
+	DdlFileLabel   = "ddl-file"
+	CsvHeaderLabel = "csv-header"
+	DescLabel      = "description"
+
+	// Instantiated in cmd/import.go
+	LoginPathLabel   = "login-path"
+	LoginFileLabel   = "login-file"
+	PasswordEnvLabel = "password-env"
+
 	// Instantiated in cmd/export.go
 
 	ForceOutputToTermLabel       = "force-output-to-terminal"
@@ -422,7 +496,9 @@ const (
 	lineLength             = 80
 	PublicDirectoryAttr    = 0755
 	ExecutableFileAttr     = 0744
+	PrivateFileAttr        = 0600
 	SandboxDescriptionName = "sbdescription.json"
+	DataLoadRecordName     = "data_load.json"
 	ForbiddenDirName       = "lost+found"
 
 	// File names found in tarballs
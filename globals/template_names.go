@@ -36,6 +36,9 @@ const (
 	TmplImportStart        = "import_start"
 	TmplImportAddOption    = "import_add_option"
 	TmplImportMysqlsh      = "import_mysqlsh"
+	TmplImportUseAll       = "import_use_all"
+	TmplImportStatusAll    = "import_status_all"
+	TmplImportCheckSlaves  = "import_check_slaves"
 
 	// multiple
 	TmplRestartMulti       = "restart_multi"
@@ -0,0 +1,142 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importing
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LoginPath contains the credentials stored by mysql_config_editor for one login path
+type LoginPath struct {
+	User     string
+	Password string
+	Host     string
+	Port     int
+}
+
+const (
+	loginFileUnusedSize = 4
+	loginFileKeySize    = 20
+)
+
+// decryptLoginFile decodes the contents of a .mylogin.cnf file.
+// The file starts with 4 unused bytes and a 20 bytes key, followed by lines
+// encrypted with AES-128-ECB, each one preceded by its length.
+func decryptLoginFile(data []byte) (string, error) {
+	headerSize := loginFileUnusedSize + loginFileKeySize
+	if len(data) < headerSize {
+		return "", fmt.Errorf("login file is too short")
+	}
+	var key [aes.BlockSize]byte
+	for i, b := range data[loginFileUnusedSize:headerSize] {
+		key[i%aes.BlockSize] ^= b
+	}
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return "", err
+	}
+	var text bytes.Buffer
+	data = data[headerSize:]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return "", fmt.Errorf("truncated login file")
+		}
+		size := int(binary.LittleEndian.Uint32(data[:4]))
+		data = data[4:]
+		if size == 0 || size > len(data) || size%aes.BlockSize != 0 {
+			return "", fmt.Errorf("invalid line size %d in login file", size)
+		}
+		line := make([]byte, size)
+		for start := 0; start < size; start += aes.BlockSize {
+			block.Decrypt(line[start:start+aes.BlockSize], data[start:start+aes.BlockSize])
+		}
+		data = data[size:]
+		// Lines are padded with PKCS#7
+		padding := int(line[size-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return "", fmt.Errorf("invalid padding in login file")
+		}
+		text.Write(line[:size-padding])
+	}
+	return text.String(), nil
+}
+
+// parseLoginPath extracts the credentials of a login path from the decoded login file
+func parseLoginPath(text, loginPath string) (LoginPath, error) {
+	var result LoginPath
+	reHeader := regexp.MustCompile(`^\s*\[([^\]]+)\]`)
+	reOption := regexp.MustCompile(`^\s*([\w-]+)\s*=\s*(.*?)\s*$`)
+	found := false
+	inSection := false
+	for _, line := range strings.Split(text, "\n") {
+		header := reHeader.FindStringSubmatch(line)
+		if len(header) > 0 {
+			inSection = header[1] == loginPath
+			found = found || inSection
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		option := reOption.FindStringSubmatch(line)
+		if len(option) == 0 {
+			continue
+		}
+		value := option[2]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		switch option[1] {
+		case "user":
+			result.User = value
+		case "password":
+			result.Password = value
+		case "host":
+			result.Host = value
+		case "port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return LoginPath{}, fmt.Errorf("invalid port '%s' in login path %s", value, loginPath)
+			}
+			result.Port = port
+		}
+	}
+	if !found {
+		return LoginPath{}, fmt.Errorf("login path '%s' not found", loginPath)
+	}
+	return result, nil
+}
+
+// ReadLoginPath returns the credentials of a login path created with mysql_config_editor
+func ReadLoginPath(fileName, loginPath string) (LoginPath, error) {
+	// #nosec G304
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return LoginPath{}, fmt.Errorf("error reading login file %s: %s", fileName, err)
+	}
+	text, err := decryptLoginFile(data)
+	if err != nil {
+		return LoginPath{}, fmt.Errorf("error decoding login file %s: %s", fileName, err)
+	}
+	return parseLoginPath(text, loginPath)
+}
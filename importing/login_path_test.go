@@ -0,0 +1,97 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importing
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+)
+
+// encryptLoginFile produces a file with the same format used by mysql_config_editor
+func encryptLoginFile(t *testing.T, lines []string) []byte {
+	fileKey := []byte("0123456789abcdefghij")
+	var key [aes.BlockSize]byte
+	for i, b := range fileKey {
+		key[i%aes.BlockSize] ^= b
+	}
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		t.Fatalf("error creating cipher: %s", err)
+	}
+	var data bytes.Buffer
+	data.Write([]byte{0, 0, 0, 0})
+	data.Write(fileKey)
+	for _, line := range lines {
+		plain := []byte(line + "\n")
+		padding := aes.BlockSize - len(plain)%aes.BlockSize
+		plain = append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+		encrypted := make([]byte, len(plain))
+		for start := 0; start < len(plain); start += aes.BlockSize {
+			block.Encrypt(encrypted[start:start+aes.BlockSize], plain[start:start+aes.BlockSize])
+		}
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(encrypted)))
+		data.Write(size)
+		data.Write(encrypted)
+	}
+	return data.Bytes()
+}
+
+func TestReadLoginPath(t *testing.T) {
+	fileName := path.Join(t.TempDir(), ".mylogin.cnf")
+	contents := encryptLoginFile(t, []string{
+		"[client]",
+		`user = "root"`,
+		"[staging]",
+		`user = "admin"`,
+		`password = "s3cr3t with spaces"`,
+		`host = "db1.example.com"`,
+		"port = 3307",
+	})
+	err := os.WriteFile(fileName, contents, 0600)
+	if err != nil {
+		t.Fatalf("error writing login file: %s", err)
+	}
+	var testData = []struct {
+		loginPath  string
+		expected   LoginPath
+		isErrorful bool
+	}{
+		{"staging", LoginPath{User: "admin", Password: "s3cr3t with spaces", Host: "db1.example.com", Port: 3307}, false},
+		{"client", LoginPath{User: "root"}, false},
+		{"production", LoginPath{}, true},
+	}
+	for _, td := range testData {
+		t.Run(td.loginPath, func(t *testing.T) {
+			loginPath, err := ReadLoginPath(fileName, td.loginPath)
+			if (err != nil) != td.isErrorful {
+				t.Fatalf("expected error: %v - got %v", td.isErrorful, err)
+			}
+			if loginPath != td.expected {
+				t.Errorf("expected %+v - got %+v", td.expected, loginPath)
+			}
+		})
+	}
+
+	_, err = decryptLoginFile(contents[:30])
+	if err == nil {
+		t.Errorf("expected error for truncated file")
+	}
+}
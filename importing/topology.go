@@ -0,0 +1,276 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importing
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+const (
+	RoleSource  = "source"
+	RoleReplica = "replica"
+)
+
+// ServerNode is a server found while exploring a replication topology
+type ServerNode struct {
+	Host string
+	Port int
+	Role string
+}
+
+// Topology describes the servers that replicate with each other.
+// The first node is always a source (or a group primary)
+type Topology struct {
+	Type  string
+	Nodes []ServerNode
+}
+
+func (node ServerNode) Address() string {
+	return fmt.Sprintf("%s:%d", node.Host, node.Port)
+}
+
+// queryRows runs a query and returns every row as a map of lowercase column names to values
+func (db *DB) queryRows(query string) ([]map[string]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string)
+		for i, column := range columns {
+			row[strings.ToLower(column)] = values[i].String
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// queryFirstAvailable runs the first query that the server accepts.
+// It is used for statements that were renamed in recent versions, such as SHOW REPLICAS
+func (db *DB) queryFirstAvailable(queries ...string) ([]map[string]string, error) {
+	var err error
+	for _, query := range queries {
+		var rows []map[string]string
+		rows, err = db.queryRows(query)
+		if err == nil {
+			return rows, nil
+		}
+	}
+	return nil, err
+}
+
+// firstValue returns the value of the first column in names that is set in row
+func firstValue(row map[string]string, names ...string) string {
+	for _, name := range names {
+		if value, ok := row[name]; ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// groupNodes converts the rows of performance_schema.replication_group_members
+// into nodes, with the primary members first
+func groupNodes(rows []map[string]string) ([]ServerNode, error) {
+	var nodes []ServerNode
+	for _, row := range rows {
+		port, err := strconv.Atoi(row["member_port"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s' for group member %s", row["member_port"], row["member_host"])
+		}
+		role := RoleReplica
+		// Before 8.0.2 there is no MEMBER_ROLE column, and all members are writable
+		if row["member_role"] == "" || strings.EqualFold(row["member_role"], "PRIMARY") {
+			role = RoleSource
+		}
+		nodes = append(nodes, ServerNode{Host: row["member_host"], Port: port, Role: role})
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Role == RoleSource && nodes[j].Role != RoleSource
+	})
+	return nodes, nil
+}
+
+// replicaNodes converts the output of SHOW REPLICAS (or SHOW SLAVE HOSTS) into nodes.
+// Replicas that don't set report_host are listed without a host, and are assumed to run
+// in the same host as their source
+func replicaNodes(rows []map[string]string, sourceHost string) ([]ServerNode, error) {
+	var nodes []ServerNode
+	for _, row := range rows {
+		port, err := strconv.Atoi(row["port"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s' for replica %s", row["port"], row["host"])
+		}
+		host := row["host"]
+		if host == "" {
+			host = sourceHost
+		}
+		nodes = append(nodes, ServerNode{Host: host, Port: port, Role: RoleReplica})
+	}
+	return nodes, nil
+}
+
+// sourceNode gets the source of a replica from the output of SHOW REPLICA STATUS (or SHOW SLAVE STATUS).
+// It returns false when the server is not a replica
+func sourceNode(rows []map[string]string) (ServerNode, bool, error) {
+	if len(rows) == 0 {
+		return ServerNode{}, false, nil
+	}
+	host := firstValue(rows[0], "source_host", "master_host")
+	strPort := firstValue(rows[0], "source_port", "master_port")
+	if host == "" {
+		return ServerNode{}, false, nil
+	}
+	port, err := strconv.Atoi(strPort)
+	if err != nil {
+		return ServerNode{}, false, fmt.Errorf("invalid source port '%s' for source %s", strPort, host)
+	}
+	return ServerNode{Host: host, Port: port, Role: RoleSource}, true, nil
+}
+
+func connectNode(node ServerNode, user, password string) (*DB, error) {
+	db, err := Connect(ParamsToConfig(node.Host, user, password, node.Port))
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("error connecting to server %s - %s", node.Address(), err)
+	}
+	return db, nil
+}
+
+func getGroupMembers(node ServerNode, user, password string) ([]ServerNode, error) {
+	db, err := connectNode(node, user, password)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.queryFirstAvailable(
+		"SELECT MEMBER_HOST, MEMBER_PORT, MEMBER_ROLE FROM performance_schema.replication_group_members WHERE MEMBER_STATE = 'ONLINE'",
+		"SELECT MEMBER_HOST, MEMBER_PORT FROM performance_schema.replication_group_members WHERE MEMBER_STATE = 'ONLINE'")
+	if err != nil {
+		// Servers without group replication support don't have the table
+		return nil, nil
+	}
+	return groupNodes(rows)
+}
+
+func getSource(node ServerNode, user, password string) (ServerNode, bool, error) {
+	db, err := connectNode(node, user, password)
+	if err != nil {
+		return ServerNode{}, false, err
+	}
+	defer db.Close()
+	rows, err := db.queryFirstAvailable("SHOW REPLICA STATUS", "SHOW SLAVE STATUS")
+	if err != nil {
+		return ServerNode{}, false, fmt.Errorf("error getting replication status from %s - %s", node.Address(), err)
+	}
+	return sourceNode(rows)
+}
+
+func getReplicas(node ServerNode, user, password string) ([]ServerNode, error) {
+	db, err := connectNode(node, user, password)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.queryFirstAvailable("SHOW REPLICAS", "SHOW SLAVE HOSTS")
+	if err != nil {
+		return nil, fmt.Errorf("error getting replicas from %s - %s", node.Address(), err)
+	}
+	return replicaNodes(rows, node.Host)
+}
+
+// DiscoverTopology finds the servers that replicate with the seed node.
+// If the seed is a group replication member, the topology is made of the online members.
+// Otherwise, the sources of the seed are followed up to the top server, and then
+// all the replicas below it are collected.
+func DiscoverTopology(host string, port int, user, password string) (Topology, error) {
+	seed := ServerNode{Host: host, Port: port}
+	members, err := getGroupMembers(seed, user, password)
+	if err != nil {
+		return Topology{}, err
+	}
+	if len(members) > 0 {
+		if len(members) < 2 {
+			return Topology{}, fmt.Errorf("group replication at %s has only one online member", seed.Address())
+		}
+		return Topology{Type: globals.GroupLabel, Nodes: members}, nil
+	}
+
+	top := seed
+	visited := map[string]bool{top.Address(): true}
+	for {
+		source, isReplica, err := getSource(top, user, password)
+		if err != nil {
+			return Topology{}, err
+		}
+		// A circular topology has no top server: we start from the last one found
+		if !isReplica || visited[source.Address()] {
+			break
+		}
+		visited[source.Address()] = true
+		top = source
+	}
+
+	top.Role = RoleSource
+	nodes := []ServerNode{top}
+	seen := map[string]bool{top.Address(): true}
+	queue := []ServerNode{top}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		replicas, err := getReplicas(current, user, password)
+		if err != nil {
+			return Topology{}, err
+		}
+		for _, replica := range replicas {
+			if seen[replica.Address()] {
+				continue
+			}
+			seen[replica.Address()] = true
+			nodes = append(nodes, replica)
+			queue = append(queue, replica)
+		}
+	}
+	if len(nodes) < 2 {
+		return Topology{}, fmt.Errorf("server %s has no replicas and is not a group replication member", seed.Address())
+	}
+	return Topology{Type: globals.MasterSlaveLabel, Nodes: nodes}, nil
+}
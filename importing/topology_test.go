@@ -0,0 +1,99 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package importing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupNodes(t *testing.T) {
+	rows := []map[string]string{
+		{"member_host": "db2", "member_port": "3306", "member_role": "SECONDARY"},
+		{"member_host": "db1", "member_port": "3306", "member_role": "PRIMARY"},
+		{"member_host": "db3", "member_port": "3307", "member_role": "SECONDARY"},
+	}
+	expected := []ServerNode{
+		{Host: "db1", Port: 3306, Role: RoleSource},
+		{Host: "db2", Port: 3306, Role: RoleReplica},
+		{Host: "db3", Port: 3307, Role: RoleReplica},
+	}
+	nodes, err := groupNodes(rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v - got %v", expected, nodes)
+	}
+
+	_, err = groupNodes([]map[string]string{{"member_host": "db1", "member_port": ""}})
+	if err == nil {
+		t.Errorf("expected error for empty port")
+	}
+}
+
+func TestReplicaNodes(t *testing.T) {
+	// SHOW REPLICAS (8.0.22+) and SHOW SLAVE HOSTS have the same Host and Port columns
+	rows := []map[string]string{
+		{"server_id": "200", "host": "db2", "port": "3306", "source_id": "100"},
+		{"server_id": "300", "host": "", "port": "3308", "source_id": "100"},
+	}
+	expected := []ServerNode{
+		{Host: "db2", Port: 3306, Role: RoleReplica},
+		{Host: "db1", Port: 3308, Role: RoleReplica},
+	}
+	nodes, err := replicaNodes(rows, "db1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v - got %v", expected, nodes)
+	}
+}
+
+func TestSourceNode(t *testing.T) {
+	var testData = []struct {
+		name       string
+		rows       []map[string]string
+		expected   ServerNode
+		isReplica  bool
+		isErrorful bool
+	}{
+		{"not a replica", nil, ServerNode{}, false, false},
+		{"replica status", []map[string]string{{"source_host": "db1", "source_port": "3306"}},
+			ServerNode{Host: "db1", Port: 3306, Role: RoleSource}, true, false},
+		{"slave status", []map[string]string{{"master_host": "db1", "master_port": "3307"}},
+			ServerNode{Host: "db1", Port: 3307, Role: RoleSource}, true, false},
+		{"reset replica", []map[string]string{{"source_host": "", "source_port": "3306"}},
+			ServerNode{}, false, false},
+		{"bad port", []map[string]string{{"source_host": "db1", "source_port": "none"}},
+			ServerNode{}, false, true},
+	}
+	for _, td := range testData {
+		t.Run(td.name, func(t *testing.T) {
+			node, isReplica, err := sourceNode(td.rows)
+			if (err != nil) != td.isErrorful {
+				t.Fatalf("expected error: %v - got %v", td.isErrorful, err)
+			}
+			if isReplica != td.isReplica {
+				t.Errorf("expected replica: %v - got %v", td.isReplica, isReplica)
+			}
+			if node != td.expected {
+				t.Errorf("expected %v - got %v", td.expected, node)
+			}
+		})
+	}
+}
@@ -20,11 +20,15 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/dustin/go-humanize"
 )
 
 func sandboxesUsingBinariesDir(basedir, binariesDir string) ([]string, error) {
@@ -83,3 +87,210 @@ func DeleteBinaries(basedir, binariesDir string, confirm bool) (deleted bool, er
 	}
 	return true, nil
 }
+
+// UnusedBinariesOptions selects which directories of binaries are removed by DeleteUnusedBinaries
+type UnusedBinariesOptions struct {
+	SandboxBinary string
+	SandboxHome   string
+	// Only directories that were not modified for this long are removed
+	OlderThan time.Duration
+	// The newest directories for each flavor and short version are kept, even when unused
+	KeepLatest int
+	DryRun     bool
+	Confirm    bool
+}
+
+// BinariesUsage describes a directory of binaries, and whether it can be removed
+type BinariesUsage struct {
+	Name         string    `json:"name"`
+	Path         string    `json:"path"`
+	Flavor       string    `json:"flavor"`
+	ShortVersion string    `json:"short_version,omitempty"`
+	Size         int64     `json:"size"`
+	Modified     time.Time `json:"modified"`
+	UsedBy       []string  `json:"used_by,omitempty"`
+	Keep         bool      `json:"keep"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// binariesReferences maps each directory of binaries used by a sandbox to the sandboxes that use it.
+// The references come from the catalog, and from the descriptions of the sandboxes in the catalog
+// and in sandboxHome, which also tell the directory used for the client
+func binariesReferences(catalog defaults.SandboxCatalog, sandboxHome string) map[string][]string {
+	references := make(map[string][]string)
+	addReference := func(binariesDir, sandbox string) {
+		if binariesDir == "" {
+			return
+		}
+		binariesDir = filepath.Clean(binariesDir)
+		for _, sb := range references[binariesDir] {
+			if sb == sandbox {
+				return
+			}
+		}
+		references[binariesDir] = append(references[binariesDir], sandbox)
+	}
+	addDescription := func(sandboxDir, sandbox string) {
+		description, err := common.ReadSandboxDescription(sandboxDir)
+		if err != nil {
+			return
+		}
+		addReference(description.Basedir, sandbox)
+		addReference(description.ClientBasedir, sandbox)
+	}
+	for _, sb := range catalog {
+		addReference(sb.Origin, sb.Destination)
+		addDescription(sb.Destination, sb.Destination)
+		for _, node := range sb.Nodes {
+			addDescription(node, sb.Destination)
+		}
+	}
+	if sandboxHome != "" {
+		installed, _ := common.GetInstalledSandboxes(sandboxHome)
+		for _, sb := range installed {
+			sandboxDir := path.Join(sandboxHome, sb.SandboxName)
+			addReference(sb.SandboxDesc.Basedir, sandboxDir)
+			addReference(sb.SandboxDesc.ClientBasedir, sandboxDir)
+		}
+	}
+	return references
+}
+
+func findUnusedBinaries(options UnusedBinariesOptions, catalog defaults.SandboxCatalog) ([]BinariesUsage, error) {
+	if !common.DirExists(options.SandboxBinary) {
+		return nil, fmt.Errorf(globals.ErrDirectoryNotFound, options.SandboxBinary)
+	}
+	references := binariesReferences(catalog, options.SandboxHome)
+	reVersion := regexp.MustCompile(`^(\D*\d+\.\d+)\.\d+$`)
+	var result []BinariesUsage
+	// Versions of each flavor and short version, so that the newest ones can be kept
+	groups := make(map[string][]string)
+	for _, versionInfo := range common.GetVersionInfoFromDir(options.SandboxBinary) {
+		dir := versionInfo.Version
+		fullPath := path.Join(options.SandboxBinary, dir)
+		stat, err := os.Stat(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		size, err := dirSize(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		usage := BinariesUsage{
+			Name:     dir,
+			Path:     fullPath,
+			Flavor:   versionInfo.Flavor,
+			Size:     size,
+			Modified: stat.ModTime(),
+			UsedBy:   references[filepath.Clean(fullPath)],
+		}
+		if realPath, err := filepath.EvalSymlinks(fullPath); err == nil && realPath != fullPath {
+			usage.UsedBy = append(usage.UsedBy, references[realPath]...)
+		}
+		matches := reVersion.FindStringSubmatch(dir)
+		if matches != nil {
+			usage.ShortVersion = matches[1]
+			key := usage.Flavor + " " + usage.ShortVersion
+			groups[key] = append(groups[key], dir)
+		}
+		result = append(result, usage)
+	}
+	latest := make(map[string]bool)
+	for _, versions := range groups {
+		sorted := common.SortVersions(versions)
+		for i := len(sorted) - 1; i >= 0 && i >= len(sorted)-options.KeepLatest; i-- {
+			latest[sorted[i]] = true
+		}
+	}
+	for i, usage := range result {
+		switch {
+		case len(usage.UsedBy) > 0:
+			result[i].Keep = true
+			result[i].Reason = "used by " + strings.Join(usage.UsedBy, ", ")
+		case latest[usage.Name]:
+			result[i].Keep = true
+			result[i].Reason = fmt.Sprintf("among the latest %d for %s %s", options.KeepLatest, usage.Flavor, usage.ShortVersion)
+		case options.OlderThan > 0 && time.Since(usage.Modified) < options.OlderThan:
+			result[i].Keep = true
+			result[i].Reason = "modified " + humanize.Time(usage.Modified)
+		default:
+			result[i].Reason = "unused, modified " + humanize.Time(usage.Modified)
+		}
+	}
+	return result, nil
+}
+
+// FindUnusedBinaries examines the directories of binaries in options.SandboxBinary, and marks
+// as removable the ones that no sandbox uses, unless they are kept by the age or latest version criteria
+func FindUnusedBinaries(options UnusedBinariesOptions) ([]BinariesUsage, error) {
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return nil, fmt.Errorf("error getting sandboxes from catalog: %s", err)
+	}
+	return findUnusedBinaries(options, catalog)
+}
+
+// DeleteUnusedBinaries removes the directories of binaries that FindUnusedBinaries marks as removable.
+// It returns the list of removed directories
+func DeleteUnusedBinaries(options UnusedBinariesOptions) ([]string, error) {
+	usageList, err := FindUnusedBinaries(options)
+	if err != nil {
+		return nil, err
+	}
+	var removable []BinariesUsage
+	var reclaimable int64
+	for _, usage := range usageList {
+		status := "keep  "
+		if !usage.Keep {
+			status = "delete"
+			removable = append(removable, usage)
+			reclaimable += usage.Size
+		}
+		fmt.Printf("%s %-25s %-10s %10s  %s\n", status, usage.Name, usage.Flavor, humanize.Bytes(uint64(usage.Size)), usage.Reason)
+	}
+	if len(removable) == 0 {
+		fmt.Printf("No unused binaries to remove in %s\n", options.SandboxBinary)
+		return nil, nil
+	}
+	fmt.Printf("%d directories to remove - %s can be reclaimed\n", len(removable), humanize.Bytes(uint64(reclaimable)))
+	if options.DryRun {
+		return nil, nil
+	}
+	if options.Confirm {
+		fmt.Printf("Do you want to delete %d directories from %s? y/[N] ", len(removable), options.SandboxBinary)
+		bio := bufio.NewReader(os.Stdin)
+		line, _, err := bio.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		answer := string(line)
+		if answer != "y" && answer != "Y" {
+			fmt.Println("Deletion skipped at user request")
+			return nil, nil
+		}
+	}
+	var deleted []string
+	for _, usage := range removable {
+		// DeleteBinaries checks the catalog again, in case a sandbox was deployed in the meantime
+		isDeleted, err := DeleteBinaries(options.SandboxBinary, usage.Name, false)
+		if !isDeleted {
+			return deleted, err
+		}
+		deleted = append(deleted, usage.Name)
+	}
+	return deleted, nil
+}
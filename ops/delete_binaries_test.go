@@ -0,0 +1,113 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestFindUnusedBinaries(t *testing.T) {
+	sandboxBinary := t.TempDir()
+	sandboxHome := t.TempDir()
+	oldTime := time.Now().Add(-100 * 24 * time.Hour)
+	for _, dir := range []string{"8.0.30", "8.0.31", "8.0.35", "8.0.36", "5.7.40", "ps8.0.33", "client8.0.36", "custom"} {
+		fullPath := path.Join(sandboxBinary, dir)
+		require.NoError(t, os.MkdirAll(path.Join(fullPath, "bin"), globals.PublicDirectoryAttr))
+		require.NoError(t, common.WriteString("0123456789", path.Join(fullPath, "bin", "mysqld")))
+		if dir == "ps8.0.33" {
+			require.NoError(t, common.WriteString(common.PerconaServerFlavor, path.Join(fullPath, globals.FlavorFileName)))
+		}
+		if dir != "8.0.36" {
+			require.NoError(t, os.Chtimes(fullPath, oldTime, oldTime))
+		}
+	}
+	// Not a directory of binaries: never touched
+	require.NoError(t, os.MkdirAll(path.Join(sandboxBinary, "notes"), globals.PublicDirectoryAttr))
+
+	// A sandbox in the catalog, and one found only in sandbox home, using a separate client
+	catalogSandbox := path.Join(t.TempDir(), "rsandbox_8_0_30")
+	catalog := defaults.SandboxCatalog{
+		catalogSandbox: {Origin: path.Join(sandboxBinary, "8.0.30"), Destination: catalogSandbox},
+	}
+	importedSandbox := path.Join(sandboxHome, "imported_8_0_31")
+	require.NoError(t, os.MkdirAll(importedSandbox, globals.PublicDirectoryAttr))
+	require.NoError(t, common.WriteSandboxDescription(importedSandbox, common.SandboxDescription{
+		Basedir:       path.Join(sandboxBinary, "8.0.31"),
+		ClientBasedir: path.Join(sandboxBinary, "client8.0.36") + "/",
+		SBType:        "single",
+		Version:       "8.0.31",
+		Port:          []int{8031},
+	}))
+
+	var tests = []struct {
+		name       string
+		olderThan  time.Duration
+		keepLatest int
+		removable  []string
+	}{
+		{"all unused", 0, 0, []string{"5.7.40", "8.0.35", "8.0.36", "custom", "ps8.0.33"}},
+		{"older than", 90 * 24 * time.Hour, 0, []string{"5.7.40", "8.0.35", "custom", "ps8.0.33"}},
+		{"keep latest", 0, 1, []string{"8.0.35", "custom"}},
+		{"keep latest two", 0, 2, []string{"custom"}},
+		{"both", 90 * 24 * time.Hour, 1, []string{"8.0.35", "custom"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usageList, err := findUnusedBinaries(UnusedBinariesOptions{
+				SandboxBinary: sandboxBinary,
+				SandboxHome:   sandboxHome,
+				OlderThan:     tt.olderThan,
+				KeepLatest:    tt.keepLatest,
+			}, catalog)
+			require.NoError(t, err)
+			require.Len(t, usageList, 8)
+			var removable []string
+			for _, usage := range usageList {
+				if !usage.Keep {
+					removable = append(removable, usage.Name)
+				}
+				require.GreaterOrEqual(t, usage.Size, int64(10))
+			}
+			require.ElementsMatch(t, tt.removable, removable)
+		})
+	}
+
+	usageList, err := findUnusedBinaries(UnusedBinariesOptions{SandboxBinary: sandboxBinary, SandboxHome: sandboxHome}, catalog)
+	require.NoError(t, err)
+	for _, usage := range usageList {
+		switch usage.Name {
+		case "8.0.30":
+			require.Equal(t, []string{catalogSandbox}, usage.UsedBy)
+		case "8.0.31", "client8.0.36":
+			require.Equal(t, []string{importedSandbox}, usage.UsedBy)
+		case "ps8.0.33":
+			require.Equal(t, common.PerconaServerFlavor, usage.Flavor)
+			require.Equal(t, "ps8.0", usage.ShortVersion)
+		}
+	}
+
+	_, err = findUnusedBinaries(UnusedBinariesOptions{SandboxBinary: path.Join(sandboxBinary, "missing")}, catalog)
+	require.Error(t, err)
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/downloads"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/datacharmer/dbdeployer/rest"
@@ -49,7 +50,9 @@ type DownloadsOptions struct {
 	DryRun            bool
 	IsShell           bool
 	Quiet             bool
+	RequireSignature  bool
 	Retries           int64
+	ParallelParts     int
 	VerbosityLevel    int
 	ProgressStep      int64
 }
@@ -104,15 +107,29 @@ func GetRemoteTarball(options DownloadsOptions) error {
 		if !options.Quiet {
 			fmt.Printf("Downloading %s\n", tarball.Name)
 		}
-		err = rest.DownloadFileWithRetry(absPath, tarball.Url, options.Quiet, options.ProgressStep, options.Retries)
+		// The checksum is verified while downloading, so that a corrupt file never gets the tarball name
+		err = rest.DownloadFileWithOptions(absPath, tarball.Url, rest.DownloadOptions{
+			Progress:     options.Quiet,
+			ProgressStep: options.ProgressStep,
+			Retries:      options.Retries,
+			Checksum:     tarball.Checksum,
+			Parallel:     options.ParallelParts,
+		})
 		if err != nil {
 			return fmt.Errorf("error getting remote file %s - %s", fileName, err)
 		}
 		downloadedTarball = absPath
-		err = postDownloadOps(tarball, fileName, absPath)
+		err = postDownloadOps(tarball, absPath)
 		if err != nil {
 			return err
 		}
+		err = getTarballSignature(tarball, absPath, options)
+		if err != nil {
+			// An unverified tarball is not left around, where it could be unpacked later
+			_ = os.Remove(absPath)
+			_ = os.Remove(absPath + globals.SignatureExt)
+			return err
+		}
 	}
 	if options.Unpack {
 		target := path.Join(options.SandboxBinary) // add target here
@@ -120,18 +137,24 @@ func GetRemoteTarball(options DownloadsOptions) error {
 			fmt.Printf("would unpack tarball into: %s\n", target)
 		} else {
 			err = UnpackTarball(UnpackOptions{
-				SandboxBinary: options.SandboxBinary,
-				TarballName:   tarball.Name,
-				TargetServer:  options.TargetServer,
-				Version:       tarball.Version,
-				Prefix:        options.Prefix,
-				Flavor:        tarball.Flavor,
-				Verbosity:     options.VerbosityLevel,
-				IsShell:       options.IsShell,
-				Overwrite:     options.Overwrite,
-				DryRun:        options.DryRun,
+				SandboxBinary:    options.SandboxBinary,
+				TarballName:      tarball.Name,
+				TargetServer:     options.TargetServer,
+				Version:          tarball.Version,
+				Prefix:           options.Prefix,
+				Flavor:           tarball.Flavor,
+				Verbosity:        options.VerbosityLevel,
+				IsShell:          options.IsShell,
+				Overwrite:        options.Overwrite,
+				DryRun:           options.DryRun,
+				RequireSignature: options.RequireSignature,
 			})
 			if err != nil {
+				if _, rejected := err.(signatureError); rejected && downloadedTarball != "" {
+					// As above, an unverified tarball is not left around
+					_ = os.Remove(downloadedTarball)
+					_ = os.Remove(downloadedTarball + globals.SignatureExt)
+				}
 				return err
 			}
 		}
@@ -146,22 +169,17 @@ func GetRemoteTarball(options DownloadsOptions) error {
 			if err != nil {
 				return fmt.Errorf("error removing downloaded file %s - %s", downloadedTarball, err)
 			}
+			_ = os.Remove(downloadedTarball + globals.SignatureExt)
 		}
 	}
 	return nil
 }
 
-func postDownloadOps(tarball downloads.TarballDescription, fileName, absPath string) error {
+func postDownloadOps(tarball downloads.TarballDescription, absPath string) error {
 	fmt.Printf("File %s downloaded\n", absPath)
 
 	if tarball.Checksum == "" {
 		fmt.Println("No checksum to compare")
-	} else {
-		err := downloads.CompareTarballChecksum(tarball, absPath)
-		if err != nil {
-			return fmt.Errorf("error comparing checksum for tarball %s - %s", fileName, err)
-		}
-		fmt.Println("Checksum matches")
 	}
 	warningMsg := getOSWarning(tarball)
 	if warningMsg != "" {
@@ -172,6 +190,40 @@ func postDownloadOps(tarball downloads.TarballDescription, fileName, absPath str
 	return nil
 }
 
+// getTarballSignature downloads the detached signature published next to a tarball, and verifies it
+// against the keys in defaults.KeysDir. When the tarball is going to be unpacked, the verification is
+// left to UnpackTarball, which records its result.
+// The signature is only mandatory when options.RequireSignature is set
+func getTarballSignature(tarball downloads.TarballDescription, absPath string, options DownloadsOptions) error {
+	keyring, err := downloads.ReadKeyring(defaults.KeysDir)
+	if err != nil {
+		return err
+	}
+	if len(keyring) == 0 && !options.RequireSignature {
+		return nil
+	}
+	signature := absPath + globals.SignatureExt
+	err = rest.DownloadFileWithOptions(signature, tarball.Url+globals.SignatureExt, rest.DownloadOptions{
+		Retries: options.Retries,
+	})
+	if err != nil {
+		if options.RequireSignature {
+			return fmt.Errorf("error getting signature for %s - %s", tarball.Name, err)
+		}
+		fmt.Printf("No signature available for %s\n", tarball.Name)
+		return nil
+	}
+	if options.Unpack {
+		return nil
+	}
+	result := downloads.VerifyTarballSignature(absPath, signature, keyring)
+	if !result.Verified && options.RequireSignature {
+		return fmt.Errorf("signature verification failed: %s", result.Error)
+	}
+	reportSignature(result)
+	return nil
+}
+
 func getOSWarning(tarball downloads.TarballDescription) string {
 	currentOS := strings.ToLower(runtime.GOOS)
 	currentArch := strings.ToLower(runtime.GOARCH)
@@ -247,6 +299,33 @@ func findRemoteTarballByVersion(version, flavor, OS, arch string, minimal, newes
 	var tarball downloads.TarballDescription
 	var err error
 
+	if downloads.IsShortVersion(version) {
+		lock, lockFile, err := downloads.ProjectLock()
+		if err != nil {
+			return downloads.TarballDescription{}, err
+		}
+		lockedBinary, found := lock.Find(version, flavor, OS, arch)
+		if found {
+			if newest || guessLatest {
+				fmt.Printf("# Options --%s and --%s are ignored: version %s is locked in %s\n",
+					globals.NewestLabel, globals.GuessLatestLabel, version, lockFile)
+			}
+			if minimal != lockedBinary.Minimal {
+				fmt.Printf("# Option --%s is ignored: the locked tarball has minimal: %v\n", globals.MinimalLabel, lockedBinary.Minimal)
+			}
+			fmt.Printf("# %s => %s (locked in %s)\n", version, lockedBinary.Tarball, lockFile)
+			return downloads.LockedTarball(lockedBinary), nil
+		}
+		pinnedVersion, found := lock.PinnedVersion(version, flavor)
+		if found {
+			fmt.Printf("# WARNING: %s locks %s %s to %s, but not for %s-%s. Looking for %s\n",
+				lockFile, flavor, version, pinnedVersion, OS, arch, pinnedVersion)
+			version = pinnedVersion
+			newest = false
+			guessLatest = false
+		}
+	}
+
 	tarball, err = downloads.FindOrGuessTarballByVersionFlavorOS(version, flavor, OS, arch, minimal, newest, guessLatest)
 	if err != nil {
 		return downloads.TarballDescription{}, fmt.Errorf(fmt.Sprintf("Error getting version %s (%s-%s)[minimal: %v - newest: %v - guess: %v]: %s",
@@ -271,3 +350,83 @@ func DisplayTarball(tarball downloads.TarballDescription) {
 		fmt.Printf("Added on:      %s\n", tarball.DateAdded)
 	}
 }
+
+// mirrorSignature downloads the signature published next to a tarball, if there is one,
+// so that the cache server can offer it to clients using --require-signature.
+// A signature already in the cache is kept
+func mirrorSignature(tarball downloads.TarballDescription, fileName string, options DownloadsOptions) {
+	signature := fileName + globals.SignatureExt
+	if common.FileExists(signature) {
+		return
+	}
+	err := rest.DownloadFileWithOptions(signature, tarball.Url+globals.SignatureExt, rest.DownloadOptions{
+		Retries: options.Retries,
+	})
+	if err != nil {
+		_ = os.Remove(signature)
+		if !options.Quiet {
+			fmt.Printf("No signature available for %s\n", tarball.Name)
+		}
+	}
+}
+
+// MirrorTarballs downloads the given tarballs, and their signatures when available, into a cache directory,
+// and records their descriptions, with the original URLs and checksums, so that a cache server can publish them.
+// Tarballs that are already in the cache with a matching checksum are not downloaded again
+func MirrorTarballs(dir string, tarballs []downloads.TarballDescription, options DownloadsOptions) error {
+	if !common.DirExists(dir) {
+		if options.DryRun {
+			fmt.Printf("would create directory %s\n", dir)
+		} else {
+			err := os.MkdirAll(dir, globals.PublicDirectoryAttr)
+			if err != nil {
+				return fmt.Errorf("error creating cache directory %s: %s", dir, err)
+			}
+		}
+	}
+	for _, tarball := range tarballs {
+		fileName := path.Join(dir, tarball.Name)
+		if common.FileExists(fileName) {
+			err := downloads.CompareTarballChecksum(tarball, fileName)
+			if err == nil {
+				fmt.Printf("%s already in cache\n", tarball.Name)
+				if !options.DryRun {
+					mirrorSignature(tarball, fileName, options)
+					err = downloads.AddToCacheMetadata(dir, tarball)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			fmt.Printf("%s in cache does not match its checksum: %s\n", tarball.Name, err)
+		}
+		if options.DryRun {
+			fmt.Printf("would download: %s\n", tarball.Url)
+			fmt.Printf("would download: %s\n", tarball.Url+globals.SignatureExt)
+			continue
+		}
+		if !options.Quiet {
+			fmt.Printf("Downloading %s\n", tarball.Name)
+		}
+		// A file with a wrong checksum is replaced
+		_ = os.Remove(fileName)
+		err := rest.DownloadFileWithOptions(fileName, tarball.Url, rest.DownloadOptions{
+			Progress:     !options.Quiet,
+			ProgressStep: options.ProgressStep,
+			Retries:      options.Retries,
+			Checksum:     tarball.Checksum,
+			Parallel:     options.ParallelParts,
+		})
+		if err != nil {
+			return fmt.Errorf("error getting remote file %s - %s", tarball.Name, err)
+		}
+		_ = os.Remove(fileName + globals.SignatureExt)
+		mirrorSignature(tarball, fileName, options)
+		err = downloads.AddToCacheMetadata(dir, tarball)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -115,3 +115,18 @@ func TestGetTarball(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckTarballSignature(t *testing.T) {
+	tarball := path.Join(t.TempDir(), "mysql-8.0.36-linux-glibc2.17-x86_64.tar.xz")
+	require.NoError(t, common.WriteString("not a real tarball", tarball))
+
+	// Without a signature, the tarball is only rejected when the signature is required
+	result, err := checkTarballSignature(tarball, false)
+	require.NoError(t, err)
+	require.Nil(t, result)
+
+	_, err = checkTarballSignature(tarball, true)
+	require.Error(t, err)
+	_, rejected := err.(signatureError)
+	require.True(t, rejected, "a missing signature is a signature error")
+}
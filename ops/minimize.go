@@ -0,0 +1,385 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/downloads"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/unpack"
+)
+
+// MinimalRules lists what a flavor's binaries don't need to run a sandbox
+type MinimalRules struct {
+	// Start of the tarball names of the flavor, used to name a packed minimal tarball
+	TarballPrefix string
+	// Patterns (as in filepath.Match) of the paths to remove, relative to the binaries directory
+	Remove []string
+}
+
+// Files and directories that no MySQL-like flavor needs for a sandbox
+var mysqlMinimalRemove = []string{
+	"mysql-test",
+	"sql-bench",
+	"man",
+	"docs",
+	"include",
+	"bin/*-debug",
+	"bin/mysqltest*",
+	"bin/mysql_client_test*",
+	"bin/mysqlxtest",
+	"bin/*_embedded",
+	"lib/*.a",
+	"lib/debug",
+	"lib/plugin/debug",
+	"lib/plugin/*test*",
+	"lib/plugin/*example*",
+	"lib/plugin/qa_auth_*",
+}
+
+var minimalRules = map[string]MinimalRules{
+	common.MySQLFlavor:         {TarballPrefix: "mysql", Remove: mysqlMinimalRemove},
+	common.NdbFlavor:           {TarballPrefix: "mysql-cluster", Remove: mysqlMinimalRemove},
+	common.PerconaServerFlavor: {TarballPrefix: "Percona-Server", Remove: mysqlMinimalRemove},
+	common.PxcFlavor:           {TarballPrefix: "Percona-XtraDB-Cluster", Remove: mysqlMinimalRemove},
+	common.MariaDbFlavor: {TarballPrefix: "mariadb", Remove: append([]string{
+		"share/doc",
+		"lib/plugin/*_test*",
+	}, mysqlMinimalRemove...)},
+}
+
+// MinimizeOptions describes how to reduce a directory of binaries
+type MinimizeOptions struct {
+	Basedir string
+	Flavor  string
+	Version string
+	// Removes the debugging symbols from executables and libraries
+	Strip bool
+	// Directory where the result is packed as a tarball, and recorded as a cache entry
+	PackDir string
+	// Name of the packed tarball. When empty, it is made from flavor, version, and platform
+	TarballName string
+	DryRun      bool
+}
+
+// MinimizeResult tells what MinimizeBinaries did
+type MinimizeResult struct {
+	Removed    []string
+	Stripped   int
+	SizeBefore int64
+	SizeAfter  int64
+	Tarball    *downloads.TarballDescription
+}
+
+// minimalCandidates returns the paths that the rules remove from basedir, without
+// the ones that are inside a directory already in the list
+func minimalCandidates(basedir string, rules MinimalRules) ([]string, error) {
+	found := make(map[string]bool)
+	for _, pattern := range rules.Remove {
+		matches, err := filepath.Glob(path.Join(basedir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %s", pattern, err)
+		}
+		for _, match := range matches {
+			found[match] = true
+		}
+	}
+	var candidates []string
+	for match := range found {
+		candidates = append(candidates, match)
+	}
+	sort.Strings(candidates)
+	var result []string
+	for _, candidate := range candidates {
+		if len(result) > 0 && strings.HasPrefix(candidate, result[len(result)-1]+"/") {
+			continue
+		}
+		result = append(result, candidate)
+	}
+	return result, nil
+}
+
+// isObjectFile tells whether a file is an executable or a library in ELF or Mach-O format
+func isObjectFile(fileName string) bool {
+	file, err := os.Open(fileName) // #nosec G304
+	if err != nil {
+		return false
+	}
+	defer file.Close() // #nosec G307
+	magic := make([]byte, 4)
+	if _, err = file.Read(magic); err != nil {
+		return false
+	}
+	for _, known := range [][]byte{
+		[]byte("\x7fELF"),
+		{0xfe, 0xed, 0xfa, 0xce}, {0xce, 0xfa, 0xed, 0xfe},
+		{0xfe, 0xed, 0xfa, 0xcf}, {0xcf, 0xfa, 0xed, 0xfe},
+		{0xca, 0xfe, 0xba, 0xbe},
+	} {
+		if bytes.Equal(magic, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripSymbols removes the debugging symbols from the executables and libraries in basedir
+func stripSymbols(basedir string) (int, error) {
+	stripCmd := common.Which("strip")
+	if stripCmd == "" {
+		return 0, fmt.Errorf("command 'strip' not found")
+	}
+	count := 0
+	for _, dir := range []string{"bin", "lib", "sbin"} {
+		if !common.DirExists(path.Join(basedir, dir)) {
+			continue
+		}
+		err := filepath.Walk(path.Join(basedir, dir), func(fileName string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() || !isObjectFile(fileName) {
+				return nil
+			}
+			// "-S" removes only the debugging symbols, with both GNU and BSD strip
+			_, err = common.RunCmdCtrlWithArgs(stripCmd, []string{"-S", fileName}, true)
+			if err != nil {
+				return fmt.Errorf("error stripping %s: %s", fileName, err)
+			}
+			count++
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// mockInitialization checks that a directory of binaries still has what a sandbox initialization uses,
+// without creating a database: the server and client, the error messages, the installation script
+// for the versions that need it, and a server that can run far enough to report its version
+func mockInitialization(basedir, flavor, version string) error {
+	required := []string{path.Join("bin", globals.FnMysqld), path.Join("bin", globals.FnMysql)}
+	canInitialize, err := common.HasCapability(flavor, common.Initialize, version)
+	if err != nil {
+		return err
+	}
+	if !canInitialize {
+		required = append(required, path.Join("scripts", globals.FnMysqlInstallDb))
+	}
+	for _, fileName := range required {
+		if !common.FileExists(path.Join(basedir, fileName)) {
+			return fmt.Errorf("%s not found in %s", fileName, basedir)
+		}
+	}
+	errorMessages, _ := filepath.Glob(path.Join(basedir, "share", "*", "errmsg.sys"))
+	moreMessages, _ := filepath.Glob(path.Join(basedir, "share", "*", "*", "errmsg.sys"))
+	if len(errorMessages)+len(moreMessages) == 0 {
+		return fmt.Errorf("no error messages (errmsg.sys) found in %s", path.Join(basedir, "share"))
+	}
+	// The server can only run on the operating system it was built for
+	if common.CheckTarballOperatingSystem(basedir) != nil {
+		return nil
+	}
+	out, err := common.RunCmdCtrlWithArgs(path.Join(basedir, "bin", globals.FnMysqld), []string{"--no-defaults", "--version"}, true)
+	if err != nil {
+		return fmt.Errorf("%s --version failed: %s", globals.FnMysqld, err)
+	}
+	if !strings.Contains(out, version) {
+		return fmt.Errorf("%s --version reports '%s' instead of version %s", globals.FnMysqld, strings.TrimSpace(out), version)
+	}
+	return nil
+}
+
+// minimalTarballName returns the name of the tarball for a minimized directory
+func minimalTarballName(options MinimizeOptions, rules MinimalRules) string {
+	if options.TarballName != "" {
+		name := options.TarballName
+		// The extensions accepted by unpack. Compound ones come before TarExt
+		for _, ext := range []string{globals.TarGzExt, globals.TarXzExt, globals.TarZstExt, globals.TgzExt, globals.ZipExt, globals.TarExt} {
+			if strings.HasSuffix(name, ext) {
+				name = strings.TrimSuffix(name, ext)
+				break
+			}
+		}
+		if !strings.Contains(name, "minimal") {
+			name += "-minimal"
+		}
+		return name + globals.TarGzExt
+	}
+	arch := runtime.GOARCH
+	if arch == "amd64" {
+		arch = "x86_64"
+	}
+	return fmt.Sprintf("%s-%s-%s-%s-minimal%s", rules.TarballPrefix, options.Version, runtime.GOOS, arch, globals.TarGzExt)
+}
+
+// packMinimal archives a minimized directory into options.PackDir, and records it in the
+// cache metadata of that directory, with its checksum, so that it can be served as a minimal tarball
+func packMinimal(options MinimizeOptions, rules MinimalRules) (downloads.TarballDescription, error) {
+	var description downloads.TarballDescription
+	if !common.DirExists(options.PackDir) {
+		return description, fmt.Errorf(globals.ErrDirectoryNotFound, options.PackDir)
+	}
+	tarballName := minimalTarballName(options, rules)
+	tarball := path.Join(options.PackDir, tarballName)
+	if common.FileExists(tarball) {
+		return description, fmt.Errorf(globals.ErrFileAlreadyExists, tarball)
+	}
+	err := unpack.PackTarGzWithLinks(options.Basedir, strings.TrimSuffix(tarballName, globals.TarGzExt), tarball)
+	if err != nil {
+		return description, fmt.Errorf("error packing %s: %s", tarball, err)
+	}
+	description, err = downloads.GetTarballInfo(tarball, downloads.TarballDescription{
+		Flavor:  options.Flavor,
+		Version: options.Version,
+		Minimal: true,
+		Arch:    runtime.GOARCH,
+		Notes:   fmt.Sprintf("minimized by dbdeployer %s", common.VersionDef),
+	})
+	if err != nil {
+		return description, err
+	}
+	err = downloads.AddToCacheMetadata(options.PackDir, description)
+	return description, err
+}
+
+// swapDirectories replaces dir with newDir, keeping dir in place if the replacement fails
+func swapDirectories(dir, newDir string) error {
+	oldDir := newDir + ".old"
+	err := os.Rename(dir, oldDir)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(newDir, dir)
+	if err != nil {
+		_ = os.Rename(oldDir, dir)
+		return fmt.Errorf("error replacing %s: %s", dir, err)
+	}
+	return os.RemoveAll(oldDir)
+}
+
+// MinimizeBinaries removes from a directory of binaries what a sandbox does not need,
+// according to the rules for its flavor, and checks that the result can still be used.
+// The directory is only changed when the checks succeed
+func MinimizeBinaries(options MinimizeOptions) (MinimizeResult, error) {
+	var result MinimizeResult
+	if !common.DirExists(options.Basedir) {
+		return result, fmt.Errorf(globals.ErrDirectoryNotFound, options.Basedir)
+	}
+	rules, ok := minimalRules[options.Flavor]
+	if !ok {
+		return result, fmt.Errorf("no rules to make minimal binaries for flavor '%s'", options.Flavor)
+	}
+	candidates, err := minimalCandidates(options.Basedir, rules)
+	if err != nil {
+		return result, err
+	}
+	result.SizeBefore, err = dirSize(options.Basedir)
+	if err != nil {
+		return result, err
+	}
+	for _, candidate := range candidates {
+		relPath, _ := filepath.Rel(options.Basedir, candidate)
+		result.Removed = append(result.Removed, relPath)
+	}
+	if options.DryRun {
+		return result, nil
+	}
+	// The minimal binaries are prepared in a copy, next to the original directory,
+	// which replaces the original only when it passes all the checks
+	workDir := fmt.Sprintf("%s.minimal-%d", options.Basedir, os.Getpid())
+	err = os.RemoveAll(workDir)
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(workDir)
+	removed := make(map[string]bool)
+	for _, relPath := range result.Removed {
+		removed[relPath] = true
+	}
+	_, _, err = common.CloneDirectory(options.Basedir, workDir, func(relPath string) bool { return removed[relPath] })
+	if err != nil {
+		return result, fmt.Errorf("error copying %s: %s", options.Basedir, err)
+	}
+	if options.Strip {
+		result.Stripped, err = stripSymbols(workDir)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.SizeAfter, err = dirSize(workDir)
+	if err != nil {
+		return result, err
+	}
+	err = common.CheckLibraries(workDir)
+	if err != nil {
+		return result, fmt.Errorf("minimal binaries for %s fail the library check: %s. %s was not changed",
+			options.Basedir, err, options.Basedir)
+	}
+	err = mockInitialization(workDir, options.Flavor, options.Version)
+	if err != nil {
+		return result, fmt.Errorf("minimal binaries for %s fail the initialization check: %s. %s was not changed",
+			options.Basedir, err, options.Basedir)
+	}
+	err = swapDirectories(options.Basedir, workDir)
+	if err != nil {
+		return result, err
+	}
+	if options.PackDir != "" {
+		description, err := packMinimal(options, rules)
+		if err != nil {
+			return result, err
+		}
+		result.Tarball = &description
+	}
+	return result, nil
+}
+
+// ReportMinimize shows the outcome of MinimizeBinaries
+func ReportMinimize(options MinimizeOptions, result MinimizeResult) {
+	action := "Removed"
+	if options.DryRun {
+		action = "Would remove"
+	}
+	for _, removed := range result.Removed {
+		fmt.Printf("%s %s\n", action, removed)
+	}
+	if options.DryRun {
+		return
+	}
+	if options.Strip {
+		fmt.Printf("Debugging symbols removed from %d files\n", result.Stripped)
+	}
+	fmt.Printf("%s reduced from %s to %s\n", options.Basedir,
+		humanize.Bytes(uint64(result.SizeBefore)), humanize.Bytes(uint64(result.SizeAfter)))
+	if result.Tarball != nil {
+		fmt.Printf("Minimal tarball %s (%s) in %s\n", result.Tarball.Name, humanize.Bytes(uint64(result.Tarball.Size)), options.PackDir)
+		fmt.Printf("Checksum: %s\n", result.Tarball.Checksum)
+	}
+}
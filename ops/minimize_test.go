@@ -0,0 +1,164 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/downloads"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/unpack"
+)
+
+func createFullBinaries(t *testing.T, basedir, version string) {
+	files := map[string]string{
+		"bin/mysqld":                   "#!/bin/sh\necho \"mysqld  Ver " + version + " for Linux\"\n",
+		"bin/mysql":                    "#!/bin/sh\n",
+		"bin/mysqld-debug":             "#!/bin/sh\n",
+		"bin/mysqltest":                "#!/bin/sh\n",
+		"lib/libmysqlclient.so":        "library",
+		"lib/libmysqlclient.a":         "static library",
+		"lib/libssl.so.3":              "library",
+		"lib/plugin/keyring_file.so":   "plugin",
+		"lib/plugin/test_udf.so":       "test plugin",
+		"lib/plugin/debug/auth.so":     "debug plugin",
+		"mysql-test/t/select.test":     "test",
+		"share/english/errmsg.sys":     "messages",
+		"share/mysql-log-rotate":       "script",
+		"docs/INFO_SRC":                "info",
+		"include/mysql.h":              "header",
+		"lib/plugin/ha_example.so":     "example plugin",
+		"lib/plugin/component_test.so": "test component",
+	}
+	for name, contents := range files {
+		fileName := path.Join(basedir, name)
+		require.NoError(t, os.MkdirAll(path.Dir(fileName), globals.PublicDirectoryAttr))
+		require.NoError(t, os.WriteFile(fileName, []byte(contents), globals.ExecutableFileAttr))
+	}
+	require.NoError(t, os.Symlink("libssl.so.3", path.Join(basedir, "lib", "libssl.so")))
+}
+
+func TestMinimizeBinaries(t *testing.T) {
+	const version = "8.0.36"
+	basedir := path.Join(t.TempDir(), version)
+	createFullBinaries(t, basedir, version)
+	expectedRemoved := []string{
+		"bin/mysqld-debug",
+		"bin/mysqltest",
+		"docs",
+		"include",
+		"lib/libmysqlclient.a",
+		"lib/plugin/component_test.so",
+		"lib/plugin/debug",
+		"lib/plugin/ha_example.so",
+		"lib/plugin/test_udf.so",
+		"mysql-test",
+	}
+
+	options := MinimizeOptions{Basedir: basedir, Flavor: common.MySQLFlavor, Version: version, DryRun: true}
+	result, err := MinimizeBinaries(options)
+	require.NoError(t, err)
+	require.Equal(t, expectedRemoved, result.Removed)
+	require.FileExists(t, path.Join(basedir, "bin", "mysqld-debug"))
+
+	_, err = MinimizeBinaries(MinimizeOptions{Basedir: basedir, Flavor: common.TiDbFlavor, Version: version})
+	require.Error(t, err, "flavor without rules")
+
+	packDir := t.TempDir()
+	options.DryRun = false
+	options.PackDir = packDir
+	options.TarballName = "mysql-8.0.36-linux-glibc2.28-x86_64.tar.xz"
+	result, err = MinimizeBinaries(options)
+	require.NoError(t, err)
+	require.Equal(t, expectedRemoved, result.Removed)
+	require.Less(t, result.SizeAfter, result.SizeBefore)
+	for _, removed := range expectedRemoved {
+		require.NoFileExists(t, path.Join(basedir, removed))
+	}
+	for _, kept := range []string{"bin/mysqld", "bin/mysql", "lib/plugin/keyring_file.so", "share/english/errmsg.sys"} {
+		require.FileExists(t, path.Join(basedir, kept))
+	}
+
+	require.NotNil(t, result.Tarball)
+	require.Equal(t, "mysql-8.0.36-linux-glibc2.28-x86_64-minimal.tar.gz", result.Tarball.Name)
+	require.True(t, result.Tarball.Minimal)
+	require.True(t, strings.HasPrefix(result.Tarball.Checksum, "SHA512:"))
+	cached, err := downloads.ReadCacheMetadata(packDir)
+	require.NoError(t, err)
+	require.Len(t, cached.Tarballs, 1)
+	require.Equal(t, *result.Tarball, cached.Tarballs[0])
+
+	// The packed tarball expands to the same directory, links included.
+	// UnpackTar changes the current directory, which must outlive the temporary one
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	defer func() { _ = os.Chdir(cwd) }()
+	expandDir := t.TempDir()
+	require.NoError(t, unpack.UnpackTar(path.Join(packDir, result.Tarball.Name), expandDir, 0))
+	expanded := path.Join(expandDir, "mysql-8.0.36-linux-glibc2.28-x86_64-minimal")
+	require.FileExists(t, path.Join(expanded, "bin", "mysqld"))
+	link, err := os.Readlink(path.Join(expanded, "lib", "libssl.so"))
+	require.NoError(t, err)
+	require.Equal(t, "libssl.so.3", link)
+
+	// A packed tarball is never overwritten
+	_, err = MinimizeBinaries(options)
+	require.Error(t, err)
+
+	// Binaries that fail the checks are left untouched
+	brokenDir := path.Join(t.TempDir(), version)
+	createFullBinaries(t, brokenDir, version)
+	require.NoError(t, os.Remove(path.Join(brokenDir, "share", "english", "errmsg.sys")))
+	options.Basedir = brokenDir
+	options.PackDir = ""
+	_, err = MinimizeBinaries(options)
+	require.Error(t, err, "missing error messages")
+	for _, removed := range expectedRemoved {
+		require.True(t, common.FileExists(path.Join(brokenDir, removed)) || common.DirExists(path.Join(brokenDir, removed)))
+	}
+	leftovers, err := filepath.Glob(brokenDir + ".minimal-*")
+	require.NoError(t, err)
+	require.Empty(t, leftovers)
+}
+
+func TestMinimalTarballName(t *testing.T) {
+	var tests = []struct {
+		tarballName string
+		expected    string
+	}{
+		{"mysql-8.0.36-linux-glibc2.28-x86_64.tar.xz", "mysql-8.0.36-linux-glibc2.28-x86_64-minimal.tar.gz"},
+		{"mysql-8.0.36-linux-glibc2.17-x86_64-minimal.tar.xz", "mysql-8.0.36-linux-glibc2.17-x86_64-minimal.tar.gz"},
+		{"Percona-Server-8.0.35-27-Linux.x86_64.glibc2.17.tar.gz", "Percona-Server-8.0.35-27-Linux.x86_64.glibc2.17-minimal.tar.gz"},
+		{"mysql-8.4.0-linux-glibc2.28-x86_64.tar.zst", "mysql-8.4.0-linux-glibc2.28-x86_64-minimal.tar.gz"},
+		{"mysql-8.0.36-macos14-arm64.zip", "mysql-8.0.36-macos14-arm64-minimal.tar.gz"},
+		{"mysql-8.0.36-linux-x86_64.tgz", "mysql-8.0.36-linux-x86_64-minimal.tar.gz"},
+		{"mysql-8.0.36-linux-x86_64.tar", "mysql-8.0.36-linux-x86_64-minimal.tar.gz"},
+	}
+	for _, tt := range tests {
+		name := minimalTarballName(MinimizeOptions{TarballName: tt.tarballName}, minimalRules[common.MySQLFlavor])
+		require.Equal(t, tt.expected, name)
+	}
+	name := minimalTarballName(MinimizeOptions{Version: "8.0.35"}, minimalRules[common.PerconaServerFlavor])
+	require.True(t, strings.HasPrefix(name, "Percona-Server-8.0.35-"))
+	require.True(t, strings.HasSuffix(name, "-minimal.tar.gz"))
+}
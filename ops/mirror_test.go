@@ -0,0 +1,99 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/downloads"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorTarballs(t *testing.T) {
+	sourceDir := t.TempDir()
+	cacheDir := path.Join(t.TempDir(), "cache")
+	contents := []byte("contents of a mock tarball")
+	sum := sha256.Sum256(contents)
+	name := "mysql-8.0.99-linux-glibc2.17-x86_64-minimal.tar.xz"
+	require.NoError(t, os.WriteFile(path.Join(sourceDir, name), contents, 0644))
+	signature := []byte("mock signature")
+	require.NoError(t, os.WriteFile(path.Join(sourceDir, name+globals.SignatureExt), signature, 0644))
+
+	var requests int32
+	cacheServer := downloads.NewCacheServer(sourceDir, "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		cacheServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tarball := downloads.TarballDescription{
+		Name:            name,
+		Checksum:        "SHA256:" + hex.EncodeToString(sum[:]),
+		OperatingSystem: "Linux",
+		Url:             server.URL + "/MySQL-8.0/" + name,
+		Flavor:          "mysql",
+		Minimal:         true,
+		Size:            int64(len(contents)),
+		ShortVersion:    "8.0",
+		Version:         "8.0.99",
+	}
+	options := DownloadsOptions{Quiet: true}
+
+	// dry run does not change anything
+	require.NoError(t, MirrorTarballs(cacheDir, []downloads.TarballDescription{tarball}, DownloadsOptions{DryRun: true}))
+	require.NoDirExists(t, cacheDir)
+	require.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	require.NoError(t, MirrorTarballs(cacheDir, []downloads.TarballDescription{tarball}, options))
+	downloaded, err := os.ReadFile(path.Join(cacheDir, name))
+	require.NoError(t, err)
+	require.Equal(t, contents, downloaded)
+	metadata, err := downloads.ReadCacheMetadata(cacheDir)
+	require.NoError(t, err)
+	require.Equal(t, []downloads.TarballDescription{tarball}, metadata.Tarballs)
+	// The signature is mirrored with the tarball
+	downloaded, err = os.ReadFile(path.Join(cacheDir, name+globals.SignatureExt))
+	require.NoError(t, err)
+	require.Equal(t, signature, downloaded)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// A tarball already in the cache is not downloaded again
+	require.NoError(t, MirrorTarballs(cacheDir, []downloads.TarballDescription{tarball}, options))
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// A corrupt tarball is replaced
+	require.NoError(t, os.WriteFile(path.Join(cacheDir, name), []byte("corrupt"), 0644))
+	require.NoError(t, MirrorTarballs(cacheDir, []downloads.TarballDescription{tarball}, options))
+	downloaded, err = os.ReadFile(path.Join(cacheDir, name))
+	require.NoError(t, err)
+	require.Equal(t, contents, downloaded)
+	require.Equal(t, int32(4), atomic.LoadInt32(&requests))
+
+	// A tarball that does not match its checksum is not kept
+	tarball.Checksum = "SHA256:0000"
+	require.NoError(t, os.Remove(path.Join(cacheDir, name)))
+	require.Error(t, MirrorTarballs(cacheDir, []downloads.TarballDescription{tarball}, options))
+	require.NoFileExists(t, path.Join(cacheDir, name))
+}
@@ -0,0 +1,461 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
+	"github.com/datacharmer/dbdeployer/unpack"
+)
+
+const snapshotsDirName = "snapshots"
+
+// SnapshotInfo describes a saved copy of the data directories of a sandbox
+type SnapshotInfo struct {
+	Name       string   `json:"name"`
+	Sandbox    string   `json:"sandbox"`
+	SandboxDir string   `json:"sandbox-dir"`
+	SBType     string   `json:"type"`
+	Version    string   `json:"version"`
+	Flavor     string   `json:"flavor,omitempty"`
+	Nodes      []string `json:"nodes"`
+	Created    string   `json:"created"`
+	Size       int64    `json:"size"`
+	Tarball    string   `json:"tarball"`
+}
+
+var reSnapshotName = regexp.MustCompile(`^[\w.-]+$`)
+
+// snapshotKey identifies the snapshots of a sandbox. Sandboxes with the same name
+// in different sandbox homes get different keys, using a hash of the full path
+func snapshotKey(sandboxDir string) string {
+	fullPath, err := filepath.Abs(sandboxDir)
+	if err != nil {
+		fullPath = sandboxDir
+	}
+	sum := sha256.Sum256([]byte(fullPath))
+	return common.BaseName(fullPath) + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// SnapshotsDir returns the directory where the snapshots of a sandbox are stored.
+// With an empty sandbox directory, it returns the directory containing all snapshots
+func SnapshotsDir(sandboxDir string) string {
+	if sandboxDir == "" {
+		return path.Join(defaults.ConfigurationDir, snapshotsDirName)
+	}
+	return path.Join(defaults.ConfigurationDir, snapshotsDirName, snapshotKey(sandboxDir))
+}
+
+// validateSnapshotName makes sure that a snapshot name can't point outside the snapshots directory
+func validateSnapshotName(snapshotName string) error {
+	if !reSnapshotName.MatchString(snapshotName) || snapshotName == "." || snapshotName == ".." {
+		return fmt.Errorf("invalid snapshot name '%s': only letters, digits, '.', '-', and '_' are allowed", snapshotName)
+	}
+	return nil
+}
+
+// snapshotFiles returns the archive and the description file of a snapshot
+func snapshotFiles(sandboxDir, snapshotName string) (tarball, infoFile string, err error) {
+	err = validateSnapshotName(snapshotName)
+	if err != nil {
+		return "", "", err
+	}
+	base := path.Join(SnapshotsDir(sandboxDir), snapshotName)
+	return base + globals.TarGzExt, base + ".json", nil
+}
+
+// relativeNodeDirs returns the node directories relative to the sandbox directory.
+// A single sandbox has one node, indicated by an empty string
+func relativeNodeDirs(sandboxDir string) ([]string, error) {
+	nodeDirs, err := lifecycle.NodeDirs(sandboxDir)
+	if err != nil {
+		return nil, err
+	}
+	var relDirs []string
+	for _, dir := range nodeDirs {
+		rel, err := filepath.Rel(sandboxDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." {
+			rel = ""
+		}
+		relDirs = append(relDirs, rel)
+	}
+	return relDirs, nil
+}
+
+// isRunning tells whether any server in the sandbox is running
+func isRunning(sandboxDir string) bool {
+	status, err := lifecycle.Status(sandboxDir)
+	if err != nil {
+		return false
+	}
+	for _, node := range status.Nodes {
+		if node.Running {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSnapshot stops a sandbox and archives the data directory and description of each node.
+// If the sandbox was running, it is restarted at the end.
+// When snapshotName is empty, the snapshot is named after the current time.
+func CreateSnapshot(sandboxDir, snapshotName string) (info SnapshotInfo, err error) {
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return info, err
+	}
+	sandboxName := common.BaseName(sandboxDir)
+	if snapshotName == "" {
+		snapshotName = time.Now().Format("20060102-150405")
+	}
+	tarball, infoFile, err := snapshotFiles(sandboxDir, snapshotName)
+	if err != nil {
+		return info, err
+	}
+	if common.FileExists(infoFile) || common.FileExists(tarball) {
+		return info, fmt.Errorf("snapshot '%s' already exists for sandbox %s", snapshotName, sandboxName)
+	}
+	nodes, err := relativeNodeDirs(sandboxDir)
+	if err != nil {
+		return info, err
+	}
+	err = os.MkdirAll(SnapshotsDir(sandboxDir), globals.PublicDirectoryAttr)
+	if err != nil {
+		return info, err
+	}
+
+	if isRunning(sandboxDir) {
+		common.CondPrintf("Stopping sandbox %s\n", sandboxName)
+		err = lifecycle.Stop(sandboxDir)
+		if err != nil {
+			return info, errors.Wrapf(err, "error stopping sandbox %s", sandboxName)
+		}
+		defer func() {
+			common.CondPrintf("Restarting sandbox %s\n", sandboxName)
+			startErr := lifecycle.Start(sandboxDir)
+			if err == nil {
+				err = startErr
+			}
+		}()
+	}
+
+	include := func(relPath string) bool {
+		if relPath == globals.SandboxDescriptionName {
+			return true
+		}
+		for _, node := range nodes {
+			dataDir := path.Join(node, globals.DataDirName)
+			if relPath == dataDir || strings.HasPrefix(relPath, dataDir+"/") ||
+				relPath == path.Join(node, globals.SandboxDescriptionName) {
+				return true
+			}
+		}
+		return false
+	}
+	common.CondPrintf("Creating snapshot %s of sandbox %s\n", snapshotName, sandboxName)
+	err = unpack.PackTarGz(sandboxDir, snapshotName, tarball, include)
+	if err != nil {
+		_ = os.Remove(tarball)
+		return info, errors.Wrapf(err, "error archiving sandbox %s", sandboxName)
+	}
+	stat, err := os.Stat(tarball)
+	if err != nil {
+		return info, err
+	}
+	info = SnapshotInfo{
+		Name:       snapshotName,
+		Sandbox:    sandboxName,
+		SandboxDir: sandboxDir,
+		SBType:     sbDesc.SBType,
+		Version:    sbDesc.Version,
+		Flavor:     sbDesc.Flavor,
+		Nodes:      nodes,
+		Created:    time.Now().Format(time.RFC3339),
+		Size:       stat.Size(),
+		Tarball:    tarball,
+	}
+	text, err := json.MarshalIndent(info, " ", "\t")
+	if err != nil {
+		return info, err
+	}
+	err = common.WriteString(string(text), infoFile)
+	return info, err
+}
+
+// ListSnapshots returns the snapshots of a sandbox, or of all sandboxes when sandboxDir is empty.
+// The snapshots of each sandbox are sorted by creation time.
+func ListSnapshots(sandboxDir string) ([]SnapshotInfo, error) {
+	pattern := path.Join(SnapshotsDir(sandboxDir), "*.json")
+	if sandboxDir == "" {
+		pattern = path.Join(SnapshotsDir(""), "*", "*.json")
+	}
+	infoFiles, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []SnapshotInfo{}
+	for _, infoFile := range infoFiles {
+		info, err := readSnapshotInfo(infoFile)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, info)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if snapshots[i].SandboxDir != snapshots[j].SandboxDir {
+			return snapshots[i].SandboxDir < snapshots[j].SandboxDir
+		}
+		return snapshots[i].Created < snapshots[j].Created
+	})
+	return snapshots, nil
+}
+
+func readSnapshotInfo(infoFile string) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	text, err := common.SlurpAsBytes(infoFile)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(text, &info)
+	if err != nil {
+		return info, errors.Wrapf(err, "error decoding snapshot description %s", infoFile)
+	}
+	return info, nil
+}
+
+// GetSnapshot returns a snapshot of a sandbox.
+// When snapshotName is empty, it returns the most recent snapshot.
+func GetSnapshot(sandboxDir, snapshotName string) (SnapshotInfo, error) {
+	if snapshotName == "" {
+		snapshots, err := ListSnapshots(sandboxDir)
+		if err != nil {
+			return SnapshotInfo{}, err
+		}
+		if len(snapshots) == 0 {
+			return SnapshotInfo{}, fmt.Errorf("no snapshots found for sandbox %s", sandboxDir)
+		}
+		return snapshots[len(snapshots)-1], nil
+	}
+	_, infoFile, err := snapshotFiles(sandboxDir, snapshotName)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if !common.FileExists(infoFile) {
+		return SnapshotInfo{}, fmt.Errorf("snapshot '%s' not found for sandbox %s", snapshotName, sandboxDir)
+	}
+	return readSnapshotInfo(infoFile)
+}
+
+// RestoreSnapshot replaces the data directories and the descriptions of a sandbox
+// with the ones saved in a snapshot.
+// A snapshot taken from a different version or flavor is only restored when force is set.
+// If the sandbox was running, it is restarted at the end.
+func RestoreSnapshot(sandboxDir, snapshotName string, force bool) (err error) {
+	sandboxName := common.BaseName(sandboxDir)
+	info, err := GetSnapshot(sandboxDir, snapshotName)
+	if err != nil {
+		return err
+	}
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return err
+	}
+	if common.FileExists(path.Join(sandboxDir, globals.ScriptNoClear)) ||
+		common.FileExists(path.Join(sandboxDir, globals.ScriptNoClearAll)) {
+		return fmt.Errorf("sandbox %s is locked. Its data cannot be replaced\n"+
+			"You can unlock it with 'dbdeployer admin unlock %s'", sandboxName, sandboxName)
+	}
+	if sbDesc.SBType != info.SBType {
+		return fmt.Errorf("snapshot '%s' was taken from a sandbox of type %s, while %s is of type %s",
+			info.Name, info.SBType, sandboxName, sbDesc.SBType)
+	}
+	if (sbDesc.Version != info.Version || sbDesc.Flavor != info.Flavor) && !force {
+		return fmt.Errorf("snapshot '%s' was taken from %s %s, while sandbox %s uses %s %s. Use --%s to restore it anyway",
+			info.Name, info.Flavor, info.Version, sandboxName, sbDesc.Flavor, sbDesc.Version, globals.ForceLabel)
+	}
+	nodes, err := relativeNodeDirs(sandboxDir)
+	if err != nil {
+		return err
+	}
+	if strings.Join(nodes, ",") != strings.Join(info.Nodes, ",") {
+		return fmt.Errorf("snapshot '%s' contains nodes %v, while sandbox %s has nodes %v",
+			info.Name, info.Nodes, sandboxName, nodes)
+	}
+	tarball, _, err := snapshotFiles(sandboxDir, info.Name)
+	if err != nil {
+		return err
+	}
+	if !common.FileExists(tarball) {
+		return fmt.Errorf(globals.ErrFileNotFound, tarball)
+	}
+
+	if isRunning(sandboxDir) {
+		common.CondPrintf("Stopping sandbox %s\n", sandboxName)
+		err = lifecycle.Stop(sandboxDir)
+		if err != nil {
+			return errors.Wrapf(err, "error stopping sandbox %s", sandboxName)
+		}
+		defer func() {
+			common.CondPrintf("Restarting sandbox %s\n", sandboxName)
+			startErr := lifecycle.Start(sandboxDir)
+			if err == nil {
+				err = startErr
+			}
+		}()
+	}
+
+	// The archive is extracted inside the sandbox, so that the data
+	// directories can be moved in place without copying them
+	restoreDir := path.Join(sandboxDir, ".snapshot-restore")
+	err = os.RemoveAll(restoreDir)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(restoreDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(restoreDir)
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	common.CondPrintf("Restoring snapshot %s into sandbox %s\n", info.Name, sandboxName)
+	err = unpack.UnpackTar(tarball, restoreDir, unpack.SILENT)
+	_ = os.Chdir(currentDir)
+	if err != nil {
+		return errors.Wrapf(err, "error extracting snapshot %s", tarball)
+	}
+	for _, node := range nodes {
+		savedData := path.Join(restoreDir, info.Name, node, globals.DataDirName)
+		if !common.DirExists(savedData) {
+			return fmt.Errorf("data directory for node '%s' not found in snapshot %s", node, info.Name)
+		}
+	}
+	// The data directories and the descriptions of the sandbox and of its nodes
+	// are replaced together
+	items := []string{}
+	for _, node := range nodes {
+		items = append(items, path.Join(node, globals.DataDirName))
+	}
+	items = append(items, globals.SandboxDescriptionName)
+	for _, node := range nodes {
+		if node != "" {
+			items = append(items, path.Join(node, globals.SandboxDescriptionName))
+		}
+	}
+	return swapSnapshotItems(sandboxDir, path.Join(restoreDir, info.Name), items)
+}
+
+// swapSnapshotItems replaces the given files and directories of a sandbox with the ones in restoredDir.
+// The current items are moved aside first, and they are removed only when every item was replaced.
+// On failure, the items already replaced are put back.
+func swapSnapshotItems(sandboxDir, restoredDir string, items []string) error {
+	previousDir := path.Join(sandboxDir, ".snapshot-previous")
+	err := os.RemoveAll(previousDir)
+	if err != nil {
+		return err
+	}
+	var moved, added []string
+	rollback := func(failure error) error {
+		for _, item := range added {
+			_ = os.RemoveAll(path.Join(sandboxDir, item))
+		}
+		for i := len(moved) - 1; i >= 0; i-- {
+			current := path.Join(sandboxDir, moved[i])
+			_ = os.RemoveAll(current)
+			err := os.Rename(path.Join(previousDir, moved[i]), current)
+			if err != nil {
+				return errors.Wrapf(failure, "error restoring %s (the previous copy is in %s)", current, previousDir)
+			}
+		}
+		_ = os.RemoveAll(previousDir)
+		return failure
+	}
+	for _, item := range items {
+		restored := path.Join(restoredDir, item)
+		if !common.FileExists(restored) && !common.DirExists(restored) {
+			// Older snapshots don't include the descriptions
+			continue
+		}
+		current := path.Join(sandboxDir, item)
+		previous := path.Join(previousDir, item)
+		err = os.MkdirAll(path.Dir(previous), globals.PublicDirectoryAttr)
+		if err != nil {
+			return rollback(err)
+		}
+		if common.FileExists(current) || common.DirExists(current) {
+			err = os.Rename(current, previous)
+			if err != nil {
+				return rollback(err)
+			}
+			moved = append(moved, item)
+		} else {
+			added = append(added, item)
+		}
+		err = os.Rename(restored, current)
+		if err != nil {
+			return rollback(err)
+		}
+	}
+	return os.RemoveAll(previousDir)
+}
+
+// DeleteSnapshot removes a snapshot of a sandbox. The sandbox directory
+// is only used to find the snapshot, and it doesn't need to exist
+func DeleteSnapshot(sandboxDir, snapshotName string) error {
+	tarball, infoFile, err := snapshotFiles(sandboxDir, snapshotName)
+	if err != nil {
+		return err
+	}
+	if !common.FileExists(infoFile) && !common.FileExists(tarball) {
+		return fmt.Errorf("snapshot '%s' not found for sandbox %s", snapshotName, sandboxDir)
+	}
+	for _, fileName := range []string{tarball, infoFile} {
+		if common.FileExists(fileName) {
+			err = os.Remove(fileName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// Removes the sandbox directory when it has no more snapshots
+	remaining, err := filepath.Glob(path.Join(SnapshotsDir(sandboxDir), "*"))
+	if err == nil && len(remaining) == 0 {
+		_ = os.Remove(SnapshotsDir(sandboxDir))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	savedConfigurationDir := defaults.ConfigurationDir
+	defaults.ConfigurationDir = t.TempDir()
+	defer func() { defaults.ConfigurationDir = savedConfigurationDir }()
+
+	// A stopped single sandbox, with just a description and a data directory
+	sandboxDir := path.Join(t.TempDir(), "msb_8_0_36")
+	dataDir := path.Join(sandboxDir, globals.DataDirName)
+	require.NoError(t, os.MkdirAll(path.Join(dataDir, "test"), globals.PublicDirectoryAttr))
+	require.NoError(t, common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		Basedir: "/opt/mysql/8.0.36",
+		SBType:  "single",
+		Version: "8.0.36",
+		Flavor:  common.MySQLFlavor,
+		Port:    []int{8036},
+		Nodes:   0,
+	}))
+	dataFile := path.Join(dataDir, "test", "t1.ibd")
+	require.NoError(t, common.WriteString("original", dataFile))
+	require.NoError(t, common.WriteString("not archived", path.Join(sandboxDir, "start")))
+
+	info, err := CreateSnapshot(sandboxDir, "first")
+	require.NoError(t, err)
+	require.Equal(t, "msb_8_0_36", info.Sandbox)
+	require.Equal(t, sandboxDir, info.SandboxDir)
+	require.Equal(t, []string{""}, info.Nodes)
+	require.FileExists(t, info.Tarball)
+
+	_, err = CreateSnapshot(sandboxDir, "first")
+	require.Error(t, err, "duplicate snapshot name")
+	_, err = CreateSnapshot(sandboxDir, "bad/name")
+	require.Error(t, err, "invalid snapshot name")
+
+	snapshots, err := ListSnapshots("")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.Equal(t, "first", snapshots[0].Name)
+
+	// A sandbox with the same name in a different sandbox home has its own snapshots
+	otherDir := path.Join(t.TempDir(), "msb_8_0_36")
+	require.NotEqual(t, SnapshotsDir(sandboxDir), SnapshotsDir(otherDir))
+	snapshots, err = ListSnapshots(otherDir)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 0)
+
+	require.NoError(t, common.WriteString("changed", dataFile))
+	require.NoError(t, common.WriteString("extra", path.Join(dataDir, "extra.ibd")))
+	require.NoError(t, RestoreSnapshot(sandboxDir, "", false))
+	text, err := common.SlurpAsString(dataFile)
+	require.NoError(t, err)
+	require.Equal(t, "original", text)
+	require.NoFileExists(t, path.Join(dataDir, "extra.ibd"))
+	require.FileExists(t, path.Join(sandboxDir, "start"))
+	require.NoDirExists(t, path.Join(sandboxDir, ".snapshot-restore"))
+
+	// A snapshot from a different version needs force
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	require.NoError(t, err)
+	sbDesc.Version = "8.0.37"
+	require.NoError(t, common.WriteSandboxDescription(sandboxDir, sbDesc))
+	require.Error(t, RestoreSnapshot(sandboxDir, "first", false))
+	require.NoError(t, RestoreSnapshot(sandboxDir, "first", true))
+	// The description is restored with the data
+	sbDesc, err = common.ReadSandboxDescription(sandboxDir)
+	require.NoError(t, err)
+	require.Equal(t, "8.0.36", sbDesc.Version)
+
+	require.NoError(t, DeleteSnapshot(sandboxDir, "first"))
+	require.NoDirExists(t, SnapshotsDir(sandboxDir))
+	require.Error(t, DeleteSnapshot(sandboxDir, "first"))
+}
+
+func TestSnapshotNameTraversal(t *testing.T) {
+	savedConfigurationDir := defaults.ConfigurationDir
+	defaults.ConfigurationDir = t.TempDir()
+	defer func() { defaults.ConfigurationDir = savedConfigurationDir }()
+
+	// A file outside the snapshots directory, reachable with a relative path
+	// from the directory where the snapshots of the sandbox are stored
+	sandboxDir := path.Join(t.TempDir(), "msb_8_0_36")
+	catalog := path.Join(defaults.ConfigurationDir, "sandboxes.json")
+	require.NoError(t, common.WriteString("{}", catalog))
+	require.NoError(t, common.WriteString("{}", path.Join(defaults.ConfigurationDir, "sandboxes.tar.gz")))
+
+	for _, name := range []string{"../../sandboxes", "..", ".", "a/b", ""} {
+		if name != "" {
+			require.Error(t, DeleteSnapshot(sandboxDir, name), "delete %q", name)
+		}
+		require.Error(t, RestoreSnapshot(sandboxDir, name, true), "restore %q", name)
+		_, err := GetSnapshot(sandboxDir, name)
+		require.Error(t, err, "get %q", name)
+	}
+	require.FileExists(t, catalog)
+}
+
+func TestSwapSnapshotItemsRollback(t *testing.T) {
+	sandboxDir := t.TempDir()
+	restoredDir := t.TempDir()
+	dataFile := path.Join(sandboxDir, globals.DataDirName, "t1.ibd")
+	require.NoError(t, os.MkdirAll(path.Dir(dataFile), globals.PublicDirectoryAttr))
+	require.NoError(t, common.WriteString("current", dataFile))
+	require.NoError(t, os.MkdirAll(path.Join(restoredDir, globals.DataDirName), globals.PublicDirectoryAttr))
+	require.NoError(t, common.WriteString("restored", path.Join(restoredDir, globals.DataDirName, "t1.ibd")))
+	// The second node doesn't exist in the sandbox, so its data directory can't be moved in place
+	require.NoError(t, os.MkdirAll(path.Join(restoredDir, "node2", globals.DataDirName), globals.PublicDirectoryAttr))
+
+	err := swapSnapshotItems(sandboxDir, restoredDir,
+		[]string{globals.DataDirName, path.Join("node2", globals.DataDirName)})
+	require.Error(t, err)
+	text, err := common.SlurpAsString(dataFile)
+	require.NoError(t, err)
+	require.Equal(t, "current", text)
+	require.NoDirExists(t, path.Join(sandboxDir, ".snapshot-previous"))
+}
@@ -0,0 +1,351 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+type SystemBinariesOptions struct {
+	SandboxBinary string
+	// Root of the file system where the packages are installed. When empty, it is "/"
+	SystemRoot string
+	Prefix     string
+	Flavor     string
+	Version    string
+	Overwrite  bool
+	// Keep an existing basedir when its links point to the binaries found
+	ReuseUnchanged bool
+	DryRun         bool
+}
+
+// SystemBinaries describes a basedir made of links to binaries installed
+// by the system packages. It is saved in the basedir as globals.SystemBinariesFileName
+type SystemBinaries struct {
+	Basedir       string            `json:"basedir"`
+	Flavor        string            `json:"flavor"`
+	Version       string            `json:"version"`
+	Mysqld        string            `json:"mysqld"`
+	Executables   map[string]string `json:"executables"`
+	PluginDir     string            `json:"plugin_dir"`
+	MessagesDir   string            `json:"messages_dir"`
+	ClientLibrary string            `json:"client_library"`
+}
+
+// Where the packages of the common distributions install the server
+var systemServerCandidates = []string{
+	"usr/sbin/mysqld",
+	"usr/libexec/mysqld",
+	"usr/bin/mysqld",
+	"usr/sbin/mariadbd",
+	"usr/libexec/mariadbd",
+	"usr/bin/mariadbd",
+}
+
+// Executables linked in the basedir bin directory, with the alternative names
+// that MariaDB packages use. The first three are needed to deploy a sandbox
+var systemExecutables = []struct {
+	name         string
+	alternatives []string
+	required     bool
+}{
+	{globals.FnMysql, []string{"mariadb"}, true},
+	{globals.FnMysqldSafe, []string{"mariadbd-safe"}, true},
+	{"mysqladmin", []string{"mariadb-admin"}, true},
+	{"mysqldump", []string{"mariadb-dump"}, false},
+	{"mysqlbinlog", []string{"mariadb-binlog"}, false},
+	{"mysqlcheck", []string{"mariadb-check"}, false},
+	{"mysqlimport", []string{"mariadb-import"}, false},
+	{"mysqlshow", []string{"mariadb-show"}, false},
+	{"mysqlslap", []string{"mariadb-slap"}, false},
+	{"mysqlpump", nil, false},
+	{"mysql_upgrade", []string{"mariadb-upgrade"}, false},
+	{"mysql_tzinfo_to_sql", []string{"mariadb-tzinfo-to-sql"}, false},
+	{"my_print_defaults", []string{"mariadb-print-defaults"}, false},
+	{"resolveip", []string{"mariadb-resolveip"}, false},
+	{globals.FnMysqlInstallDb, []string{"mariadb-install-db"}, false},
+}
+
+var systemPluginDirCandidates = []string{
+	"usr/lib/mysql/plugin",
+	"usr/lib64/mysql/plugin",
+	"usr/lib/mariadb/plugin",
+	"usr/lib64/mariadb/plugin",
+	"usr/lib/*-linux-gnu/mariadb*/plugin",
+}
+
+// Directories containing english/errmsg.sys, and the SQL scripts for mysql_install_db
+var systemMessagesDirCandidates = []string{
+	"usr/share/mysql",
+	"usr/share/mysql-*",
+	"usr/share/mariadb",
+}
+
+var systemLibraryDirCandidates = []string{
+	"usr/lib64",
+	"usr/lib64/mysql",
+	"usr/lib/*-linux-gnu",
+	"usr/lib",
+	"usr/lib/mysql",
+}
+
+// The client library for each flavor, and the name under which CheckTarballOperatingSystem looks for it
+var systemClientLibraries = map[string]struct {
+	pattern   string
+	linkName  string
+	extraLibs []string
+}{
+	common.MySQLFlavor:         {"libmysqlclient.so*", globals.FnLibMySQLClientSo, nil},
+	common.PerconaServerFlavor: {"libperconaserverclient.so*", globals.FnLibPerconaServerClientSo, []string{"libmysqlclient.so*"}},
+	common.MariaDbFlavor:       {"libmariadb.so*", globals.FnLibMariadbClientSo, []string{"libmariadbclient.so*"}},
+}
+
+// firstSystemPath returns the first path, relative to root, that matches one of the candidates
+// and satisfies the check
+func firstSystemPath(root string, candidates []string, check func(string) bool) string {
+	for _, candidate := range candidates {
+		matches, _ := filepath.Glob(path.Join(root, candidate))
+		sort.Strings(matches)
+		for _, match := range matches {
+			if check(match) {
+				return match
+			}
+		}
+	}
+	return ""
+}
+
+// detectSystemServer runs mysqld --version, and gets the version and flavor from its output:
+// "mysqld  Ver 8.0.36-0ubuntu0.22.04.1 for Linux on x86_64 ((Ubuntu))"
+func detectSystemServer(mysqld string) (version, flavor string, err error) {
+	out, err := common.RunCmdCtrlWithArgs(mysqld, []string{"--no-defaults", "--version"}, true)
+	if err != nil {
+		return "", "", fmt.Errorf("%s --version failed: %s", mysqld, err)
+	}
+	verList := regexp.MustCompile(`Ver\s+(\d+\.\d+\.\d+)`).FindStringSubmatch(out)
+	if verList == nil {
+		return "", "", fmt.Errorf("no version found in '%s'", strings.TrimSpace(out))
+	}
+	version = verList[1]
+	switch {
+	case strings.Contains(out, "MariaDB"):
+		flavor = common.MariaDbFlavor
+	case strings.Contains(out, "Percona"):
+		flavor = common.PerconaServerFlavor
+	default:
+		flavor = common.MySQLFlavor
+	}
+	return version, flavor, nil
+}
+
+// FindSystemBinaries looks for the server, client, plugins, and error messages installed
+// by the system packages (apt, yum, dnf) under options.SystemRoot
+func FindSystemBinaries(options SystemBinariesOptions) (SystemBinaries, error) {
+	var sb SystemBinaries
+	root := options.SystemRoot
+	if root == "" {
+		root = "/"
+	}
+	sb.Mysqld = firstSystemPath(root, systemServerCandidates, common.ExecExists)
+	if sb.Mysqld == "" {
+		return sb, fmt.Errorf("no MySQL server found in the system (looked for %s)", strings.Join(systemServerCandidates, ", "))
+	}
+	sb.Version = options.Version
+	sb.Flavor = options.Flavor
+	if sb.Version == "" || sb.Flavor == "" {
+		version, flavor, err := detectSystemServer(sb.Mysqld)
+		if err != nil {
+			return sb, err
+		}
+		if sb.Version == "" {
+			sb.Version = version
+		}
+		if sb.Flavor == "" {
+			sb.Flavor = flavor
+		}
+	}
+
+	sb.Executables = make(map[string]string)
+	binDirs := []string{path.Join(root, "usr", "bin"), path.Dir(sb.Mysqld)}
+	var missing []string
+	for _, executable := range systemExecutables {
+		for _, name := range append([]string{executable.name}, executable.alternatives...) {
+			for _, dir := range binDirs {
+				fullName := path.Join(dir, name)
+				if sb.Executables[executable.name] == "" && common.ExecExists(fullName) {
+					sb.Executables[executable.name] = fullName
+				}
+			}
+		}
+		if executable.required && sb.Executables[executable.name] == "" {
+			missing = append(missing, executable.name)
+		}
+	}
+	if len(missing) > 0 {
+		return sb, fmt.Errorf("executables %v not found in %v. Is the client package installed?", missing, binDirs)
+	}
+
+	sb.PluginDir = firstSystemPath(root, systemPluginDirCandidates, common.DirExists)
+	sb.MessagesDir = firstSystemPath(root, systemMessagesDirCandidates, func(dir string) bool {
+		return common.FileExists(path.Join(dir, "english", "errmsg.sys"))
+	})
+	if sb.MessagesDir == "" {
+		return sb, fmt.Errorf("no error messages directory (containing english/errmsg.sys) found in %s", strings.Join(systemMessagesDirCandidates, ", "))
+	}
+	if library, ok := systemClientLibraries[sb.Flavor]; ok {
+		var libraryPatterns []string
+		for _, pattern := range append([]string{library.pattern}, library.extraLibs...) {
+			for _, dir := range systemLibraryDirCandidates {
+				libraryPatterns = append(libraryPatterns, path.Join(dir, pattern))
+			}
+		}
+		sb.ClientLibrary = firstSystemPath(root, libraryPatterns, common.FileExists)
+	}
+	return sb, nil
+}
+
+// IsSystemBasedir tells whether a basedir was made from the system packages
+func IsSystemBasedir(basedir string) bool {
+	return common.FileExists(path.Join(basedir, globals.SystemBinariesFileName))
+}
+
+// systemLinks returns the links of a basedir made from system binaries,
+// relative to the basedir, with their targets
+func systemLinks(sb SystemBinaries) map[string]string {
+	links := map[string]string{
+		path.Join("bin", globals.FnMysqld): sb.Mysqld,
+		"share":                            sb.MessagesDir,
+	}
+	for name, source := range sb.Executables {
+		links[path.Join("bin", name)] = source
+	}
+	// mysql_install_db is searched in scripts
+	if installDb, ok := sb.Executables[globals.FnMysqlInstallDb]; ok {
+		links[path.Join("scripts", globals.FnMysqlInstallDb)] = installDb
+	}
+	if sb.PluginDir != "" {
+		links[path.Join("lib", "plugin")] = sb.PluginDir
+	}
+	if sb.ClientLibrary != "" {
+		links[path.Join("lib", systemClientLibraries[sb.Flavor].linkName)] = sb.ClientLibrary
+	}
+	return links
+}
+
+// systemBasedirUnchanged tells whether an existing basedir has the same description
+// and the same links that createSystemBasedir would make for sb
+func systemBasedirUnchanged(sb SystemBinaries) bool {
+	var saved SystemBinaries
+	text, err := common.SlurpAsBytes(path.Join(sb.Basedir, globals.SystemBinariesFileName))
+	if err != nil || json.Unmarshal(text, &saved) != nil || !reflect.DeepEqual(saved, sb) {
+		return false
+	}
+	for name, source := range systemLinks(sb) {
+		destination, err := os.Readlink(path.Join(sb.Basedir, name))
+		if err != nil || destination != source {
+			return false
+		}
+	}
+	return true
+}
+
+// createSystemBasedir makes the layout that dbdeployer expects from an expanded tarball,
+// with links to the files found by FindSystemBinaries
+func createSystemBasedir(sb SystemBinaries) error {
+	for _, dir := range []string{"bin", "lib", "scripts"} {
+		err := os.MkdirAll(path.Join(sb.Basedir, dir), globals.PublicDirectoryAttr)
+		if err != nil {
+			return err
+		}
+	}
+	links := systemLinks(sb)
+	for name, source := range links {
+		err := os.Symlink(source, path.Join(sb.Basedir, name))
+		if err != nil {
+			return err
+		}
+	}
+	err := common.WriteString(sb.Flavor, path.Join(sb.Basedir, globals.FlavorFileName))
+	if err != nil {
+		return err
+	}
+	text, err := json.MarshalIndent(sb, "", "  ")
+	if err != nil {
+		return err
+	}
+	return common.WriteString(string(text), path.Join(sb.Basedir, globals.SystemBinariesFileName))
+}
+
+// CreateSystemBasedir finds the binaries installed by the system packages, and links them
+// into a directory of sandbox-binary named after their version (system8.0.36), which can
+// then be used as if it were an expanded tarball
+func CreateSystemBasedir(options SystemBinariesOptions) (SystemBinaries, error) {
+	if !common.DirExists(options.SandboxBinary) {
+		return SystemBinaries{}, fmt.Errorf(globals.ErrDirectoryNotFound, options.SandboxBinary)
+	}
+	sb, err := FindSystemBinaries(options)
+	if err != nil {
+		return sb, err
+	}
+	prefix := options.Prefix
+	if prefix == "" {
+		prefix = globals.SystemBinariesPrefix
+	}
+	sb.Basedir = path.Join(options.SandboxBinary, prefix+sb.Version)
+
+	fmt.Printf("System %s server %s (%s)\n", sb.Flavor, sb.Version, sb.Mysqld)
+	fmt.Printf("%-15s %s\n", "plugins", common.CoalesceString(sb.PluginDir, "(not found)"))
+	fmt.Printf("%-15s %s\n", "messages", sb.MessagesDir)
+	fmt.Printf("%-15s %s\n", "client library", common.CoalesceString(sb.ClientLibrary, "(not found)"))
+	if options.DryRun {
+		fmt.Printf("Would create %s\n", sb.Basedir)
+		return sb, nil
+	}
+	if common.DirExists(sb.Basedir) {
+		if !IsSystemBasedir(sb.Basedir) {
+			return sb, fmt.Errorf("directory %s already exists, and it was not made from system binaries", sb.Basedir)
+		}
+		if options.ReuseUnchanged && systemBasedirUnchanged(sb) {
+			fmt.Printf("Using %s\n", sb.Basedir)
+			return sb, nil
+		}
+		if !options.Overwrite {
+			return sb, fmt.Errorf(globals.ErrNamedDirectoryAlreadyExists, "destination", sb.Basedir)
+		}
+		// Only links and small files: the system binaries are not touched
+		err = os.RemoveAll(sb.Basedir)
+		if err != nil {
+			return sb, err
+		}
+	}
+	err = createSystemBasedir(sb)
+	if err != nil {
+		return sb, fmt.Errorf("error creating %s: %s", sb.Basedir, err)
+	}
+	fmt.Printf("Created %s\n", sb.Basedir)
+	return sb, nil
+}
@@ -0,0 +1,146 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ops
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func createSystemRoot(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, contents := range files {
+		fileName := path.Join(root, name)
+		require.NoError(t, os.MkdirAll(path.Dir(fileName), globals.PublicDirectoryAttr))
+		require.NoError(t, os.WriteFile(fileName, []byte(contents), globals.ExecutableFileAttr))
+	}
+	return root
+}
+
+func TestCreateSystemBasedir(t *testing.T) {
+	// Layout of the Debian/Ubuntu packages
+	root := createSystemRoot(t, map[string]string{
+		"usr/sbin/mysqld":                               "#!/bin/sh\necho \"/usr/sbin/mysqld  Ver 8.0.36-0ubuntu0.22.04.1 for Linux on x86_64 ((Ubuntu))\"\n",
+		"usr/bin/mysql":                                 "#!/bin/sh\n",
+		"usr/bin/mysqladmin":                            "#!/bin/sh\n",
+		"usr/bin/mysqld_safe":                           "#!/bin/sh\n",
+		"usr/bin/mysqldump":                             "#!/bin/sh\n",
+		"usr/lib/mysql/plugin/auth_socket.so":           "plugin",
+		"usr/share/mysql-8.0/english/errmsg.sys":        "messages",
+		"usr/lib/x86_64-linux-gnu/libmysqlclient.so.21": "library",
+	})
+	sandboxBinary := t.TempDir()
+	options := SystemBinariesOptions{SandboxBinary: sandboxBinary, SystemRoot: root}
+
+	sb, err := CreateSystemBasedir(options)
+	require.NoError(t, err)
+	basedir := path.Join(sandboxBinary, "system8.0.36")
+	require.Equal(t, basedir, sb.Basedir)
+	require.Equal(t, common.MySQLFlavor, sb.Flavor)
+	require.Equal(t, "8.0.36", sb.Version)
+	require.True(t, IsSystemBasedir(basedir))
+
+	expectedLinks := map[string]string{
+		"bin/mysqld":            "usr/sbin/mysqld",
+		"bin/mysql":             "usr/bin/mysql",
+		"bin/mysqldump":         "usr/bin/mysqldump",
+		"lib/plugin":            "usr/lib/mysql/plugin",
+		"share":                 "usr/share/mysql-8.0",
+		"lib/libmysqlclient.so": "usr/lib/x86_64-linux-gnu/libmysqlclient.so.21",
+	}
+	for link, target := range expectedLinks {
+		destination, err := os.Readlink(path.Join(basedir, link))
+		require.NoError(t, err)
+		require.Equal(t, path.Join(root, target), destination)
+	}
+	require.NoFileExists(t, path.Join(basedir, "bin", "mysqlbinlog"))
+
+	// The synthetic basedir is accepted as an expanded tarball
+	require.Equal(t, common.MySQLFlavor, common.DetectBinaryFlavor(basedir))
+	require.NoError(t, common.CheckTarballOperatingSystem(basedir))
+	require.FileExists(t, path.Join(basedir, "share", "english", "errmsg.sys"))
+
+	_, err = CreateSystemBasedir(options)
+	require.Error(t, err, "existing basedir without overwrite")
+	options.Overwrite = true
+	_, err = CreateSystemBasedir(options)
+	require.NoError(t, err)
+
+	// An unchanged basedir is reused, while one with different links is rebuilt
+	options.ReuseUnchanged = true
+	marker := path.Join(basedir, "marker")
+	require.NoError(t, common.WriteString("reused", marker))
+	_, err = CreateSystemBasedir(options)
+	require.NoError(t, err)
+	require.FileExists(t, marker)
+	require.NoError(t, os.Remove(path.Join(basedir, "bin", "mysqldump")))
+	require.NoError(t, os.Symlink(path.Join(root, "usr/bin/mysql"), path.Join(basedir, "bin", "mysqldump")))
+	_, err = CreateSystemBasedir(options)
+	require.NoError(t, err)
+	require.NoFileExists(t, marker)
+	destination, err := os.Readlink(path.Join(basedir, "bin", "mysqldump"))
+	require.NoError(t, err)
+	require.Equal(t, path.Join(root, "usr/bin/mysqldump"), destination)
+
+	// A directory with the same name that was not made from system binaries is never removed
+	require.NoError(t, os.RemoveAll(basedir))
+	require.NoError(t, os.MkdirAll(path.Join(basedir, "bin"), globals.PublicDirectoryAttr))
+	_, err = CreateSystemBasedir(options)
+	require.Error(t, err)
+	require.DirExists(t, path.Join(basedir, "bin"))
+}
+
+func TestFindSystemBinaries(t *testing.T) {
+	// Layout of the RHEL/Fedora MariaDB packages
+	root := createSystemRoot(t, map[string]string{
+		"usr/libexec/mariadbd":                 "#!/bin/sh\necho \"/usr/libexec/mariadbd  Ver 10.11.6-MariaDB for Linux on x86_64 (MariaDB Server)\"\n",
+		"usr/bin/mariadb":                      "#!/bin/sh\n",
+		"usr/bin/mariadb-admin":                "#!/bin/sh\n",
+		"usr/bin/mysqld_safe":                  "#!/bin/sh\n",
+		"usr/bin/mariadb-install-db":           "#!/bin/sh\n",
+		"usr/lib64/mariadb/plugin/auth_pam.so": "plugin",
+		"usr/share/mariadb/english/errmsg.sys": "messages",
+		"usr/lib64/libmariadb.so.3":            "library",
+	})
+	sb, err := FindSystemBinaries(SystemBinariesOptions{SystemRoot: root})
+	require.NoError(t, err)
+	require.Equal(t, common.MariaDbFlavor, sb.Flavor)
+	require.Equal(t, "10.11.6", sb.Version)
+	require.Equal(t, path.Join(root, "usr/libexec/mariadbd"), sb.Mysqld)
+	require.Equal(t, path.Join(root, "usr/bin/mariadb"), sb.Executables[globals.FnMysql])
+	require.Equal(t, path.Join(root, "usr/bin/mariadb-install-db"), sb.Executables[globals.FnMysqlInstallDb])
+	require.Equal(t, path.Join(root, "usr/lib64/mariadb/plugin"), sb.PluginDir)
+	require.Equal(t, path.Join(root, "usr/share/mariadb"), sb.MessagesDir)
+	require.Equal(t, path.Join(root, "usr/lib64/libmariadb.so.3"), sb.ClientLibrary)
+
+	sb, err = FindSystemBinaries(SystemBinariesOptions{SystemRoot: root, Version: "10.11.5", Flavor: common.MySQLFlavor})
+	require.NoError(t, err)
+	require.Equal(t, "10.11.5", sb.Version)
+	require.Equal(t, common.MySQLFlavor, sb.Flavor)
+
+	require.NoError(t, os.Remove(path.Join(root, "usr/bin/mariadb-admin")))
+	_, err = FindSystemBinaries(SystemBinariesOptions{SystemRoot: root})
+	require.Error(t, err, "missing client")
+
+	_, err = FindSystemBinaries(SystemBinariesOptions{SystemRoot: t.TempDir()})
+	require.Error(t, err, "no server installed")
+}
@@ -23,6 +23,8 @@ import (
 	"strings"
 
 	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/downloads"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/datacharmer/dbdeployer/unpack"
 )
@@ -38,6 +40,48 @@ type UnpackOptions struct {
 	IsShell       bool
 	Overwrite     bool
 	DryRun        bool
+	// Refuse tarballs without a signature that matches a key in defaults.KeysDir
+	RequireSignature bool
+	// Remove from the unpacked directory what a sandbox does not need (see MinimizeBinaries)
+	MakeMinimal  bool
+	StripSymbols bool
+	PackDir      string
+	// RPM or DEB packages extracted into the same directory as TarballName, when it is a package
+	ExtraPackages []string
+}
+
+// signatureError is returned when a tarball is rejected for its signature
+type signatureError struct {
+	error
+}
+
+// checkTarballSignature verifies a tarball against the detached signature stored next to it.
+// It returns nil when there is no signature and none is required
+func checkTarballSignature(tarball string, required bool) (*downloads.SignatureVerification, error) {
+	signature := tarball + globals.SignatureExt
+	if !common.FileExists(signature) {
+		if required {
+			return nil, signatureError{fmt.Errorf("signature %s not found: the tarball can't be verified", signature)}
+		}
+		return nil, nil
+	}
+	keyring, err := downloads.ReadKeyring(defaults.KeysDir)
+	if err != nil {
+		return nil, err
+	}
+	result := downloads.VerifyTarballSignature(tarball, signature, keyring)
+	if !result.Verified && required {
+		return nil, signatureError{fmt.Errorf("signature verification failed: %s", result.Error)}
+	}
+	return &result, nil
+}
+
+func reportSignature(result downloads.SignatureVerification) {
+	if result.Verified {
+		fmt.Printf("Signature verified: key %s %s\n", result.KeyId, result.Signer)
+	} else {
+		fmt.Printf("WARNING: signature not verified: %s\n", result.Error)
+	}
 }
 
 func UnpackTarball(options UnpackOptions) error {
@@ -61,6 +105,9 @@ func UnpackTarball(options UnpackOptions) error {
 	if !isShell && target != "" {
 		return fmt.Errorf("unpack: Option --target-server can only be used with --shell")
 	}
+	if isShell && options.MakeMinimal {
+		return fmt.Errorf("unpack: Option --%s can't be used with --shell", globals.MakeMinimalLabel)
+	}
 
 	overwrite := options.Overwrite
 	flavor := options.Flavor
@@ -119,6 +166,15 @@ func UnpackTarball(options UnpackOptions) error {
 			return fmt.Errorf(globals.ErrNamedDirectoryAlreadyExists, "destination directory", destination)
 		}
 	}
+	if unpack.IsPackage(tarball) {
+		if isShell {
+			return fmt.Errorf("unpack: Option --%s can't be used with packages", globals.ShellLabel)
+		}
+		return unpackPackages(options, destination, flavor, Version)
+	}
+	if len(options.ExtraPackages) > 0 {
+		return fmt.Errorf("unpack: only RPM or DEB packages can be unpacked together")
+	}
 	extracted := path.Base(tarball)
 	var bareName string
 
@@ -132,14 +188,28 @@ func UnpackTarball(options UnpackOptions) error {
 	case strings.HasSuffix(tarball, globals.TarXzExt):
 		extractFunc = unpack.UnpackXzTar
 		foundExtension = globals.TarXzExt
+	case strings.HasSuffix(tarball, globals.TarZstExt):
+		extractFunc = unpack.UnpackZstTar
+		foundExtension = globals.TarZstExt
+	case strings.HasSuffix(tarball, globals.ZipExt):
+		extractFunc = unpack.UnpackZip
+		foundExtension = globals.ZipExt
 	default:
-		return fmt.Errorf("tarball extension must be either '%s' or '%s'", globals.TarGzExt, globals.TarXzExt)
+		return fmt.Errorf("tarball extension must be one of '%s', '%s', '%s', '%s', '%s', or '%s'",
+			globals.TarGzExt, globals.TarXzExt, globals.TarZstExt, globals.ZipExt, globals.RpmExt, globals.DebExt)
 	}
 	err = unpack.VerifyTarFile(tarball)
 	if err != nil {
 		return fmt.Errorf("validation for %s failed: %s", tarball, err)
 	}
-	bareName = extracted[0 : len(extracted)-len(globals.TarGzExt)]
+	signature, err := checkTarballSignature(tarball, options.RequireSignature)
+	if err != nil {
+		return err
+	}
+	if signature != nil && verbosity > 0 {
+		reportSignature(*signature)
+	}
+	bareName = extracted[0 : len(extracted)-len(foundExtension)]
 	if isShell {
 		common.CondPrintf("Merging shell tarball %s to %s\n", common.ReplaceLiteralHome(tarball), common.ReplaceLiteralHome(destination))
 		if !dryRun {
@@ -173,9 +243,108 @@ func UnpackTarball(options UnpackOptions) error {
 			return err
 		}
 	}
-	err = common.WriteString(flavor, path.Join(destination, globals.FlavorFileName))
+	return completeUnpack(options, destination, flavor, Version, signature)
+}
+
+// completeUnpack records flavor and signature in a newly unpacked directory, and reduces it if requested
+func completeUnpack(options UnpackOptions, destination, flavor, Version string, signature *downloads.SignatureVerification) error {
+	err := common.WriteString(flavor, path.Join(destination, globals.FlavorFileName))
 	if err != nil {
 		return fmt.Errorf("error writing %s in %s: %s", globals.FlavorFileName, destination, err)
 	}
+	if signature != nil {
+		err = downloads.WriteSignatureVerification(destination, *signature)
+		if err != nil {
+			return fmt.Errorf("error writing %s in %s: %s", globals.SignatureFileName, destination, err)
+		}
+	}
+	if options.MakeMinimal {
+		minimizeOptions := MinimizeOptions{
+			Basedir:     destination,
+			Flavor:      flavor,
+			Version:     Version,
+			Strip:       options.StripSymbols,
+			PackDir:     options.PackDir,
+			TarballName: path.Base(options.TarballName),
+		}
+		result, err := MinimizeBinaries(minimizeOptions)
+		if err != nil {
+			return err
+		}
+		if options.Verbosity > 0 {
+			ReportMinimize(minimizeOptions, result)
+		}
+	}
 	return nil
 }
+
+// unpackPackages extracts one or more RPM or DEB packages (server, client, libraries)
+// into destination, with the layout of an expanded tarball
+func unpackPackages(options UnpackOptions, destination, flavor, Version string) error {
+	var packages []string
+	var signature *downloads.SignatureVerification
+	for _, pkg := range append([]string{options.TarballName}, options.ExtraPackages...) {
+		if !unpack.IsPackage(pkg) {
+			return fmt.Errorf("%s is not a RPM or DEB package: only packages can be unpacked together", pkg)
+		}
+		if !common.FileExists(pkg) {
+			return fmt.Errorf(globals.ErrFileNotFound, pkg)
+		}
+		// The extraction changes the current directory
+		fullPath, err := common.AbsolutePath(pkg)
+		if err != nil {
+			return err
+		}
+		pkgSignature, err := checkTarballSignature(fullPath, options.RequireSignature)
+		if err != nil {
+			return err
+		}
+		if pkgSignature != nil && options.Verbosity > 0 {
+			reportSignature(*pkgSignature)
+		}
+		// The verification of the first package is the one recorded
+		if signature == nil {
+			signature = pkgSignature
+		}
+		packages = append(packages, fullPath)
+	}
+	for _, pkg := range packages {
+		common.CondPrintf("Unpacking package %s to %s\n", pkg, common.ReplaceLiteralHome(destination))
+	}
+	if options.DryRun {
+		return nil
+	}
+	for _, pkg := range packages {
+		extractFunc := unpack.UnpackRpm
+		if strings.HasSuffix(pkg, globals.DebExt) {
+			extractFunc = unpack.UnpackDeb
+		}
+		err := extractFunc(pkg, destination, options.Verbosity)
+		if err != nil {
+			return err
+		}
+	}
+	// Recent MariaDB packages install the server as mariadbd
+	mysqld := path.Join(destination, "bin", globals.FnMysqld)
+	if !common.ExecExists(mysqld) && common.ExecExists(path.Join(destination, "bin", "mariadbd")) {
+		err := os.Symlink("mariadbd", mysqld)
+		if err != nil {
+			return err
+		}
+	}
+	// mysql_install_db is searched in scripts, as in the tarballs that need it
+	for _, installDb := range []string{globals.FnMysqlInstallDb, "mariadb-install-db"} {
+		scriptsInstallDb := path.Join(destination, "scripts", globals.FnMysqlInstallDb)
+		if common.ExecExists(path.Join(destination, "bin", installDb)) && !common.FileExists(scriptsInstallDb) {
+			err := os.MkdirAll(path.Join(destination, "scripts"), globals.PublicDirectoryAttr)
+			if err != nil {
+				return err
+			}
+			err = os.Symlink(path.Join("..", "bin", installDb), scriptsInstallDb)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return completeUnpack(options, destination, flavor, Version, signature)
+}
@@ -17,6 +17,7 @@ package ops
 
 import (
 	"fmt"
+	"path"
 
 	"github.com/datacharmer/dbdeployer/common"
 )
@@ -34,6 +35,33 @@ func validateVersionOptions(options VersionOptions) error {
 	return nil
 }
 
+// VersionRecord describes a directory of database binaries
+type VersionRecord struct {
+	Version string `json:"version"`
+	Flavor  string `json:"flavor"`
+	Basedir string `json:"basedir"`
+}
+
+// GetVersions returns the directories of binaries in SandboxBinary,
+// filtered by flavor when one is given
+func GetVersions(options VersionOptions) ([]VersionRecord, error) {
+	err := validateVersionOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	records := []VersionRecord{}
+	for _, verInfo := range common.GetVersionInfoFromDir(options.SandboxBinary) {
+		if options.Flavor == verInfo.Flavor || options.Flavor == "" {
+			records = append(records, VersionRecord{
+				Version: verInfo.Version,
+				Flavor:  verInfo.Flavor,
+				Basedir: path.Join(options.SandboxBinary, verInfo.Version),
+			})
+		}
+	}
+	return records, nil
+}
+
 func ShowVersions(options VersionOptions) error {
 	err := validateVersionOptions(options)
 	if err != nil {
@@ -0,0 +1,379 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// PartialSuffix is added to the name of a file while it is being downloaded.
+// A download that finds the partial file resumes from its end
+const PartialSuffix = ".partial"
+
+// DefaultMinParallelSize is the smallest file that is downloaded in parallel byte ranges,
+// unless DownloadOptions.MinParallelSize says otherwise
+const DefaultMinParallelSize int64 = 64 * globals.MB
+
+const maxDownloadRetries = 10
+
+// DownloadOptions defines how DownloadFileWithOptions retrieves a file
+type DownloadOptions struct {
+	Progress        bool   // Show the progress of the download
+	ProgressStep    int64  // How many bytes for each progress dot
+	Retries         int64  // How many attempts are made before giving up (max 10). 0 or 1 for a single attempt
+	Checksum        string // Expected checksum, as "SHA256:xxxx" or "MD5:xxxx". Empty for no check
+	Parallel        int    // How many byte ranges are downloaded at once. 0 or 1 for a single stream
+	MinParallelSize int64  // Files smaller than this size are downloaded with a single stream
+}
+
+// DownloadFileWithOptions downloads a url to a local file.
+// The data is written to fileName+PartialSuffix, and the file gets its final name only
+// when the download is complete and the checksum, if given, matches.
+// An interrupted download is resumed with HTTP Range requests when retrying.
+// A partial file left by a previous call is only resumed when options.Checksum is set,
+// so that its contents can be verified. Otherwise, the download starts from scratch.
+// When options.Parallel is greater than 1 and the server accepts ranges, a file larger than
+// options.MinParallelSize is downloaded as several byte ranges at once.
+func DownloadFileWithOptions(fileName string, url string, options DownloadOptions) error {
+	var hasher hash.Hash
+	var crcType, crcText string
+	var err error
+	if options.Checksum != "" {
+		crcType, crcText, err = common.ParseChecksum(options.Checksum)
+		if err != nil {
+			return err
+		}
+		hasher, err = common.NewChecksumHasher(crcType)
+		if err != nil {
+			return err
+		}
+	}
+	if options.Retries > maxDownloadRetries {
+		options.Retries = maxDownloadRetries
+	}
+	if options.MinParallelSize <= 0 {
+		options.MinParallelSize = DefaultMinParallelSize
+	}
+	progress := newPassThru(nil, options.Progress, options.ProgressStep)
+	partialName := fileName + PartialSuffix
+	if hasher == nil {
+		removePartialFiles(partialName, options.Parallel)
+	}
+
+	var size int64
+	if options.Parallel > 1 {
+		size = rangeSize(url)
+	}
+	if size > 0 && size >= options.MinParallelSize {
+		for i := 0; i < options.Parallel; i++ {
+			progress.skip(fileSize(partName(partialName, i)))
+		}
+		err = downloadParallel(partialName, url, size, options, progress, hasher)
+	} else {
+		progress.skip(fileSize(partialName))
+		err = withRetries(options.Retries, func() error {
+			return downloadRange(partialName, url, 0, -1, progress, hasher)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("[DownloadFileWithOptions] error downloading %s: %s", url, err)
+	}
+	progress.done()
+
+	if hasher != nil {
+		localChecksum := hex.EncodeToString(hasher.Sum(nil))
+		if !strings.EqualFold(localChecksum, crcText) {
+			_ = os.Remove(partialName)
+			return fmt.Errorf("[DownloadFileWithOptions] unmatched checksum for %s: expected '%s' but found '%s'",
+				url, crcText, localChecksum)
+		}
+		fmt.Println("Checksum matches")
+	}
+	err = os.Rename(partialName, fileName)
+	if err != nil {
+		return fmt.Errorf("[DownloadFileWithOptions] error renaming %s to %s: %s", partialName, fileName, err)
+	}
+	return nil
+}
+
+// withRetries runs a download until it succeeds, or until it has failed 'retries' times.
+// The download is always attempted at least once
+func withRetries(retries int64, download func() error) error {
+	var attempts int64 = 1
+	err := download()
+	for err != nil && attempts < retries {
+		time.Sleep(time.Second)
+		err = download()
+		attempts++
+	}
+	if err != nil && attempts > 1 {
+		return fmt.Errorf("%s (attempts: %d)", err, attempts)
+	}
+	return err
+}
+
+// rangeSize returns the size of the remote file if the server accepts byte ranges, or 0 otherwise
+func rangeSize(url string) int64 {
+	resp, err := http.Head(url) // #nosec G107
+	if err != nil {
+		return 0
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0
+	}
+	if resp.ContentLength < 0 {
+		return 0
+	}
+	return resp.ContentLength
+}
+
+// removePartialFiles removes the partial file and the parts of a parallel download
+func removePartialFiles(partialName string, parts int) {
+	_ = os.Remove(partialName)
+	for i := 0; i < parts; i++ {
+		_ = os.Remove(partName(partialName, i))
+	}
+}
+
+func fileSize(fileName string) int64 {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
+
+// hashFile adds the first 'size' bytes of a file to the hasher
+func hashFile(hasher hash.Hash, fileName string, size int64) error {
+	f, err := os.Open(fileName) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer f.Close() // #nosec G307
+	_, err = io.CopyN(hasher, f, size)
+	return err
+}
+
+var reContentRange = regexp.MustCompile(`^bytes\s+(?:(\d+)-\d+|\*)/(\d+|\*)`)
+
+// parseContentRange returns the start and the total size from a Content-Range header.
+// Either value is -1 when it is not known
+func parseContentRange(header string) (start, total int64) {
+	start, total = -1, -1
+	matches := reContentRange.FindStringSubmatch(header)
+	if len(matches) < 3 {
+		return
+	}
+	if matches[1] != "" {
+		start, _ = strconv.ParseInt(matches[1], 10, 64)
+	}
+	if matches[2] != "*" {
+		total, _ = strconv.ParseInt(matches[2], 10, 64)
+	}
+	return
+}
+
+// downloadRange downloads the bytes from 'start' to 'end' (or to the end of the file when end is -1)
+// into partialName, starting from where a previous attempt stopped.
+// When hasher is not nil, it gets all the contents of partialName.
+func downloadRange(partialName, url string, start, end int64, progress *PassThru, hasher hash.Hash) error {
+	offset := fileSize(partialName)
+	if end >= 0 && start+offset > end {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 || start > 0 || end >= 0 {
+		endText := ""
+		if end >= 0 {
+			endText = fmt.Sprintf("%d", end)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%s", start+offset, endText))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			fmt.Printf("[downloadRange] error closing response body: %s", err)
+		}
+	}(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if start > 0 || end >= 0 {
+			return fmt.Errorf("the server does not support byte ranges")
+		}
+		// The server sends the whole file: the partial one is discarded
+		offset = 0
+	case http.StatusPartialContent:
+		rangeStart, _ := parseContentRange(resp.Header.Get("Content-Range"))
+		if rangeStart != start+offset {
+			return fmt.Errorf("requested bytes from %d, but received from %d", start+offset, rangeStart)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may already have all the contents
+		_, total := parseContentRange(resp.Header.Get("Content-Range"))
+		if end < 0 && offset > 0 && total == offset {
+			if hasher != nil {
+				hasher.Reset()
+				return hashFile(hasher, partialName, offset)
+			}
+			return nil
+		}
+		_ = os.Remove(partialName)
+		return fmt.Errorf("received code %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("received code %d", resp.StatusCode)
+	}
+
+	out, err := os.OpenFile(partialName, os.O_CREATE|os.O_WRONLY, 0644) // #nosec G304
+	if err != nil {
+		return fmt.Errorf("error opening file %s: %s", partialName, err)
+	}
+	defer out.Close() // #nosec G307
+	err = out.Truncate(offset)
+	if err != nil {
+		return err
+	}
+	_, err = out.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	var writer io.Writer = out
+	if hasher != nil {
+		hasher.Reset()
+		if offset > 0 {
+			err = hashFile(hasher, partialName, offset)
+			if err != nil {
+				return err
+			}
+		}
+		writer = io.MultiWriter(out, hasher)
+	}
+	_, err = io.Copy(writer, &rangeReader{Reader: resp.Body, progress: progress})
+	if err != nil {
+		return fmt.Errorf("error during data writing to file %s: %s", partialName, err)
+	}
+	if end >= 0 {
+		expected := end - start + 1
+		if size := fileSize(partialName); size != expected {
+			return fmt.Errorf("range %d-%d incomplete: expected %d bytes, found %d", start, end, expected, size)
+		}
+	}
+	return nil
+}
+
+// rangeReader counts the bytes of one download into a progress shared by several ones
+type rangeReader struct {
+	io.Reader
+	progress *PassThru
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.progress.count(int64(n))
+	return n, err
+}
+
+func partName(partialName string, part int) string {
+	return fmt.Sprintf("%s.%d", partialName, part)
+}
+
+// downloadParallel downloads a file of the given size as options.Parallel byte ranges at once,
+// and then joins them into partialName.
+// Each range is saved in its own file, so that it can be resumed independently.
+func downloadParallel(partialName, url string, size int64, options DownloadOptions, progress *PassThru, hasher hash.Hash) error {
+	parts := options.Parallel
+	partSize := size / int64(parts)
+	if size%int64(parts) != 0 {
+		partSize++
+	}
+	// Very small files may need fewer parts
+	parts = int((size + partSize - 1) / partSize)
+	var wg sync.WaitGroup
+	errs := make([]error, parts)
+	for i := 0; i < parts; i++ {
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		wg.Add(1)
+		go func(part int, start, end int64) {
+			defer wg.Done()
+			errs[part] = withRetries(options.Retries, func() error {
+				return downloadRange(partName(partialName, part), url, start, end, progress, nil)
+			})
+		}(i, start, end)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("part %d: %s", i, err)
+		}
+	}
+
+	out, err := os.Create(partialName) // #nosec G304
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %s", partialName, err)
+	}
+	defer out.Close() // #nosec G307
+	var writer io.Writer = out
+	if hasher != nil {
+		hasher.Reset()
+		writer = io.MultiWriter(out, hasher)
+	}
+	for i := 0; i < parts; i++ {
+		err = appendFile(writer, partName(partialName, i))
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < parts; i++ {
+		_ = os.Remove(partName(partialName, i))
+	}
+	return nil
+}
+
+func appendFile(writer io.Writer, fileName string) error {
+	f, err := os.Open(fileName) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer f.Close() // #nosec G307
+	_, err = io.Copy(writer, f)
+	return err
+}
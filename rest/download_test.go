@@ -0,0 +1,256 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2020 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package rest
+
+import (
+	"bytes"
+	"crypto/md5" // #nosec G501
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+// testServer serves 'contents' with byte ranges, and records the requested ranges.
+// The first 'failures' requests send only half of the data they promise.
+type testServer struct {
+	contents     []byte
+	failures     int
+	acceptRanges bool
+	mutex        sync.Mutex
+	ranges       []string
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.mutex.Lock()
+	if r.Method == http.MethodGet {
+		ts.ranges = append(ts.ranges, r.Header.Get("Range"))
+	}
+	fail := r.Method == http.MethodGet && ts.failures > 0
+	if fail {
+		ts.failures--
+	}
+	ts.mutex.Unlock()
+
+	if !ts.acceptRanges {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(ts.contents)))
+		_, _ = w.Write(ts.contents)
+		return
+	}
+	if fail {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(ts.contents)))
+		_, _ = w.Write(ts.contents[:len(ts.contents)/2])
+		panic(http.ErrAbortHandler)
+	}
+	http.ServeContent(w, r, "file.tar.xz", time.Time{}, bytes.NewReader(ts.contents))
+}
+
+func testContents(size int) []byte {
+	var contents []byte
+	for i := 0; len(contents) < size; i++ {
+		contents = append(contents, []byte(fmt.Sprintf("line %d\n", i))...)
+	}
+	return contents[:size]
+}
+
+func sha256Checksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return "SHA256:" + hex.EncodeToString(sum[:])
+}
+
+func md5Checksum(contents []byte) string {
+	sum := md5.Sum(contents) // #nosec G401
+	return "MD5:" + hex.EncodeToString(sum[:])
+}
+
+func TestDownloadFileWithOptions(t *testing.T) {
+	contents := testContents(100000)
+
+	type downloadTest struct {
+		name           string
+		server         *testServer
+		existing       []byte
+		options        DownloadOptions
+		expectedRanges []string
+		requests       int
+		wantError      bool
+		keepPartial    bool
+	}
+	var tests = []downloadTest{
+		{
+			name:           "plain",
+			server:         &testServer{contents: contents, acceptRanges: true},
+			options:        DownloadOptions{Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{""},
+		},
+		{
+			name:           "resume-partial",
+			server:         &testServer{contents: contents, acceptRanges: true},
+			existing:       contents[:30000],
+			options:        DownloadOptions{Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{"bytes=30000-"},
+		},
+		{
+			name:           "complete-partial",
+			server:         &testServer{contents: contents, acceptRanges: true},
+			existing:       contents,
+			options:        DownloadOptions{Checksum: md5Checksum(contents)},
+			expectedRanges: []string{"bytes=100000-"},
+		},
+		{
+			name:           "partial-without-checksum",
+			server:         &testServer{contents: contents, acceptRanges: true},
+			existing:       bytes.Repeat([]byte("x"), 30000),
+			expectedRanges: []string{""},
+		},
+		{
+			name:           "no-ranges",
+			server:         &testServer{contents: contents},
+			existing:       contents[:30000],
+			options:        DownloadOptions{Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{"bytes=30000-"},
+		},
+		{
+			name:           "retry-resumes",
+			server:         &testServer{contents: contents, acceptRanges: true, failures: 1},
+			options:        DownloadOptions{Retries: 2, Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{"", "bytes=50000-"},
+		},
+		{
+			name:           "no-retries",
+			server:         &testServer{contents: contents, acceptRanges: true, failures: 1},
+			options:        DownloadOptions{Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{""},
+			wantError:      true,
+			keepPartial:    true,
+		},
+		{
+			name:           "one-attempt",
+			server:         &testServer{contents: contents, acceptRanges: true, failures: 1},
+			options:        DownloadOptions{Retries: 1, Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{""},
+			wantError:      true,
+			keepPartial:    true,
+		},
+		{
+			name:           "bad-checksum",
+			server:         &testServer{contents: contents, acceptRanges: true},
+			options:        DownloadOptions{Checksum: sha256Checksum(contents[1:])},
+			expectedRanges: []string{""},
+			wantError:      true,
+		},
+		{
+			name:           "corrupt-partial",
+			server:         &testServer{contents: contents, acceptRanges: true},
+			existing:       bytes.Repeat([]byte("x"), 30000),
+			options:        DownloadOptions{Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{"bytes=30000-"},
+			wantError:      true,
+		},
+		{
+			name:   "parallel",
+			server: &testServer{contents: contents, acceptRanges: true},
+			options: DownloadOptions{Parallel: 4, MinParallelSize: 1000,
+				Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{"bytes=0-24999", "bytes=25000-49999", "bytes=50000-74999", "bytes=75000-99999"},
+		},
+		{
+			name:   "parallel-small-file",
+			server: &testServer{contents: contents, acceptRanges: true},
+			options: DownloadOptions{Parallel: 4, MinParallelSize: 200000,
+				Checksum: sha256Checksum(contents)},
+			expectedRanges: []string{""},
+		},
+		{
+			name:   "parallel-retry",
+			server: &testServer{contents: contents, acceptRanges: true, failures: 1},
+			options: DownloadOptions{Parallel: 2, MinParallelSize: 1000, Retries: 2,
+				Checksum: sha256Checksum(contents)},
+			// Either part can fail, and it is downloaded again
+			requests: 3,
+		},
+	}
+
+	dir := t.TempDir()
+	for _, dt := range tests {
+		t.Run(dt.name, func(t *testing.T) {
+			server := httptest.NewServer(dt.server)
+			defer server.Close()
+			fileName := path.Join(dir, dt.name+".tar.xz")
+			partialName := fileName + PartialSuffix
+			if len(dt.existing) > 0 {
+				err := os.WriteFile(partialName, dt.existing, 0644)
+				compare.OkIsNil("partial file written", err, t)
+			}
+
+			err := DownloadFileWithOptions(fileName, server.URL+"/file.tar.xz", dt.options)
+			if dt.wantError {
+				compare.OkIsNotNil("download error", err, t)
+				compare.OkEqualBool("file not created", common.FileExists(fileName), false, t)
+				compare.OkEqualBool("partial file kept", common.FileExists(partialName), dt.keepPartial, t)
+			} else {
+				compare.OkIsNil("download", err, t)
+				downloaded, err := os.ReadFile(fileName)
+				compare.OkIsNil("downloaded file read", err, t)
+				compare.OkEqualBool("downloaded contents", bytes.Equal(downloaded, contents), true, t)
+				compare.OkEqualBool("partial file removed", common.FileExists(partialName), false, t)
+				for i := 0; i < dt.options.Parallel; i++ {
+					compare.OkEqualBool(fmt.Sprintf("part %d removed", i), common.FileExists(partName(partialName, i)), false, t)
+				}
+			}
+			if dt.requests == 0 {
+				dt.requests = len(dt.expectedRanges)
+			}
+			compare.OkEqualInt("requests", len(dt.server.ranges), dt.requests, t)
+			// The parts of a parallel download are requested in any order
+			for _, expected := range dt.expectedRanges {
+				found := false
+				for _, r := range dt.server.ranges {
+					if r == expected {
+						found = true
+					}
+				}
+				compare.OkEqualBool(fmt.Sprintf("range '%s' requested", expected), found, true, t)
+			}
+		})
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	var tests = []struct {
+		header string
+		start  int64
+		total  int64
+	}{
+		{"bytes 100-199/1000", 100, 1000},
+		{"bytes 0-0/*", 0, -1},
+		{"bytes */1000", -1, 1000},
+		{"", -1, -1},
+	}
+	for _, pt := range tests {
+		start, total := parseContentRange(pt.header)
+		compare.OkEqualInt(fmt.Sprintf("start of '%s'", pt.header), int(start), int(pt.start), t)
+		compare.OkEqualInt(fmt.Sprintf("total of '%s'", pt.header), int(total), int(pt.total), t)
+	}
+}
@@ -21,7 +21,7 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"time"
+	"sync"
 
 	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
@@ -101,6 +101,53 @@ type PassThru struct {
 	stepProgress    int64 // Bytes to calculate towards showing the dot
 	markProgress    int64 // Bytes to calculate towards showing the amount
 	showProgress    bool  // Shall we show the progress at all
+	mutex           sync.Mutex
+}
+
+func newPassThru(reader io.Reader, progress bool, progressStep int64) *PassThru {
+	if progressStep <= 0 {
+		progress = false
+	}
+	return &PassThru{
+		Reader:          reader,
+		maxBytesPerDot:  progressStep,
+		maxBytesPerMark: progressStep * 10,
+		showProgress:    progress,
+	}
+}
+
+// count adds n bytes to the total, and shows the progress.
+// It can be called by several downloads at once
+func (pt *PassThru) count(n int64) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	pt.total += n
+	pt.stepProgress += n
+	pt.markProgress += n
+
+	if pt.showProgress && pt.stepProgress >= pt.maxBytesPerDot {
+		if pt.markProgress >= pt.maxBytesPerMark {
+			fmt.Print(humanize.Bytes(uint64(pt.total)))
+			pt.markProgress -= pt.maxBytesPerMark
+		} else {
+			fmt.Print(".")
+		}
+		pt.stepProgress -= pt.maxBytesPerDot
+	}
+}
+
+// skip adds to the total the bytes that were transferred before, without showing them as progress
+func (pt *PassThru) skip(n int64) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+	pt.total += n
+}
+
+// done shows the final amount transferred
+func (pt *PassThru) done() {
+	if pt.showProgress {
+		fmt.Println(" ", humanize.Bytes(uint64(pt.total)))
+	}
 }
 
 // Read 'overrides' the underlying io.Reader's Read method.
@@ -108,23 +155,13 @@ type PassThru struct {
 // use it to keep track of byte counts and then forward the call.
 func (pt *PassThru) Read(p []byte) (int, error) {
 	n, err := pt.Reader.Read(p)
-	pt.total += int64(n)
-	pt.stepProgress += int64(n)
-	pt.markProgress += int64(n)
-
 	if err == nil {
-		if pt.showProgress && pt.stepProgress >= pt.maxBytesPerDot {
-			if pt.markProgress >= pt.maxBytesPerMark {
-				fmt.Print(humanize.Bytes(uint64(pt.total)))
-				pt.markProgress -= pt.maxBytesPerMark
-			} else {
-				fmt.Print(".")
-			}
-			pt.stepProgress -= pt.maxBytesPerDot
-		}
+		pt.count(int64(n))
+	} else {
+		pt.skip(int64(n))
 	}
-	if pt.showProgress && err == io.EOF {
-		fmt.Println(" ", humanize.Bytes(uint64(pt.total)))
+	if err == io.EOF {
+		pt.done()
 	}
 	return n, err
 }
@@ -137,60 +174,13 @@ func DownloadFile(filepath string, url string, progress bool, progressStep int64
 
 // DownloadFileWithRetry will download a url to a local file. It's efficient because it will
 // write as it downloads and not load the whole file into memory.
+// When the download fails, it is resumed from where it stopped, up to retriesOnFailure times.
 func DownloadFileWithRetry(filepath string, url string, progress bool, progressStep, retriesOnFailure int64) error {
-
-	// Get the data
-	var resp *http.Response
-	var err error
-	var attempts int64 = 1
-	if retriesOnFailure > 10 {
-		retriesOnFailure = 10
-	}
-	resp, err = http.Get(url) // #nosec G107
-	for err != nil && attempts < retriesOnFailure {
-		time.Sleep(time.Second)
-		resp, err = http.Get(url) // #nosec G107
-		attempts++
-	}
-	if err != nil {
-		return fmt.Errorf("[DownloadFileWithRetry] error getting %s (attempts: %d): %s", url, attempts, err)
-	}
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			fmt.Printf("[DownloadFileWithRetry] error closing response body: %s", err)
-		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("[DownloadFileWithRetry] received code %d ", resp.StatusCode)
-	}
-
-	// Create the file
-	out, err := os.Create(filepath) // #nosec G304
-	if err != nil {
-		return fmt.Errorf("[DownloadFileWithRetry] error creating file %s", filepath)
-	}
-	defer out.Close() // #nosec G307
-
-	progressMark := progressStep * 10
-	if progressStep <= 0 {
-		progress = false
-	}
-	newBody := &PassThru{
-		Reader:          resp.Body,
-		maxBytesPerDot:  progressStep,
-		maxBytesPerMark: progressMark,
-		showProgress:    progress,
-	}
-
-	// Write the body to file
-	_, err = io.Copy(out, newBody)
-	if err != nil {
-		return fmt.Errorf("[DownloadFileWithRetry] error during data writing to file %s: %s", filepath, err)
-	}
-
-	return nil
+	return DownloadFileWithOptions(filepath, url, DownloadOptions{
+		Progress:     progress,
+		ProgressStep: progressStep,
+		Retries:      retriesOnFailure,
+	})
 }
 
 func GetRemoteIndex() (index RemoteFilesMap, err error) {
@@ -0,0 +1,162 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
+	"github.com/pkg/errors"
+)
+
+// Options of the source my.sandbox.cnf that are generated again for the clone,
+// in addition to the ones that getOptionsFromFile skips
+var cloneSkipOptions = map[string]bool{
+	"report-host":   true,
+	"report-port":   true,
+	"mysqlx-port":   true,
+	"mysqlx-socket": true,
+	"admin-port":    true,
+	"admin-address": true,
+}
+
+// cloneSkipFile tells which files of a data directory must not be copied to a clone:
+// the ones that identify the running server or the original sandbox
+func cloneSkipFile(relPath string) bool {
+	base := common.BaseName(relPath)
+	if relPath == globals.AutoCnfName {
+		return true
+	}
+	for _, suffix := range []string{".pid", ".sock", ".sock.lock"} {
+		if strings.HasSuffix(base, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyDataDir fills the data directory of a new sandbox with a copy of sandboxDef.DataDirFrom,
+// and gives the copy a new server UUID
+func copyDataDir(sandboxDef SandboxDef, dataDir string) error {
+	cloned, copied, err := common.CloneDirectory(sandboxDef.DataDirFrom, dataDir, cloneSkipFile)
+	if err != nil {
+		return errors.Wrapf(err, "error copying data directory from %s", sandboxDef.DataDirFrom)
+	}
+	if sandboxDef.Logger != nil {
+		sandboxDef.Logger.Printf("Data directory copied from %s (%d files cloned, %d copied)\n",
+			sandboxDef.DataDirFrom, cloned, copied)
+	}
+	if sandboxDef.KeepUuid {
+		return nil
+	}
+	uuidDef, uuidFname, err := fixServerUuid(sandboxDef)
+	if err != nil {
+		return err
+	}
+	if uuidFname == "" {
+		return nil
+	}
+	return common.WriteStrings([]string{"[auto]", uuidDef}, uuidFname, "\n")
+}
+
+// CloneSandbox creates a new single sandbox using a copy of the data directory of an existing one,
+// which must be stopped.
+// sandboxDef must contain the sandbox home (SandboxDir), the name of the new sandbox (DirName),
+// and the ports to avoid (InstalledPorts). Everything else is taken from the source sandbox.
+// The clone gets new ports and a new server UUID, and all its scripts are generated from the templates.
+func CloneSandbox(sourceDir string, sandboxDef SandboxDef) error {
+	sbDesc, err := common.ReadSandboxDescription(sourceDir)
+	if err != nil {
+		return err
+	}
+	if sbDesc.SBType != globals.SbTypeSingle {
+		return fmt.Errorf("only single sandboxes can be cloned. %s is of type %s", sourceDir, sbDesc.SBType)
+	}
+	status, err := lifecycle.Status(sourceDir)
+	if err != nil {
+		return err
+	}
+	if status.Running {
+		return fmt.Errorf("sandbox %s is running. Stop it before cloning", common.BaseName(sourceDir))
+	}
+	if len(sbDesc.Port) == 0 {
+		return fmt.Errorf("no port found in the description of %s", sourceDir)
+	}
+	configFile := path.Join(sourceDir, globals.ScriptMySandboxCnf)
+	config, err := common.ParseConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	options, err := getOptionsFromFile(configFile)
+	if err != nil {
+		return err
+	}
+
+	sandboxDef.SBType = globals.SbTypeSingle
+	sandboxDef.Basedir = sbDesc.Basedir
+	sandboxDef.BasedirName = common.BaseName(sbDesc.Basedir)
+	sandboxDef.ClientBasedir = sbDesc.ClientBasedir
+	sandboxDef.Version = sbDesc.Version
+	sandboxDef.Flavor = sbDesc.Flavor
+	sandboxDef.SbHost = sbDesc.Host
+	sandboxDef.CustomMysqld = sbDesc.CustomMysqld
+	sandboxDef.Port = sbDesc.Port[0]
+	sandboxDef.DataDirFrom = path.Join(sourceDir, globals.DataDirName)
+	// The grants are already in the data directory
+	sandboxDef.LoadGrants = false
+
+	for _, kv := range config["client"] {
+		switch kv.Key {
+		case "user":
+			sandboxDef.DbUser = kv.Value
+		case "password":
+			sandboxDef.DbPassword = kv.Value
+		}
+	}
+	for _, kv := range config["mysqld"] {
+		switch kv.Key {
+		case "bind-address":
+			sandboxDef.BindAddress = kv.Value
+		case "server-id":
+			// The clone must not share the server ID with its source
+			sandboxDef.PortAsServerId = true
+		case "socket":
+			sandboxDef.SocketInDatadir = filepath.Dir(kv.Value) == sandboxDef.DataDirFrom
+		case "admin-address":
+			sandboxDef.EnableAdminAddress = true
+		}
+	}
+	newDir := path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
+	for _, option := range options {
+		keyValue := strings.SplitN(option, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if cloneSkipOptions[key] {
+			continue
+		}
+		// mysqlx=OFF is added again by the sandbox creation
+		if key == "mysqlx" && len(keyValue) > 1 && strings.EqualFold(strings.TrimSpace(keyValue[1]), "OFF") {
+			sandboxDef.DisableMysqlX = true
+			continue
+		}
+		sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, strings.ReplaceAll(option, sourceDir, newDir))
+	}
+	return CreateStandaloneSandbox(sandboxDef)
+}
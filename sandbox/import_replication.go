@@ -0,0 +1,195 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// ImportedNode is an existing server that is part of an imported replication
+type ImportedNode struct {
+	Host string
+	Port int
+	Role string // "source" or "replica"
+}
+
+// CreateImportedReplication creates a sandbox directory containing one imported sandbox
+// for each of the given nodes, plus the scripts that operate on all of them.
+// The first node is the source (or the group primary). Servers are neither started nor
+// initialized: the sandbox only provides the tools to use them.
+func CreateImportedReplication(sandboxDef SandboxDef, topology string, nodes []ImportedNode) error {
+	if len(nodes) < 2 {
+		return fmt.Errorf("an imported replication requires at least two nodes")
+	}
+	if sandboxDef.ClientBasedir == "" {
+		return fmt.Errorf("imported sandbox requires option --%s", globals.ClientFromLabel)
+	}
+	sbType := globals.SbTypeReplImported
+	logger, logFileName, err := defaults.NewLogger(common.LogDirName(), sbType)
+	if err != nil {
+		return err
+	}
+	sandboxDef.Logger = logger
+	sandboxDef.LogFileName = common.ReplaceLiteralHome(logFileName)
+
+	if sandboxDef.DirName == "" {
+		sandboxDef.DirName = defaults.Defaults().ImportedSandboxPrefix + "repl_" + common.VersionToName(sandboxDef.Version)
+	}
+	sandboxDef.SandboxDir = path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
+	if common.DirExists(sandboxDef.SandboxDir) {
+		sandboxDef, err = checkDirectory(sandboxDef)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Mkdir(sandboxDef.SandboxDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Created directory %s\n", sandboxDef.SandboxDir)
+	logger.Printf("Imported replication Sandbox Definition: %s\n", sandboxDefToJson(sandboxDef))
+
+	common.AddToCleanupStack(common.RmdirAll, "RmdirAll", sandboxDef.SandboxDir)
+
+	timestamp := time.Now()
+	nodeLabel := defaults.Defaults().NodePrefix
+	var data = common.StringMap{
+		"ShellPath":  sandboxDef.ShellPath,
+		"Copyright":  globals.ShellScriptCopyright,
+		"AppVersion": common.VersionDef,
+		"DateTime":   timestamp.Format(time.UnixDate),
+		"SandboxDir": sandboxDef.SandboxDir,
+		"NodeLabel":  nodeLabel,
+		"Topology":   topology,
+		"Nodes":      []common.StringMap{},
+	}
+
+	sbDesc := common.SandboxDescription{
+		Basedir:       sandboxDef.Basedir,
+		ClientBasedir: sandboxDef.ClientBasedir,
+		SBType:        sbType,
+		Version:       sandboxDef.Version,
+		Flavor:        sandboxDef.Flavor,
+		Host:          nodes[0].Host,
+		Port:          []int{},
+		Nodes:         len(nodes),
+		NodeNum:       0,
+		LogFile:       sandboxDef.LogFileName,
+	}
+
+	sbItem := defaults.SandboxItem{
+		Origin:      sbDesc.Basedir,
+		SBType:      sbDesc.SBType,
+		Version:     sandboxDef.Version,
+		Flavor:      sandboxDef.Flavor,
+		Host:        nodes[0].Host,
+		Port:        []int{},
+		Nodes:       []string{},
+		Destination: sandboxDef.SandboxDir,
+	}
+	if sandboxDef.LogFileName != "" {
+		sbItem.LogDirectory = common.DirName(sandboxDef.LogFileName)
+	}
+
+	for i, node := range nodes {
+		nodeNum := i + 1
+		sandboxDef.SbHost = node.Host
+		sandboxDef.Port = node.Port
+		sandboxDef.DirName = fmt.Sprintf("%s%d", nodeLabel, nodeNum)
+		sandboxDef.Multi = true
+		sandboxDef.NodeNum = nodeNum
+		sandboxDef.Prompt = fmt.Sprintf("%s%d", nodeLabel, nodeNum)
+		sandboxDef.SBType = globals.SbTypeReplImportedNode
+		sandboxDef.RunConcurrently = false
+		sandboxDef.SkipStart = true
+		sandboxDef.LoadGrants = false
+
+		var dataNode = common.StringMap{
+			"ShellPath":  sandboxDef.ShellPath,
+			"Copyright":  globals.ShellScriptCopyright,
+			"AppVersion": common.VersionDef,
+			"DateTime":   timestamp.Format(time.UnixDate),
+			"Node":       nodeNum,
+			"NodePort":   node.Port,
+			"NodeHost":   node.Host,
+			"NodeRole":   node.Role,
+			"NodeLabel":  nodeLabel,
+			"SandboxDir": sandboxDef.SandboxDir,
+		}
+		data["Nodes"] = append(data["Nodes"].([]common.StringMap), dataNode)
+		sbItem.Nodes = append(sbItem.Nodes, sandboxDef.DirName)
+		sbItem.Port = append(sbItem.Port, node.Port)
+		sbDesc.Port = append(sbDesc.Port, node.Port)
+
+		common.CondPrintf("Importing %s %d (%s %s:%d)\n", nodeLabel, nodeNum, node.Role, node.Host, node.Port)
+		logger.Printf("Creating imported sandbox for node %d (%s:%d)\n", nodeNum, node.Host, node.Port)
+		_, err = CreateChildSandbox(sandboxDef)
+		if err != nil {
+			return fmt.Errorf(globals.ErrCreatingSandbox, err)
+		}
+		logger.Printf("Creating node script for node %d\n", nodeNum)
+		err = writeScript(logger, MultipleTemplates, fmt.Sprintf("n%d", nodeNum), globals.TmplNode,
+			sandboxDef.SandboxDir, dataNode, true)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Printf("Write sandbox description\n")
+	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write sandbox description")
+	}
+	err = defaults.UpdateCatalog(sandboxDef.SandboxDir, sbItem)
+	if err != nil {
+		return errors.Wrapf(err, "unable to update catalog")
+	}
+
+	logger.Printf("Write imported replication scripts\n")
+	err = writeScripts(ScriptBatch{
+		tc:         ImportTemplates,
+		logger:     logger,
+		sandboxDir: sandboxDef.SandboxDir,
+		data:       data,
+		scripts: []ScriptDef{
+			{globals.ScriptUseAll, globals.TmplImportUseAll, true},
+			{globals.ScriptStatusAll, globals.TmplImportStatusAll, true},
+			{globals.ScriptCheckSlaves, globals.TmplImportCheckSlaves, true},
+			{globals.ScriptStartAll, globals.TmplImportStart, true},
+			{globals.ScriptRestartAll, globals.TmplImportRestart, true},
+			{globals.ScriptStopAll, globals.TmplImportStop, true},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = writeScript(logger, MultipleTemplates, globals.ScriptExecAll, globals.TmplExecMulti,
+		sandboxDef.SandboxDir, data, true)
+	if err != nil {
+		return err
+	}
+
+	common.CondPrintf("%s directory installed in %s\n", topology, common.ReplaceLiteralHome(sandboxDef.SandboxDir))
+	return nil
+}
@@ -53,6 +53,15 @@ var (
 
 	//go:embed templates/import/import_test_sb.gotxt
 	importTestSbTemplate string
+
+	//go:embed templates/import/import_use_all.gotxt
+	importUseAllTemplate string
+
+	//go:embed templates/import/import_status_all.gotxt
+	importStatusAllTemplate string
+
+	//go:embed templates/import/import_check_slaves.gotxt
+	importCheckSlavesTemplate string
 )
 
 var ImportTemplates = TemplateCollection{
@@ -146,6 +155,21 @@ var ImportTemplates = TemplateCollection{
 		Notes:       "",
 		Contents:    importMetadataTemplate,
 	},
+	globals.TmplImportUseAll: TemplateDesc{
+		Description: "Runs a query in all the nodes of an imported replication",
+		Notes:       "",
+		Contents:    importUseAllTemplate,
+	},
+	globals.TmplImportStatusAll: TemplateDesc{
+		Description: "Shows the status of all the nodes of an imported replication",
+		Notes:       "",
+		Contents:    importStatusAllTemplate,
+	},
+	globals.TmplImportCheckSlaves: TemplateDesc{
+		Description: "Checks replication status in all the nodes of an imported replication",
+		Notes:       "",
+		Contents:    importCheckSlavesTemplate,
+	},
 }
 
 func init() {
@@ -0,0 +1,226 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// ChannelHealth is the state of a replication channel, with the transactions
+// that the master has executed and the slave has not
+type ChannelHealth struct {
+	ReplicationChannel
+	MissingGtids string `json:"missing-gtids,omitempty"`
+}
+
+// WsrepHealth is the state of a Galera node
+type WsrepHealth struct {
+	ClusterStatus string `json:"cluster-status"`
+	LocalState    string `json:"local-state"`
+	Ready         string `json:"ready"`
+	ClusterSize   string `json:"cluster-size"`
+}
+
+// NodeHealth is the verdict about a single server
+type NodeHealth struct {
+	Name             string          `json:"name"`
+	Directory        string          `json:"directory"`
+	Port             int             `json:"port"`
+	Up               bool            `json:"up"`
+	GtidExecuted     string          `json:"gtid-executed,omitempty"`
+	Channels         []ChannelHealth `json:"channels,omitempty"`
+	GroupMemberState string          `json:"group-member-state,omitempty"`
+	GroupMemberRole  string          `json:"group-member-role,omitempty"`
+	Wsrep            *WsrepHealth    `json:"wsrep,omitempty"`
+	Healthy          bool            `json:"healthy"`
+	Problems         []string        `json:"problems,omitempty"`
+}
+
+// SandboxHealth is the verdict about all the servers of a sandbox
+type SandboxHealth struct {
+	Name      string       `json:"name"`
+	Directory string       `json:"directory"`
+	Type      string       `json:"type"`
+	Version   string       `json:"version"`
+	Healthy   bool         `json:"healthy"`
+	Nodes     []NodeHealth `json:"nodes"`
+}
+
+func (h *NodeHealth) addProblem(format string, args ...interface{}) {
+	h.Problems = append(h.Problems, fmt.Sprintf(format, args...))
+}
+
+func errorSuffix(lastError string) string {
+	if lastError == "" {
+		return ""
+	}
+	return " - " + lastError
+}
+
+// checkNodeServer fills the health of a node with what the server says about itself
+func checkNodeServer(n node, health *NodeHealth) {
+	db, err := Connect(n.dir)
+	if err != nil {
+		health.addProblem("server is not reachable: %s", err)
+		return
+	}
+	defer db.Close()
+	err = db.Ping()
+	if err != nil {
+		health.addProblem("server is not reachable: %s", err)
+		return
+	}
+	health.Up = true
+
+	// Servers without GTID support fail this query, and they are not checked for missing transactions
+	var gtidExecuted string
+	if db.QueryRow("SELECT @@gtid_executed").Scan(&gtidExecuted) == nil {
+		health.GtidExecuted = strings.ReplaceAll(gtidExecuted, "\n", "")
+	}
+
+	if strings.HasPrefix(n.desc.SBType, "group") {
+		uuid, err := ServerUuid(n.dir)
+		if err != nil {
+			health.addProblem("error reading server UUID: %s", err)
+		}
+		members, err := GroupMembers(n.dir)
+		if err != nil {
+			health.addProblem("error reading group members: %s", err)
+		}
+		for _, member := range members {
+			if member.Id == uuid {
+				health.GroupMemberState = member.State
+				health.GroupMemberRole = member.Role
+			}
+		}
+		if health.GroupMemberState != "ONLINE" {
+			health.addProblem("group member state is '%s'", health.GroupMemberState)
+		}
+	}
+
+	if n.desc.Flavor == common.PxcFlavor {
+		rows, err := queryRows(db, "SHOW GLOBAL STATUS LIKE 'wsrep_%'")
+		if err != nil {
+			health.addProblem("error reading wsrep status: %s", err)
+		} else {
+			health.Wsrep = &WsrepHealth{}
+			for _, row := range rows {
+				switch row["Variable_name"] {
+				case "wsrep_cluster_status":
+					health.Wsrep.ClusterStatus = row["Value"]
+				case "wsrep_local_state_comment":
+					health.Wsrep.LocalState = row["Value"]
+				case "wsrep_ready":
+					health.Wsrep.Ready = row["Value"]
+				case "wsrep_cluster_size":
+					health.Wsrep.ClusterSize = row["Value"]
+				}
+			}
+			if health.Wsrep.ClusterStatus != "Primary" {
+				health.addProblem("wsrep cluster status is '%s'", health.Wsrep.ClusterStatus)
+			}
+			if health.Wsrep.LocalState != "Synced" {
+				health.addProblem("wsrep local state is '%s'", health.Wsrep.LocalState)
+			}
+			if health.Wsrep.Ready != "ON" {
+				health.addProblem("wsrep is not ready")
+			}
+		}
+	}
+
+	channels, err := ReplicationChannels(n.dir)
+	if err != nil {
+		health.addProblem("error reading replication status: %s", err)
+		return
+	}
+	for _, channel := range channels {
+		health.Channels = append(health.Channels, ChannelHealth{ReplicationChannel: channel})
+	}
+}
+
+// checkChannels compares the state of the replication channels of a node with its masters
+func checkChannels(health *NodeHealth, gtidByPort map[int]string, maxLag int) {
+	for i, channel := range health.Channels {
+		label := "replication"
+		if channel.Name != "" {
+			label = fmt.Sprintf("channel '%s'", channel.Name)
+		}
+		if !channel.IORunning {
+			health.addProblem("%s: IO thread not running%s", label, errorSuffix(channel.LastIOError))
+		}
+		if !channel.SQLRunning {
+			health.addProblem("%s: SQL thread not running%s", label, errorSuffix(channel.LastSQLError))
+		}
+		if channel.SecondsBehindMaster != nil && maxLag > 0 && *channel.SecondsBehindMaster > maxLag {
+			health.addProblem("%s: %d seconds behind master", label, *channel.SecondsBehindMaster)
+		}
+		masterGtids := gtidByPort[channel.MasterPort]
+		if masterGtids == "" || !health.Up {
+			continue
+		}
+		db, err := Connect(health.Directory)
+		if err != nil {
+			continue
+		}
+		var missing string
+		err = db.QueryRow("SELECT GTID_SUBTRACT(?, @@gtid_executed)", masterGtids).Scan(&missing)
+		db.Close()
+		if err == nil {
+			health.Channels[i].MissingGtids = strings.ReplaceAll(missing, "\n", "")
+		}
+	}
+}
+
+// Health checks all the servers of a sandbox: whether they are up, and the state of
+// their replication channels, group membership, and Galera cluster.
+// When maxLag is greater than 0, a slave that lags behind its master by more seconds is unhealthy.
+// The result is not an error when the sandbox is unhealthy: the verdict is in the Healthy fields
+func Health(sandboxDir string, maxLag int) (SandboxHealth, error) {
+	desc, nodes, err := getNodes(sandboxDir)
+	if err != nil {
+		return SandboxHealth{}, err
+	}
+	health := SandboxHealth{
+		Name:      common.BaseName(sandboxDir),
+		Directory: sandboxDir,
+		Type:      desc.SBType,
+		Version:   desc.Version,
+		Healthy:   len(nodes) > 0,
+		Nodes:     []NodeHealth{},
+	}
+	gtidByPort := make(map[int]string)
+	for _, n := range nodes {
+		nodeHealth := NodeHealth{
+			Name:      common.BaseName(n.dir),
+			Directory: n.dir,
+		}
+		if len(n.desc.Port) > 0 {
+			nodeHealth.Port = n.desc.Port[0]
+		}
+		checkNodeServer(n, &nodeHealth)
+		gtidByPort[nodeHealth.Port] = nodeHealth.GtidExecuted
+		health.Nodes = append(health.Nodes, nodeHealth)
+	}
+	for i := range health.Nodes {
+		checkChannels(&health.Nodes[i], gtidByPort, maxLag)
+		health.Nodes[i].Healthy = len(health.Nodes[i].Problems) == 0
+		health.Healthy = health.Healthy && health.Nodes[i].Healthy
+	}
+	return health, nil
+}
@@ -0,0 +1,540 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lifecycle starts, stops, and inspects sandboxes without going
+// through the generated shell scripts.
+// It uses the sandbox description, the pid file, and the configuration
+// file found in each sandbox directory.
+package lifecycle
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTimeout is the time that Start, Stop, and WaitReady wait for a server
+	DefaultTimeout = 180 * time.Second
+	stopTimeout    = 30 * time.Second
+	pollInterval   = 500 * time.Millisecond
+	queryTimeout   = 2 * time.Second
+)
+
+// NativeEnabled allows this package to start and stop servers directly.
+// When it is false, as in the mock environment used by tests, every sandbox
+// is handled by its own scripts
+var NativeEnabled = true
+
+// NodeStatus is the state of a single database server
+type NodeStatus struct {
+	Name           string `json:"name"`
+	Directory      string `json:"directory"`
+	Port           int    `json:"port"`
+	Running        bool   `json:"running"`
+	Pid            int    `json:"pid,omitempty"`
+	UptimeSeconds  int64  `json:"uptime_seconds,omitempty"`
+	PortsListening []int  `json:"ports_listening"`
+	ReadOnly       *bool  `json:"read_only,omitempty"`
+}
+
+// SandboxStatus is the state of a sandbox, with one entry for each of its servers
+type SandboxStatus struct {
+	Name      string       `json:"name"`
+	Directory string       `json:"directory"`
+	Type      string       `json:"type"`
+	Version   string       `json:"version"`
+	Flavor    string       `json:"flavor,omitempty"`
+	Running   bool         `json:"running"`
+	Nodes     []NodeStatus `json:"nodes"`
+}
+
+// node is a sandbox directory containing a single server
+type node struct {
+	dir  string
+	desc common.SandboxDescription
+}
+
+// pidFile returns the name of the file where the server writes its process ID
+func (n node) pidFile() string {
+	port := 0
+	if len(n.desc.Port) > 0 {
+		port = n.desc.Port[0]
+	}
+	return path.Join(n.dir, globals.DataDirName, fmt.Sprintf("mysql_sandbox%d.pid", port))
+}
+
+func (n node) host() string {
+	if n.desc.Host != "" && n.desc.Host != "localhost" {
+		return n.desc.Host
+	}
+	return globals.LocalHostIP
+}
+
+// nativeSupport tells whether the server can be started and stopped by this package.
+// Servers that need a special procedure, such as cluster nodes, imported databases, or TiDB,
+// are handled by their own scripts
+func (n node) nativeSupport() bool {
+	if !NativeEnabled || n.desc.Flavor == common.TiDbFlavor {
+		return false
+	}
+	switch n.desc.SBType {
+	case globals.SbTypeSingle, "replication-node", "group-node", "multiple-node":
+		return true
+	}
+	return false
+}
+
+// getNodes returns the servers belonging to the sandbox in sandboxDir.
+// A single sandbox is its own node.
+func getNodes(sandboxDir string) (common.SandboxDescription, []node, error) {
+	desc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return desc, nil, err
+	}
+	if desc.Nodes == 0 {
+		return desc, []node{{dir: sandboxDir, desc: desc}}, nil
+	}
+	var nodes []node
+	nodeList, err := common.GetInstalledSandboxes(sandboxDir)
+	if err != nil {
+		return desc, nil, err
+	}
+	for _, sb := range nodeList {
+		nodeDir := path.Join(sandboxDir, sb.SandboxName)
+		// Some topologies have links with alternative names to the same node
+		info, err := os.Lstat(nodeDir)
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		if sb.SandboxDesc.SBType == "" {
+			continue
+		}
+		nodes = append(nodes, node{dir: nodeDir, desc: sb.SandboxDesc})
+	}
+	return desc, nodes, nil
+}
+
+// NativeSupport tells whether all the servers in a sandbox are handled by this package.
+// For the other sandboxes, callers should use the sandbox scripts
+func NativeSupport(sandboxDir string) bool {
+	_, nodes, err := getNodes(sandboxDir)
+	if err != nil || len(nodes) == 0 {
+		return false
+	}
+	for _, n := range nodes {
+		if !n.nativeSupport() {
+			return false
+		}
+	}
+	return true
+}
+
+// NodeDirs returns the directories of the servers belonging to a sandbox.
+// For a single sandbox, it is the sandbox directory itself
+func NodeDirs(sandboxDir string) ([]string, error) {
+	_, nodes, err := getNodes(sandboxDir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, n := range nodes {
+		dirs = append(dirs, n.dir)
+	}
+	return dirs, nil
+}
+
+// readPid returns the process ID from the pid file, or 0 if the file is missing
+func readPid(pidFile string) int {
+	if !common.FileExists(pidFile) {
+		return 0
+	}
+	text, err := common.SlurpAsString(pidFile)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// isProcessAlive checks whether a process exists, without affecting it
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// isListening checks whether a TCP port accepts connections
+func isListening(host string, port int) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), time.Second)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// readOnlyState queries the server for its read_only variable
+func readOnlyState(n node) (bool, error) {
+	db, err := Connect(n.dir)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	var readOnly int
+	err = db.QueryRow("SELECT @@read_only").Scan(&readOnly)
+	return readOnly != 0, err
+}
+
+func nodeStatus(n node) NodeStatus {
+	status := NodeStatus{
+		Name:           common.BaseName(n.dir),
+		Directory:      n.dir,
+		PortsListening: []int{},
+	}
+	if len(n.desc.Port) > 0 {
+		status.Port = n.desc.Port[0]
+	}
+	pidFile := n.pidFile()
+	pid := readPid(pidFile)
+	if !isProcessAlive(pid) {
+		return status
+	}
+	status.Running = true
+	status.Pid = pid
+	stat, err := os.Stat(pidFile)
+	if err == nil {
+		status.UptimeSeconds = int64(time.Since(stat.ModTime()).Seconds())
+	}
+	for _, port := range n.desc.Port {
+		if isListening(n.host(), port) {
+			status.PortsListening = append(status.PortsListening, port)
+		}
+	}
+	if len(status.PortsListening) > 0 {
+		readOnly, err := readOnlyState(n)
+		if err == nil {
+			status.ReadOnly = &readOnly
+		}
+	}
+	return status
+}
+
+// Status returns the state of all the servers in a sandbox
+func Status(sandboxDir string) (SandboxStatus, error) {
+	desc, nodes, err := getNodes(sandboxDir)
+	if err != nil {
+		return SandboxStatus{}, err
+	}
+	status := SandboxStatus{
+		Name:      common.BaseName(sandboxDir),
+		Directory: sandboxDir,
+		Type:      desc.SBType,
+		Version:   desc.Version,
+		Flavor:    desc.Flavor,
+		Running:   len(nodes) > 0,
+		Nodes:     []NodeStatus{},
+	}
+	for _, n := range nodes {
+		ns := nodeStatus(n)
+		status.Running = status.Running && ns.Running
+		status.Nodes = append(status.Nodes, ns)
+	}
+	return status, nil
+}
+
+// runScript runs a script in the node directory, for servers that are not handled natively
+func runScript(n node, script string, args ...string) error {
+	scriptFile := path.Join(n.dir, script)
+	if !common.ExecExists(scriptFile) {
+		return fmt.Errorf(globals.ErrExecutableNotFound, scriptFile)
+	}
+	_, err := common.RunCmdCtrlWithArgs(scriptFile, args, true)
+	return err
+}
+
+// serverEnv returns the environment used to run the server from its base directory
+func serverEnv(basedir string) []string {
+	env := os.Environ()
+	libDirs := path.Join(basedir, "lib") + ":" + path.Join(basedir, "lib", "mysql")
+	env = append(env,
+		"LD_LIBRARY_PATH="+libDirs+":"+os.Getenv("LD_LIBRARY_PATH"),
+		"DYLD_LIBRARY_PATH="+libDirs+":"+os.Getenv("DYLD_LIBRARY_PATH"),
+		// Disables .mylogin.cnf, which would bypass --defaults-file
+		"MYSQL_TEST_LOGIN_FILE=/tmp/dont_break_my_sandboxes"+strconv.Itoa(os.Getpid()),
+	)
+	return env
+}
+
+func startNode(n node, timeout time.Duration, args ...string) error {
+	if !n.nativeSupport() {
+		return runScript(n, globals.ScriptStart, args...)
+	}
+	pidFile := n.pidFile()
+	if isProcessAlive(readPid(pidFile)) {
+		return nil
+	}
+	// Server is not running. Removing stale pid-file
+	if common.FileExists(pidFile) {
+		err := os.Remove(pidFile)
+		if err != nil {
+			return err
+		}
+	}
+	mysqldSafe := path.Join(n.desc.Basedir, "bin", "mysqld_safe")
+	if !common.ExecExists(mysqldSafe) {
+		return fmt.Errorf("mysqld_safe not found in %s", path.Join(n.desc.Basedir, "bin"))
+	}
+	cmdArgs := []string{"--defaults-file=" + path.Join(n.dir, globals.ScriptMySandboxCnf)}
+	if n.desc.CustomMysqld != "" {
+		cmdArgs = append(cmdArgs, "--mysqld="+n.desc.CustomMysqld)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	startLog := path.Join(n.dir, "start.log")
+	logFile, err := os.Create(startLog) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer logFile.Close() // #nosec G307
+
+	cmd := exec.Command(mysqldSafe, cmdArgs...) // #nosec G204
+	cmd.Dir = n.desc.Basedir
+	cmd.Env = serverEnv(n.desc.Basedir)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrapf(err, "error starting server in %s", n.dir)
+	}
+	// mysqld_safe keeps running after we exit
+	go func() { _ = cmd.Wait() }()
+	return waitNodeReady(n, timeout)
+}
+
+// startFailureMarkers match the messages that mysqld_safe writes to start.log
+// when the server can't start. Paths and options containing the word "error"
+// (such as log-error) are not failures
+var startFailureMarkers = regexp.MustCompile(`(?mi)\[ERROR\]|^ERROR\b|error while loading shared libraries|` +
+	`mysqld from pid file \S+ ended|command not found`)
+
+func startFailed(logText string) bool {
+	return startFailureMarkers.MatchString(logText)
+}
+
+func waitNodeReady(n node, timeout time.Duration) error {
+	pidFile := n.pidFile()
+	port := 0
+	if len(n.desc.Port) > 0 {
+		port = n.desc.Port[0]
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if isProcessAlive(readPid(pidFile)) && (port == 0 || isListening(n.host(), port)) {
+			return nil
+		}
+		startLog := path.Join(n.dir, "start.log")
+		if common.FileExists(startLog) {
+			logText, _ := common.SlurpAsString(startLog)
+			if startFailed(logText) {
+				return fmt.Errorf("errors detected while starting %s:\n%s", n.dir, logText)
+			}
+		}
+		time.Sleep(pollInterval)
+	}
+	return fmt.Errorf("server in %s not ready after %s", n.dir, timeout)
+}
+
+// waitPidGone waits for the pid file to disappear or the process to end
+func waitPidGone(pidFile string, pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !common.FileExists(pidFile) || !isProcessAlive(pid) {
+			return true
+		}
+		time.Sleep(pollInterval)
+	}
+	return false
+}
+
+func stopNode(n node) error {
+	if !n.nativeSupport() {
+		return runScript(n, globals.ScriptStop)
+	}
+	pidFile := n.pidFile()
+	pid := readPid(pidFile)
+	if !isProcessAlive(pid) {
+		if common.FileExists(pidFile) {
+			return os.Remove(pidFile)
+		}
+		return nil
+	}
+	// On SIGTERM, the server shuts down cleanly and removes the pid file,
+	// so that mysqld_safe does not restart it
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	err = process.Signal(syscall.SIGTERM)
+	if err != nil {
+		return errors.Wrapf(err, "error stopping server in %s", n.dir)
+	}
+	if waitPidGone(pidFile, pid, stopTimeout) {
+		return nil
+	}
+	// Server unresponsive
+	return killNode(n)
+}
+
+// mysqldSafePid returns the parent process of pid, if it is mysqld_safe
+func mysqldSafePid(pid int) int {
+	out, err := common.RunCmdCtrlWithArgs("ps", []string{"-o", "ppid=", "-p", strconv.Itoa(pid)}, true)
+	if err != nil {
+		return 0
+	}
+	ppid, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil || ppid <= 1 {
+		return 0
+	}
+	out, err = common.RunCmdCtrlWithArgs("ps", []string{"-o", "args=", "-p", strconv.Itoa(ppid)}, true)
+	if err != nil || !strings.Contains(out, "mysqld_safe") {
+		return 0
+	}
+	return ppid
+}
+
+func killNode(n node) error {
+	if !n.nativeSupport() {
+		return runScript(n, globals.ScriptSendKill, "destroy")
+	}
+	pidFile := n.pidFile()
+	pid := readPid(pidFile)
+	if isProcessAlive(pid) {
+		// mysqld_safe would restart the server after a crash
+		safePid := mysqldSafePid(pid)
+		if safePid > 0 {
+			if safeProcess, err := os.FindProcess(safePid); err == nil {
+				_ = safeProcess.Signal(syscall.SIGKILL)
+			}
+		}
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		err = process.Signal(syscall.SIGKILL)
+		if err != nil {
+			return errors.Wrapf(err, "error killing server in %s", n.dir)
+		}
+		waitPidGone(pidFile, -1, stopTimeout)
+	}
+	if common.FileExists(pidFile) {
+		return os.Remove(pidFile)
+	}
+	return nil
+}
+
+// Start starts all the servers in a sandbox and waits until they accept connections.
+// Servers that are already running are left alone.
+// The optional arguments are passed to every server.
+func Start(sandboxDir string, args ...string) error {
+	_, nodes, err := getNodes(sandboxDir)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		err = startNode(n, DefaultTimeout, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops all the servers in a sandbox, in reverse order of start
+func Stop(sandboxDir string) error {
+	_, nodes, err := getNodes(sandboxDir)
+	if err != nil {
+		return err
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		err = stopNode(nodes[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restart stops and starts all the servers in a sandbox
+func Restart(sandboxDir string, args ...string) error {
+	err := Stop(sandboxDir)
+	if err != nil {
+		return err
+	}
+	return Start(sandboxDir, args...)
+}
+
+// Kill terminates immediately all the servers in a sandbox, without a clean shutdown
+func Kill(sandboxDir string) error {
+	_, nodes, err := getNodes(sandboxDir)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		err = killNode(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitReady waits until all the servers in a sandbox are running and accept connections
+func WaitReady(sandboxDir string, timeout time.Duration) error {
+	_, nodes, err := getNodes(sandboxDir)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for _, n := range nodes {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("sandbox %s not ready after %s", sandboxDir, timeout)
+		}
+		err = waitNodeReady(n, remaining)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// makeFakeNode creates a sandbox directory with a description and,
+// when pid is not 0, a pid file
+func makeFakeNode(t *testing.T, dir, sbType string, nodes, port, pid int) {
+	err := os.MkdirAll(path.Join(dir, globals.DataDirName), globals.PublicDirectoryAttr)
+	compare.OkIsNil("creating data directory", err, t)
+	err = common.WriteSandboxDescription(dir, common.SandboxDescription{
+		SBType:  sbType,
+		Version: "8.0.36",
+		Flavor:  common.MySQLFlavor,
+		Port:    []int{port},
+		Nodes:   nodes,
+	})
+	compare.OkIsNil("writing description", err, t)
+	if pid != 0 {
+		pidFile := path.Join(dir, globals.DataDirName, fmt.Sprintf("mysql_sandbox%d.pid", port))
+		err = common.WriteString(fmt.Sprintf("%d\n", pid), pidFile)
+		compare.OkIsNil("writing pid file", err, t)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	compare.OkIsNil("listening", err, t)
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	sandboxHome := t.TempDir()
+	single := path.Join(sandboxHome, "msb_8_0_36")
+	makeFakeNode(t, single, globals.SbTypeSingle, 0, port, os.Getpid())
+
+	status, err := Status(single)
+	compare.OkIsNil("single status", err, t)
+	compare.OkEqualString("name", status.Name, "msb_8_0_36", t)
+	compare.OkEqualBool("running", status.Running, true, t)
+	compare.OkEqualInt("nodes", len(status.Nodes), 1, t)
+	compare.OkEqualInt("pid", status.Nodes[0].Pid, os.Getpid(), t)
+	compare.OkEqualIntSlices(t, status.Nodes[0].PortsListening, []int{port})
+
+	err = WaitReady(single, 2*time.Second)
+	compare.OkIsNil("wait ready", err, t)
+
+	multiple := path.Join(sandboxHome, "multi_msb_8_0_36")
+	makeFakeNode(t, multiple, globals.SbTypeMultiple, 2, 0, 0)
+	makeFakeNode(t, path.Join(multiple, "node1"), "multiple-node", 0, port, os.Getpid())
+	makeFakeNode(t, path.Join(multiple, "node2"), "multiple-node", 0, port+1, 0)
+	err = os.Symlink(path.Join(multiple, "node1"), path.Join(multiple, "master"))
+	compare.OkIsNil("linking node", err, t)
+
+	status, err = Status(multiple)
+	compare.OkIsNil("multiple status", err, t)
+	compare.OkEqualBool("running", status.Running, false, t)
+	compare.OkEqualInt("nodes", len(status.Nodes), 2, t)
+	compare.OkEqualBool("node1 running", status.Nodes[0].Running, true, t)
+	compare.OkEqualBool("node2 running", status.Nodes[1].Running, false, t)
+	compare.OkEqualInt("node2 pid", status.Nodes[1].Pid, 0, t)
+
+	err = WaitReady(multiple, time.Second)
+	compare.OkIsNotNil("wait ready with a stopped node", err, t)
+
+	_, err = Status(path.Join(sandboxHome, "no_such_sandbox"))
+	compare.OkIsNotNil("missing sandbox", err, t)
+}
+
+func TestHealth(t *testing.T) {
+	sandboxHome := t.TempDir()
+	single := path.Join(sandboxHome, "msb_8_0_36")
+	makeFakeNode(t, single, globals.SbTypeSingle, 0, 8036, 0)
+
+	// Without connection files, the server can't be reached
+	health, err := Health(single, 0)
+	compare.OkIsNil("single health", err, t)
+	compare.OkEqualBool("healthy", health.Healthy, false, t)
+	compare.OkEqualInt("nodes", len(health.Nodes), 1, t)
+	compare.OkEqualBool("up", health.Nodes[0].Up, false, t)
+	compare.OkEqualInt("problems", len(health.Nodes[0].Problems), 1, t)
+
+	lag := 30
+	var testData = []struct {
+		label    string
+		channel  ReplicationChannel
+		maxLag   int
+		problems int
+	}{
+		{"running", ReplicationChannel{IORunning: true, SQLRunning: true, SecondsBehindMaster: &lag}, 0, 0},
+		{"lag within limit", ReplicationChannel{IORunning: true, SQLRunning: true, SecondsBehindMaster: &lag}, 60, 0},
+		{"lag over limit", ReplicationChannel{IORunning: true, SQLRunning: true, SecondsBehindMaster: &lag}, 10, 1},
+		{"IO stopped", ReplicationChannel{SQLRunning: true, LastIOError: "error connecting to master"}, 0, 1},
+		{"both stopped", ReplicationChannel{Name: "node1"}, 0, 2},
+	}
+	for _, td := range testData {
+		nodeHealth := NodeHealth{Channels: []ChannelHealth{{ReplicationChannel: td.channel}}}
+		checkChannels(&nodeHealth, map[int]string{}, td.maxLag)
+		compare.OkEqualInt(td.label, len(nodeHealth.Problems), td.problems, t)
+	}
+}
+
+func TestNativeSupport(t *testing.T) {
+	sandboxHome := t.TempDir()
+	single := path.Join(sandboxHome, "msb_8_0_36")
+	makeFakeNode(t, single, globals.SbTypeSingle, 0, 8036, 0)
+	compare.OkEqualBool("single", NativeSupport(single), true, t)
+
+	imported := path.Join(sandboxHome, "imp_msb_8_0_36")
+	makeFakeNode(t, imported, globals.SbTypeSingleImported, 0, 8037, 0)
+	compare.OkEqualBool("imported", NativeSupport(imported), false, t)
+
+	compare.OkEqualBool("missing", NativeSupport(path.Join(sandboxHome, "no_such_sandbox")), false, t)
+
+	NativeEnabled = false
+	defer func() { NativeEnabled = true }()
+	compare.OkEqualBool("single without native support", NativeSupport(single), false, t)
+}
+
+func TestStartFailed(t *testing.T) {
+	var testData = []struct {
+		logText  string
+		expected bool
+	}{
+		{"Logging to '/home/error_logs/msb_8_0_36/data/msandbox.err'.\n" +
+			"Starting mysqld daemon with databases from /home/error_logs/msb_8_0_36/data\n", false},
+		{"2024-01-01T10:00:00Z mysqld_safe Logging to '/sb/data/msandbox.err'.\n" +
+			"mysqld_safe mysqld from pid file /sb/data/mysql_sandbox8036.pid ended\n", true},
+		{"2024-01-01T10:00:00.000Z 0 [ERROR] [MY-000067] [Server] unknown variable 'foo=1'.\n", true},
+		{"mysqld: error while loading shared libraries: libaio.so.1\n", true},
+		{"ERROR: the server could not start\n", true},
+	}
+	for _, td := range testData {
+		compare.OkEqualBool(td.logText, startFailed(td.logText), td.expected, t)
+	}
+}
@@ -0,0 +1,362 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/importing"
+)
+
+// ReplicationChannel is the state of a replication channel, as reported by SHOW SLAVE STATUS
+type ReplicationChannel struct {
+	Name                string `json:"name"`
+	MasterHost          string `json:"master-host"`
+	MasterPort          int    `json:"master-port"`
+	IORunning           bool   `json:"io-running"`
+	SQLRunning          bool   `json:"sql-running"`
+	LastIOError         string `json:"last-io-error,omitempty"`
+	LastSQLError        string `json:"last-sql-error,omitempty"`
+	SecondsBehindMaster *int   `json:"seconds-behind-master,omitempty"`
+	RelayMasterLogFile  string `json:"relay-master-log-file"`
+	ExecMasterLogPos    int64  `json:"exec-master-log-pos"`
+	AutoPosition        bool   `json:"auto-position"`
+	RetrievedGtidSet    string `json:"retrieved-gtid-set,omitempty"`
+	ExecutedGtidSet     string `json:"executed-gtid-set,omitempty"`
+}
+
+// GroupMember is a member of a replication group, as seen by one of the nodes
+type GroupMember struct {
+	Id    string `json:"id"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	State string `json:"state"`
+	Role  string `json:"role,omitempty"`
+}
+
+// Credentials are the contents of the connection files of a node
+type Credentials struct {
+	Host     string `json:"master_host"`
+	Port     int    `json:"master_port"`
+	User     string `json:"master_user"`
+	Password string `json:"master_password"`
+}
+
+func readConnectionFile(connectionFile string) (Credentials, error) {
+	var credentials Credentials
+	text, err := common.SlurpAsBytes(connectionFile)
+	if err != nil {
+		return credentials, err
+	}
+	err = json.Unmarshal(text, &credentials)
+	return credentials, err
+}
+
+// ReplicationCredentials returns the address of a node and the credentials
+// that the slaves use to replicate from it
+func ReplicationCredentials(nodeDir string) (Credentials, error) {
+	return readConnectionFile(path.Join(nodeDir, globals.ScriptConnectionJson))
+}
+
+// Connect opens a connection to the server of a sandbox node,
+// using the credentials of its connection files
+func Connect(nodeDir string) (*importing.DB, error) {
+	connectionFile := path.Join(nodeDir, globals.ScriptConnectionSuperJson)
+	if !common.FileExists(connectionFile) {
+		connectionFile = path.Join(nodeDir, globals.ScriptConnectionJson)
+	}
+	credentials, err := readConnectionFile(connectionFile)
+	if err != nil {
+		return nil, err
+	}
+	config := importing.ParamsToConfig(credentials.Host, credentials.User, credentials.Password, credentials.Port)
+	config.Timeout = queryTimeout
+	return importing.Connect(config)
+}
+
+// execQueries runs a list of statements in a node, stopping at the first error
+func execQueries(nodeDir string, queries ...string) error {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, query := range queries {
+		_, err = db.Exec(query)
+		if err != nil {
+			return fmt.Errorf("error running '%s' in %s: %s", query, nodeDir, err)
+		}
+	}
+	return nil
+}
+
+// queryRows runs a query and returns its rows as maps from column name to value
+func queryRows(db *importing.DB, query string) ([]map[string]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string)
+		for i, column := range columns {
+			row[column] = values[i].String
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// ReplicationChannels returns the replication channels of a node.
+// A node that does not replicate from another server has no channels
+func ReplicationChannels(nodeDir string) ([]ReplicationChannel, error) {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := queryRows(db, "SHOW SLAVE STATUS")
+	if err != nil {
+		return nil, err
+	}
+	var channels []ReplicationChannel
+	for _, row := range rows {
+		// Group replication channels are not regular replication
+		if strings.HasPrefix(row["Channel_Name"], "group_replication") {
+			continue
+		}
+		channel := ReplicationChannel{
+			Name:               row["Channel_Name"],
+			MasterHost:         row["Master_Host"],
+			IORunning:          row["Slave_IO_Running"] == "Yes",
+			SQLRunning:         row["Slave_SQL_Running"] == "Yes",
+			LastIOError:        row["Last_IO_Error"],
+			LastSQLError:       row["Last_SQL_Error"],
+			RelayMasterLogFile: row["Relay_Master_Log_File"],
+			AutoPosition:       row["Auto_Position"] == "1",
+			RetrievedGtidSet:   strings.ReplaceAll(row["Retrieved_Gtid_Set"], "\n", ""),
+			ExecutedGtidSet:    strings.ReplaceAll(row["Executed_Gtid_Set"], "\n", ""),
+		}
+		channel.MasterPort, _ = strconv.Atoi(row["Master_Port"])
+		channel.ExecMasterLogPos, _ = strconv.ParseInt(row["Exec_Master_Log_Pos"], 10, 64)
+		if behind, err := strconv.Atoi(row["Seconds_Behind_Master"]); err == nil {
+			channel.SecondsBehindMaster = &behind
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// channelClause returns the FOR CHANNEL clause for a named channel
+func channelClause(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" FOR CHANNEL '%s'", name)
+}
+
+// RepointChannel makes a replication channel of a node read from a different host and port,
+// starting from the last transaction that it has executed
+func RepointChannel(nodeDir string, channel ReplicationChannel, host string, port int) error {
+	changeMaster := fmt.Sprintf("CHANGE MASTER TO MASTER_HOST='%s', MASTER_PORT=%d", host, port)
+	if !channel.AutoPosition {
+		// When the host or port change, the server forgets the position:
+		// we continue from the last executed event
+		changeMaster += fmt.Sprintf(", MASTER_LOG_FILE='%s', MASTER_LOG_POS=%d",
+			channel.RelayMasterLogFile, channel.ExecMasterLogPos)
+	}
+	return execQueries(nodeDir,
+		"STOP SLAVE"+channelClause(channel.Name),
+		changeMaster+channelClause(channel.Name),
+		"START SLAVE"+channelClause(channel.Name),
+	)
+}
+
+// ReplicateFrom makes a node replicate from the server in masterDir, using GTID auto-position
+// and the replication credentials of the master connection file.
+// Any previous replication setting of the node is removed.
+// Extra options for CHANGE MASTER TO, if any, must start with a comma
+func ReplicateFrom(nodeDir, masterDir, extraOptions string) error {
+	credentials, err := ReplicationCredentials(masterDir)
+	if err != nil {
+		return err
+	}
+	return execQueries(nodeDir,
+		"STOP SLAVE",
+		"RESET SLAVE ALL",
+		fmt.Sprintf("CHANGE MASTER TO MASTER_HOST='%s', MASTER_PORT=%d, MASTER_USER='%s', MASTER_PASSWORD='%s', "+
+			"MASTER_AUTO_POSITION=1%s",
+			credentials.Host, credentials.Port, credentials.User, credentials.Password, extraOptions),
+		"START SLAVE",
+	)
+}
+
+// ResetReplication stops replication in a node and removes its settings
+func ResetReplication(nodeDir string) error {
+	return execQueries(nodeDir, "STOP SLAVE", "RESET SLAVE ALL")
+}
+
+// StopReplicationIO stops the IO thread of a node, leaving the SQL thread to apply what was already received
+func StopReplicationIO(nodeDir string) error {
+	return execQueries(nodeDir, "STOP SLAVE IO_THREAD")
+}
+
+// SetReadOnly changes read_only and super_read_only in a node.
+// super_read_only is only changed in servers that support it
+func SetReadOnly(nodeDir string, readOnly, superReadOnly bool) error {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var current int
+	hasSuperReadOnly := db.QueryRow("SELECT @@super_read_only").Scan(&current) == nil
+	if superReadOnly && !hasSuperReadOnly {
+		return fmt.Errorf("super_read_only is not supported in %s", nodeDir)
+	}
+	var queries []string
+	if !superReadOnly && hasSuperReadOnly {
+		queries = append(queries, "SET GLOBAL super_read_only=OFF")
+	}
+	if readOnly || superReadOnly {
+		queries = append(queries, "SET GLOBAL read_only=ON")
+	} else {
+		queries = append(queries, "SET GLOBAL read_only=OFF")
+	}
+	if superReadOnly {
+		queries = append(queries, "SET GLOBAL super_read_only=ON")
+	}
+	for _, query := range queries {
+		_, err = db.Exec(query)
+		if err != nil {
+			return fmt.Errorf("error running '%s' in %s: %s", query, nodeDir, err)
+		}
+	}
+	return nil
+}
+
+// GtidExecuted returns the set of transactions executed by a node.
+// It fails when GTIDs are not enabled
+func GtidExecuted(nodeDir string) (string, error) {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	var gtidMode, gtidExecuted string
+	err = db.QueryRow("SELECT @@gtid_mode, @@gtid_executed").Scan(&gtidMode, &gtidExecuted)
+	if err != nil {
+		return "", err
+	}
+	if gtidMode != "ON" {
+		return "", fmt.Errorf("GTID is not enabled in %s", nodeDir)
+	}
+	return strings.ReplaceAll(gtidExecuted, "\n", ""), nil
+}
+
+// IsGtidSubset tells whether all the transactions in subset are also in set, according to a node
+func IsGtidSubset(nodeDir, subset, set string) (bool, error) {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	var result int
+	err = db.QueryRow("SELECT GTID_SUBSET(?, ?)", subset, set).Scan(&result)
+	return result == 1, err
+}
+
+// WaitForGtidSet waits until a node has executed all the transactions in gtidSet
+func WaitForGtidSet(nodeDir, gtidSet string, timeout time.Duration) error {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result int
+	err = db.QueryRow("SELECT WAIT_FOR_EXECUTED_GTID_SET(?, ?)", gtidSet, int(timeout.Seconds())).Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result != 0 {
+		return fmt.Errorf("%s did not execute the transactions %s within %s", nodeDir, gtidSet, timeout)
+	}
+	return nil
+}
+
+// GroupMembers returns the members of the replication group, as seen by a node.
+// The role is only available from MySQL 8.0
+func GroupMembers(nodeDir string) ([]GroupMember, error) {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := queryRows(db, "SELECT * FROM performance_schema.replication_group_members")
+	if err != nil {
+		return nil, err
+	}
+	var members []GroupMember
+	for _, row := range rows {
+		// Before the group starts, a node reports itself with an empty ID
+		if row["MEMBER_ID"] == "" {
+			continue
+		}
+		member := GroupMember{
+			Id:    row["MEMBER_ID"],
+			Host:  row["MEMBER_HOST"],
+			State: row["MEMBER_STATE"],
+			Role:  row["MEMBER_ROLE"],
+		}
+		member.Port, _ = strconv.Atoi(row["MEMBER_PORT"])
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// ServerUuid returns the server UUID of a node
+func ServerUuid(nodeDir string) (string, error) {
+	db, err := Connect(nodeDir)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	var uuid string
+	err = db.QueryRow("SELECT @@server_uuid").Scan(&uuid)
+	return uuid, err
+}
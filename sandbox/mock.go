@@ -24,6 +24,7 @@ import (
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
 	"github.com/pkg/errors"
 )
 
@@ -70,6 +71,7 @@ func SetMockEnvironment(mockUpperDir string) error {
 	_ = os.Setenv("HOME", home)
 	_ = os.Setenv("SLEEP_TIME", "0")
 	_ = os.Setenv("SB_MOCKING", "1")
+	lifecycle.NativeEnabled = false
 	defaults.ResetDefaults()
 	defaults.ConfigurationDir = path.Join(home, defaults.ConfigurationDirName)
 	defaults.ConfigurationFile = path.Join(home, defaults.ConfigurationDirName, defaults.ConfigurationFileName)
@@ -89,6 +91,7 @@ func RemoveMockEnvironment(mockUpperDir string) error {
 	_ = os.Setenv("SANDBOX_HOME", saveSandboxHome)
 	_ = os.Setenv("SANDBOX_BINARY", saveSandboxBinary)
 	_ = os.Setenv("SLEEP_TIME", "")
+	lifecycle.NativeEnabled = true
 	defaults.ResetDefaults()
 	return nil
 }
@@ -64,6 +64,16 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 	} else {
 		sandboxDef.SandboxDir = path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
 	}
+	if sandboxDef.Reconcile && common.DirExists(sandboxDef.SandboxDir) {
+		var upToDate bool
+		sandboxDef, upToDate, err = reconcileSandbox(sandboxDef, sbType, nodes)
+		if err != nil {
+			return emptyStringMap, err
+		}
+		if upToDate {
+			return emptyStringMap, nil
+		}
+	}
 	if common.DirExists(sandboxDef.SandboxDir) {
 		sandboxDef, err = checkDirectory(sandboxDef)
 		if err != nil {
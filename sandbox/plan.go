@@ -0,0 +1,660 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/downloads"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
+)
+
+// DeploymentPlanVersion is the version of the plan format that this
+// release of dbdeployer can read and write
+const DeploymentPlanVersion = 1
+
+// Topologies that can be used in a deployment plan, in addition to
+// the replication topologies listed in globals.AllowedTopologies
+const (
+	PlanTopologySingle   = globals.SbTypeSingle
+	PlanTopologyMultiple = globals.SbTypeMultiple
+)
+
+// PlanSandbox describes one sandbox in a deployment plan.
+// Its fields mirror the options of 'dbdeployer deploy'
+type PlanSandbox struct {
+	Name                string   `json:"name,omitempty" yaml:"name,omitempty"`                                     // Name of the sandbox directory
+	Version             string   `json:"version" yaml:"version"`                                                   // MySQL version (x.x.xx or x.x)
+	Flavor              string   `json:"flavor,omitempty" yaml:"flavor,omitempty"`                                 // Flavor of the binaries
+	BinaryDir           string   `json:"binary-dir,omitempty" yaml:"binary-dir,omitempty"`                         // Name of the binaries directory, when different from version
+	ClientFrom          string   `json:"client-from,omitempty" yaml:"client-from,omitempty"`                       // Where to get the client binaries from
+	Topology            string   `json:"topology,omitempty" yaml:"topology,omitempty"`                             // single, multiple, or a replication topology
+	Nodes               int      `json:"nodes,omitempty" yaml:"nodes,omitempty"`                                   // How many nodes for multiple and replication
+	NdbNodes            int      `json:"ndb-nodes,omitempty" yaml:"ndb-nodes,omitempty"`                           // How many NDB nodes for NDB topology
+	Port                int      `json:"port,omitempty" yaml:"port,omitempty"`                                     // Port for a single sandbox
+	BasePort            int      `json:"base-port,omitempty" yaml:"base-port,omitempty"`                           // Base port for multiple sandboxes
+	BaseServerId        int      `json:"base-server-id,omitempty" yaml:"base-server-id,omitempty"`                 // Base server ID for multiple sandboxes
+	Gtid                bool     `json:"gtid,omitempty" yaml:"gtid,omitempty"`                                     // Enables GTID
+	SemiSync            bool     `json:"semi-sync,omitempty" yaml:"semi-sync,omitempty"`                           // Enables semi-synchronous replication
+	SinglePrimary       bool     `json:"single-primary,omitempty" yaml:"single-primary,omitempty"`                 // Single primary for group replication
+	ReadOnlySlaves      bool     `json:"read-only-slaves,omitempty" yaml:"read-only-slaves,omitempty"`             // Sets read_only for slaves
+	SuperReadOnlySlaves bool     `json:"super-read-only-slaves,omitempty" yaml:"super-read-only-slaves,omitempty"` // Sets super_read_only for slaves
+	MasterIp            string   `json:"master-ip,omitempty" yaml:"master-ip,omitempty"`                           // Which IP the slaves will connect to
+	MasterList          string   `json:"master-list,omitempty" yaml:"master-list,omitempty"`                       // Masters in fan-in deployments
+	SlaveList           string   `json:"slave-list,omitempty" yaml:"slave-list,omitempty"`                         // Slaves in fan-in deployments
+	MyCnfOptions        []string `json:"my-cnf-options,omitempty" yaml:"my-cnf-options,omitempty"`                 // Options to add to my.sandbox.cnf
+	InitOptions         []string `json:"init-options,omitempty" yaml:"init-options,omitempty"`                     // Options to use during initialization
+	PreGrantsSql        []string `json:"pre-grants-sql,omitempty" yaml:"pre-grants-sql,omitempty"`                 // SQL to run before loading grants
+	PostGrantsSql       []string `json:"post-grants-sql,omitempty" yaml:"post-grants-sql,omitempty"`               // SQL to run after loading grants
+	DbUser              string   `json:"db-user,omitempty" yaml:"db-user,omitempty"`                               // Database user
+	DbPassword          string   `json:"db-password,omitempty" yaml:"db-password,omitempty"`                       // Database password
+	RplUser             string   `json:"rpl-user,omitempty" yaml:"rpl-user,omitempty"`                             // Replication user
+	RplPassword         string   `json:"rpl-password,omitempty" yaml:"rpl-password,omitempty"`                     // Replication password
+	DefaultRole         string   `json:"default-role,omitempty" yaml:"default-role,omitempty"`                     // Role for the default user (8.0+)
+	TaskUser            string   `json:"task-user,omitempty" yaml:"task-user,omitempty"`                           // Task user to create (8.0+)
+	TaskUserRole        string   `json:"task-user-role,omitempty" yaml:"task-user-role,omitempty"`                 // Role for the task user (8.0+)
+	EnableMysqlX        bool     `json:"enable-mysqlx,omitempty" yaml:"enable-mysqlx,omitempty"`                   // Enables MySQLX plugin (5.7.12+)
+	DisableMysqlX       bool     `json:"disable-mysqlx,omitempty" yaml:"disable-mysqlx,omitempty"`                 // Disables MySQLX plugin (8.0.11+)
+	EnableAdminAddress  bool     `json:"enable-admin-address,omitempty" yaml:"enable-admin-address,omitempty"`     // Enables admin address (8.0.14+)
+	SkipStart           bool     `json:"skip-start,omitempty" yaml:"skip-start,omitempty"`                         // Does not start the server
+}
+
+// DeploymentPlan is a versioned collection of sandboxes to deploy
+type DeploymentPlan struct {
+	PlanVersion   int           `json:"plan-version" yaml:"plan-version"`
+	SandboxBinary string        `json:"sandbox-binary,omitempty" yaml:"sandbox-binary,omitempty"`
+	SandboxHome   string        `json:"sandbox-home,omitempty" yaml:"sandbox-home,omitempty"`
+	Sandboxes     []PlanSandbox `json:"sandboxes" yaml:"sandboxes"`
+}
+
+// Maps the sandbox types found in sbdescription.json to plan topologies
+var sbTypeToTopology = map[string]string{
+	globals.SbTypeSingle:   PlanTopologySingle,
+	globals.SbTypeMultiple: PlanTopologyMultiple,
+	"master-slave":         globals.MasterSlaveLabel,
+	"group-multi-primary":  globals.GroupLabel,
+	"group-single-primary": globals.GroupLabel,
+	"fan-in":               globals.FanInLabel,
+	"all-masters":          globals.AllMastersLabel,
+	"pxc":                  globals.PxcLabel,
+	"ndb":                  globals.NdbLabel,
+}
+
+func isYamlFile(fileName string) bool {
+	return strings.HasSuffix(fileName, ".yaml") || strings.HasSuffix(fileName, ".yml")
+}
+
+// ReadDeploymentPlan reads a plan from a YAML or JSON file.
+// The format is chosen from the file extension
+func ReadDeploymentPlan(fileName string) (DeploymentPlan, error) {
+	var plan DeploymentPlan
+	if !common.FileExists(fileName) {
+		return plan, fmt.Errorf(globals.ErrFileNotFound, fileName)
+	}
+	contents, err := common.SlurpAsBytes(fileName)
+	if err != nil {
+		return plan, err
+	}
+	if isYamlFile(fileName) {
+		err = yaml.UnmarshalStrict(contents, &plan)
+	} else {
+		decoder := json.NewDecoder(strings.NewReader(string(contents)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&plan)
+	}
+	if err != nil {
+		return DeploymentPlan{}, errors.Wrapf(err, "error decoding deployment plan %s", fileName)
+	}
+	return plan, nil
+}
+
+// DeploymentPlanToText encodes a plan as YAML (when useYaml is set) or JSON
+func DeploymentPlanToText(plan DeploymentPlan, useYaml bool) (string, error) {
+	var contents []byte
+	var err error
+	if useYaml {
+		contents, err = yaml.Marshal(plan)
+	} else {
+		contents, err = json.MarshalIndent(plan, "", "  ")
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error encoding deployment plan")
+	}
+	return string(contents), nil
+}
+
+// WriteDeploymentPlan saves a plan to file, using the extension to choose the format
+func WriteDeploymentPlan(plan DeploymentPlan, fileName string) error {
+	text, err := DeploymentPlanToText(plan, isYamlFile(fileName))
+	if err != nil {
+		return err
+	}
+	return common.WriteString(text, fileName)
+}
+
+func isReplicationTopology(topology string) bool {
+	for _, t := range globals.AllowedTopologies {
+		if t == topology {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the topology of a plan entry, using 'single' when none was given
+func (ps PlanSandbox) topology() string {
+	if ps.Topology == "" {
+		return PlanTopologySingle
+	}
+	return ps.Topology
+}
+
+// Returns the number of nodes of a plan entry, using the defaults
+// of the command line when none was given
+func (ps PlanSandbox) nodes() int {
+	switch {
+	case ps.topology() == PlanTopologySingle:
+		return 1
+	case ps.Nodes > 0:
+		return ps.Nodes
+	}
+	return globals.NodesValue
+}
+
+// Validate checks the plan structure. When sandboxHome is not empty, it also checks
+// the directories where the sandboxes will be deployed (see validateTargets)
+func (plan DeploymentPlan) Validate(sandboxBinary, sandboxHome string, allowExisting bool) error {
+	if plan.PlanVersion == 0 {
+		return fmt.Errorf("missing plan-version in deployment plan")
+	}
+	if plan.PlanVersion > DeploymentPlanVersion {
+		return fmt.Errorf("deployment plan version %d not supported. Maximum supported version is %d",
+			plan.PlanVersion, DeploymentPlanVersion)
+	}
+	if len(plan.Sandboxes) == 0 {
+		return fmt.Errorf("no sandboxes defined in deployment plan")
+	}
+	versionRe := regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
+	names := make(map[string]int)
+	usedPorts := make(map[int]int)
+	for i, ps := range plan.Sandboxes {
+		entry := fmt.Sprintf("sandbox #%d", i+1)
+		if ps.Name != "" {
+			entry = fmt.Sprintf("sandbox #%d (%s)", i+1, ps.Name)
+			if strings.Contains(ps.Name, "/") || ps.Name == globals.ForbiddenDirName {
+				return fmt.Errorf("%s: invalid name '%s'", entry, ps.Name)
+			}
+			if previous, found := names[ps.Name]; found {
+				return fmt.Errorf("%s: name '%s' already used by sandbox #%d", entry, ps.Name, previous)
+			}
+			names[ps.Name] = i + 1
+		}
+		if ps.Version == "" {
+			return fmt.Errorf("%s: missing version", entry)
+		}
+		if !versionRe.MatchString(ps.Version) {
+			return fmt.Errorf("%s: invalid version '%s'", entry, ps.Version)
+		}
+		if ps.Flavor != "" {
+			err := common.CheckFlavorSupport(ps.Flavor)
+			if err != nil {
+				return fmt.Errorf("%s: %s", entry, err)
+			}
+		}
+		topology := ps.topology()
+		if topology != PlanTopologySingle && topology != PlanTopologyMultiple && !isReplicationTopology(topology) {
+			return fmt.Errorf("%s: unrecognized topology '%s'. Accepted: %s, %s, %v",
+				entry, topology, PlanTopologySingle, PlanTopologyMultiple, globals.AllowedTopologies)
+		}
+		if ps.Nodes < 0 || ps.NdbNodes < 0 {
+			return fmt.Errorf("%s: the number of nodes cannot be negative", entry)
+		}
+		if topology == PlanTopologySingle && ps.Nodes > 1 {
+			return fmt.Errorf("%s: topology '%s' cannot have %d nodes", entry, topology, ps.Nodes)
+		}
+		if topology != PlanTopologySingle && ps.Port != 0 {
+			return fmt.Errorf("%s: 'port' can only be used with a single sandbox. Use 'base-port' instead", entry)
+		}
+		if topology == PlanTopologySingle && ps.BasePort != 0 {
+			return fmt.Errorf("%s: 'base-port' cannot be used with a single sandbox. Use 'port' instead", entry)
+		}
+		if ps.SemiSync && topology != globals.MasterSlaveLabel {
+			return fmt.Errorf("%s: semi-sync is only available with %s topology", entry, globals.MasterSlaveLabel)
+		}
+		if ps.SinglePrimary && topology != globals.GroupLabel {
+			return fmt.Errorf("%s: single-primary can only be used with %s topology", entry, globals.GroupLabel)
+		}
+		if ps.NdbNodes != 0 && topology != globals.NdbLabel {
+			return fmt.Errorf("%s: ndb-nodes can only be used with %s topology", entry, globals.NdbLabel)
+		}
+		if ps.ReadOnlySlaves && ps.SuperReadOnlySlaves {
+			return fmt.Errorf("%s: only one of read-only-slaves and super-read-only-slaves should be used", entry)
+		}
+		if ps.EnableMysqlX && ps.DisableMysqlX {
+			return fmt.Errorf("%s: enable-mysqlx and disable-mysqlx cannot be used together", entry)
+		}
+		if ps.DbUser == "root" || ps.RplUser == "root" {
+			return fmt.Errorf("%s: the database and replication users cannot be 'root'", entry)
+		}
+		if ps.TaskUser != "" && ps.TaskUserRole == "" {
+			return fmt.Errorf("%s: task-user defined but task-user-role is empty", entry)
+		}
+		if ps.MasterIp != "" && !common.IsIPV4(ps.MasterIp) {
+			return fmt.Errorf("%s: master-ip %s is not a valid IPV4", entry, ps.MasterIp)
+		}
+		// Explicit ports must be in the allowed range and must not overlap
+		// with the ones requested by other sandboxes in the same plan
+		firstPort := ps.Port
+		howMany := 1
+		if ps.BasePort != 0 {
+			firstPort = ps.BasePort + 1
+			howMany = ps.nodes()
+		}
+		if firstPort != 0 {
+			lastPort := firstPort + howMany - 1
+			if firstPort < globals.MinAllowedPort || lastPort > globals.MaxAllowedPort {
+				return fmt.Errorf("%s: ports %d-%d outside the allowed range %d-%d",
+					entry, firstPort, lastPort, globals.MinAllowedPort, globals.MaxAllowedPort)
+			}
+			for port := firstPort; port <= lastPort; port++ {
+				if previous, found := usedPorts[port]; found {
+					return fmt.Errorf("%s: port %d already requested by sandbox #%d", entry, port, previous)
+				}
+				usedPorts[port] = i + 1
+			}
+		}
+	}
+	if sandboxHome != "" {
+		return plan.validateTargets(sandboxBinary, sandboxHome, allowExisting)
+	}
+	return nil
+}
+
+// resolvePlanVersion returns the full version of a plan entry, using the lock file
+// or the latest binaries for short versions, and the name of its binaries directory
+func resolvePlanVersion(ps PlanSandbox, sandboxBinary string) (string, string, error) {
+	version := ps.Version
+	if regexp.MustCompile(`^\d+\.\d+$`).MatchString(version) {
+		lockedVersion, lockFile, err := downloads.LockedVersion(version, ps.Flavor)
+		if err != nil {
+			return "", "", err
+		}
+		if lockedVersion != "" && !common.DirExists(path.Join(sandboxBinary, lockedVersion)) {
+			return "", "", fmt.Errorf("version %s is locked to %s in %s, but %s was not found in %s",
+				version, lockedVersion, lockFile, lockedVersion, sandboxBinary)
+		}
+		fullVersion := lockedVersion
+		if fullVersion == "" {
+			fullVersion = common.LatestVersion(sandboxBinary, version)
+		}
+		if fullVersion == "" {
+			return "", "", fmt.Errorf("no full version found for %s in %s", version, sandboxBinary)
+		}
+		version = fullVersion
+	}
+	basedirName := ps.BinaryDir
+	if basedirName == "" {
+		basedirName = version
+	}
+	return version, basedirName, nil
+}
+
+// planDirName returns the directory that a plan entry will use in the sandbox home,
+// following the same rules used when the sandbox is created
+func planDirName(ps PlanSandbox, version, basedirName string) string {
+	if ps.Name != "" {
+		return ps.Name
+	}
+	d := defaults.Defaults()
+	origin := common.VersionToName(basedirName)
+	switch ps.topology() {
+	case PlanTopologySingle:
+		if version != basedirName {
+			return d.SandboxPrefix + basedirName
+		}
+		return d.SandboxPrefix + common.VersionToName(version)
+	case PlanTopologyMultiple:
+		return d.MultiplePrefix + origin
+	case globals.MasterSlaveLabel:
+		return d.MasterSlavePrefix + origin
+	case globals.GroupLabel:
+		if ps.SinglePrimary {
+			return d.GroupSpPrefix + origin
+		}
+		return d.GroupPrefix + origin
+	case globals.FanInLabel:
+		return d.FanInPrefix + origin
+	case globals.AllMastersLabel:
+		return d.AllMastersPrefix + origin
+	case globals.PxcLabel:
+		return d.PxcPrefix + origin
+	case globals.NdbLabel:
+		return d.NdbPrefix + origin
+	}
+	return ""
+}
+
+// validateTargets checks that the plan entries don't deploy into the same directory,
+// and, unless allowExisting is set, that their directories don't exist yet
+func (plan DeploymentPlan) validateTargets(sandboxBinary, sandboxHome string, allowExisting bool) error {
+	dirNames := make(map[string]int)
+	for i, ps := range plan.Sandboxes {
+		entry := fmt.Sprintf("sandbox #%d", i+1)
+		version, basedirName, err := resolvePlanVersion(ps, sandboxBinary)
+		if err != nil {
+			return fmt.Errorf("%s: %s", entry, err)
+		}
+		dirName := planDirName(ps, version, basedirName)
+		if previous, found := dirNames[dirName]; found {
+			return fmt.Errorf("%s: directory '%s' already used by sandbox #%d. Set a different 'name' for one of them",
+				entry, dirName, previous)
+		}
+		dirNames[dirName] = i + 1
+		if !allowExisting && common.DirExists(path.Join(sandboxHome, dirName)) {
+			return fmt.Errorf("%s: directory %s already exists. Use --%s or --%s to deploy over it",
+				entry, path.Join(sandboxHome, dirName), globals.ForceLabel, globals.ReconcileLabel)
+		}
+	}
+	return nil
+}
+
+// PlanSandboxToDefinition converts a plan entry into the definitions needed
+// to create the sandbox. It checks that binaries and capabilities are
+// available, but it does not write anything to disk.
+func PlanSandboxToDefinition(ps PlanSandbox, sandboxBinary, sandboxHome string) (SandboxDef, ReplicationData, error) {
+	var sd SandboxDef
+	var rd ReplicationData
+	topology := ps.topology()
+
+	version, basedirName, err := resolvePlanVersion(ps, sandboxBinary)
+	if err != nil {
+		return sd, rd, err
+	}
+	sd.Version = version
+	sd.BasedirName = basedirName
+	sd.Basedir = path.Join(sandboxBinary, basedirName)
+	if !common.DirExists(sd.Basedir) {
+		return sd, rd, fmt.Errorf(globals.ErrBaseDirectoryNotFound, sd.Basedir)
+	}
+	if ps.ClientFrom != "" {
+		sd.ClientBasedir = path.Join(sandboxBinary, ps.ClientFrom)
+		if !common.DirExists(sd.ClientBasedir) {
+			return sd, rd, fmt.Errorf(globals.ErrDirectoryNotFound, sd.ClientBasedir)
+		}
+	}
+
+	sd.Flavor = ps.Flavor
+	flavorFile := path.Join(sd.Basedir, globals.FlavorFileName)
+	if common.FileExists(flavorFile) {
+		flavorText, err := common.SlurpAsString(flavorFile)
+		if err != nil {
+			return sd, rd, err
+		}
+		flavorText = strings.TrimSpace(flavorText)
+		if sd.Flavor != "" && sd.Flavor != flavorText {
+			return sd, rd, fmt.Errorf("plan flavor %s doesn't match found flavor %s", sd.Flavor, flavorText)
+		}
+		sd.Flavor = flavorText
+	}
+	if sd.Flavor == "" {
+		sd.Flavor = common.DetectBinaryFlavor(sd.Basedir)
+	}
+	err = common.CheckFlavorSupport(sd.Flavor)
+	if err != nil {
+		return sd, rd, err
+	}
+	if topology != PlanTopologySingle && sd.Flavor == common.TiDbFlavor {
+		return sd, rd, fmt.Errorf("flavor '%s' is not suitable to create %s sandboxes", common.TiDbFlavor, topology)
+	}
+
+	sd.Port, err = common.VersionToPort(sd.Version)
+	if err != nil {
+		return sd, rd, errors.Wrapf(err, "can't convert '%s' into port number", sd.Version)
+	}
+	if ps.Port > 0 {
+		sd.Port = ps.Port
+		sd.UserPort = ps.Port
+	}
+	sd.BasePort = ps.BasePort
+	sd.BaseServerId = ps.BaseServerId
+	sd.DirName = ps.Name
+	sd.SandboxDir = sandboxHome
+	sd.SbHost = globals.LocalHostIP
+	sd.InstalledPorts, err = common.GetInstalledPorts(sandboxHome)
+	if err != nil {
+		return sd, rd, err
+	}
+	sd.InstalledPorts = append(sd.InstalledPorts, defaults.Defaults().ReservedPorts...)
+	sd.ShellPath = defaults.Defaults().ShellPath
+	sd.SkipStart = ps.SkipStart
+	sd.LoadGrants = !ps.SkipStart
+	sd.DbUser = common.CoalesceString(ps.DbUser, globals.DbUserValue)
+	sd.DbPassword = common.CoalesceString(ps.DbPassword, globals.DbPasswordValue)
+	sd.RplUser = common.CoalesceString(ps.RplUser, globals.RplUserValue)
+	sd.RplPassword = common.CoalesceString(ps.RplPassword, globals.RplPasswordValue)
+	sd.RemoteAccess = globals.RemoteAccessValue
+	sd.BindAddress = globals.BindAddressValue
+	sd.DefaultRole = common.CoalesceString(ps.DefaultRole, "R_DO_IT_ALL")
+	sd.CustomRoleName = "R_CUSTOM"
+	sd.CustomRolePrivileges = "ALL PRIVILEGES"
+	sd.CustomRoleTarget = "*.*"
+	sd.CustomRoleExtra = "WITH GRANT OPTION"
+	sd.TaskUser = ps.TaskUser
+	sd.TaskUserRole = ps.TaskUserRole
+	sd.MyCnfOptions = ps.MyCnfOptions
+	sd.InitOptions = ps.InitOptions
+	sd.PreGrantsSql = ps.PreGrantsSql
+	sd.PostGrantsSql = ps.PostGrantsSql
+	sd.EnableMysqlX = ps.EnableMysqlX
+	sd.DisableMysqlX = ps.DisableMysqlX
+	sd.EnableAdminAddress = ps.EnableAdminAddress
+	sd.SlavesReadOnly = ps.ReadOnlySlaves
+	sd.SlavesSuperReadOnly = ps.SuperReadOnlySlaves
+	sd.SinglePrimary = ps.SinglePrimary
+
+	if ps.TaskUser != "" || ps.DefaultRole != "" {
+		isRoleEnabled, err := common.HasCapability(sd.Flavor, common.Roles, sd.Version)
+		if err != nil {
+			return sd, rd, err
+		}
+		if !isRoleEnabled {
+			return sd, rd, fmt.Errorf("options about roles requires version 8.0+")
+		}
+	}
+	if ps.Gtid {
+		isMinimumGtid, err := common.HasCapability(sd.Flavor, common.GTID, sd.Version)
+		if err != nil {
+			return sd, rd, err
+		}
+		if !isMinimumGtid {
+			return sd, rd, fmt.Errorf(globals.ErrOptionRequiresVersion, globals.GtidLabel,
+				common.IntSliceToDottedString(globals.MinimumGtidVersion))
+		}
+		isEnhancedGtid, err := common.HasCapability(sd.Flavor, common.EnhancedGTID, sd.Version)
+		if err != nil {
+			return sd, rd, err
+		}
+		templateName := globals.TmplGtidOptions56
+		if isEnhancedGtid {
+			templateName = globals.TmplGtidOptions57
+		}
+		sd.GtidOptions = SingleTemplates[templateName].Contents
+		sd.ReplCrashSafeOptions = SingleTemplates[globals.TmplReplCrashSafeOptions].Contents
+		sd.ReplOptions = SingleTemplates[globals.TmplReplicationOptions].Contents
+		sd.PortAsServerId = true
+	}
+	if ps.SemiSync {
+		isMinimumSync, err := common.HasCapability(sd.Flavor, common.SemiSynch, sd.Version)
+		if err != nil {
+			return sd, rd, err
+		}
+		if !isMinimumSync {
+			return sd, rd, fmt.Errorf(globals.ErrOptionRequiresVersion, globals.SemiSyncLabel,
+				common.IntSliceToDottedString(globals.MinimumSemiSyncVersion))
+		}
+		sd.SemiSyncOptions = SingleTemplates[globals.TmplSemisyncMasterOptions].Contents
+	}
+	if isReplicationTopology(topology) {
+		sd.ReplOptions = SingleTemplates[globals.TmplReplicationOptions].Contents
+		_, err = checkReadOnlyFlags(sd)
+		if err != nil {
+			return sd, rd, err
+		}
+	}
+	if topology == PlanTopologyMultiple {
+		sd.SBType = globals.SbTypeMultiple
+	}
+	ndbNodes := ps.NdbNodes
+	if ndbNodes == 0 {
+		ndbNodes = globals.NdbNodesValue
+	}
+	rd = ReplicationData{
+		Topology:   topology,
+		Nodes:      ps.nodes(),
+		NdbNodes:   ndbNodes,
+		MasterIp:   common.CoalesceString(ps.MasterIp, globals.MasterIpValue),
+		MasterList: common.CoalesceString(ps.MasterList, globals.MasterListValue),
+		SlaveList:  common.CoalesceString(ps.SlaveList, globals.SlaveListValue),
+	}
+	return sd, rd, nil
+}
+
+// DeployPlanSandbox creates the sandbox described by a definition
+// returned by PlanSandboxToDefinition
+func DeployPlanSandbox(sd SandboxDef, rd ReplicationData) error {
+	switch rd.Topology {
+	case PlanTopologySingle:
+		return CreateStandaloneSandbox(sd)
+	case PlanTopologyMultiple:
+		_, err := CreateMultipleSandbox(sd, sd.BasedirName, rd.Nodes)
+		return err
+	}
+	return CreateReplicationSandbox(sd, sd.BasedirName, rd)
+}
+
+// readPlanNodeSettings fills the users and the GTID setting of a plan entry,
+// using the configuration and connection files of the sandbox (or of its first node).
+// Users are only recorded when they differ from the defaults.
+func readPlanNodeSettings(ps *PlanSandbox, sandboxDir string) error {
+	nodeDir := ""
+	for _, dir := range []string{
+		sandboxDir,
+		path.Join(sandboxDir, defaults.Defaults().MasterName),
+		path.Join(sandboxDir, defaults.Defaults().NodePrefix+"1"),
+	} {
+		if common.FileExists(path.Join(dir, globals.ScriptMySandboxCnf)) {
+			nodeDir = dir
+			break
+		}
+	}
+	if nodeDir == "" {
+		return nil
+	}
+	config, err := common.ParseConfigFile(path.Join(nodeDir, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return err
+	}
+	for _, kv := range config["client"] {
+		switch kv.Key {
+		case "user":
+			if kv.Value != globals.DbUserValue {
+				ps.DbUser = kv.Value
+			}
+		case "password":
+			if kv.Value != globals.DbPasswordValue {
+				ps.DbPassword = kv.Value
+			}
+		}
+	}
+	// Group replication enables GTID on its own
+	if ps.Topology == PlanTopologySingle || ps.Topology == PlanTopologyMultiple || ps.Topology == globals.MasterSlaveLabel {
+		for _, kv := range config["mysqld"] {
+			if strings.ReplaceAll(kv.Key, "-", "_") == "gtid_mode" && strings.EqualFold(kv.Value, "ON") {
+				ps.Gtid = true
+			}
+		}
+	}
+	if common.FileExists(path.Join(nodeDir, globals.ScriptConnectionJson)) {
+		credentials, err := lifecycle.ReplicationCredentials(nodeDir)
+		if err != nil {
+			return err
+		}
+		if credentials.User != globals.RplUserValue {
+			ps.RplUser = credentials.User
+		}
+		if credentials.Password != globals.RplPasswordValue {
+			ps.RplPassword = credentials.Password
+		}
+	}
+	return nil
+}
+
+// DeploymentPlanFromSandbox builds a plan that reproduces an existing sandbox
+func DeploymentPlanFromSandbox(sandboxHome, sandboxName string) (DeploymentPlan, error) {
+	sandboxDir := path.Join(sandboxHome, sandboxName)
+	sbd, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return DeploymentPlan{}, err
+	}
+	topology, found := sbTypeToTopology[sbd.SBType]
+	if !found {
+		return DeploymentPlan{}, fmt.Errorf("sandbox type '%s' can't be described in a deployment plan", sbd.SBType)
+	}
+	ps := PlanSandbox{
+		Name:     sandboxName,
+		Version:  sbd.Version,
+		Flavor:   sbd.Flavor,
+		Topology: topology,
+	}
+	basedirName := common.BaseName(sbd.Basedir)
+	if basedirName != sbd.Version {
+		ps.BinaryDir = basedirName
+	}
+	if sbd.ClientBasedir != "" && sbd.ClientBasedir != sbd.Basedir {
+		ps.ClientFrom = common.BaseName(sbd.ClientBasedir)
+	}
+	switch topology {
+	case PlanTopologySingle:
+		if len(sbd.Port) > 0 {
+			ps.Port = sbd.Port[0]
+		}
+	default:
+		ps.Nodes = sbd.Nodes
+		if sbd.SBType == "group-single-primary" {
+			ps.SinglePrimary = true
+		}
+	}
+	ps.MyCnfOptions = sbd.MyCnfOptions
+	err = readPlanNodeSettings(&ps, sandboxDir)
+	if err != nil {
+		return DeploymentPlan{}, err
+	}
+	return DeploymentPlan{
+		PlanVersion:   DeploymentPlanVersion,
+		SandboxBinary: common.DirName(sbd.Basedir),
+		Sandboxes:     []PlanSandbox{ps},
+	}, nil
+}
@@ -0,0 +1,194 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+const yamlPlan = `
+plan-version: 1
+sandboxes:
+  - version: 8.0.36
+    topology: master-slave
+    nodes: 3
+    gtid: true
+    my-cnf-options:
+      - max_connections=500
+  - version: "5.7"
+    name: single57
+    port: 15744
+`
+
+func TestReadDeploymentPlan(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := path.Join(dir, "plan.yaml")
+	err := common.WriteString(yamlPlan, yamlFile)
+	compare.OkIsNil("writing YAML plan", err, t)
+
+	plan, err := ReadDeploymentPlan(yamlFile)
+	compare.OkIsNil("reading YAML plan", err, t)
+	compare.OkEqualInt("plan version", plan.PlanVersion, 1, t)
+	compare.OkEqualInt("plan sandboxes", len(plan.Sandboxes), 2, t)
+	compare.OkEqualString("first topology", plan.Sandboxes[0].Topology, "master-slave", t)
+	compare.OkEqualBool("first gtid", plan.Sandboxes[0].Gtid, true, t)
+	compare.OkEqualStringSlices(t, plan.Sandboxes[0].MyCnfOptions, []string{"max_connections=500"})
+	compare.OkEqualString("second version", plan.Sandboxes[1].Version, "5.7", t)
+	compare.OkEqualInt("second port", plan.Sandboxes[1].Port, 15744, t)
+	compare.OkIsNil("validation", plan.Validate("", "", false), t)
+
+	// A plan written as JSON must be read back unchanged
+	jsonFile := path.Join(dir, "plan.json")
+	err = WriteDeploymentPlan(plan, jsonFile)
+	compare.OkIsNil("writing JSON plan", err, t)
+	jsonPlan, err := ReadDeploymentPlan(jsonFile)
+	compare.OkIsNil("reading JSON plan", err, t)
+	if !reflect.DeepEqual(jsonPlan, plan) {
+		t.Errorf("JSON round trip: expected %v - got %v", plan, jsonPlan)
+	}
+
+	err = common.WriteString("plan-version: 1\nunknown-field: 2\n", yamlFile)
+	compare.OkIsNil("writing wrong YAML plan", err, t)
+	_, err = ReadDeploymentPlan(yamlFile)
+	compare.OkIsNotNil("unknown field", err, t)
+}
+
+func TestValidateDeploymentPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    DeploymentPlan
+		isValid bool
+	}{
+		{"no-version", DeploymentPlan{Sandboxes: []PlanSandbox{{Version: "8.0.36"}}}, false},
+		{"future-version", DeploymentPlan{PlanVersion: DeploymentPlanVersion + 1, Sandboxes: []PlanSandbox{{Version: "8.0.36"}}}, false},
+		{"no-sandboxes", DeploymentPlan{PlanVersion: 1}, false},
+		{"single", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36"}}}, true},
+		{"short-version", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0"}}}, true},
+		{"bad-version", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "eight"}}}, false},
+		{"bad-flavor", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", Flavor: "oracle"}}}, false},
+		{"bad-topology", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", Topology: "star"}}}, false},
+		{"group", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", Topology: "group", SinglePrimary: true}}}, true},
+		{"single-nodes", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", Nodes: 3}}}, false},
+		{"semi-sync-group", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", Topology: "group", SemiSync: true}}}, false},
+		{"port-multiple", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", Topology: "multiple", Port: 9000}}}, false},
+		{"port-too-low", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", Port: 80}}}, false},
+		{"root-user", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{{Version: "8.0.36", DbUser: "root"}}}, false},
+		{"same-name", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{
+			{Version: "8.0.36", Name: "one"},
+			{Version: "5.7.44", Name: "one"}}}, false},
+		{"port-overlap", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{
+			{Version: "8.0.36", Topology: "master-slave", BasePort: 20000, Nodes: 3},
+			{Version: "5.7.44", Port: 20002}}}, false},
+		{"port-no-overlap", DeploymentPlan{PlanVersion: 1, Sandboxes: []PlanSandbox{
+			{Version: "8.0.36", Topology: "master-slave", BasePort: 20000, Nodes: 3},
+			{Version: "5.7.44", Port: 20004}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plan.Validate("", "", false)
+			if tt.isValid && err != nil {
+				t.Errorf("expected valid plan - got error %s", err)
+			}
+			if !tt.isValid && err == nil {
+				t.Errorf("expected invalid plan - got no error")
+			}
+		})
+	}
+}
+
+func TestValidatePlanTargets(t *testing.T) {
+	sandboxBinary := t.TempDir()
+	sandboxHome := t.TempDir()
+	err := os.Mkdir(path.Join(sandboxHome, "existing"), 0755)
+	compare.OkIsNil("creating directory", err, t)
+
+	tests := []struct {
+		name          string
+		sandboxes     []PlanSandbox
+		allowExisting bool
+		isValid       bool
+	}{
+		{"different-topologies", []PlanSandbox{
+			{Version: "8.0.36"},
+			{Version: "8.0.36", Topology: "master-slave"}}, false, true},
+		{"same-default-dir", []PlanSandbox{
+			{Version: "8.0.36", Topology: "master-slave"},
+			{Version: "8.0.36", Topology: "master-slave", BasePort: 30000}}, false, false},
+		{"name-clashing-with-default", []PlanSandbox{
+			{Version: "8.0.36"},
+			{Version: "5.7.44", Name: "msb_8_0_36"}}, false, false},
+		{"group-single-primary", []PlanSandbox{
+			{Version: "8.0.36", Topology: "group"},
+			{Version: "8.0.36", Topology: "group", SinglePrimary: true}}, false, true},
+		{"existing-dir", []PlanSandbox{{Version: "8.0.36", Name: "existing"}}, false, false},
+		{"existing-dir-allowed", []PlanSandbox{{Version: "8.0.36", Name: "existing"}}, true, true},
+		{"short-version-not-found", []PlanSandbox{{Version: "8.0"}}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := DeploymentPlan{PlanVersion: 1, Sandboxes: tt.sandboxes}
+			err := plan.Validate(sandboxBinary, sandboxHome, tt.allowExisting)
+			if tt.isValid && err != nil {
+				t.Errorf("expected valid plan - got error %s", err)
+			}
+			if !tt.isValid && err == nil {
+				t.Errorf("expected invalid plan - got no error")
+			}
+		})
+	}
+}
+
+func TestDeploymentPlanFromSandbox(t *testing.T) {
+	sandboxHome := t.TempDir()
+	sandboxDir := path.Join(sandboxHome, "msb_8_0_36")
+	err := os.Mkdir(sandboxDir, 0755)
+	compare.OkIsNil("creating sandbox directory", err, t)
+	err = common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		Basedir:      "/opt/mysql/8.0.36",
+		SBType:       "single",
+		Version:      "8.0.36",
+		Flavor:       common.MySQLFlavor,
+		Port:         []int{8036},
+		MyCnfOptions: []string{"max_connections=500"},
+	})
+	compare.OkIsNil("writing description", err, t)
+	err = common.WriteString("[client]\nuser = app\npassword = secret\n\n[mysqld]\nport = 8036\ngtid_mode=ON\n",
+		path.Join(sandboxDir, globals.ScriptMySandboxCnf))
+	compare.OkIsNil("writing configuration", err, t)
+	err = common.WriteString(`{"master_host": "127.0.0.1", "master_port": 8036, "master_user": "repl", "master_password": "rsandbox"}`,
+		path.Join(sandboxDir, globals.ScriptConnectionJson))
+	compare.OkIsNil("writing connection file", err, t)
+
+	plan, err := DeploymentPlanFromSandbox(sandboxHome, "msb_8_0_36")
+	compare.OkIsNil("exporting plan", err, t)
+	compare.OkEqualInt("plan sandboxes", len(plan.Sandboxes), 1, t)
+	ps := plan.Sandboxes[0]
+	compare.OkEqualString("sandbox binary", plan.SandboxBinary, "/opt/mysql", t)
+	compare.OkEqualInt("port", ps.Port, 8036, t)
+	compare.OkEqualStringSlices(t, ps.MyCnfOptions, []string{"max_connections=500"})
+	compare.OkEqualString("db user", ps.DbUser, "app", t)
+	compare.OkEqualString("db password", ps.DbPassword, "secret", t)
+	compare.OkEqualString("replication user", ps.RplUser, "repl", t)
+	compare.OkEqualString("replication password", ps.RplPassword, "", t)
+	compare.OkEqualBool("gtid", ps.Gtid, true, t)
+}
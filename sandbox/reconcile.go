@@ -0,0 +1,296 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/pkg/errors"
+)
+
+// Outcomes of the comparison between an existing sandbox and a requested definition
+const (
+	ReconcileUnchanged    = "unchanged"
+	ReconcileConfigChange = "config-change"
+	ReconcileRebuild      = "rebuild"
+)
+
+// ReconcileReport describes what needs to change in an existing sandbox
+// to match a requested definition
+type ReconcileReport struct {
+	SandboxDir  string
+	Action      string
+	Differences []string
+	nodeDirs    []string
+	oldOptions  [][]string
+}
+
+// sameOptions returns true if two lists of options contain the same
+// elements, regardless of their order
+func sameOptions(a, b []string) bool {
+	var listA, listB []string
+	for _, s := range a {
+		if strings.TrimSpace(s) != "" {
+			listA = append(listA, strings.TrimSpace(s))
+		}
+	}
+	for _, s := range b {
+		if strings.TrimSpace(s) != "" {
+			listB = append(listB, strings.TrimSpace(s))
+		}
+	}
+	if len(listA) != len(listB) {
+		return false
+	}
+	sort.Strings(listA)
+	sort.Strings(listB)
+	for i := range listA {
+		if listA[i] != listB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// replicationSbType returns the sandbox type that a replication
+// topology records in its description
+func replicationSbType(topology string, singlePrimary bool) string {
+	switch topology {
+	case globals.GroupLabel:
+		if singlePrimary {
+			return "group-single-primary"
+		}
+		return "group-multi-primary"
+	case globals.PxcLabel:
+		return "Percona-Xtradb-Cluster"
+	case globals.NdbLabel:
+		return "ndb"
+	}
+	return topology
+}
+
+// replicationNodes returns the number of nodes that a replication
+// topology records in its description
+func replicationNodes(topology string, nodes int) int {
+	if topology == globals.MasterSlaveLabel {
+		return nodes - 1
+	}
+	return nodes
+}
+
+// CompareSandboxDefinition compares the sandbox installed in sandboxDef.SandboxDir
+// with the requested definition, where sbType and nodes are the values
+// that the new sandbox would record in its description.
+// Changes to the server options only need a rewrite of the configuration file,
+// while changes to version, flavor, topology, ports, or users need a rebuild.
+func CompareSandboxDefinition(sandboxDef SandboxDef, sbType string, nodes int) (ReconcileReport, error) {
+	sandboxDir := sandboxDef.SandboxDir
+	report := ReconcileReport{SandboxDir: sandboxDir, Action: ReconcileUnchanged}
+	if !common.DirExists(sandboxDir) {
+		return report, fmt.Errorf(globals.ErrDirectoryNotFound, sandboxDir)
+	}
+	rebuild := func(format string, args ...interface{}) {
+		report.Action = ReconcileRebuild
+		report.Differences = append(report.Differences, fmt.Sprintf(format, args...))
+	}
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		rebuild("description: %s", err)
+		return report, nil
+	}
+	if sbDesc.SBType != sbType {
+		rebuild("type: %s => %s", sbDesc.SBType, sbType)
+	}
+	if sbDesc.Version != sandboxDef.Version {
+		rebuild("version: %s => %s", sbDesc.Version, sandboxDef.Version)
+	}
+	if sbDesc.Flavor != "" && sbDesc.Flavor != sandboxDef.Flavor {
+		rebuild("flavor: %s => %s", sbDesc.Flavor, sandboxDef.Flavor)
+	}
+	if sbDesc.Basedir != sandboxDef.Basedir {
+		rebuild("basedir: %s => %s", sbDesc.Basedir, sandboxDef.Basedir)
+	}
+	if sandboxDef.ClientBasedir != "" && sbDesc.ClientBasedir != sandboxDef.ClientBasedir {
+		rebuild("client basedir: %s => %s", sbDesc.ClientBasedir, sandboxDef.ClientBasedir)
+	}
+	if sbDesc.Nodes != nodes {
+		rebuild("nodes: %d => %d", sbDesc.Nodes, nodes)
+	}
+
+	catalog, err := defaults.ReadCatalog()
+	if err != nil {
+		return report, err
+	}
+	sbItem, inCatalog := catalog[sandboxDir]
+	if !inCatalog {
+		rebuild("catalog: sandbox not registered")
+	}
+	wantedPort := 0
+	if nodes == 0 && sandboxDef.UserPort > 0 {
+		wantedPort = sandboxDef.UserPort
+	}
+	if nodes > 0 && sandboxDef.BasePort > 0 {
+		wantedPort = sandboxDef.BasePort + 1
+	}
+	if inCatalog && wantedPort > 0 {
+		portFound := false
+		for _, port := range sbItem.Port {
+			if port == wantedPort {
+				portFound = true
+			}
+		}
+		if !portFound {
+			rebuild("port: %v does not include %d", sbItem.Port, wantedPort)
+		}
+	}
+
+	// A single sandbox is its own node. Multiple sandboxes keep
+	// the server options in each node directory
+	nodeDirs := []string{sandboxDir}
+	if nodes > 0 {
+		nodeDirs = []string{}
+		nodeList, err := common.GetInstalledSandboxes(sandboxDir)
+		if err != nil {
+			return report, err
+		}
+		for _, node := range nodeList {
+			nodeDirs = append(nodeDirs, path.Join(sandboxDir, node.SandboxName))
+		}
+	}
+	for i, nodeDir := range nodeDirs {
+		nodeDesc, err := common.ReadSandboxDescription(nodeDir)
+		if err != nil {
+			rebuild("description: %s", err)
+			continue
+		}
+		// The users are checked in the first node only, as they are the same for all nodes
+		if i == 0 {
+			config, err := common.ParseConfigFile(path.Join(nodeDir, globals.ScriptMySandboxCnf))
+			if err != nil {
+				rebuild("configuration: %s", err)
+				continue
+			}
+			for _, kv := range config["client"] {
+				if kv.Key == "user" && sandboxDef.DbUser != "" && kv.Value != sandboxDef.DbUser {
+					rebuild("db user: %s => %s", kv.Value, sandboxDef.DbUser)
+				}
+				if kv.Key == "password" && sandboxDef.DbPassword != "" && kv.Value != sandboxDef.DbPassword {
+					rebuild("db password changed")
+				}
+			}
+		}
+		report.nodeDirs = append(report.nodeDirs, nodeDir)
+		report.oldOptions = append(report.oldOptions, nodeDesc.MyCnfOptions)
+		if !sameOptions(nodeDesc.MyCnfOptions, sandboxDef.MyCnfOptions) {
+			if report.Action == ReconcileUnchanged {
+				report.Action = ReconcileConfigChange
+			}
+			report.Differences = append(report.Differences,
+				fmt.Sprintf("%s options: %v => %v", common.BaseName(nodeDir), nodeDesc.MyCnfOptions, sandboxDef.MyCnfOptions))
+		}
+	}
+	return report, nil
+}
+
+// applyConfigChange replaces the server options recorded in each node
+// with the requested ones, and restarts the sandbox
+func applyConfigChange(report ReconcileReport, newOptions []string) error {
+	for i, nodeDir := range report.nodeDirs {
+		configFile := path.Join(nodeDir, globals.ScriptMySandboxCnf)
+		lines, err := common.SlurpAsLines(configFile)
+		if err != nil {
+			return err
+		}
+		oldOptions := make(map[string]bool)
+		for _, option := range report.oldOptions[i] {
+			oldOptions[strings.TrimSpace(option)] = true
+		}
+		var newLines []string
+		for _, line := range lines {
+			if !oldOptions[strings.TrimSpace(line)] {
+				newLines = append(newLines, line)
+			}
+		}
+		for _, option := range newOptions {
+			if strings.TrimSpace(option) != "" {
+				newLines = append(newLines, option)
+			}
+		}
+		err = common.WriteStrings(newLines, configFile, "\n")
+		if err != nil {
+			return err
+		}
+		sbDesc, err := common.ReadSandboxDescription(nodeDir)
+		if err != nil {
+			return err
+		}
+		sbDesc.MyCnfOptions = newOptions
+		err = common.WriteSandboxDescription(nodeDir, sbDesc)
+		if err != nil {
+			return errors.Wrapf(err, "unable to write sandbox description")
+		}
+	}
+	restartCommand := path.Join(report.SandboxDir, globals.ScriptRestart)
+	if !common.ExecExists(restartCommand) {
+		restartCommand = path.Join(report.SandboxDir, globals.ScriptRestartAll)
+	}
+	if !common.ExecExists(restartCommand) {
+		return fmt.Errorf("neither '%s' or '%s' found in %s", globals.ScriptRestart, globals.ScriptRestartAll, report.SandboxDir)
+	}
+	_, err := common.RunCmd(restartCommand)
+	return err
+}
+
+// reconcileSandbox compares an existing sandbox with the requested definition
+// and brings it up to date. It returns true when the sandbox is already
+// in the wanted state and no deployment is needed.
+// When a rebuild is needed, the existing sandbox is replaced only if
+// the definition has Force enabled. Otherwise, an error is returned.
+func reconcileSandbox(sandboxDef SandboxDef, sbType string, nodes int) (SandboxDef, bool, error) {
+	sandboxDef, err := mergeMyCnfFile(sandboxDef)
+	if err != nil {
+		return sandboxDef, false, err
+	}
+	report, err := CompareSandboxDefinition(sandboxDef, sbType, nodes)
+	if err != nil {
+		return sandboxDef, false, err
+	}
+	common.CondPrintf("# Sandbox %s: %s\n", report.SandboxDir, report.Action)
+	for _, diff := range report.Differences {
+		common.CondPrintf("#   %s\n", diff)
+	}
+	switch report.Action {
+	case ReconcileUnchanged:
+		return sandboxDef, true, nil
+	case ReconcileConfigChange:
+		err = applyConfigChange(report, sandboxDef.MyCnfOptions)
+		if err != nil {
+			return sandboxDef, false, errors.Wrapf(err, "error applying configuration to %s", report.SandboxDir)
+		}
+		return sandboxDef, true, nil
+	}
+	if !sandboxDef.Force {
+		return sandboxDef, false, fmt.Errorf("sandbox %s needs to be rebuilt. Use --%s to replace it",
+			report.SandboxDir, globals.ForceLabel)
+	}
+	return sandboxDef, false, nil
+}
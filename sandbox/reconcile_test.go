@@ -0,0 +1,119 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestSameOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want bool
+	}{
+		{"empty", nil, []string{}, true},
+		{"same", []string{"a=1", "b=2"}, []string{"a=1", "b=2"}, true},
+		{"different-order", []string{"a=1", "b=2"}, []string{"b=2", "a=1"}, true},
+		{"blanks", []string{" a=1 ", ""}, []string{"a=1"}, true},
+		{"different-value", []string{"a=1"}, []string{"a=2"}, false},
+		{"more-options", []string{"a=1"}, []string{"a=1", "b=2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameOptions(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameOptions(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigChange(t *testing.T) {
+	sandboxDir := t.TempDir()
+	configFile := path.Join(sandboxDir, globals.ScriptMySandboxCnf)
+	err := common.WriteStrings([]string{"[mysqld]", "port = 5000", "", "max_connections=100", "log_bin=ON"}, configFile, "\n")
+	compare.OkIsNil("writing configuration", err, t)
+	err = common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		SBType:       globals.SbTypeSingle,
+		Version:      "8.0.36",
+		MyCnfOptions: []string{"max_connections=100", "log_bin=ON"},
+	})
+	compare.OkIsNil("writing description", err, t)
+	restartScript := path.Join(sandboxDir, globals.ScriptRestart)
+	err = common.WriteString("#!/bin/sh\ntouch "+path.Join(sandboxDir, "restarted")+"\n", restartScript)
+	compare.OkIsNil("writing restart script", err, t)
+	err = os.Chmod(restartScript, globals.ExecutableFileAttr)
+	compare.OkIsNil("making restart script executable", err, t)
+
+	report := ReconcileReport{
+		SandboxDir: sandboxDir,
+		Action:     ReconcileConfigChange,
+		nodeDirs:   []string{sandboxDir},
+		oldOptions: [][]string{{"max_connections=100", "log_bin=ON"}},
+	}
+	newOptions := []string{"max_connections=500"}
+	err = applyConfigChange(report, newOptions)
+	compare.OkIsNil("applying configuration", err, t)
+
+	lines, err := common.SlurpAsLines(configFile)
+	compare.OkIsNil("reading configuration", err, t)
+	compare.OkEqualStringSlices(t, lines, []string{"[mysqld]", "port = 5000", "", "max_connections=500"})
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	compare.OkIsNil("reading description", err, t)
+	compare.OkEqualStringSlices(t, sbDesc.MyCnfOptions, newOptions)
+	compare.OkEqualBool("sandbox restarted", common.FileExists(path.Join(sandboxDir, "restarted")), true, t)
+}
+
+func TestReconcileSandboxRebuild(t *testing.T) {
+	// A directory without description needs a rebuild
+	sandboxDef := SandboxDef{SandboxDir: t.TempDir(), Version: "8.0.36"}
+	_, upToDate, err := reconcileSandbox(sandboxDef, globals.SbTypeSingle, 0)
+	compare.OkIsNotNil("rebuild without force", err, t)
+	compare.OkEqualBool("up to date without force", upToDate, false, t)
+
+	sandboxDef.Force = true
+	newDef, upToDate, err := reconcileSandbox(sandboxDef, globals.SbTypeSingle, 0)
+	compare.OkIsNil("rebuild with force", err, t)
+	compare.OkEqualBool("up to date with force", upToDate, false, t)
+	compare.OkEqualBool("force", newDef.Force, true, t)
+}
+
+func TestMergeMyCnfFile(t *testing.T) {
+	myCnfFile := path.Join(t.TempDir(), "my.cnf")
+	err := common.WriteString("[mysqld]\nport = 5000\nmax_connections = 500\n", myCnfFile)
+	compare.OkIsNil("writing options file", err, t)
+
+	sandboxDef, err := mergeMyCnfFile(SandboxDef{MyCnfOptions: []string{"log_bin=ON"}, MyCnfFile: myCnfFile})
+	compare.OkIsNil("merging options file", err, t)
+	compare.OkEqualStringSlices(t, sandboxDef.MyCnfOptions,
+		[]string{"log_bin=ON", "# options retrieved from " + myCnfFile, "max_connections = 500"})
+	compare.OkEqualString("options file", sandboxDef.MyCnfFile, "", t)
+
+	// A second merge does not add the options again
+	merged, err := mergeMyCnfFile(sandboxDef)
+	compare.OkIsNil("merging again", err, t)
+	compare.OkEqualStringSlices(t, merged.MyCnfOptions, sandboxDef.MyCnfOptions)
+
+	_, err = mergeMyCnfFile(SandboxDef{MyCnfFile: path.Join(t.TempDir(), "missing.cnf")})
+	compare.OkIsNotNil("missing options file", err, t)
+}
@@ -102,6 +102,60 @@ func computeBaseport(proposed int) int {
 	return proposed
 }
 
+// masterSlaveScripts returns the scripts of a master-slave sandbox, except the ones for each slave
+func masterSlaveScripts(enableAdminAddress bool) []ScriptDef {
+	masterAbbr := defaults.Defaults().MasterAbbr
+	slavePlural := english.PluralWord(2, defaults.Defaults().SlavePrefix, "")
+	masterPlural := english.PluralWord(2, defaults.Defaults().MasterName, "")
+	scripts := []ScriptDef{
+		{globals.ScriptStartAll, globals.TmplStartAll, true},
+		{globals.ScriptRestartAll, globals.TmplRestartAll, true},
+		{globals.ScriptStatusAll, globals.TmplStatusAll, true},
+		{globals.ScriptTestSbAll, globals.TmplTestSbAll, true},
+		{globals.ScriptStopAll, globals.TmplStopAll, true},
+		{globals.ScriptClearAll, globals.TmplClearAll, true},
+		{globals.ScriptSendKillAll, globals.TmplSendKillAll, true},
+		{globals.ScriptUseAll, globals.TmplUseAll, true},
+		{globals.ScriptExecAll, globals.TmplExecAll, true},
+		{globals.ScriptMetadataAll, globals.TmplMetadataAll, true},
+		{"use_all_" + slavePlural, globals.TmplUseAllSlaves, true},
+		{"use_all_" + masterPlural, globals.TmplUseAllMasters, true},
+		{"initialize_" + slavePlural, globals.TmplInitSlaves, true},
+		{"check_" + slavePlural, globals.TmplCheckSlaves, true},
+		{masterAbbr, globals.TmplMaster, true},
+		{"exec_all_" + slavePlural, globals.TmplExecAllSlaves, true},
+		{"exec_all_" + masterPlural, globals.TmplExecAllMasters, true},
+		{globals.ScriptWipeRestartAll, globals.TmplWipeAndRestartAll, true},
+		{"n1", globals.TmplMaster, true},
+		{"test_replication", globals.TmplTestReplication, true},
+		{globals.ScriptReplicateFrom, globals.TmplReplReplicateFrom, true},
+		{globals.ScriptSysbench, globals.TmplReplSysbench, true},
+		{globals.ScriptSysbenchReady, globals.TmplReplSysbenchReady, true},
+	}
+	if enableAdminAddress {
+		scripts = append(scripts,
+			ScriptDef{masterAbbr + "a", globals.TmplMasterAdmin, true},
+			ScriptDef{"na1", globals.TmplMasterAdmin, true},
+			ScriptDef{globals.ScriptUseAllAdmin, globals.TmplUseAllAdmin, true})
+	}
+	return scripts
+}
+
+// slaveScripts returns the scripts that reach the slave number i of a master-slave sandbox
+func slaveScripts(i int, enableAdminAddress bool) []ScriptDef {
+	slaveAbbr := defaults.Defaults().SlaveAbbr
+	scripts := []ScriptDef{
+		{fmt.Sprintf("%s%d", slaveAbbr, i), globals.TmplSlave, true},
+		{fmt.Sprintf("n%d", i+1), globals.TmplSlave, true},
+	}
+	if enableAdminAddress {
+		scripts = append(scripts,
+			ScriptDef{fmt.Sprintf("%sa%d", slaveAbbr, i), globals.TmplSlaveAdmin, true},
+			ScriptDef{fmt.Sprintf("na%d", i+1), globals.TmplSlaveAdmin, true})
+	}
+	return scripts
+}
+
 func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
 
 	var execLists []concurrent.ExecutionList
@@ -371,26 +425,12 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 			"SandboxDir":         sandboxDef.SandboxDir,
 		}
 		logger.Printf("Defining replication node data: %v\n", stringMapToJson(dataSlave))
-		logger.Printf("Create slave script %d\n", i)
+		logger.Printf("Create slave scripts %d\n", i)
 		err = writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDef.SandboxDir, dataSlave,
-			[]ScriptDef{
-				{fmt.Sprintf("%s%d", slaveAbbr, i), globals.TmplSlave, true},
-				{fmt.Sprintf("n%d", i+1), globals.TmplSlave, true},
-			}})
+			slaveScripts(i, sandboxDef.EnableAdminAddress)})
 		if err != nil {
 			return err
 		}
-		if sandboxDef.EnableAdminAddress {
-			logger.Printf("Create slave admin script %d\n", i)
-			err = writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDef.SandboxDir, dataSlave,
-				[]ScriptDef{
-					{fmt.Sprintf("%sa%d", slaveAbbr, i), globals.TmplSlaveAdmin, true},
-					{fmt.Sprintf("na%d", i+1), globals.TmplSlaveAdmin, true},
-				}})
-			if err != nil {
-				return err
-			}
-		}
 	}
 	err = common.WriteSandboxDescription(sandboxDef.SandboxDir, sbDesc)
 	if err != nil {
@@ -402,54 +442,17 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		return errors.Wrapf(err, "unable to update catalog")
 	}
 
-	slavePlural := english.PluralWord(2, slaveLabel, "")
-	masterPlural := english.PluralWord(2, masterLabel, "")
-	initializeSlaves := "initialize_" + slavePlural
-	checkSlaves := "check_" + slavePlural
-	useAllMasters := "use_all_" + masterPlural
-	useAllSlaves := "use_all_" + slavePlural
-	execAllSlaves := "exec_all_" + slavePlural
-	execAllMasters := "exec_all_" + masterPlural
-
+	initializeSlaves := "initialize_" + english.PluralWord(2, slaveLabel, "")
 	sb := ScriptBatch{
 		tc:         ReplicationTemplates,
 		logger:     logger,
 		sandboxDir: sandboxDef.SandboxDir,
 		data:       data,
-		scripts: []ScriptDef{
-			{globals.ScriptStartAll, globals.TmplStartAll, true},
-			{globals.ScriptRestartAll, globals.TmplRestartAll, true},
-			{globals.ScriptStatusAll, globals.TmplStatusAll, true},
-			{globals.ScriptTestSbAll, globals.TmplTestSbAll, true},
-			{globals.ScriptStopAll, globals.TmplStopAll, true},
-			{globals.ScriptClearAll, globals.TmplClearAll, true},
-			{globals.ScriptSendKillAll, globals.TmplSendKillAll, true},
-			{globals.ScriptUseAll, globals.TmplUseAll, true},
-			{globals.ScriptExecAll, globals.TmplExecAll, true},
-			{globals.ScriptMetadataAll, globals.TmplMetadataAll, true},
-			{useAllSlaves, globals.TmplUseAllSlaves, true},
-			{useAllMasters, globals.TmplUseAllMasters, true},
-			{initializeSlaves, globals.TmplInitSlaves, true},
-			{checkSlaves, globals.TmplCheckSlaves, true},
-			{masterAbbr, globals.TmplMaster, true},
-			{execAllSlaves, globals.TmplExecAllSlaves, true},
-			{execAllMasters, globals.TmplExecAllMasters, true},
-			{globals.ScriptWipeRestartAll, globals.TmplWipeAndRestartAll, true},
-			{"n1", globals.TmplMaster, true},
-			{"test_replication", globals.TmplTestReplication, true},
-			{globals.ScriptReplicateFrom, globals.TmplReplReplicateFrom, true},
-			{globals.ScriptSysbench, globals.TmplReplSysbench, true},
-			{globals.ScriptSysbenchReady, globals.TmplReplSysbenchReady, true},
-		},
+		scripts:    masterSlaveScripts(sandboxDef.EnableAdminAddress),
 	}
 	if sandboxDef.SemiSyncOptions != "" {
 		sb.scripts = append(sb.scripts, ScriptDef{"post_initialization", globals.TmplSemiSyncStart, true})
 	}
-	if sandboxDef.EnableAdminAddress {
-		sb.scripts = append(sb.scripts, ScriptDef{masterAbbr + "a", globals.TmplMasterAdmin, true})
-		sb.scripts = append(sb.scripts, ScriptDef{"na1", globals.TmplMasterAdmin, true})
-		sb.scripts = append(sb.scripts, ScriptDef{globals.ScriptUseAllAdmin, globals.TmplUseAllAdmin, true})
-	}
 	logger.Printf("Create replication scripts\n")
 	err = writeScripts(sb)
 	if err != nil {
@@ -550,6 +553,19 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, replData Replicati
 		sdef.SandboxDir = path.Join(sandboxDir, sdef.DirName)
 	}
 
+	if sdef.Reconcile && common.DirExists(sdef.SandboxDir) {
+		var upToDate bool
+		var err error
+		sdef, upToDate, err = reconcileSandbox(sdef,
+			replicationSbType(replData.Topology, sdef.SinglePrimary),
+			replicationNodes(replData.Topology, replData.Nodes))
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			return nil
+		}
+	}
 	if common.DirExists(sdef.SandboxDir) {
 		var err error
 		sdef, err = checkDirectory(sdef)
@@ -106,8 +106,10 @@ type SandboxDef struct {
 	KeepUuid             bool             // Do not change UUID
 	SinglePrimary        bool             // Use single primary for group replication
 	Force                bool             // Overwrite an existing sandbox with same target
+	Reconcile            bool             // Compare an existing sandbox with the requested definition
 	ExposeDdTables       bool             // Show hidden data dictionary tables (MySQL 8.0.0+)
 	RunConcurrently      bool             // Run multiple sandbox creation concurrently
+	DataDirFrom          string           // Copy the data directory from here instead of initializing it
 }
 
 type ScriptDef struct {
@@ -126,6 +128,16 @@ type ScriptBatch struct {
 
 var emptyExecutionList = []concurrent.ExecutionList{}
 
+// Files that contain the credentials for the sandbox server
+var credentialFiles = map[string]bool{
+	globals.ScriptMySandboxCnf:        true,
+	globals.ScriptConnectionSql:       true,
+	globals.ScriptConnectionConf:      true,
+	globals.ScriptConnectionSuperConf: true,
+	globals.ScriptConnectionJson:      true,
+	globals.ScriptConnectionSuperJson: true,
+}
+
 func getOptionsFromFile(filename string) (options []string, err error) {
 	skipOptions := map[string]bool{
 		"user":         true,
@@ -154,7 +166,37 @@ func getOptionsFromFile(filename string) (options []string, err error) {
 	return options, nil
 }
 
+// mergeMyCnfFile adds the options from the file given with --my-cnf-file
+// to the server options of the sandbox definition. The file is
+// cleared from the definition, so that its options are merged only once.
+func mergeMyCnfFile(sandboxDef SandboxDef) (SandboxDef, error) {
+	if sandboxDef.MyCnfFile == "" {
+		return sandboxDef, nil
+	}
+	if !common.FileExists(sandboxDef.MyCnfFile) {
+		return sandboxDef, fmt.Errorf(globals.ErrFileNotFound, sandboxDef.MyCnfFile)
+	}
+	options, err := getOptionsFromFile(sandboxDef.MyCnfFile)
+	if err != nil {
+		return sandboxDef, errors.Wrapf(err, "error reading provided configuration file")
+	}
+	myCnfOptions := append([]string{}, sandboxDef.MyCnfOptions...)
+	if len(options) > 0 {
+		myCnfOptions = append(myCnfOptions, fmt.Sprintf("# options retrieved from %s", sandboxDef.MyCnfFile))
+	}
+	sandboxDef.MyCnfOptions = append(myCnfOptions, options...)
+	sandboxDef.MyCnfFile = ""
+	return sandboxDef, nil
+}
+
+// sandboxDefToJson encodes a sandbox definition for the logs, with the passwords masked
 func sandboxDefToJson(sd SandboxDef) string {
+	if sd.DbPassword != "" {
+		sd.DbPassword = common.RedactedValue
+	}
+	if sd.RplPassword != "" {
+		sd.RplPassword = common.RedactedValue
+	}
 	b, err := json.MarshalIndent(sd, " ", "\t")
 	if err != nil {
 		return "Sandbox definition could not be encoded\n"
@@ -162,11 +204,17 @@ func sandboxDefToJson(sd SandboxDef) string {
 	return string(b)
 }
 
+// stringMapToJson encodes template data for the logs, with the passwords masked
 func stringMapToJson(data common.StringMap) string {
-	copyright := data[globals.TmplCopyright]
-	data[globals.TmplCopyright] = "[skipped] (See 'copyright' template for full text)"
-	b, err := json.MarshalIndent(data, " ", "\t")
-	data[globals.TmplCopyright] = copyright
+	logData := make(common.StringMap, len(data))
+	for key, value := range data {
+		if text, ok := value.(string); ok && text != "" && strings.HasSuffix(key, "Password") {
+			value = common.RedactedValue
+		}
+		logData[key] = value
+	}
+	logData[globals.TmplCopyright] = "[skipped] (See 'copyright' template for full text)"
+	b, err := json.MarshalIndent(logData, " ", "\t")
 	if err != nil {
 		return "String map could not be encoded"
 	}
@@ -241,7 +289,7 @@ func checkDirectory(sandboxDef SandboxDef) (SandboxDef, error) {
 }
 
 func checkPortAvailability(caller string, sandboxType string, installedPorts []int, port int) error {
-	if sandboxType == globals.SbTypeSingleImported {
+	if sandboxType == globals.SbTypeSingleImported || sandboxType == globals.SbTypeReplImportedNode {
 		return nil
 	}
 	conflict := 0
@@ -355,6 +403,14 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	if sandboxDef.SBType == "" {
 		sandboxDef.SBType = globals.SbTypeSingle
 	}
+	sandboxDef, err = mergeMyCnfFile(sandboxDef)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	// The options requested by the user, including the ones from --my-cnf-file,
+	// are recorded in the sandbox description, before the ones that are added
+	// automatically in this function
+	requestedOptions := append([]string{}, sandboxDef.MyCnfOptions...)
 	// Assuming a default flavor for backward compatibility
 	if sandboxDef.Flavor == "" {
 		sandboxDef.Flavor = common.MySQLFlavor
@@ -447,6 +503,16 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	sandboxDir = path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
 	sandboxDef.SandboxDir = sandboxDir
 	logger.Printf("Single Sandbox directory defined as %s\n", sandboxDef.SandboxDir)
+	if sandboxDef.Reconcile && sandboxDef.NodeNum == 0 && !sandboxDef.Imported && common.DirExists(sandboxDir) {
+		var upToDate bool
+		sandboxDef, upToDate, err = reconcileSandbox(sandboxDef, sandboxDef.SBType, 0)
+		if err != nil {
+			return emptyExecutionList, err
+		}
+		if upToDate {
+			return emptyExecutionList, nil
+		}
+	}
 	dataDir := path.Join(sandboxDir, globals.DataDirName)
 	tmpDir := path.Join(sandboxDir, "tmp")
 
@@ -546,6 +612,16 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 			}
 		}
 	}
+	// A basedir linked to the system packages: the server was compiled with the
+	// distribution paths, which must be redirected to the links in basedir
+	if common.FileExists(path.Join(sandboxDef.Basedir, globals.SystemBinariesFileName)) {
+		systemPluginDir := path.Join(sandboxDef.Basedir, "lib", "plugin")
+		if common.DirExists(systemPluginDir) && sandboxDef.CustomMysqld != "mysqld-debug" {
+			sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, fmt.Sprintf("plugin-dir=%s", systemPluginDir))
+		}
+		sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions,
+			fmt.Sprintf("lc-messages-dir=%s", path.Join(sandboxDef.Basedir, "share")))
+	}
 	// 5.1.0
 	// isMinimumDynVariables, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumDynVariablesVersion)
 	isMinimumDynVariables, err := common.HasCapability(sandboxDef.Flavor, common.DynVariables, sandboxDef.Version)
@@ -609,19 +685,6 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	if !common.ExecExists(mysqlshExecutable) {
 		mysqlshExecutable = "mysqlsh"
 	}
-	if sandboxDef.MyCnfFile != "" {
-		if !common.FileExists(sandboxDef.MyCnfFile) {
-			return emptyExecutionList, fmt.Errorf(globals.ErrFileNotFound, sandboxDef.MyCnfFile)
-		}
-		options, err := getOptionsFromFile(sandboxDef.MyCnfFile)
-		if err != nil {
-			return emptyExecutionList, errors.Wrapf(err, "error reading provided configuration file")
-		}
-		if len(options) > 0 {
-			sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, fmt.Sprintf("# options retrieved from %s", sandboxDef.MyCnfFile))
-		}
-		sandboxDef.MyCnfOptions = append(sandboxDef.MyCnfOptions, options...)
-	}
 	if common.Includes(sliceToText(sandboxDef.MyCnfOptions), "plugin.load") {
 		usingPlugins = true
 	}
@@ -836,7 +899,12 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	if err != nil {
 		return emptyExecutionList, err
 	}
-	if sandboxDef.RunConcurrently {
+	if sandboxDef.DataDirFrom != "" {
+		err = copyDataDir(sandboxDef, dataDir)
+		if err != nil {
+			return emptyExecutionList, err
+		}
+	} else if sandboxDef.RunConcurrently {
 		var eCommand = concurrent.ExecCommand{
 			Cmd:  path.Join(sandboxDir, globals.ScriptInitDb),
 			Args: []string{},
@@ -888,6 +956,8 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		Nodes:         0,
 		NodeNum:       sandboxDef.NodeNum,
 		LogFile:       sandboxDef.LogFileName,
+		MyCnfOptions:  requestedOptions,
+		CustomMysqld:  sandboxDef.CustomMysqld,
 	}
 	if len(sandboxDef.MorePorts) > 0 {
 		for _, port := range sandboxDef.MorePorts {
@@ -980,10 +1050,30 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	sb.scripts = append(sb.scripts, ScriptDef{globals.ScriptGrantsMysql, grantsTemplateName, false})
 	sb.scripts = append(sb.scripts, ScriptDef{globals.ScriptSbInclude, globals.TmplSbInclude, false})
 
+	var privateScripts []ScriptDef
+	if sandboxDef.Imported {
+		// The client configuration of an imported sandbox contains the password
+		// of an existing server: it must be readable only by the owner
+		var publicScripts []ScriptDef
+		for _, scriptDef := range sb.scripts {
+			if credentialFiles[scriptDef.scriptName] {
+				privateScripts = append(privateScripts, scriptDef)
+			} else {
+				publicScripts = append(publicScripts, scriptDef)
+			}
+		}
+		sb.scripts = publicScripts
+	}
 	err = writeScripts(sb)
 	if err != nil {
 		return emptyExecutionList, err
 	}
+	for _, scriptDef := range privateScripts {
+		err = writePrivateScript(logger, sb.tc, scriptDef.scriptName, scriptDef.templateName, sandboxDir, data)
+		if err != nil {
+			return emptyExecutionList, err
+		}
+	}
 	preGrantSqlFile := path.Join(sandboxDir, globals.ScriptPreGrantsSql)
 	postGrantSqlFile := path.Join(sandboxDir, globals.ScriptPostGrantsSql)
 	if sandboxDef.PreGrantsSqlFile != "" {
@@ -1104,20 +1194,42 @@ func writeScripts(scriptBatch ScriptBatch) error {
 	return nil
 }
 
-func writeScript(logger *defaults.Logger, tempVar TemplateCollection, scriptName, templateName, directory string,
-	data common.StringMap, makeExecutable bool) error {
+func fillScriptTemplate(tempVar TemplateCollection, scriptName, templateName, directory string,
+	data common.StringMap) (string, error) {
 	if directory == "" {
-		return fmt.Errorf("writeScript (%s): missing directory", scriptName)
+		return "", fmt.Errorf("writeScript (%s): missing directory", scriptName)
 	}
 	_, ok := tempVar[templateName]
 	if !ok {
-		return fmt.Errorf("writeScript (%s): template %s not found", scriptName, templateName)
+		return "", fmt.Errorf("writeScript (%s): template %s not found", scriptName, templateName)
 	}
 	template := tempVar[templateName].Contents
 	template = common.TrimmedLines(template)
 	data["TemplateName"] = templateName
-	var err error
-	text, err := common.SafeTemplateFill(templateName, template, data)
+	return common.SafeTemplateFill(templateName, template, data)
+}
+
+// writePrivateScript creates a file that only the owner can read, such as
+// a client configuration that contains the password of an existing server
+func writePrivateScript(logger *defaults.Logger, tempVar TemplateCollection, scriptName, templateName, directory string,
+	data common.StringMap) error {
+	text, err := fillScriptTemplate(tempVar, scriptName, templateName, directory, data)
+	if err != nil {
+		return err
+	}
+	err = common.WritePrivateString(text, path.Join(directory, scriptName))
+	if err != nil {
+		return err
+	}
+	if logger != nil {
+		logger.Printf("Creating private file '%s/%s' using template '%s'\n", common.ReplaceLiteralHome(directory), scriptName, templateName)
+	}
+	return nil
+}
+
+func writeScript(logger *defaults.Logger, tempVar TemplateCollection, scriptName, templateName, directory string,
+	data common.StringMap, makeExecutable bool) error {
+	text, err := fillScriptTemplate(tempVar, scriptName, templateName, directory, data)
 	if err != nil {
 		return err
 	}
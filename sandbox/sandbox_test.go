@@ -148,6 +148,75 @@ func testCreateMockSandbox(t *testing.T) {
 	compare.OkIsNil("removal", err, t)
 }
 
+func testCloneMockSandbox(t *testing.T) {
+	err := SetMockEnvironment(DefaultMockDir)
+	if err != nil {
+		t.Fatal("mock dir creation failed")
+	}
+	mysqlVersion := "8.0.11"
+	err = CreateMockVersion(mysqlVersion)
+	compare.OkIsNil("version creation", err, t)
+	var sandboxDef = SandboxDef{
+		Version:        mysqlVersion,
+		Flavor:         common.MySQLFlavor,
+		Basedir:        path.Join(mockSandboxBinary, mysqlVersion),
+		SandboxDir:     mockSandboxHome,
+		DirName:        "msb_clone_source",
+		SkipStart:      true,
+		InstalledPorts: defaults.Defaults().ReservedPorts,
+		Port:           8011,
+		DbUser:         globals.DbUserValue,
+		RplUser:        globals.RplUserValue,
+		DbPassword:     globals.DbPasswordValue,
+		RplPassword:    globals.RplPasswordValue,
+		RemoteAccess:   globals.RemoteAccessValue,
+		BindAddress:    globals.BindAddressValue,
+		MyCnfOptions:   []string{"max_connections=42"},
+	}
+	err = CreateStandaloneSandbox(sandboxDef)
+	compare.OkIsNil("source creation", err, t)
+	sourceDir := path.Join(mockSandboxHome, sandboxDef.DirName)
+	err = common.WriteString("some data", path.Join(sourceDir, globals.DataDirName, "t1.ibd"))
+	compare.OkIsNil("source data", err, t)
+
+	cloneDef := SandboxDef{
+		SandboxDir:     mockSandboxHome,
+		DirName:        "msb_clone_copy",
+		SkipStart:      true,
+		InstalledPorts: append([]int{8011}, defaults.Defaults().ReservedPorts...),
+		RplUser:        globals.RplUserValue,
+		RplPassword:    globals.RplPasswordValue,
+		RemoteAccess:   globals.RemoteAccessValue,
+		BindAddress:    globals.BindAddressValue,
+	}
+	err = CloneSandbox(sourceDir, cloneDef)
+	compare.OkIsNil("clone creation", err, t)
+	cloneDir := path.Join(mockSandboxHome, cloneDef.DirName)
+	for _, script := range singleScriptNames {
+		okExecutableExists(t, cloneDir, script)
+	}
+	text, err := common.SlurpAsString(path.Join(cloneDir, globals.DataDirName, "t1.ibd"))
+	compare.OkIsNil("cloned data", err, t)
+	compare.OkEqualString("cloned data", text, "some data", t)
+
+	sbDesc, err := common.ReadSandboxDescription(cloneDir)
+	compare.OkIsNil("clone description", err, t)
+	compare.OkEqualString("clone version", sbDesc.Version, mysqlVersion, t)
+	compare.OkEqualBool("clone port changed", sbDesc.Port[0] != 8011, true, t)
+	okPortExists(t, cloneDir, sbDesc.Port[0])
+
+	myCnf, err := common.SlurpAsString(path.Join(cloneDir, globals.ScriptMySandboxCnf))
+	compare.OkIsNil("clone configuration", err, t)
+	compare.OkEqualBool("option carried over", strings.Contains(myCnf, "max_connections = 42"), true, t)
+	compare.OkEqualBool("no source path", strings.Contains(myCnf, sourceDir), false, t)
+	autoCnf, err := common.SlurpAsString(path.Join(cloneDir, globals.DataDirName, globals.AutoCnfName))
+	compare.OkIsNil("clone UUID", err, t)
+	compare.OkEqualBool("new server UUID", strings.Contains(autoCnf, "server-uuid="), true, t)
+
+	err = RemoveMockEnvironment(DefaultMockDir)
+	compare.OkIsNil("removal", err, t)
+}
+
 func testDetectFlavor(t *testing.T) {
 
 	err := SetMockEnvironment(DefaultMockDir)
@@ -261,6 +330,63 @@ func testDetectFlavor(t *testing.T) {
 
 }
 
+func testCreateImportedReplicationMockSandbox(t *testing.T) {
+	err := SetMockEnvironment(DefaultMockDir)
+	if err != nil {
+		t.Fatal("mock dir creation failed")
+	}
+	compare.OkIsNil("mock creation", err, t)
+	err = CreateMockVersion("8.0.36")
+	compare.OkIsNil("client version creation", err, t)
+	var sandboxDef = SandboxDef{
+		Version:       "8.0.36",
+		Flavor:        common.MySQLFlavor,
+		Basedir:       path.Join(mockSandboxBinary, "8.0.36"),
+		ClientBasedir: path.Join(mockSandboxBinary, "8.0.36"),
+		SandboxDir:    mockSandboxHome,
+		Imported:      true,
+		DbUser:        "admin",
+		DbPassword:    "secret",
+		RplUser:       "admin",
+		RplPassword:   "secret",
+	}
+	nodes := []ImportedNode{
+		{Host: "db1", Port: 3306, Role: "source"},
+		{Host: "db2", Port: 3306, Role: "replica"},
+		{Host: "db2", Port: 3307, Role: "replica"},
+	}
+	err = CreateImportedReplication(sandboxDef, globals.MasterSlaveLabel, nodes)
+	compare.OkIsNil("imported replication creation", err, t)
+
+	sandboxDir := path.Join(mockSandboxHome, defaults.Defaults().ImportedSandboxPrefix+"repl_8_0_36")
+	for _, script := range []string{"n1", "n2", "n3", globals.ScriptUseAll, globals.ScriptStatusAll,
+		globals.ScriptCheckSlaves, globals.ScriptStopAll} {
+		okExecutableExists(t, sandboxDir, script)
+	}
+	for i, node := range nodes {
+		nodeDir := path.Join(sandboxDir, fmt.Sprintf("%s%d", defaults.Defaults().NodePrefix, i+1))
+		sbDesc, err := common.ReadSandboxDescription(nodeDir)
+		compare.OkIsNil("node description", err, t)
+		compare.OkEqualString("node host", sbDesc.Host, node.Host, t)
+		compare.OkEqualInt("node port", sbDesc.Port[0], node.Port, t)
+		// The client configuration contains the password of the imported server
+		for _, fileName := range []string{globals.ScriptMySandboxCnf, globals.ScriptConnectionJson} {
+			stat, err := os.Stat(path.Join(nodeDir, fileName))
+			compare.OkIsNil("client configuration", err, t)
+			compare.OkEqualInt(fileName+" permissions", int(stat.Mode().Perm()), globals.PrivateFileAttr, t)
+		}
+	}
+	okPortExists(t, sandboxDir, 3307)
+	useAll, err := common.SlurpAsString(path.Join(sandboxDir, globals.ScriptUseAll))
+	compare.OkIsNil("use_all contents", err, t)
+	compare.OkMatchesString("use_all replica", useAll, `server: 3 \(replica db2:3307\)`, t)
+	_, err = RemoveCustomSandbox(mockSandboxHome, common.BaseName(sandboxDir), false, true)
+	compare.OkIsNil("imported replication removal", err, t)
+
+	err = RemoveMockEnvironment(DefaultMockDir)
+	compare.OkIsNil("removal", err, t)
+}
+
 func testCreateTidbMockSandbox(t *testing.T) {
 	err := SetMockEnvironment(DefaultMockDir)
 	if err != nil {
@@ -677,7 +803,23 @@ func TestCreateSandbox(t *testing.T) {
 	t.Run("single", testCreateStandaloneSandbox)
 	t.Run("replication", testCreateReplicationSandbox)
 	t.Run("mock", testCreateMockSandbox)
+	t.Run("clone", testCloneMockSandbox)
 	t.Run("mocktidb", testCreateTidbMockSandbox)
 	t.Run("expectedFailures", testFailSandboxConditions)
 	t.Run("flavors", testDetectFlavor)
+	// Must run last, as imported sandboxes replace the single sandbox templates
+	t.Run("mockImportedReplication", testCreateImportedReplicationMockSandbox)
+}
+
+func TestLogMasksPasswords(t *testing.T) {
+	text := sandboxDefToJson(SandboxDef{DbUser: "msandbox", DbPassword: "secret1", RplPassword: "secret2"})
+	compare.OkEqualBool("db password masked", strings.Contains(text, "secret1"), false, t)
+	compare.OkEqualBool("replication password masked", strings.Contains(text, "secret2"), false, t)
+	compare.OkEqualBool("user kept", strings.Contains(text, "msandbox"), true, t)
+
+	data := common.StringMap{"DbPassword": "secret1", "RplPassword": "secret2", "Port": 8036}
+	text = stringMapToJson(data)
+	compare.OkEqualBool("template db password masked", strings.Contains(text, "secret1"), false, t)
+	compare.OkEqualBool("template replication password masked", strings.Contains(text, "secret2"), false, t)
+	compare.OkEqualString("original data", data["DbPassword"].(string), "secret1", t)
 }
@@ -0,0 +1,470 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize/english"
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox/lifecycle"
+)
+
+// How long the slaves can take to apply the transactions of the master during a switchover or failover
+const catchUpTimeout = 60 * time.Second
+
+// replicationNode is a node of a master-slave sandbox
+type replicationNode struct {
+	dir      string
+	name     string
+	port     int
+	running  bool
+	channels []lifecycle.ReplicationChannel
+}
+
+// replicationRoles describes who is the master of a master-slave sandbox,
+// according to the replication status of its running nodes
+type replicationRoles struct {
+	master *replicationNode
+	slaves []*replicationNode
+}
+
+// getReplicationRoles finds the master and the slaves of a master-slave sandbox.
+// The master is the node from which the slaves replicate. It does not need to be running.
+// Running nodes that don't replicate from anyone, other than the master, are counted among the slaves
+func getReplicationRoles(sandboxDir string) (replicationRoles, error) {
+	var roles replicationRoles
+	sbDesc, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return roles, err
+	}
+	if sbDesc.SBType != globals.MasterSlaveLabel {
+		return roles, fmt.Errorf("%s is a sandbox of type %s. Only %s sandboxes are supported",
+			sandboxDir, sbDesc.SBType, globals.MasterSlaveLabel)
+	}
+	status, err := lifecycle.Status(sandboxDir)
+	if err != nil {
+		return roles, err
+	}
+	var nodes []*replicationNode
+	masterPort := 0
+	for _, nodeStatus := range status.Nodes {
+		n := &replicationNode{
+			dir:     nodeStatus.Directory,
+			name:    nodeStatus.Name,
+			port:    nodeStatus.Port,
+			running: nodeStatus.Running,
+		}
+		nodes = append(nodes, n)
+		if !n.running {
+			continue
+		}
+		n.channels, err = lifecycle.ReplicationChannels(n.dir)
+		if err != nil {
+			return roles, errors.Wrapf(err, "error reading replication status of %s", n.name)
+		}
+		if len(n.channels) > 1 {
+			return roles, fmt.Errorf("node %s has more than one replication channel", n.name)
+		}
+		for _, channel := range n.channels {
+			if masterPort != 0 && channel.MasterPort != masterPort {
+				return roles, fmt.Errorf("the nodes of %s replicate from different masters (ports %d and %d)",
+					sandboxDir, masterPort, channel.MasterPort)
+			}
+			masterPort = channel.MasterPort
+		}
+	}
+	if masterPort == 0 {
+		return roles, fmt.Errorf("no replicating node found in %s", sandboxDir)
+	}
+	for _, n := range nodes {
+		if n.port == masterPort {
+			roles.master = n
+		} else {
+			roles.slaves = append(roles.slaves, n)
+		}
+	}
+	if roles.master == nil {
+		return roles, fmt.Errorf("the slaves of %s replicate from port %d, which does not belong to the sandbox",
+			sandboxDir, masterPort)
+	}
+	return roles, nil
+}
+
+// findSlave returns the slave with the given name
+func (roles replicationRoles) findSlave(name string) (*replicationNode, error) {
+	if roles.master.name == name {
+		return nil, fmt.Errorf("%s is already the %s", name, defaults.Defaults().MasterName)
+	}
+	for _, n := range roles.slaves {
+		if n.name == name {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("node %s not found", name)
+}
+
+// slavesReadOnlyFlags tells how the slaves of the sandbox were made read-only,
+// from the configuration file of one of them
+func slavesReadOnlyFlags(slaveDir string) (readOnly, superReadOnly bool, err error) {
+	config, err := common.ParseConfigFile(path.Join(slaveDir, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return false, false, err
+	}
+	for _, kv := range config["mysqld"] {
+		isOn := strings.EqualFold(strings.TrimSpace(kv.Value), "on") || strings.TrimSpace(kv.Value) == "1"
+		switch strings.ReplaceAll(kv.Key, "-", "_") {
+		case "super_read_only":
+			superReadOnly = isOn
+		case "read_only":
+			readOnly = isOn
+		}
+	}
+	return readOnly, superReadOnly, nil
+}
+
+var (
+	reReadOnlyOption = regexp.MustCompile(`(?m)^\s*(super[_-])?read[_-]only\s*=.*\n`)
+	rePrompt         = regexp.MustCompile(`(?m)^(prompt\s*=\s*')([^ ']*)( \[)`)
+)
+
+// setNodeRole changes the configuration file of a node, so that it matches its new role when restarted:
+// the read-only options are removed from the master and added to the slaves,
+// and the prompt shows the new role
+func setNodeRole(nodeDir, prompt, readOnlyOption string) error {
+	configFile := path.Join(nodeDir, globals.ScriptMySandboxCnf)
+	text, err := common.SlurpAsString(configFile)
+	if err != nil {
+		return err
+	}
+	text = reReadOnlyOption.ReplaceAllString(text, "")
+	if readOnlyOption != "" {
+		text = strings.TrimRight(text, "\n") + "\n" + readOnlyOption + "\n"
+	}
+	sbDesc, err := common.ReadSandboxDescription(nodeDir)
+	if err != nil {
+		return err
+	}
+	text = rePrompt.ReplaceAllStringFunc(text, func(line string) string {
+		matches := rePrompt.FindStringSubmatch(line)
+		// Keep the flavor, when it was added to the prompt
+		if strings.HasPrefix(matches[2], sbDesc.Flavor+"-") {
+			return matches[1] + sbDesc.Flavor + "-" + prompt + matches[3]
+		}
+		return matches[1] + prompt + matches[3]
+	})
+	return common.WriteString(text, configFile)
+}
+
+// changeMasterExtraOptions returns the options that were added to CHANGE MASTER TO when the sandbox
+// was created, except MASTER_AUTO_POSITION, each one preceded by a comma
+func changeMasterExtraOptions(sandboxDir string) string {
+	initializeSlaves := path.Join(sandboxDir, "initialize_"+english.PluralWord(2, defaults.Defaults().SlavePrefix, ""))
+	text, err := common.SlurpAsString(initializeSlaves)
+	if err != nil {
+		return ""
+	}
+	reOptions := regexp.MustCompile(`(?i)master_password="[^"]*"([^']*)'`)
+	matches := reOptions.FindStringSubmatch(text)
+	if matches == nil {
+		return ""
+	}
+	extra := ""
+	for _, option := range strings.Split(matches[1], ",") {
+		option = strings.TrimSpace(option)
+		if option == "" || strings.HasPrefix(strings.ToUpper(option), "MASTER_AUTO_POSITION") {
+			continue
+		}
+		extra += ", " + option
+	}
+	return extra
+}
+
+// rewriteReplicationScripts writes again the scripts of a master-slave sandbox, so that the master scripts
+// use the node that is now the master, and the slave scripts use the other nodes, in their directory order.
+// The node directories keep their names: only the scripts change
+func rewriteReplicationScripts(sandboxDir string, master *replicationNode, slaves []*replicationNode, changeMasterExtra string) error {
+	credentials, err := lifecycle.ReplicationCredentials(master.dir)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now()
+	masterAutoPosition := ", MASTER_AUTO_POSITION=1"
+	data := common.StringMap{
+		"ShellPath":          defaults.Defaults().ShellPath,
+		"Copyright":          globals.ShellScriptCopyright,
+		"AppVersion":         common.VersionDef,
+		"DateTime":           timestamp.Format(time.UnixDate),
+		"SandboxDir":         sandboxDir,
+		"MasterLabel":        master.name,
+		"MasterPort":         master.port,
+		"SlaveLabel":         defaults.Defaults().SlavePrefix,
+		"MasterAbbr":         defaults.Defaults().MasterAbbr,
+		"MasterIp":           credentials.Host,
+		"RplUser":            credentials.User,
+		"RplPassword":        credentials.Password,
+		"SlaveAbbr":          defaults.Defaults().SlaveAbbr,
+		"ChangeMasterExtra":  changeMasterExtra,
+		"MasterAutoPosition": masterAutoPosition,
+		"Slaves":             []common.StringMap{},
+	}
+	enableAdminAddress := common.ExecExists(path.Join(sandboxDir, defaults.Defaults().MasterAbbr+"a"))
+	for i, slave := range slaves {
+		// The templates build the slave directory as NodeLabel + Node.
+		// Since the directories don't follow the role numbering anymore, Node holds the whole name
+		slaveData := common.StringMap{
+			"ShellPath":          data["ShellPath"],
+			"Copyright":          data["Copyright"],
+			"AppVersion":         data["AppVersion"],
+			"DateTime":           data["DateTime"],
+			"Node":               slave.name,
+			"NodeLabel":          "",
+			"NodePort":           slave.port,
+			"SlaveLabel":         data["SlaveLabel"],
+			"MasterAbbr":         data["MasterAbbr"],
+			"SlaveAbbr":          data["SlaveAbbr"],
+			"SandboxDir":         sandboxDir,
+			"MasterPort":         master.port,
+			"MasterIp":           credentials.Host,
+			"ChangeMasterExtra":  changeMasterExtra,
+			"MasterAutoPosition": masterAutoPosition,
+			"RplUser":            credentials.User,
+			"RplPassword":        credentials.Password,
+		}
+		data["Slaves"] = append(data["Slaves"].([]common.StringMap), slaveData)
+		err = writeScripts(ScriptBatch{ReplicationTemplates, nil, sandboxDir, slaveData,
+			slaveScripts(i+1, enableAdminAddress)})
+		if err != nil {
+			return err
+		}
+	}
+	return writeScripts(ScriptBatch{ReplicationTemplates, nil, sandboxDir, data, masterSlaveScripts(enableAdminAddress)})
+}
+
+// assignRoles repoints the slaves to the new master, sets read-only mode in all nodes,
+// and rewrites the configuration files and the scripts of the sandbox to match the new roles.
+// Slaves that are not running keep their replication settings
+func assignRoles(sandboxDir string, newMaster *replicationNode, slaves []*replicationNode) error {
+	readOnly, superReadOnly, err := slavesReadOnlyFlags(newMaster.dir)
+	if err != nil {
+		return err
+	}
+	readOnlyOption := ""
+	if superReadOnly {
+		readOnlyOption = "super_read_only=on"
+	} else if readOnly {
+		readOnlyOption = "read_only=on"
+	}
+	changeMasterExtra := changeMasterExtraOptions(sandboxDir)
+
+	common.CondPrintf("Promoting %s to %s\n", newMaster.name, defaults.Defaults().MasterName)
+	err = lifecycle.ResetReplication(newMaster.dir)
+	if err != nil {
+		return err
+	}
+	err = lifecycle.SetReadOnly(newMaster.dir, false, false)
+	if err != nil {
+		return err
+	}
+	err = setNodeRole(newMaster.dir, defaults.Defaults().MasterName, "")
+	if err != nil {
+		return err
+	}
+	for i, slave := range slaves {
+		if slave.running {
+			common.CondPrintf("Replicating %s from %s\n", slave.name, newMaster.name)
+			err = lifecycle.ReplicateFrom(slave.dir, newMaster.dir, changeMasterExtra)
+			if err != nil {
+				return err
+			}
+			err = lifecycle.SetReadOnly(slave.dir, readOnly, superReadOnly)
+			if err != nil {
+				return err
+			}
+		}
+		err = setNodeRole(slave.dir, fmt.Sprintf("%s%d", defaults.Defaults().SlavePrefix, i+1), readOnlyOption)
+		if err != nil {
+			return err
+		}
+	}
+	return rewriteReplicationScripts(sandboxDir, newMaster, slaves, changeMasterExtra)
+}
+
+// Switchover makes a slave of a running master-slave sandbox the new master.
+// The current master stops accepting writes, the slaves apply all its transactions, and then
+// all the other nodes, including the old master, replicate from the new one using GTID auto-position.
+// The scripts of the sandbox are rewritten so that "m" and "s1", "s2", ... follow the new roles.
+func Switchover(sandboxDir, newMasterName string) error {
+	roles, err := getReplicationRoles(sandboxDir)
+	if err != nil {
+		return err
+	}
+	newMaster, err := roles.findSlave(newMasterName)
+	if err != nil {
+		return err
+	}
+	oldMaster := roles.master
+	for _, n := range append([]*replicationNode{oldMaster}, roles.slaves...) {
+		if !n.running {
+			return fmt.Errorf("node %s is not running. A switchover needs all the nodes", n.name)
+		}
+	}
+	_, superReadOnly, err := slavesReadOnlyFlags(newMaster.dir)
+	if err != nil {
+		return err
+	}
+	_, err = lifecycle.GtidExecuted(oldMaster.dir)
+	if err != nil {
+		return errors.Wrapf(err, "a switchover needs GTID")
+	}
+
+	common.CondPrintf("Setting %s read-only\n", oldMaster.name)
+	err = lifecycle.SetReadOnly(oldMaster.dir, true, superReadOnly)
+	if err != nil {
+		return err
+	}
+	gtidExecuted, err := lifecycle.GtidExecuted(oldMaster.dir)
+	if err != nil {
+		return err
+	}
+	for _, slave := range roles.slaves {
+		common.CondPrintf("Waiting for %s to apply the transactions of %s\n", slave.name, oldMaster.name)
+		err = lifecycle.WaitForGtidSet(slave.dir, gtidExecuted, catchUpTimeout)
+		if err != nil {
+			// The old master can accept writes again, as nothing has changed
+			_ = lifecycle.SetReadOnly(oldMaster.dir, false, false)
+			return err
+		}
+	}
+
+	var newSlaves []*replicationNode
+	for _, n := range append([]*replicationNode{oldMaster}, roles.slaves...) {
+		if n != newMaster {
+			newSlaves = append(newSlaves, n)
+		}
+	}
+	sortReplicationNodes(newSlaves)
+	// The old master gets the same read-only mode as the other slaves
+	return assignRoles(sandboxDir, newMaster, newSlaves)
+}
+
+// Failover promotes a slave of a master-slave sandbox whose master is not running.
+// The slaves apply all the transactions that they have received, and then the most up-to-date one
+// becomes the master, unless newMasterName indicates a different one.
+// The other running slaves replicate from the new master using GTID auto-position.
+// The old master is left out of replication, but it is made read-only for when it restarts.
+func Failover(sandboxDir, newMasterName string) error {
+	roles, err := getReplicationRoles(sandboxDir)
+	if err != nil {
+		return err
+	}
+	if roles.master.running {
+		return fmt.Errorf("%s %s is running. Stop it to simulate a failure, or use a switchover instead",
+			defaults.Defaults().MasterName, roles.master.name)
+	}
+	type candidate struct {
+		node         *replicationNode
+		gtidExecuted string
+	}
+	var candidates []candidate
+	for _, slave := range roles.slaves {
+		if !slave.running {
+			common.CondPrintf("Skipping %s, as it is not running\n", slave.name)
+			continue
+		}
+		if len(slave.channels) > 0 {
+			err = lifecycle.StopReplicationIO(slave.dir)
+			if err != nil {
+				return err
+			}
+			if slave.channels[0].RetrievedGtidSet != "" {
+				common.CondPrintf("Waiting for %s to apply the transactions it received\n", slave.name)
+				err = lifecycle.WaitForGtidSet(slave.dir, slave.channels[0].RetrievedGtidSet, catchUpTimeout)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		gtidExecuted, err := lifecycle.GtidExecuted(slave.dir)
+		if err != nil {
+			return errors.Wrapf(err, "a failover needs GTID")
+		}
+		candidates = append(candidates, candidate{slave, gtidExecuted})
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no running slaves found in %s", sandboxDir)
+	}
+
+	var newMaster *replicationNode
+	if newMasterName != "" {
+		newMaster, err = roles.findSlave(newMasterName)
+		if err != nil {
+			return err
+		}
+		if !newMaster.running {
+			return fmt.Errorf("node %s is not running", newMasterName)
+		}
+	} else {
+		// The new master is the first slave that has executed all the transactions of the others
+		for _, c := range candidates {
+			upToDate := true
+			for _, other := range candidates {
+				isSubset, err := lifecycle.IsGtidSubset(c.node.dir, other.gtidExecuted, c.gtidExecuted)
+				if err != nil {
+					return err
+				}
+				upToDate = upToDate && isSubset
+			}
+			if upToDate {
+				newMaster = c.node
+				break
+			}
+		}
+		if newMaster == nil {
+			return fmt.Errorf("the slaves have executed different transactions: " +
+				"choose the new master with --new-master")
+		}
+	}
+
+	var newSlaves []*replicationNode
+	for _, n := range append([]*replicationNode{roles.master}, roles.slaves...) {
+		if n != newMaster {
+			newSlaves = append(newSlaves, n)
+		}
+	}
+	sortReplicationNodes(newSlaves)
+	err = assignRoles(sandboxDir, newMaster, newSlaves)
+	if err != nil {
+		return err
+	}
+	common.CondPrintf("%s was left out of replication. After restarting it, make it replicate from %s\n",
+		roles.master.name, newMaster.name)
+	return nil
+}
+
+// sortReplicationNodes puts the nodes in directory order, which is the order of the slave scripts
+func sortReplicationNodes(nodes []*replicationNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+}
@@ -0,0 +1,103 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestSwitchoverFiles(t *testing.T) {
+	sandboxDir := t.TempDir()
+	err := common.WriteString(`echo 'CHANGE MASTER TO  master_host="127.0.0.1",  master_port=19001,  `+
+		`master_user="rsandbox",  master_password="rsandbox" , MASTER_AUTO_POSITION=1 , GET_MASTER_PUBLIC_KEY=1' | use`,
+		path.Join(sandboxDir, "initialize_slaves"))
+	compare.OkIsNil("writing initialize_slaves", err, t)
+	compare.OkEqualString("extra options", changeMasterExtraOptions(sandboxDir), ", GET_MASTER_PUBLIC_KEY=1", t)
+
+	var nodes []*replicationNode
+	for i, name := range []string{"master", "node1", "node2"} {
+		nodeDir := path.Join(sandboxDir, name)
+		port := 19001 + i
+		err = os.Mkdir(nodeDir, globals.PublicDirectoryAttr)
+		compare.OkIsNil("creating node", err, t)
+		err = common.WriteSandboxDescription(nodeDir, common.SandboxDescription{
+			SBType: "replication-node", Version: "8.0.36", Flavor: common.MySQLFlavor, Port: []int{port},
+		})
+		compare.OkIsNil("writing description", err, t)
+		prompt := "master"
+		readOnly := ""
+		if i > 0 {
+			prompt = fmt.Sprintf("slave%d", i)
+			readOnly = "super_read_only=on\n"
+		}
+		err = common.WriteString(fmt.Sprintf("[mysql]\nprompt='%s [\\h:%d] {\\u} (\\d) > '\n\n[mysqld]\nport=%d\n%s",
+			prompt, port, port, readOnly), path.Join(nodeDir, globals.ScriptMySandboxCnf))
+		compare.OkIsNil("writing configuration", err, t)
+		err = common.WriteString(fmt.Sprintf(`{"master_host": "127.0.0.1", "master_port": %d, `+
+			`"master_user": "rsandbox", "master_password": "rsandbox"}`, port), path.Join(nodeDir, globals.ScriptConnectionJson))
+		compare.OkIsNil("writing connection file", err, t)
+		nodes = append(nodes, &replicationNode{dir: nodeDir, name: name, port: port})
+	}
+
+	readOnly, superReadOnly, err := slavesReadOnlyFlags(nodes[1].dir)
+	compare.OkIsNil("read-only flags", err, t)
+	compare.OkEqualBool("read only", readOnly, false, t)
+	compare.OkEqualBool("super read only", superReadOnly, true, t)
+
+	// node1 becomes the master
+	err = setNodeRole(nodes[1].dir, "master", "")
+	compare.OkIsNil("new master role", err, t)
+	err = setNodeRole(nodes[0].dir, "slave1", "super_read_only=on")
+	compare.OkIsNil("new slave role", err, t)
+	newMasterCnf, err := common.SlurpAsString(path.Join(nodes[1].dir, globals.ScriptMySandboxCnf))
+	compare.OkIsNil("reading new master configuration", err, t)
+	compare.OkEqualBool("new master prompt", strings.Contains(newMasterCnf, "prompt='master [\\h:19002]"), true, t)
+	compare.OkEqualBool("new master writable", strings.Contains(newMasterCnf, "read_only"), false, t)
+	oldMasterCnf, err := common.SlurpAsString(path.Join(nodes[0].dir, globals.ScriptMySandboxCnf))
+	compare.OkIsNil("reading old master configuration", err, t)
+	compare.OkEqualBool("old master prompt", strings.Contains(oldMasterCnf, "prompt='slave1 [\\h:19001]"), true, t)
+	compare.OkEqualBool("old master read-only", strings.Contains(oldMasterCnf, "super_read_only=on"), true, t)
+
+	err = rewriteReplicationScripts(sandboxDir, nodes[1], []*replicationNode{nodes[0], nodes[2]}, ", GET_MASTER_PUBLIC_KEY=1")
+	compare.OkIsNil("rewriting scripts", err, t)
+	var scriptTests = []struct {
+		script   string
+		expected string
+	}{
+		{"m", path.Join(sandboxDir, "node1", "use")},
+		{"n1", path.Join(sandboxDir, "node1", "use")},
+		{"s1", path.Join(sandboxDir, "master", "use")},
+		{"n2", path.Join(sandboxDir, "master", "use")},
+		{"s2", path.Join(sandboxDir, "node2", "use")},
+		{"use_all_slaves", "export ONLY_SLAVES=1"},
+		{"use_all", "$SBDIR/node1/$USE_SCRIPT"},
+		{"initialize_slaves", "master_port=19002"},
+		{"initialize_slaves", "MASTER_AUTO_POSITION=1 , GET_MASTER_PUBLIC_KEY=1"},
+	}
+	for _, st := range scriptTests {
+		text, err := common.SlurpAsString(path.Join(sandboxDir, st.script))
+		compare.OkIsNil("reading "+st.script, err, t)
+		compare.OkEqualBool(st.script+" contains "+st.expected, strings.Contains(text, st.expected), true, t)
+	}
+}
@@ -0,0 +1,242 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/xi2/xz"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func UnpackZstTar(filename string, destination string, verbosityLevel int) (err error) {
+	Verbose = verbosityLevel
+	if !common.FileExists(filename) {
+		return fmt.Errorf("file %s not found", filename)
+	}
+	if !common.DirExists(destination) {
+		return fmt.Errorf("directory %s not found", destination)
+	}
+	filename, err = common.AbsolutePath(filename)
+	if err != nil {
+		return err
+	}
+	err = os.Chdir(destination)
+	if err != nil {
+		return errors.Wrapf(err, "error changing directory to %s", destination)
+	}
+
+	f, err := os.Open(filename) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer f.Close() // #nosec G307
+	// Create a zstd Reader
+	r, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// Create a tar Reader
+	tr := tar.NewReader(r)
+	return unpackTarFiles(tr, destination)
+}
+
+// UnpackZip extracts a zip archive with the same rules used for tarballs:
+// all the entries must be inside one directory, and links can't point outside of it
+func UnpackZip(filename string, destination string, verbosityLevel int) (err error) {
+	Verbose = verbosityLevel
+	if !common.FileExists(filename) {
+		return fmt.Errorf("file %s not found", filename)
+	}
+	if !common.DirExists(destination) {
+		return fmt.Errorf("directory %s not found", destination)
+	}
+	extractAbsDir, err := filepath.Abs(destination)
+	if err != nil {
+		return fmt.Errorf("error defining the absolute path of '%s': %s", destination, err)
+	}
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	err = os.Chdir(destination)
+	if err != nil {
+		return errors.Wrapf(err, "error changing directory to %s", destination)
+	}
+	var count int = 0
+	var reSlash = regexp.MustCompile(`/.*`)
+
+	innerDir := ""
+	for _, entry := range archive.File {
+		fileName := sanitizedName(entry.Name)
+		if fileName == "" {
+			continue
+		}
+		fileDir := path.Dir(fileName)
+		upperDir := reSlash.ReplaceAllString(fileDir, "")
+		if innerDir != "" {
+			if upperDir != innerDir {
+				return fmt.Errorf(errMoreThanOneDirectory, upperDir, innerDir)
+			}
+		} else {
+			innerDir = upperDir
+		}
+		if !common.DirExists(fileDir) {
+			if err = os.MkdirAll(fileDir, globals.PublicDirectoryAttr); err != nil {
+				return err
+			}
+			condPrint(" + "+fileDir+" ", true, CHATTY)
+		}
+		fileMode := entry.Mode()
+		switch {
+		case fileMode.IsDir():
+			if err = os.MkdirAll(fileName, globals.PublicDirectoryAttr); err != nil {
+				return err
+			}
+		case fileMode&os.ModeSymlink != 0:
+			// The target of a link is stored as the contents of the entry
+			linkName, err := readZipEntry(entry)
+			if err != nil {
+				return err
+			}
+			if linkName == "" {
+				return fmt.Errorf("file %s is a symlink, but no link information was provided", fileName)
+			}
+			err = checkLinkTarget(entry.Name, linkName, extractAbsDir)
+			if err != nil {
+				return err
+			}
+			condPrint(fmt.Sprintf("%s -> %s", fileName, linkName), true, CHATTY)
+			err = os.Symlink(linkName, fileName)
+			if err != nil {
+				return fmt.Errorf("%s -> %s\n#ERROR: %s", fileName, linkName, err)
+			}
+		case fileMode.IsRegular():
+			if err = unpackZipFile(fileName, entry); err != nil {
+				return err
+			}
+			count++
+			condPrint(fileName, true, CHATTY)
+			if count%10 == 0 {
+				mark := "."
+				if count%100 == 0 {
+					mark = strconv.Itoa(count)
+				}
+				if Verbose < CHATTY {
+					condPrint(mark, false, 1)
+				}
+			}
+		}
+	}
+	condPrint("Files ", false, CHATTY)
+	condPrint(strconv.Itoa(count), true, 1)
+	return nil
+}
+
+func readZipEntry(entry *zip.File) (string, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	return string(contents), err
+}
+
+func unpackZipFile(fileName string, entry *zip.File) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	// #nosec G304
+	writer, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer writer.Close() // #nosec G307
+	// #nosec G110
+	if _, err = io.Copy(writer, reader); err != nil {
+		return err
+	}
+	return os.Chmod(fileName, entry.Mode().Perm())
+}
+
+func verifyZipFile(fileName string) error {
+	archive, err := zip.OpenReader(fileName)
+	if err != nil {
+		return fmt.Errorf("[zip Validation] %s", err)
+	}
+	defer archive.Close()
+	if len(archive.File) == 0 {
+		return fmt.Errorf("[EOF Validation] file %s is empty", fileName)
+	}
+	return checkInnerDirectory(fileName, archive.File[0].Name)
+}
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte{'B', 'Z', 'h'}
+)
+
+// decompressedReader recognizes the compression of a stream from its first bytes,
+// and returns a reader of the uncompressed contents. A stream without a known
+// compression is returned as it is
+func decompressedReader(r io.Reader) (io.Reader, func(), error) {
+	noClose := func() {}
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(xzMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, noClose, err
+		}
+		return reader, func() { _ = reader.Close() }, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		reader, err := xz.NewReader(buffered, 0)
+		return reader, noClose, err
+	case bytes.HasPrefix(magic, zstdMagic):
+		reader, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, noClose, err
+		}
+		return reader, reader.Close, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(buffered), noClose, nil
+	}
+	return buffered, noClose, nil
+}
@@ -0,0 +1,128 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2021 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// PackTarGz creates a compressed tarball with the contents of sourceDir.
+// All the entries are stored under topDir, so that the tarball can be
+// extracted with UnpackTar.
+// When include is not nil, only the paths (relative to sourceDir) for which
+// it returns true are archived.
+// Only directories and regular files are archived.
+func PackTarGz(sourceDir, topDir, filename string, include func(relPath string) bool) error {
+	return packTarGz(sourceDir, topDir, filename, include, false)
+}
+
+// PackTarGzWithLinks works like PackTarGz, and also archives symbolic links,
+// which directories of binaries use for their shared libraries
+func PackTarGzWithLinks(sourceDir, topDir, filename string) error {
+	return packTarGz(sourceDir, topDir, filename, nil, true)
+}
+
+func packTarGz(sourceDir, topDir, filename string, include func(relPath string) bool, withLinks bool) (err error) {
+	if !common.DirExists(sourceDir) {
+		return fmt.Errorf("directory %s not found", sourceDir)
+	}
+	file, err := os.Create(filename) // #nosec G304
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	compressor := gzip.NewWriter(file)
+	writer := tar.NewWriter(compressor)
+
+	count := 0
+	err = filepath.Walk(sourceDir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(sourceDir, fullPath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		// A directory that is not included may still contain included paths,
+		// and it is walked anyway
+		if include != nil && !include(relPath) {
+			return nil
+		}
+		isLink := info.Mode()&os.ModeSymlink != 0
+		linkName := ""
+		if isLink && withLinks {
+			linkName, err = os.Readlink(fullPath)
+			if err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			// sockets, pipes, and links are not archived
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, linkName)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(topDir, filepath.ToSlash(relPath))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err = writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() || isLink {
+			return nil
+		}
+		source, err := os.Open(fullPath) // #nosec G304
+		if err != nil {
+			return err
+		}
+		defer source.Close() // #nosec G307
+		if _, err = io.Copy(writer, source); err != nil {
+			return err
+		}
+		count++
+		condPrint(fullPath, true, CHATTY)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	if err = compressor.Close(); err != nil {
+		return err
+	}
+	condPrint(fmt.Sprintf("Files %d", count), true, VERBOSE)
+	return nil
+}
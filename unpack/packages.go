@@ -0,0 +1,460 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Where the files of RPM and DEB packages go in the layout of an expanded tarball.
+// The first matching rule is used. Files that match no rule (documentation,
+// configuration, service definitions) are not extracted
+var packageLayout = []struct {
+	re     *regexp.Regexp
+	target string
+}{
+	{regexp.MustCompile(`^usr/(?:s?bin|libexec)/([^/]+)$`), "bin/$1"},
+	{regexp.MustCompile(`^usr/lib(?:64)?/(?:[^/]+-linux-gnu/)?(?:mysql|mariadb\d*)/plugin/(.+)$`), "lib/plugin/$1"},
+	{regexp.MustCompile(`^usr/lib(?:64)?/(?:[^/]+-linux-gnu/)?(?:mysql/|mariadb\d*/)?(lib[^/]+\.so[^/]*)$`), "lib/$1"},
+	{regexp.MustCompile(`^usr/share/(?:mysql|mariadb)[^/]*/(.+)$`), "share/$1"},
+}
+
+// packagedPath returns the position in the basedir of a file installed by a package,
+// or an empty string if the file is not needed
+func packagedPath(name string) string {
+	name = path.Clean(sanitizedName(name))
+	for _, rule := range packageLayout {
+		if rule.re.MatchString(name) {
+			return rule.re.ReplaceAllString(name, rule.target)
+		}
+	}
+	return ""
+}
+
+// packageExtractor places the files of a package payload in the basedir layout
+type packageExtractor struct {
+	extractAbsDir string
+	count         int
+	// Files that share their data with other entries (cpio hard links)
+	pendingLinks map[string][]string
+}
+
+func newPackageExtractor(destination string) (*packageExtractor, error) {
+	if !common.DirExists(destination) {
+		err := os.MkdirAll(destination, globals.PublicDirectoryAttr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	extractAbsDir, err := filepath.Abs(destination)
+	if err != nil {
+		return nil, fmt.Errorf("error defining the absolute path of '%s': %s", destination, err)
+	}
+	err = os.Chdir(extractAbsDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error changing directory to %s", destination)
+	}
+	return &packageExtractor{extractAbsDir: extractAbsDir, pendingLinks: make(map[string][]string)}, nil
+}
+
+func (pe *packageExtractor) prepare(fileName string) error {
+	fileDir := path.Dir(fileName)
+	if !common.DirExists(fileDir) {
+		if err := os.MkdirAll(fileDir, globals.PublicDirectoryAttr); err != nil {
+			return err
+		}
+		condPrint(" + "+fileDir+" ", true, CHATTY)
+	}
+	// A file installed by more than one package: the last one wins
+	if _, err := os.Lstat(fileName); err == nil {
+		return os.Remove(fileName)
+	}
+	return nil
+}
+
+func (pe *packageExtractor) writeFile(name string, mode os.FileMode, reader io.Reader) error {
+	fileName := packagedPath(name)
+	if fileName == "" {
+		condPrint("- "+name, true, CHATTY)
+		return nil
+	}
+	if err := pe.prepare(fileName); err != nil {
+		return err
+	}
+	// #nosec G304
+	writer, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer writer.Close() // #nosec G307
+	// #nosec G110
+	if _, err = io.Copy(writer, reader); err != nil {
+		return err
+	}
+	err = os.Chmod(fileName, mode.Perm())
+	if err != nil {
+		return err
+	}
+	pe.count++
+	condPrint(fileName, true, CHATTY)
+	return nil
+}
+
+// writeLink creates a link, relative to the basedir layout, for a link in the package.
+// Links to files that are not extracted are skipped
+func (pe *packageExtractor) writeLink(name, linkName string) error {
+	fileName := packagedPath(name)
+	if fileName == "" {
+		return nil
+	}
+	target := linkName
+	if !strings.HasPrefix(target, "/") {
+		target = path.Join(path.Dir(sanitizedName(name)), target)
+	}
+	targetName := packagedPath(target)
+	if targetName == "" {
+		condPrint(fmt.Sprintf("- %s -> %s (target not extracted)", name, linkName), true, CHATTY)
+		return nil
+	}
+	relativeLink, err := filepath.Rel(path.Dir(fileName), targetName)
+	if err != nil {
+		return err
+	}
+	err = checkLinkTarget(fileName, relativeLink, pe.extractAbsDir)
+	if err != nil {
+		return err
+	}
+	if err = pe.prepare(fileName); err != nil {
+		return err
+	}
+	condPrint(fmt.Sprintf("%s -> %s", fileName, relativeLink), true, CHATTY)
+	return os.Symlink(relativeLink, fileName)
+}
+
+// writeHardLink copies an extracted file to the position of a hard link
+func (pe *packageExtractor) writeHardLink(name, source string) error {
+	sourceName := packagedPath(source)
+	if sourceName == "" || !common.FileExists(sourceName) {
+		return nil
+	}
+	info, err := os.Stat(sourceName)
+	if err != nil {
+		return err
+	}
+	// #nosec G304
+	reader, err := os.Open(sourceName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close() // #nosec G307
+	return pe.writeFile(name, info.Mode(), reader)
+}
+
+func (pe *packageExtractor) done() {
+	condPrint("Files ", false, CHATTY)
+	condPrint(strconv.Itoa(pe.count), true, 1)
+}
+
+var (
+	rpmLeadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+const (
+	rpmLeadSize = 96
+	// Larger headers are not produced by rpmbuild, and are rejected to avoid a corrupted file
+	// causing huge reads
+	rpmMaxHeaderSize = 256 * 1024 * 1024
+	cpioHeaderSize   = 110
+	cpioTrailer      = "TRAILER!!!"
+)
+
+// skipRpmHeader reads a RPM header structure without interpreting it.
+// The signature header is padded to a multiple of 8 bytes
+func skipRpmHeader(reader io.Reader, padded bool) error {
+	intro := make([]byte, 16)
+	if _, err := io.ReadFull(reader, intro); err != nil {
+		return fmt.Errorf("error reading RPM header: %s", err)
+	}
+	if !bytes.Equal(intro[0:4], rpmHeaderMagic) {
+		return fmt.Errorf("invalid RPM header")
+	}
+	indexCount := int64(binary.BigEndian.Uint32(intro[8:12]))
+	dataSize := int64(binary.BigEndian.Uint32(intro[12:16]))
+	size := indexCount*16 + dataSize
+	if size > rpmMaxHeaderSize {
+		return fmt.Errorf("RPM header too large (%d bytes)", size)
+	}
+	if padded {
+		size += (8 - size%8) % 8
+	}
+	_, err := io.CopyN(io.Discard, reader, size)
+	return err
+}
+
+type cpioHeader struct {
+	name   string
+	mode   uint32
+	inode  uint32
+	links  uint32
+	size   int64
+	isLast bool
+}
+
+const (
+	cpioTypeMask    = 0170000
+	cpioTypeRegular = 0100000
+	cpioTypeSymlink = 0120000
+)
+
+// readCpioHeader reads a header of a cpio archive in "new ASCII" format,
+// which is what RPM payloads use
+func readCpioHeader(reader io.Reader) (cpioHeader, error) {
+	var header cpioHeader
+	raw := make([]byte, cpioHeaderSize)
+	if _, err := io.ReadFull(reader, raw); err != nil {
+		return header, fmt.Errorf("error reading cpio header: %s", err)
+	}
+	magic := string(raw[0:6])
+	if magic != "070701" && magic != "070702" {
+		return header, fmt.Errorf("unsupported cpio format (magic %q)", magic)
+	}
+	field := func(n int) (uint32, error) {
+		value, err := strconv.ParseUint(string(raw[6+n*8:14+n*8]), 16, 32)
+		return uint32(value), err
+	}
+	var values [13]uint32
+	for n := range values {
+		value, err := field(n)
+		if err != nil {
+			return header, fmt.Errorf("invalid cpio header: %s", err)
+		}
+		values[n] = value
+	}
+	header.inode = values[0]
+	header.mode = values[1]
+	header.links = values[4]
+	header.size = int64(values[6])
+	nameSize := int64(values[11])
+	// The name is followed by a NUL, and padded so that header and name are a multiple of 4
+	name := make([]byte, nameSize+(4-(cpioHeaderSize+nameSize)%4)%4)
+	if _, err := io.ReadFull(reader, name); err != nil {
+		return header, fmt.Errorf("error reading cpio file name: %s", err)
+	}
+	header.name = strings.TrimRight(string(name), "\x00")
+	header.isLast = header.name == cpioTrailer
+	return header, nil
+}
+
+// unpackCpioFiles extracts a cpio payload. In a set of hard links, only the last
+// entry has data: the others are recorded, and written when the data is found
+func unpackCpioFiles(reader io.Reader, pe *packageExtractor) error {
+	for {
+		header, err := readCpioHeader(reader)
+		if err != nil {
+			return err
+		}
+		if header.isLast {
+			return nil
+		}
+		data := io.LimitReader(reader, header.size)
+		switch header.mode & cpioTypeMask {
+		case cpioTypeRegular:
+			hardLinkKey := strconv.FormatUint(uint64(header.inode), 10)
+			if header.links > 1 && header.size == 0 {
+				pe.pendingLinks[hardLinkKey] = append(pe.pendingLinks[hardLinkKey], header.name)
+				break
+			}
+			err = pe.writeFile(header.name, os.FileMode(header.mode), data)
+			if err != nil {
+				return err
+			}
+			for _, linked := range pe.pendingLinks[hardLinkKey] {
+				err = pe.writeHardLink(linked, header.name)
+				if err != nil {
+					return err
+				}
+			}
+			delete(pe.pendingLinks, hardLinkKey)
+		case cpioTypeSymlink:
+			linkName, err := io.ReadAll(data)
+			if err != nil {
+				return err
+			}
+			err = pe.writeLink(header.name, string(linkName))
+			if err != nil {
+				return err
+			}
+		}
+		// Directories are created when their files are extracted
+		if _, err = io.Copy(io.Discard, data); err != nil {
+			return err
+		}
+		if _, err = io.CopyN(io.Discard, reader, (4-header.size%4)%4); err != nil {
+			return err
+		}
+	}
+}
+
+// UnpackRpm extracts from a RPM package the files that a sandbox uses, and places
+// them in destination with the layout of an expanded tarball (bin, lib, lib/plugin, share).
+// Several packages (server, client, libraries) can be extracted into the same destination
+func UnpackRpm(filename string, destination string, verbosityLevel int) (err error) {
+	Verbose = verbosityLevel
+	if !common.FileExists(filename) {
+		return fmt.Errorf("file %s not found", filename)
+	}
+	// #nosec G304
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close() // #nosec G307
+	reader := bufio.NewReader(file)
+	lead := make([]byte, rpmLeadSize)
+	if _, err = io.ReadFull(reader, lead); err != nil || !bytes.Equal(lead[0:4], rpmLeadMagic) {
+		return fmt.Errorf("file %s is not a RPM package", filename)
+	}
+	// Signature header, and then the header with the package metadata
+	if err = skipRpmHeader(reader, true); err != nil {
+		return err
+	}
+	if err = skipRpmHeader(reader, false); err != nil {
+		return err
+	}
+	payload, closePayload, err := decompressedReader(reader)
+	if err != nil {
+		return fmt.Errorf("error reading the payload of %s: %s", filename, err)
+	}
+	defer closePayload()
+	pe, err := newPackageExtractor(destination)
+	if err != nil {
+		return err
+	}
+	err = unpackCpioFiles(payload, pe)
+	if err != nil {
+		return fmt.Errorf("error extracting %s: %s", filename, err)
+	}
+	pe.done()
+	return nil
+}
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+)
+
+// debDataMember finds the data archive (data.tar, data.tar.xz, data.tar.zst, ...)
+// among the members of the ar archive that makes a DEB package
+func debDataMember(reader io.Reader) (io.Reader, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("not a DEB package")
+	}
+	header := make([]byte, arHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("data archive not found in DEB package")
+			}
+			return nil, err
+		}
+		name := strings.TrimRight(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size for member %s of DEB package", name)
+		}
+		if strings.HasPrefix(name, "data.tar") {
+			return io.LimitReader(reader, size), nil
+		}
+		// Members are aligned to an even offset
+		if _, err = io.CopyN(io.Discard, reader, size+size%2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// UnpackDeb extracts from a DEB package the files that a sandbox uses, and places
+// them in destination with the layout of an expanded tarball (see UnpackRpm)
+func UnpackDeb(filename string, destination string, verbosityLevel int) (err error) {
+	Verbose = verbosityLevel
+	if !common.FileExists(filename) {
+		return fmt.Errorf("file %s not found", filename)
+	}
+	// #nosec G304
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close() // #nosec G307
+	member, err := debDataMember(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", filename, err)
+	}
+	data, closeData, err := decompressedReader(member)
+	if err != nil {
+		return fmt.Errorf("error reading the data of %s: %s", filename, err)
+	}
+	defer closeData()
+	pe, err := newPackageExtractor(destination)
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(data)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting %s: %s", filename, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			err = pe.writeFile(header.Name, os.FileMode(header.Mode), reader)
+		case tar.TypeSymlink:
+			err = pe.writeLink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = pe.writeHardLink(header.Name, header.Linkname)
+		}
+		if err != nil {
+			return fmt.Errorf("error extracting %s: %s", filename, err)
+		}
+	}
+	pe.done()
+	return nil
+}
+
+// IsPackage tells whether a file is a RPM or DEB package
+func IsPackage(filename string) bool {
+	return strings.HasSuffix(filename, globals.RpmExt) || strings.HasSuffix(filename, globals.DebExt)
+}
@@ -0,0 +1,376 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2022 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type testEntry struct {
+	name     string
+	contents string
+	linkName string
+}
+
+// The files of a server package, as installed by RPM and DEB
+var testPackageEntries = []testEntry{
+	{name: "./usr/sbin/mysqld", contents: "server"},
+	{name: "./usr/bin/mysql", contents: "client"},
+	{name: "./usr/bin/mysql_client", linkName: "mysql"},
+	{name: "./usr/lib64/mysql/plugin/auth_socket.so", contents: "plugin"},
+	{name: "./usr/lib/x86_64-linux-gnu/libmysqlclient.so.21", contents: "library"},
+	{name: "./usr/lib/x86_64-linux-gnu/libmysqlclient.so", linkName: "libmysqlclient.so.21"},
+	{name: "./usr/share/mysql-8.0/english/errmsg.sys", contents: "messages"},
+	{name: "./usr/share/doc/mysql/README", contents: "not needed"},
+	{name: "./etc/mysql/my.cnf", contents: "not needed"},
+	{name: "./usr/bin/../../etc/passwd", contents: "outside"},
+	{name: "./usr/bin/mysql_passwd", linkName: "/etc/passwd"},
+}
+
+// The same files in the layout of an expanded tarball. An empty value means a link
+var testExpectedLayout = map[string]string{
+	"bin/mysqld":                "server",
+	"bin/mysql":                 "client",
+	"bin/mysql_client":          "",
+	"lib/plugin/auth_socket.so": "plugin",
+	"lib/libmysqlclient.so.21":  "library",
+	"lib/libmysqlclient.so":     "",
+	"share/english/errmsg.sys":  "messages",
+}
+
+func checkExtractedLayout(t *testing.T, destination string) {
+	for name, contents := range testExpectedLayout {
+		fullName := path.Join(destination, name)
+		info, err := os.Lstat(fullName)
+		if err != nil {
+			t.Fatalf("expected file %s not found: %s", name, err)
+		}
+		if contents == "" {
+			if info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("%s should be a link", name)
+			}
+			fullName, err = os.Readlink(fullName)
+			if err != nil || path.Dir(fullName) != "." {
+				t.Errorf("%s should be a link to the same directory - found %s", name, fullName)
+			}
+			continue
+		}
+		found, err := os.ReadFile(fullName)
+		if err != nil || string(found) != contents {
+			t.Errorf("%s: expected contents %q - found %q (%v)", name, contents, found, err)
+		}
+	}
+	for _, unwanted := range []string{"usr", "etc", "share/README", "bin/mysql_passwd", "bin/passwd"} {
+		if _, err := os.Lstat(path.Join(destination, unwanted)); err == nil {
+			t.Errorf("unexpected file %s extracted", unwanted)
+		}
+	}
+	if _, err := os.Lstat(path.Join(path.Dir(destination), "etc", "passwd")); err == nil {
+		t.Errorf("file extracted outside of destination")
+	}
+}
+
+func cpioHeaderBytes(name string, mode, size int) []byte {
+	header := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		1, mode, 0, 0, 1, 0, size, 0, 0, 0, 0, len(name)+1, 0)
+	header += name + "\x00"
+	for len(header)%4 != 0 {
+		header += "\x00"
+	}
+	return []byte(header)
+}
+
+func makeCpio(entries []testEntry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		mode, data := 0100755, entry.contents
+		if entry.linkName != "" {
+			mode, data = 0120777, entry.linkName
+		}
+		buf.Write(cpioHeaderBytes(entry.name, mode, len(data)))
+		buf.WriteString(data)
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	buf.Write(cpioHeaderBytes(cpioTrailer, 0, 0))
+	return buf.Bytes()
+}
+
+func rpmHeaderBytes(dataSize int, padded bool) []byte {
+	header := make([]byte, 16)
+	copy(header, rpmHeaderMagic)
+	binary.BigEndian.PutUint32(header[8:12], 1)
+	binary.BigEndian.PutUint32(header[12:16], uint32(dataSize))
+	header = append(header, make([]byte, 16+dataSize)...)
+	for padded && len(header)%8 != 0 {
+		header = append(header, 0)
+	}
+	return header
+}
+
+func makeRpm(t *testing.T, fileName string, entries []testEntry) {
+	var buf bytes.Buffer
+	lead := make([]byte, rpmLeadSize)
+	copy(lead, rpmLeadMagic)
+	buf.Write(lead)
+	buf.Write(rpmHeaderBytes(5, true))
+	buf.Write(rpmHeaderBytes(21, false))
+	compressor := gzip.NewWriter(&buf)
+	if _, err := compressor.Write(makeCpio(entries)); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeTar(t *testing.T, writer io.Writer, entries []testEntry) {
+	tw := tar.NewWriter(writer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0755, Size: int64(len(entry.contents)), Typeflag: tar.TypeReg}
+		if entry.linkName != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0777, Linkname: entry.linkName, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func arMember(name string, data []byte) []byte {
+	member := []byte(fmt.Sprintf("%-16s%-12d%-6d%-6d%-8s%-10d`\n", name, 0, 0, 0, "100644", len(data)))
+	member = append(member, data...)
+	if len(data)%2 != 0 {
+		member = append(member, '\n')
+	}
+	return member
+}
+
+func makeDeb(t *testing.T, fileName string, entries []testEntry) {
+	var data bytes.Buffer
+	compressor, err := zstd.NewWriter(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	makeTar(t, compressor, entries)
+	if err = compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var control bytes.Buffer
+	makeTar(t, &control, []testEntry{{name: "./control", contents: "Package: mysql-server-core"}})
+	deb := []byte(arMagic)
+	deb = append(deb, arMember("debian-binary", []byte("2.0\n"))...)
+	deb = append(deb, arMember("control.tar", control.Bytes())...)
+	deb = append(deb, arMember("data.tar.zst", data.Bytes())...)
+	if err = os.WriteFile(fileName, deb, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func keepWorkingDirectory(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+}
+
+func TestUnpackPackages(t *testing.T) {
+	keepWorkingDirectory(t)
+	var tests = []struct {
+		name      string
+		extension string
+		maker     func(*testing.T, string, []testEntry)
+		unpacker  func(string, string, int) error
+	}{
+		{"rpm", ".rpm", makeRpm, UnpackRpm},
+		{"deb", ".deb", makeDeb, UnpackDeb},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			destination := path.Join(workDir, "mysql", "8.0.36")
+			// Server and client in separate packages, extracted into the same directory
+			serverPackage := path.Join(workDir, "mysql-server"+tt.extension)
+			clientPackage := path.Join(workDir, "mysql-client"+tt.extension)
+			tt.maker(t, serverPackage, testPackageEntries[:3])
+			tt.maker(t, clientPackage, testPackageEntries[3:])
+			for _, pkg := range []string{serverPackage, clientPackage} {
+				if err := tt.unpacker(pkg, destination, SILENT); err != nil {
+					t.Fatalf("error unpacking %s: %s", pkg, err)
+				}
+			}
+			checkExtractedLayout(t, destination)
+
+			if err := tt.unpacker(path.Join(workDir, "missing"+tt.extension), destination, SILENT); err == nil {
+				t.Errorf("missing package accepted")
+			}
+		})
+	}
+	notPackage := path.Join(t.TempDir(), "fake.rpm")
+	if err := os.WriteFile(notPackage, []byte("not a package"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnpackRpm(notPackage, t.TempDir(), SILENT); err == nil {
+		t.Errorf("invalid RPM accepted")
+	}
+	if err := UnpackDeb(notPackage, t.TempDir(), SILENT); err == nil {
+		t.Errorf("invalid DEB accepted")
+	}
+}
+
+func TestPackagedPath(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"./usr/sbin/mysqld", "bin/mysqld"},
+		{"/usr/libexec/mysqld", "bin/mysqld"},
+		{"usr/bin/mariadb", "bin/mariadb"},
+		{"./usr/lib64/mysql/libmysqlclient.so.21.2.36", "lib/libmysqlclient.so.21.2.36"},
+		{"./usr/lib64/libmariadb.so.3", "lib/libmariadb.so.3"},
+		{"./usr/lib/mysql/plugin/debug/auth.so", "lib/plugin/debug/auth.so"},
+		{"./usr/lib/x86_64-linux-gnu/mariadb19/plugin/auth_pam.so", "lib/plugin/auth_pam.so"},
+		{"./usr/share/mariadb/english/errmsg.sys", "share/english/errmsg.sys"},
+		{"./usr/share/mysql/mysql_system_tables.sql", "share/mysql_system_tables.sql"},
+		{"./usr/share/man/man1/mysql.1.gz", ""},
+		{"./usr/bin/subdir/tool", ""},
+		// sanitizedName removes the parent references: the file stays inside the basedir
+		{"./usr/share/mysql/../../../etc/passwd", "share/etc/passwd"},
+		{"../../usr/bin/mysql", "bin/mysql"},
+		{"./lib/systemd/system/mysql.service", ""},
+	}
+	for _, tt := range tests {
+		if got := packagedPath(tt.name); got != tt.expected {
+			t.Errorf("packagedPath(%s) = %q, want %q", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestUnpackZstTarAndZip(t *testing.T) {
+	keepWorkingDirectory(t)
+	entries := []testEntry{
+		{name: "mysql-8.0.36-linux-x86_64/bin/mysqld", contents: "server"},
+		{name: "mysql-8.0.36-linux-x86_64/lib/libmysqlclient.so.21", contents: "library"},
+		{name: "mysql-8.0.36-linux-x86_64/lib/libmysqlclient.so", linkName: "libmysqlclient.so.21"},
+	}
+	outside := append(entries, testEntry{name: "mysql-8.0.36-linux-x86_64/lib/passwd", linkName: "../../../etc/passwd"})
+	twoDirs := append(entries, testEntry{name: "other/bin/mysql", contents: "client"})
+
+	makeZst := func(t *testing.T, fileName string, entries []testEntry) {
+		var buf bytes.Buffer
+		compressor, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		makeTar(t, compressor, entries)
+		if err = compressor.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makeZip := func(t *testing.T, fileName string, entries []testEntry) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, entry := range entries {
+			header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+			contents := entry.contents
+			header.SetMode(0755)
+			if entry.linkName != "" {
+				header.SetMode(os.ModeSymlink | 0777)
+				contents = entry.linkName
+			}
+			writer, err := zw.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = writer.Write([]byte(contents)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		name      string
+		extension string
+		maker     func(*testing.T, string, []testEntry)
+		unpacker  func(string, string, int) error
+	}{
+		{"zst", ".tar.zst", makeZst, UnpackZstTar},
+		{"zip", ".zip", makeZip, UnpackZip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			archive := path.Join(workDir, "mysql-8.0.36-linux-x86_64"+tt.extension)
+			tt.maker(t, archive, entries)
+			if err := VerifyTarFile(archive); err != nil {
+				t.Fatalf("verification failed: %s", err)
+			}
+			destination := t.TempDir()
+			if err := tt.unpacker(archive, destination, SILENT); err != nil {
+				t.Fatalf("error unpacking %s: %s", archive, err)
+			}
+			found, err := os.ReadFile(path.Join(destination, "mysql-8.0.36-linux-x86_64", "lib", "libmysqlclient.so"))
+			if err != nil || string(found) != "library" {
+				t.Errorf("link not extracted: %q %v", found, err)
+			}
+
+			tt.maker(t, archive, outside)
+			if err := tt.unpacker(archive, t.TempDir(), SILENT); err == nil {
+				t.Errorf("link outside of the extraction directory accepted")
+			}
+			tt.maker(t, archive, twoDirs)
+			if err := tt.unpacker(archive, t.TempDir(), SILENT); err == nil {
+				t.Errorf("archive with two top directories accepted")
+			}
+			renamed := path.Join(workDir, "other"+tt.extension)
+			tt.maker(t, renamed, entries)
+			if err := VerifyTarFile(renamed); err == nil {
+				t.Errorf("inner directory different from the archive name accepted")
+			}
+		})
+	}
+}
@@ -54,6 +54,8 @@ func MergeShell(tarball, extension, basedir, destination, bareName string, verbo
 		err = UnpackTar(tarball, basedir, verbosity)
 	case globals.TarXzExt:
 		err = UnpackXzTar(tarball, basedir, verbosity)
+	case globals.TarZstExt:
+		err = UnpackZstTar(tarball, basedir, verbosity)
 	default:
 		return fmt.Errorf("unrecognized extension %s", extension)
 	}
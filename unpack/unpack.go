@@ -62,6 +62,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
 	"github.com/xi2/xz"
 
@@ -77,6 +78,11 @@ const (
 
 var Verbose int
 
+const (
+	errLinkedDirectoryOutside = "linked directory '%s' is outside the extraction directory"
+	errMoreThanOneDirectory   = "found more than one directory inside the tarball\n<%s> and <%s>"
+)
+
 func condPrint(s string, nl bool, level int) {
 	if Verbose >= level {
 		if nl {
@@ -88,7 +94,7 @@ func condPrint(s string, nl bool, level int) {
 }
 
 func validSuffix(filename string) bool {
-	for _, suffix := range []string{globals.TgzExt, globals.TarExt, globals.TarGzExt, globals.TarXzExt} {
+	for _, suffix := range []string{globals.TgzExt, globals.TarExt, globals.TarGzExt, globals.TarXzExt, globals.TarZstExt, globals.ZipExt} {
 		if strings.HasSuffix(filename, suffix) {
 			return true
 		}
@@ -169,7 +175,6 @@ func UnpackTar(filename string, destination string, verbosityLevel int) (err err
 }
 
 func unpackTarFiles(reader *tar.Reader, extractDir string) error {
-	const errLinkedDirectoryOutside = "linked directory '%s' is outside the extraction directory"
 	extractAbsDir, err := filepath.Abs(extractDir)
 	if err != nil {
 		return fmt.Errorf("error defining the absolute path of '%s': %s", extractDir, err)
@@ -232,8 +237,7 @@ func unpackTarFiles(reader *tar.Reader, extractDir string) error {
 		upperDir := reSlash.ReplaceAllString(fileDir, "")
 		if innerDir != "" {
 			if upperDir != innerDir {
-				return fmt.Errorf("found more than one directory inside the tarball\n"+
-					"<%s> and <%s>", upperDir, innerDir)
+				return fmt.Errorf(errMoreThanOneDirectory, upperDir, innerDir)
 			}
 		} else {
 			innerDir = upperDir
@@ -274,26 +278,10 @@ func unpackTarFiles(reader *tar.Reader, extractDir string) error {
 			}
 		case tar.TypeSymlink:
 			if header.Linkname != "" {
-				linkDepth := pathDepth(header.Linkname)
-				nameDepth := pathDepth(header.Name)
-				if linkDepth > nameDepth {
+				err = checkLinkTarget(header.Name, header.Linkname, extractAbsDir)
+				if err != nil {
 					fmt.Println()
-					return fmt.Errorf(errLinkedDirectoryOutside, header.Linkname)
-				}
-				if common.FileExists(header.Linkname) {
-					absFile, err := filepath.Abs(header.Linkname)
-					if err != nil {
-						return fmt.Errorf("error retrieving absolute path of %s: %s", header.Linkname, err)
-					}
-					if !common.BeginsWith(absFile, extractAbsDir) {
-						return fmt.Errorf(errLinkedDirectoryOutside, header.Linkname)
-					}
-				} else {
-					if common.BeginsWith(header.Linkname, "/") {
-						if !common.BeginsWith(header.Linkname, extractAbsDir) {
-							return fmt.Errorf(errLinkedDirectoryOutside, header.Linkname)
-						}
-					}
+					return err
 				}
 				condPrint(fmt.Sprintf("%s -> %s", filename, header.Linkname), true, CHATTY)
 				err = os.Symlink(header.Linkname, filename)
@@ -308,6 +296,31 @@ func unpackTarFiles(reader *tar.Reader, extractDir string) error {
 	// return nil
 }
 
+// checkLinkTarget refuses a link that would point outside the extraction directory
+func checkLinkTarget(name, linkName, extractAbsDir string) error {
+	linkDepth := pathDepth(linkName)
+	nameDepth := pathDepth(name)
+	if linkDepth > nameDepth {
+		return fmt.Errorf(errLinkedDirectoryOutside, linkName)
+	}
+	if common.FileExists(linkName) {
+		absFile, err := filepath.Abs(linkName)
+		if err != nil {
+			return fmt.Errorf("error retrieving absolute path of %s: %s", linkName, err)
+		}
+		if !common.BeginsWith(absFile, extractAbsDir) {
+			return fmt.Errorf(errLinkedDirectoryOutside, linkName)
+		}
+	} else {
+		if common.BeginsWith(linkName, "/") {
+			if !common.BeginsWith(linkName, extractAbsDir) {
+				return fmt.Errorf(errLinkedDirectoryOutside, linkName)
+			}
+		}
+	}
+	return nil
+}
+
 func pathDepth(s string) int {
 	reSlash := regexp.MustCompilePOSIX("(/)")
 	list := reSlash.FindAllStringIndex(s, -1)
@@ -341,6 +354,9 @@ func VerifyTarFile(fileName string) error {
 	if !validSuffix(fileName) {
 		return fmt.Errorf("unrecognized archive suffix %s", fileName)
 	}
+	if strings.HasSuffix(fileName, globals.ZipExt) {
+		return verifyZipFile(fileName)
+	}
 	var file *os.File
 	var err error
 	// #nosec G304
@@ -351,6 +367,7 @@ func VerifyTarFile(fileName string) error {
 	var fileReader io.Reader = file
 	var decompressor *gzip.Reader
 	var xzDecompressor *xz.Reader
+	var zstDecompressor *zstd.Decoder
 
 	if strings.HasSuffix(fileName, globals.GzExt) {
 		if decompressor, err = gzip.NewReader(file); err != nil {
@@ -363,29 +380,42 @@ func VerifyTarFile(fileName string) error {
 				return fmt.Errorf("[xz Validation] %s", err)
 			}
 		}
+		if strings.HasSuffix(fileName, globals.TarZstExt) {
+			if zstDecompressor, err = zstd.NewReader(file); err != nil {
+				return fmt.Errorf("[zst Validation] %s", err)
+			}
+			defer zstDecompressor.Close()
+		}
 	}
 	var reader *tar.Reader
-	if decompressor != nil {
+	switch {
+	case decompressor != nil:
 		reader = tar.NewReader(decompressor)
-	} else {
-		if xzDecompressor != nil {
-			reader = tar.NewReader(xzDecompressor)
-		} else {
-			reader = tar.NewReader(fileReader)
-		}
+	case xzDecompressor != nil:
+		reader = tar.NewReader(xzDecompressor)
+	case zstDecompressor != nil:
+		reader = tar.NewReader(zstDecompressor)
+	default:
+		reader = tar.NewReader(fileReader)
 	}
 	var header *tar.Header
-	expectedDirName := common.BaseName(fileName)
-	reExt := regexp.MustCompile(`\.(?:tar(?:\.gz|\.xz)?)$`)
-	expectedDirName = reExt.ReplaceAllString(expectedDirName, "")
-
 	if header, err = reader.Next(); err != nil {
 		if err == io.EOF {
 			return fmt.Errorf("[EOF Validation] file %s is empty", fileName)
 		}
 		return fmt.Errorf("[header validation] %s", err)
 	}
-	innerFileName := sanitizedName(header.Name)
+	return checkInnerDirectory(fileName, header.Name)
+}
+
+// checkInnerDirectory compares the directory of the first entry in an archive
+// with the archive name
+func checkInnerDirectory(fileName, entryName string) error {
+	expectedDirName := common.BaseName(fileName)
+	reExt := regexp.MustCompile(`\.(?:tar(?:\.gz|\.xz|\.zst)?|zip)$`)
+	expectedDirName = reExt.ReplaceAllString(expectedDirName, "")
+
+	innerFileName := sanitizedName(entryName)
 	fileDir := path.Dir(innerFileName)
 
 	reSlash := regexp.MustCompile(`/.*`)